@@ -0,0 +1,461 @@
+//-----------------------------------------------------------------------------
+/*
+
+Backends
+
+A Backend serves a context in place of libusb, e.g. the in-memory fake in
+package usb/usbtest. Init_Backend returns a context whose devices and
+handles are served by the backend, so code written against Open,
+Claim_Interface, Bulk_Transfer and the other synchronous functions can be
+tested without hardware.
+
+The context, devices and handles of a backend are placeholders that are
+never passed to libusb. Asynchronous transfers, event handling, pollfds,
+hotplug callbacks, bulk streams and kernel driver calls aren't served by a
+backend, they return ERROR_NOT_SUPPORTED or do nothing. Watch is served by
+the backend.
+
+Backends return libusb errors (*Error), so errors.Is with the Err* values
+works whatever the backend.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+/*
+#include <stdlib.h>
+#include <libusb-1.0/libusb.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/deadsy/libusb/descriptor"
+)
+
+//-----------------------------------------------------------------------------
+
+// Backend enumerates and watches devices.
+type Backend interface {
+	// Devices returns the attached devices. Each device holds a reference
+	// that is released by its Close.
+	Devices() ([]Backend_Device, error)
+	// Watch reports devices arriving and leaving, see Watch.
+	Watch(filter *WatchFilter) (<-chan DeviceEvent, func(), error)
+	// Close ends the session.
+	Close() error
+}
+
+// Backend_Device is a referenced device.
+type Backend_Device interface {
+	Descriptor() (*Device_Descriptor, error)
+	Config(index uint8) (*Config_Descriptor, error)
+	ActiveConfig() (*Config_Descriptor, error)
+	Bus() uint8
+	Address() uint8
+	PortPath() ([]byte, error)
+	Speed() Speed
+	// Serial returns the serial number string, without opening the device if possible.
+	Serial() (string, error)
+	// Parent returns a referenced parent device, nil for a root hub.
+	Parent() Backend_Device
+	// Ref returns a new reference to the device.
+	Ref() Backend_Device
+	Open() (Backend_Handle, error)
+	// Close releases the reference.
+	Close() error
+}
+
+// Backend_Handle is an open device. A zero timeout waits forever.
+// String and descriptor reads are built on Control.
+type Backend_Handle interface {
+	Claim(n int) error
+	Release(n int) error
+	Configuration() (int, error)
+	SetConfiguration(cfg int) error
+	SetAltSetting(n int, alt int) error
+	ClearHalt(endpoint uint8) error
+	Reset() error
+	SetAutoDetachKernelDriver(enable bool) error
+	Control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout time.Duration) ([]byte, error)
+	Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error)
+	Interrupt(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error)
+	Close() error
+}
+
+//-----------------------------------------------------------------------------
+
+// The contexts, devices and handles of a backend are allocated C pointers,
+// so they are unique and can't be confused with libusb objects. They are
+// kept here and looked up by the libusb functions.
+
+type backend_device struct {
+	ctx    Context
+	dev    Backend_Device
+	refs   int
+	parent Device // referenced parent, nil until Get_Parent
+}
+
+type backend_handle struct {
+	dev Device
+	hdl Backend_Handle
+}
+
+var backends = struct {
+	sync.Mutex
+	contexts map[Context]Backend
+	devices  map[Device]*backend_device
+	handles  map[Device_Handle]*backend_handle
+	lists    map[*Device]bool // device lists from Get_Device_List
+}{
+	contexts: make(map[Context]Backend),
+	devices:  make(map[Device]*backend_device),
+	handles:  make(map[Device_Handle]*backend_handle),
+	lists:    make(map[*Device]bool),
+}
+
+// Init_Backend returns a context served by a backend.
+// Exit closes the backend.
+func Init_Backend(ctx *Context, b Backend) error {
+	if b == nil {
+		return new_error(ERROR_INVALID_PARAM, "Init_Backend")
+	}
+	c := Context((*C.struct_libusb_context)(C.malloc(1)))
+	backends.Lock()
+	backends.contexts[c] = b
+	backends.Unlock()
+	*ctx = c
+	return nil
+}
+
+// return the backend of a context
+func lookup_backend(ctx Context) (Backend, bool) {
+	backends.Lock()
+	defer backends.Unlock()
+	b, ok := backends.contexts[ctx]
+	return b, ok
+}
+
+// return the backend device of a device
+func lookup_device(dev Device) (*backend_device, bool) {
+	backends.Lock()
+	defer backends.Unlock()
+	d, ok := backends.devices[dev]
+	return d, ok
+}
+
+// return the backend handle of a device handle
+func lookup_handle(hdl Device_Handle) (*backend_handle, bool) {
+	backends.Lock()
+	defer backends.Unlock()
+	h, ok := backends.handles[hdl]
+	return h, ok
+}
+
+// return an error from a backend as the error of a libusb operation
+func backend_error(err error, op string, hdl Device_Handle, endpoint int) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return &Error{Code: e.Code, Op: op, Endpoint: endpoint, Handle: hdl}
+	}
+	return err
+}
+
+// convert a timeout in milliseconds to a duration
+func backend_timeout(timeout uint) time.Duration {
+	return time.Duration(timeout) * time.Millisecond
+}
+
+//-----------------------------------------------------------------------------
+// contexts
+
+// close a backend context
+func backend_exit(ctx Context, b Backend) {
+	backends.Lock()
+	delete(backends.contexts, ctx)
+	backends.Unlock()
+	b.Close()
+	C.free(unsafe.Pointer(ctx))
+}
+
+// add a referenced backend device, returning its device
+func backend_add_device(ctx Context, d Backend_Device) Device {
+	dev := Device((*C.struct_libusb_device)(C.malloc(1)))
+	backends.Lock()
+	backends.devices[dev] = &backend_device{ctx: ctx, dev: d, refs: 1}
+	backends.Unlock()
+	return dev
+}
+
+func backend_get_device_list(ctx Context, b Backend) ([]Device, error) {
+	devices, err := b.Devices()
+	if err != nil {
+		return nil, backend_error(err, "Get_Device_List", nil, -1)
+	}
+	list := make([]Device, len(devices))
+	for i, d := range devices {
+		list[i] = backend_add_device(ctx, d)
+	}
+	if len(list) != 0 {
+		backends.Lock()
+		backends.lists[&list[0]] = true
+		backends.Unlock()
+	}
+	return list, nil
+}
+
+// free a device list from Get_Device_List, returning false if it isn't from a backend
+func backend_free_device_list(list []Device, unref_devices int) bool {
+	backends.Lock()
+	ok := backends.lists[&list[:1][0]]
+	delete(backends.lists, &list[:1][0])
+	backends.Unlock()
+	if ok && unref_devices != 0 {
+		for _, dev := range list {
+			Unref_Device(dev)
+		}
+	}
+	return ok
+}
+
+func backend_open_device_with_vid_pid(ctx Context, b Backend, vendor_id uint16, product_id uint16) Device_Handle {
+	list, err := backend_get_device_list(ctx, b)
+	if err != nil {
+		return nil
+	}
+	defer Free_Device_List(list, 1)
+	for _, dev := range list {
+		dd, err := Get_Device_Descriptor(dev)
+		if err == nil && dd.IdVendor == vendor_id && dd.IdProduct == product_id {
+			hdl, err := Open(dev)
+			if err != nil {
+				return nil
+			}
+			return hdl
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// devices
+
+func backend_ref_device(dev Device) {
+	backends.Lock()
+	backends.devices[dev].refs++
+	backends.Unlock()
+}
+
+func backend_unref_device(dev Device) {
+	backends.Lock()
+	d := backends.devices[dev]
+	d.refs--
+	if d.refs != 0 {
+		backends.Unlock()
+		return
+	}
+	delete(backends.devices, dev)
+	backends.Unlock()
+	d.dev.Close()
+	C.free(unsafe.Pointer(dev))
+	if d.parent != nil {
+		Unref_Device(d.parent)
+	}
+}
+
+func backend_get_port_numbers(d *backend_device, ports []byte) ([]byte, error) {
+	path, err := d.dev.PortPath()
+	if err != nil {
+		return nil, backend_error(err, "Get_Port_Numbers", nil, -1)
+	}
+	if len(path) > len(ports) {
+		return nil, new_error(ERROR_OVERFLOW, "Get_Port_Numbers")
+	}
+	return ports[:copy(ports, path)], nil
+}
+
+func backend_get_port_number(d *backend_device) uint8 {
+	path, err := d.dev.PortPath()
+	if err != nil || len(path) == 0 {
+		return 0
+	}
+	return path[len(path)-1]
+}
+
+// The parent is referenced by the device, so it is valid while the device is.
+func backend_get_parent(dev Device, d *backend_device) Device {
+	backends.Lock()
+	parent := d.parent
+	backends.Unlock()
+	if parent != nil {
+		return parent
+	}
+	p := d.dev.Parent()
+	if p == nil {
+		return nil
+	}
+	parent = backend_add_device(d.ctx, p)
+	backends.Lock()
+	if d.parent == nil {
+		d.parent = parent
+		backends.Unlock()
+		return parent
+	}
+	// another goroutine got there first
+	backends.Unlock()
+	Unref_Device(parent)
+	return d.parent
+}
+
+// return an endpoint descriptor from the active configuration
+func backend_endpoint(d *backend_device, endpoint uint8) (*Endpoint_Descriptor, int) {
+	cd, err := d.dev.ActiveConfig()
+	if err != nil {
+		return nil, ERROR_NOT_FOUND
+	}
+	for _, itf := range cd.Interface {
+		for _, id := range itf.Altsetting {
+			for _, ep := range id.Endpoint {
+				if ep.BEndpointAddress == endpoint {
+					return ep, SUCCESS
+				}
+			}
+		}
+	}
+	return nil, ERROR_NOT_FOUND
+}
+
+func backend_get_max_packet_size(d *backend_device, endpoint uint8) int {
+	ep, rc := backend_endpoint(d, endpoint)
+	if ep == nil {
+		return rc
+	}
+	return int(ep.WMaxPacketSize)
+}
+
+func backend_get_max_iso_packet_size(d *backend_device, endpoint uint8) int {
+	ep, rc := backend_endpoint(d, endpoint)
+	if ep == nil {
+		return rc
+	}
+	return ep.MaxPacketSize()
+}
+
+func backend_get_config_descriptor_by_value(d *backend_device, bConfigurationValue uint8) (*Config_Descriptor, error) {
+	const op = "Get_Config_Descriptor_By_Value"
+	dd, err := d.dev.Descriptor()
+	if err != nil {
+		return nil, backend_error(err, op, nil, -1)
+	}
+	for i := uint8(0); i < dd.BNumConfigurations; i++ {
+		cd, err := d.dev.Config(i)
+		if err != nil {
+			return nil, backend_error(err, op, nil, -1)
+		}
+		if cd.BConfigurationValue == bConfigurationValue {
+			return cd, nil
+		}
+	}
+	return nil, new_error(ERROR_NOT_FOUND, op)
+}
+
+// Opening a device references it, closing the handle releases the reference.
+func backend_open(dev Device, d *backend_device) (Device_Handle, error) {
+	h, err := d.dev.Open()
+	if err != nil {
+		return nil, backend_error(err, "Open", nil, -1)
+	}
+	backend_ref_device(dev)
+	hdl := Device_Handle((*C.struct_libusb_device_handle)(C.malloc(1)))
+	backends.Lock()
+	backends.handles[hdl] = &backend_handle{dev: dev, hdl: h}
+	backends.Unlock()
+	return hdl, nil
+}
+
+//-----------------------------------------------------------------------------
+// handles
+
+func backend_close(hdl Device_Handle, h *backend_handle) {
+	backends.Lock()
+	delete(backends.handles, hdl)
+	backends.Unlock()
+	h.hdl.Close()
+	C.free(unsafe.Pointer(hdl))
+	Unref_Device(h.dev)
+}
+
+func backend_control_transfer(hdl Device_Handle, h *backend_handle, bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout uint, op string) ([]byte, error) {
+	buf, err := h.hdl.Control(bmRequestType, bRequest, wValue, wIndex, data, backend_timeout(timeout))
+	if err != nil {
+		return nil, backend_error(err, op, hdl, -1)
+	}
+	return data[:copy(data, buf)], nil
+}
+
+// bulk or interrupt transfer, returning the data transferred before an error along with the error
+func backend_transfer(hdl Device_Handle, fn func(uint8, []byte, time.Duration) ([]byte, error), op string, endpoint uint8, data []byte, timeout uint) ([]byte, error) {
+	buf, err := fn(endpoint, data, backend_timeout(timeout))
+	data = data[:copy(data, buf)]
+	if err != nil {
+		return data, backend_error(err, op, hdl, int(endpoint))
+	}
+	return data, nil
+}
+
+func backend_get_descriptor(hdl Device_Handle, h *backend_handle, desc_type uint8, desc_index uint8, langid uint16, data []byte, op string) ([]byte, error) {
+	return backend_control_transfer(hdl, h, ENDPOINT_IN, REQUEST_GET_DESCRIPTOR, uint16(desc_type)<<8|uint16(desc_index), langid, data, 1000, op)
+}
+
+// The string is read in the first language, non-ASCII characters are replaced with '?'
+// and the result is NUL terminated, as libusb does.
+func backend_get_string_descriptor_ascii(hdl Device_Handle, h *backend_handle, desc_index uint8, data []byte) ([]byte, error) {
+	read := func(index uint8, langid uint16, data []byte) ([]byte, error) {
+		return backend_get_descriptor(hdl, h, DT_STRING, index, langid, data, "Get_String_Descriptor_ASCII")
+	}
+	s, _, err := String_Reader(read).String_Preferred(desc_index, nil)
+	if err != nil {
+		return nil, backend_error(err, "Get_String_Descriptor_ASCII", hdl, -1)
+	}
+	n := 0
+	for _, r := range s {
+		if n == len(data)-1 {
+			break
+		}
+		if r >= 0x80 {
+			r = '?'
+		}
+		data[n] = byte(r)
+		n++
+	}
+	data[n] = 0
+	return data[:n], nil
+}
+
+func backend_get_bos_descriptor(hdl Device_Handle, h *backend_handle) (*BOS_Descriptor, error) {
+	const op = "Get_BOS_Descriptor"
+	buf, err := backend_get_descriptor(hdl, h, DT_BOS, 0, 0, make([]byte, DT_BOS_SIZE), op)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < DT_BOS_SIZE {
+		return nil, new_handle_error(ERROR_IO, op, hdl)
+	}
+	buf, err = backend_get_descriptor(hdl, h, DT_BOS, 0, 0, make([]byte, binary.LittleEndian.Uint16(buf[2:])), op)
+	if err != nil {
+		return nil, err
+	}
+	bos, err := descriptor.Parse_BOS_Descriptor(buf)
+	if err != nil {
+		return nil, new_handle_error(ERROR_IO, op, hdl)
+	}
+	return bos, nil
+}
+
+//-----------------------------------------------------------------------------
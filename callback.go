@@ -0,0 +1,45 @@
+//-----------------------------------------------------------------------------
+/*
+
+Callbacks from libusb-1.0 into Go
+
+These are exported to C, so the cgo preamble may only hold declarations.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+/*
+#include <libusb-1.0/libusb.h>
+*/
+import "C"
+
+import "unsafe"
+
+//-----------------------------------------------------------------------------
+
+//export go_hotplug_callback
+func go_hotplug_callback(ctx *C.struct_libusb_context, dev *C.struct_libusb_device, event C.libusb_hotplug_event, user_data unsafe.Pointer) C.int {
+	if hotplug_callback(ctx, dev, int(event), uintptr(user_data)) {
+		return 1
+	}
+	return 0
+}
+
+//export go_pollfd_added
+func go_pollfd_added(fd C.int, events C.short, user_data unsafe.Pointer) {
+	pollfd_added(int(fd), int16(events), uintptr(user_data))
+}
+
+//export go_pollfd_removed
+func go_pollfd_removed(fd C.int, user_data unsafe.Pointer) {
+	pollfd_removed(int(fd), uintptr(user_data))
+}
+
+//export go_transfer_callback
+func go_transfer_callback(transfer *C.struct_libusb_transfer) {
+	transfer_callback(uintptr(transfer.user_data))
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,326 @@
+//-----------------------------------------------------------------------------
+/*
+
+USB Audio class descriptors
+
+Audio control, audio streaming and MIDI streaming descriptors as defined by
+the USB Device Class Definition for Audio Devices 1.0 and for MIDI Devices 1.0.
+
+Audio 2.0 interfaces (protocol PROTOCOL_UAC2) use different layouts and are
+not decoded.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+//-----------------------------------------------------------------------------
+
+// Audio interface subclasses.
+const (
+	SUBCLASS_AUDIOCONTROL   = 0x01
+	SUBCLASS_AUDIOSTREAMING = 0x02
+	SUBCLASS_MIDISTREAMING  = 0x03
+)
+
+// Audio interface protocols.
+const (
+	PROTOCOL_UAC1 = 0x00
+	PROTOCOL_UAC2 = 0x20
+)
+
+// Audio control interface descriptor subtypes.
+const (
+	AC_HEADER          = 0x01
+	AC_INPUT_TERMINAL  = 0x02
+	AC_OUTPUT_TERMINAL = 0x03
+	AC_MIXER_UNIT      = 0x04
+	AC_SELECTOR_UNIT   = 0x05
+	AC_FEATURE_UNIT    = 0x06
+)
+
+// Audio streaming interface descriptor subtypes.
+const (
+	AS_GENERAL     = 0x01
+	AS_FORMAT_TYPE = 0x02
+)
+
+// MIDI streaming interface descriptor subtypes.
+const (
+	MS_HEADER        = 0x01
+	MS_MIDI_IN_JACK  = 0x02
+	MS_MIDI_OUT_JACK = 0x03
+)
+
+// Class specific endpoint descriptor subtypes.
+const (
+	EP_GENERAL = 0x01
+)
+
+//-----------------------------------------------------------------------------
+// audio control
+
+// AC_Header_Descriptor is the audio control interface header.
+type AC_Header_Descriptor struct {
+	CS_Header
+	BcdADC        uint16
+	WTotalLength  uint16
+	BInCollection uint8
+	BaInterfaceNr []uint8
+}
+
+// AC_Input_Terminal_Descriptor describes an audio input terminal.
+type AC_Input_Terminal_Descriptor struct {
+	CS_Header
+	BTerminalID    uint8
+	WTerminalType  uint16
+	BAssocTerminal uint8
+	BNrChannels    uint8
+	WChannelConfig uint16
+	IChannelNames  uint8
+	ITerminal      uint8
+}
+
+// AC_Output_Terminal_Descriptor describes an audio output terminal.
+type AC_Output_Terminal_Descriptor struct {
+	CS_Header
+	BTerminalID    uint8
+	WTerminalType  uint16
+	BAssocTerminal uint8
+	BSourceID      uint8
+	ITerminal      uint8
+}
+
+// AC_Feature_Unit_Descriptor describes an audio feature unit.
+type AC_Feature_Unit_Descriptor struct {
+	CS_Header
+	BUnitID      uint8
+	BSourceID    uint8
+	BControlSize uint8
+	BmaControls  [][]byte // per channel, master channel first
+	IFeature     uint8
+}
+
+func decode_audio_control(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	var d Class_Descriptor
+	switch cs_subtype(b) {
+	case AC_HEADER:
+		x := &AC_Header_Descriptor{
+			CS_Header:     r.cs_header(),
+			BcdADC:        r.u16(),
+			WTotalLength:  r.u16(),
+			BInCollection: r.u8(),
+		}
+		x.BaInterfaceNr = r.bytes(int(x.BInCollection))
+		d = x
+	case AC_INPUT_TERMINAL:
+		d = &AC_Input_Terminal_Descriptor{
+			CS_Header:      r.cs_header(),
+			BTerminalID:    r.u8(),
+			WTerminalType:  r.u16(),
+			BAssocTerminal: r.u8(),
+			BNrChannels:    r.u8(),
+			WChannelConfig: r.u16(),
+			IChannelNames:  r.u8(),
+			ITerminal:      r.u8(),
+		}
+	case AC_OUTPUT_TERMINAL:
+		d = &AC_Output_Terminal_Descriptor{
+			CS_Header:      r.cs_header(),
+			BTerminalID:    r.u8(),
+			WTerminalType:  r.u16(),
+			BAssocTerminal: r.u8(),
+			BSourceID:      r.u8(),
+			ITerminal:      r.u8(),
+		}
+	case AC_FEATURE_UNIT:
+		x := &AC_Feature_Unit_Descriptor{
+			CS_Header:    r.cs_header(),
+			BUnitID:      r.u8(),
+			BSourceID:    r.u8(),
+			BControlSize: r.u8(),
+		}
+		if x.BControlSize != 0 {
+			n := (len(b) - 7) / int(x.BControlSize)
+			for i := 0; i < n; i++ {
+				x.BmaControls = append(x.BmaControls, r.bytes(int(x.BControlSize)))
+			}
+		}
+		x.IFeature = r.u8()
+		d = x
+	default:
+		return decode_cs(b)
+	}
+	return d, r.check("audio control")
+}
+
+//-----------------------------------------------------------------------------
+// audio streaming
+
+// AS_General_Descriptor describes an audio streaming interface.
+type AS_General_Descriptor struct {
+	CS_Header
+	BTerminalLink uint8
+	BDelay        uint8
+	WFormatTag    uint16
+}
+
+// AS_Format_Type_Descriptor describes a type I or III audio format.
+type AS_Format_Type_Descriptor struct {
+	CS_Header
+	BFormatType    uint8
+	BNrChannels    uint8
+	BSubframeSize  uint8
+	BBitResolution uint8
+	BSamFreqType   uint8    // 0 for a continuous range
+	TSamFreq       []uint32 // lower/upper bound for a continuous range
+}
+
+// AS_Endpoint_Descriptor is the class specific audio streaming endpoint descriptor.
+type AS_Endpoint_Descriptor struct {
+	CS_Header
+	BmAttributes    uint8
+	BLockDelayUnits uint8
+	WLockDelay      uint16
+}
+
+func decode_audio_streaming(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	var d Class_Descriptor
+	switch cs_subtype(b) {
+	case AS_GENERAL:
+		d = &AS_General_Descriptor{
+			CS_Header:     r.cs_header(),
+			BTerminalLink: r.u8(),
+			BDelay:        r.u8(),
+			WFormatTag:    r.u16(),
+		}
+	case AS_FORMAT_TYPE:
+		x := &AS_Format_Type_Descriptor{
+			CS_Header:      r.cs_header(),
+			BFormatType:    r.u8(),
+			BNrChannels:    r.u8(),
+			BSubframeSize:  r.u8(),
+			BBitResolution: r.u8(),
+			BSamFreqType:   r.u8(),
+		}
+		n := int(x.BSamFreqType)
+		if n == 0 {
+			n = 2
+		}
+		for i := 0; i < n; i++ {
+			x.TSamFreq = append(x.TSamFreq, r.u24())
+		}
+		d = x
+	default:
+		return decode_cs(b)
+	}
+	return d, r.check("audio streaming")
+}
+
+func decode_audio_endpoint(b []byte) (Class_Descriptor, error) {
+	if cs_subtype(b) != EP_GENERAL {
+		return decode_cs(b)
+	}
+	r := &reader{b: b}
+	d := &AS_Endpoint_Descriptor{
+		CS_Header:       r.cs_header(),
+		BmAttributes:    r.u8(),
+		BLockDelayUnits: r.u8(),
+		WLockDelay:      r.u16(),
+	}
+	return d, r.check("audio streaming endpoint")
+}
+
+//-----------------------------------------------------------------------------
+// MIDI streaming
+
+// MS_Header_Descriptor is the MIDI streaming interface header.
+type MS_Header_Descriptor struct {
+	CS_Header
+	BcdMSC       uint16
+	WTotalLength uint16
+}
+
+// MS_In_Jack_Descriptor describes a MIDI IN jack.
+type MS_In_Jack_Descriptor struct {
+	CS_Header
+	BJackType uint8
+	BJackID   uint8
+	IJack     uint8
+}
+
+// MS_Source is an input pin of a MIDI OUT jack.
+type MS_Source struct {
+	BaSourceID  uint8
+	BaSourcePin uint8
+}
+
+// MS_Out_Jack_Descriptor describes a MIDI OUT jack.
+type MS_Out_Jack_Descriptor struct {
+	CS_Header
+	BJackType    uint8
+	BJackID      uint8
+	BNrInputPins uint8
+	Sources      []MS_Source
+	IJack        uint8
+}
+
+// MS_Endpoint_Descriptor is the class specific MIDI streaming endpoint descriptor.
+type MS_Endpoint_Descriptor struct {
+	CS_Header
+	BNumEmbMIDIJack uint8
+	BaAssocJackID   []uint8
+}
+
+func decode_midi_streaming(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	var d Class_Descriptor
+	switch cs_subtype(b) {
+	case MS_HEADER:
+		d = &MS_Header_Descriptor{
+			CS_Header:    r.cs_header(),
+			BcdMSC:       r.u16(),
+			WTotalLength: r.u16(),
+		}
+	case MS_MIDI_IN_JACK:
+		d = &MS_In_Jack_Descriptor{
+			CS_Header: r.cs_header(),
+			BJackType: r.u8(),
+			BJackID:   r.u8(),
+			IJack:     r.u8(),
+		}
+	case MS_MIDI_OUT_JACK:
+		x := &MS_Out_Jack_Descriptor{
+			CS_Header:    r.cs_header(),
+			BJackType:    r.u8(),
+			BJackID:      r.u8(),
+			BNrInputPins: r.u8(),
+		}
+		x.Sources = make([]MS_Source, x.BNrInputPins)
+		for i := range x.Sources {
+			x.Sources[i] = MS_Source{BaSourceID: r.u8(), BaSourcePin: r.u8()}
+		}
+		x.IJack = r.u8()
+		d = x
+	default:
+		return decode_cs(b)
+	}
+	return d, r.check("MIDI streaming")
+}
+
+func decode_midi_endpoint(b []byte) (Class_Descriptor, error) {
+	if cs_subtype(b) != EP_GENERAL {
+		return decode_cs(b)
+	}
+	r := &reader{b: b}
+	d := &MS_Endpoint_Descriptor{
+		CS_Header:       r.cs_header(),
+		BNumEmbMIDIJack: r.u8(),
+	}
+	d.BaAssocJackID = r.bytes(int(d.BNumEmbMIDIJack))
+	return d, r.check("MIDI streaming endpoint")
+}
+
+//-----------------------------------------------------------------------------
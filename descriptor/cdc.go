@@ -0,0 +1,101 @@
+//-----------------------------------------------------------------------------
+/*
+
+CDC functional descriptors
+
+See the USB Class Definitions for Communications Devices 1.2, section 5.2.3.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+//-----------------------------------------------------------------------------
+
+// CDC functional descriptor subtypes.
+const (
+	CDC_HEADER          = 0x00
+	CDC_CALL_MANAGEMENT = 0x01
+	CDC_ACM             = 0x02
+	CDC_UNION           = 0x06
+	CDC_ETHERNET        = 0x0f
+)
+
+// CDC_Header_Descriptor marks the start of the functional descriptors.
+type CDC_Header_Descriptor struct {
+	CS_Header
+	BcdCDC uint16
+}
+
+// CDC_Call_Management_Descriptor describes call management.
+type CDC_Call_Management_Descriptor struct {
+	CS_Header
+	BmCapabilities uint8
+	BDataInterface uint8
+}
+
+// CDC_ACM_Descriptor describes the abstract control management capabilities.
+type CDC_ACM_Descriptor struct {
+	CS_Header
+	BmCapabilities uint8
+}
+
+// CDC_Union_Descriptor groups a control interface with its subordinate interfaces.
+type CDC_Union_Descriptor struct {
+	CS_Header
+	BControlInterface     uint8
+	BSubordinateInterface []uint8
+}
+
+// CDC_Ethernet_Descriptor describes an ethernet networking function.
+type CDC_Ethernet_Descriptor struct {
+	CS_Header
+	IMACAddress          uint8
+	BmEthernetStatistics uint32
+	WMaxSegmentSize      uint16
+	WNumberMCFilters     uint16
+	BNumberPowerFilters  uint8
+}
+
+func decode_cdc(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	var d Class_Descriptor
+	switch cs_subtype(b) {
+	case CDC_HEADER:
+		d = &CDC_Header_Descriptor{
+			CS_Header: r.cs_header(),
+			BcdCDC:    r.u16(),
+		}
+	case CDC_CALL_MANAGEMENT:
+		d = &CDC_Call_Management_Descriptor{
+			CS_Header:      r.cs_header(),
+			BmCapabilities: r.u8(),
+			BDataInterface: r.u8(),
+		}
+	case CDC_ACM:
+		d = &CDC_ACM_Descriptor{
+			CS_Header:      r.cs_header(),
+			BmCapabilities: r.u8(),
+		}
+	case CDC_UNION:
+		d = &CDC_Union_Descriptor{
+			CS_Header:             r.cs_header(),
+			BControlInterface:     r.u8(),
+			BSubordinateInterface: r.rest(),
+		}
+	case CDC_ETHERNET:
+		d = &CDC_Ethernet_Descriptor{
+			CS_Header:            r.cs_header(),
+			IMACAddress:          r.u8(),
+			BmEthernetStatistics: r.u32(),
+			WMaxSegmentSize:      r.u16(),
+			WNumberMCFilters:     r.u16(),
+			BNumberPowerFilters:  r.u8(),
+		}
+	default:
+		return decode_cs(b)
+	}
+	return d, r.check("CDC functional")
+}
+
+//-----------------------------------------------------------------------------
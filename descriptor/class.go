@@ -0,0 +1,378 @@
+//-----------------------------------------------------------------------------
+/*
+
+Class and vendor specific descriptors
+
+The Extra bytes of config, interface and endpoint descriptors hold class and
+vendor specific descriptors. They are decoded by functions registered for an
+interface class, subclass, protocol and descriptor type. Descriptors with no
+decoder are returned as a Generic_Descriptor.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------
+
+// Class specific descriptor types.
+const (
+	DT_INTERFACE_ASSOCIATION = 0x0b
+	DT_DFU_FUNCTIONAL        = 0x21
+	DT_CS_INTERFACE          = 0x24
+	DT_CS_ENDPOINT           = 0x25
+)
+
+// Application specific subclasses.
+const SUBCLASS_DFU = 0x01
+
+//-----------------------------------------------------------------------------
+
+// Class_Descriptor is a decoded class or vendor specific descriptor.
+type Class_Descriptor interface {
+	Descriptor_Type() uint8
+}
+
+// Header is the common start of all descriptors.
+type Header struct {
+	BLength         uint8
+	BDescriptorType uint8
+}
+
+func (h *Header) Descriptor_Type() uint8 {
+	return h.BDescriptorType
+}
+
+// CS_Header is the common start of class specific interface and endpoint descriptors.
+type CS_Header struct {
+	Header
+	BDescriptorSubtype uint8
+}
+
+// Generic_Descriptor is a descriptor with no decoder.
+type Generic_Descriptor struct {
+	Header
+	Data []byte // bytes following the header
+}
+
+// CS_Descriptor is a class specific descriptor with a subtype that has no decoder.
+type CS_Descriptor struct {
+	CS_Header
+	Data []byte // bytes following the header
+}
+
+//-----------------------------------------------------------------------------
+// decoder registry
+
+// Matches any class, subclass or protocol in a Decoder_Key.
+const MATCH_ANY = -1
+
+// Decoder_Key selects the descriptors handled by a decoder.
+type Decoder_Key struct {
+	Class    int   // interface class or MATCH_ANY
+	SubClass int   // interface subclass or MATCH_ANY
+	Protocol int   // interface protocol or MATCH_ANY
+	Type     uint8 // bDescriptorType
+}
+
+// Decoder_Fn decodes a single descriptor. The buffer holds the whole descriptor, including the header.
+type Decoder_Fn func(b []byte) (Class_Descriptor, error)
+
+var decoders = struct {
+	sync.RWMutex
+	fn map[Decoder_Key]Decoder_Fn
+}{
+	fn: make(map[Decoder_Key]Decoder_Fn),
+}
+
+// Register_Decoder adds or replaces the decoder for a class, subclass, protocol and descriptor type.
+// Use MATCH_ANY for decoders that apply to any interface class, subclass or protocol.
+// A nil function removes the decoder.
+func Register_Decoder(key Decoder_Key, fn Decoder_Fn) {
+	decoders.Lock()
+	defer decoders.Unlock()
+	if fn == nil {
+		delete(decoders.fn, key)
+		return
+	}
+	decoders.fn[key] = fn
+}
+
+// return the most specific decoder for a descriptor
+func lookup_decoder(class int, subclass int, protocol int, dtype uint8) Decoder_Fn {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	keys := []Decoder_Key{
+		{class, subclass, protocol, dtype},
+		{class, subclass, MATCH_ANY, dtype},
+		{class, MATCH_ANY, MATCH_ANY, dtype},
+		{MATCH_ANY, MATCH_ANY, MATCH_ANY, dtype},
+	}
+	for _, k := range keys {
+		if fn := decoders.fn[k]; fn != nil {
+			return fn
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Decode_Extra decodes the descriptors in an Extra blob.
+// The class, subclass and protocol are those of the interface the bytes belong to, or MATCH_ANY if there is none.
+// On error the descriptors decoded so far are returned.
+func Decode_Extra(class int, subclass int, protocol int, extra []byte) ([]Class_Descriptor, error) {
+	p := &parser{buf: extra}
+	list := make([]Class_Descriptor, 0)
+	for !p.done() {
+		n, t, err := p.peek()
+		if err != nil {
+			return list, err
+		}
+		b := p.buf[p.ofs : p.ofs+n]
+		var d Class_Descriptor
+		if fn := lookup_decoder(class, subclass, protocol, t); fn != nil {
+			d, err = fn(b)
+			if err != nil {
+				return list, p.error("%s", err)
+			}
+		} else {
+			d = decode_generic(b)
+		}
+		list = append(list, d)
+		p.ofs += n
+	}
+	return list, nil
+}
+
+// Decode_Config_Extra decodes the Extra bytes of a configuration descriptor.
+func Decode_Config_Extra(cd *Config_Descriptor) ([]Class_Descriptor, error) {
+	return Decode_Extra(MATCH_ANY, MATCH_ANY, MATCH_ANY, cd.Extra)
+}
+
+// Decode_Interface_Extra decodes the Extra bytes of an interface descriptor.
+func Decode_Interface_Extra(id *Interface_Descriptor) ([]Class_Descriptor, error) {
+	return Decode_Extra(int(id.BInterfaceClass), int(id.BInterfaceSubClass), int(id.BInterfaceProtocol), id.Extra)
+}
+
+// Decode_Endpoint_Extra decodes the Extra bytes of an endpoint descriptor of an interface.
+func Decode_Endpoint_Extra(id *Interface_Descriptor, ep *Endpoint_Descriptor) ([]Class_Descriptor, error) {
+	return Decode_Extra(int(id.BInterfaceClass), int(id.BInterfaceSubClass), int(id.BInterfaceProtocol), ep.Extra)
+}
+
+//-----------------------------------------------------------------------------
+// little-endian field reader for decoders
+
+type reader struct {
+	b     []byte
+	i     int
+	short bool // a read went past the end of the buffer
+}
+
+func (r *reader) has(n int) bool {
+	if r.i+n > len(r.b) {
+		r.short = true
+		return false
+	}
+	return true
+}
+
+func (r *reader) u8() uint8 {
+	if !r.has(1) {
+		return 0
+	}
+	r.i++
+	return r.b[r.i-1]
+}
+
+func (r *reader) u16() uint16 {
+	if !r.has(2) {
+		return 0
+	}
+	r.i += 2
+	return binary.LittleEndian.Uint16(r.b[r.i-2:])
+}
+
+func (r *reader) u24() uint32 {
+	if !r.has(3) {
+		return 0
+	}
+	r.i += 3
+	return uint32(r.b[r.i-3]) | uint32(r.b[r.i-2])<<8 | uint32(r.b[r.i-1])<<16
+}
+
+func (r *reader) u32() uint32 {
+	if !r.has(4) {
+		return 0
+	}
+	r.i += 4
+	return binary.LittleEndian.Uint32(r.b[r.i-4:])
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || !r.has(n) {
+		return nil
+	}
+	r.i += n
+	return append([]byte{}, r.b[r.i-n:r.i]...)
+}
+
+// return the unread bytes
+func (r *reader) rest() []byte {
+	b := append([]byte{}, r.b[r.i:]...)
+	r.i = len(r.b)
+	return b
+}
+
+// return the descriptor header
+func (r *reader) header() Header {
+	return Header{BLength: r.u8(), BDescriptorType: r.u8()}
+}
+
+// return the class specific descriptor header
+func (r *reader) cs_header() CS_Header {
+	return CS_Header{Header: r.header(), BDescriptorSubtype: r.u8()}
+}
+
+// return an error if a read was short
+func (r *reader) check(name string) error {
+	if r.short {
+		return fmt.Errorf("%s descriptor bLength %d is too short", name, len(r.b))
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// generic descriptors
+
+func decode_generic(b []byte) Class_Descriptor {
+	r := &reader{b: b}
+	return &Generic_Descriptor{Header: r.header(), Data: r.rest()}
+}
+
+// decode a class specific descriptor with an unknown subtype
+func decode_cs(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	d := &CS_Descriptor{CS_Header: r.cs_header(), Data: r.rest()}
+	return d, r.check("class specific")
+}
+
+// return the subtype of a class specific descriptor
+func cs_subtype(b []byte) uint8 {
+	if len(b) < 3 {
+		return 0
+	}
+	return b[2]
+}
+
+//-----------------------------------------------------------------------------
+// interface association
+
+// Interface_Association_Descriptor groups the interfaces of a function.
+type Interface_Association_Descriptor struct {
+	Header
+	BFirstInterface   uint8
+	BInterfaceCount   uint8
+	BFunctionClass    uint8
+	BFunctionSubClass uint8
+	BFunctionProtocol uint8
+	IFunction         uint8
+}
+
+func decode_iad(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	d := &Interface_Association_Descriptor{
+		Header:            r.header(),
+		BFirstInterface:   r.u8(),
+		BInterfaceCount:   r.u8(),
+		BFunctionClass:    r.u8(),
+		BFunctionSubClass: r.u8(),
+		BFunctionProtocol: r.u8(),
+		IFunction:         r.u8(),
+	}
+	return d, r.check("interface association")
+}
+
+//-----------------------------------------------------------------------------
+// HID
+
+// HID_Report_Info is a report or physical descriptor listed in a HID descriptor.
+type HID_Report_Info struct {
+	BDescriptorType   uint8
+	WDescriptorLength uint16
+}
+
+// HID_Descriptor is the HID class descriptor.
+type HID_Descriptor struct {
+	Header
+	BcdHID          uint16
+	BCountryCode    uint8
+	BNumDescriptors uint8
+	Descriptors     []HID_Report_Info
+}
+
+func decode_hid(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	d := &HID_Descriptor{
+		Header:          r.header(),
+		BcdHID:          r.u16(),
+		BCountryCode:    r.u8(),
+		BNumDescriptors: r.u8(),
+	}
+	d.Descriptors = make([]HID_Report_Info, d.BNumDescriptors)
+	for i := range d.Descriptors {
+		d.Descriptors[i] = HID_Report_Info{BDescriptorType: r.u8(), WDescriptorLength: r.u16()}
+	}
+	return d, r.check("HID")
+}
+
+//-----------------------------------------------------------------------------
+// DFU
+
+// DFU_Functional_Descriptor is the DFU functional descriptor.
+type DFU_Functional_Descriptor struct {
+	Header
+	BmAttributes   uint8
+	WDetachTimeOut uint16
+	WTransferSize  uint16
+	BcdDFUVersion  uint16 // 0 for DFU 1.0 descriptors without the field
+}
+
+func decode_dfu(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	d := &DFU_Functional_Descriptor{
+		Header:         r.header(),
+		BmAttributes:   r.u8(),
+		WDetachTimeOut: r.u16(),
+		WTransferSize:  r.u16(),
+	}
+	if len(b) >= 9 {
+		d.BcdDFUVersion = r.u16()
+	}
+	return d, r.check("DFU functional")
+}
+
+//-----------------------------------------------------------------------------
+
+func init() {
+	Register_Decoder(Decoder_Key{MATCH_ANY, MATCH_ANY, MATCH_ANY, DT_INTERFACE_ASSOCIATION}, decode_iad)
+	Register_Decoder(Decoder_Key{int(CLASS_HID), MATCH_ANY, MATCH_ANY, DT_HID}, decode_hid)
+	Register_Decoder(Decoder_Key{int(CLASS_APPLICATION), SUBCLASS_DFU, MATCH_ANY, DT_DFU_FUNCTIONAL}, decode_dfu)
+	Register_Decoder(Decoder_Key{int(CLASS_COMM), MATCH_ANY, MATCH_ANY, DT_CS_INTERFACE}, decode_cdc)
+	Register_Decoder(Decoder_Key{int(CLASS_AUDIO), SUBCLASS_AUDIOCONTROL, PROTOCOL_UAC1, DT_CS_INTERFACE}, decode_audio_control)
+	Register_Decoder(Decoder_Key{int(CLASS_AUDIO), SUBCLASS_AUDIOSTREAMING, PROTOCOL_UAC1, DT_CS_INTERFACE}, decode_audio_streaming)
+	Register_Decoder(Decoder_Key{int(CLASS_AUDIO), SUBCLASS_AUDIOSTREAMING, PROTOCOL_UAC1, DT_CS_ENDPOINT}, decode_audio_endpoint)
+	Register_Decoder(Decoder_Key{int(CLASS_AUDIO), SUBCLASS_MIDISTREAMING, MATCH_ANY, DT_CS_INTERFACE}, decode_midi_streaming)
+	Register_Decoder(Decoder_Key{int(CLASS_AUDIO), SUBCLASS_MIDISTREAMING, MATCH_ANY, DT_CS_ENDPOINT}, decode_midi_endpoint)
+	Register_Decoder(Decoder_Key{int(CLASS_VIDEO), SUBCLASS_VIDEOCONTROL, MATCH_ANY, DT_CS_INTERFACE}, decode_video_control)
+	Register_Decoder(Decoder_Key{int(CLASS_VIDEO), SUBCLASS_VIDEOCONTROL, MATCH_ANY, DT_CS_ENDPOINT}, decode_video_endpoint)
+	Register_Decoder(Decoder_Key{int(CLASS_VIDEO), SUBCLASS_VIDEOSTREAMING, MATCH_ANY, DT_CS_INTERFACE}, decode_video_streaming)
+}
+
+//-----------------------------------------------------------------------------
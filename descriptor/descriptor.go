@@ -0,0 +1,209 @@
+//-----------------------------------------------------------------------------
+/*
+
+USB Descriptors
+
+Descriptor types shared with the libusb package, and a pure-Go parser and
+encoder for raw descriptor bytes. No cgo is needed, so descriptor tables can
+be checked on machines without libusb or USB devices.
+
+*/
+//-----------------------------------------------------------------------------
+
+// Package descriptor parses and encodes raw USB descriptors.
+package descriptor
+
+//-----------------------------------------------------------------------------
+
+// Descriptor types as defined by the USB specification.
+const (
+	DT_DEVICE                = 0x01
+	DT_CONFIG                = 0x02
+	DT_STRING                = 0x03
+	DT_INTERFACE             = 0x04
+	DT_ENDPOINT              = 0x05
+	DT_BOS                   = 0x0f
+	DT_DEVICE_CAPABILITY     = 0x10
+	DT_HID                   = 0x21
+	DT_REPORT                = 0x22
+	DT_PHYSICAL              = 0x23
+	DT_HUB                   = 0x29
+	DT_SUPERSPEED_HUB        = 0x2a
+	DT_SS_ENDPOINT_COMPANION = 0x30
+)
+
+// Descriptor sizes per descriptor type.
+const (
+	DT_DEVICE_SIZE                = 18
+	DT_CONFIG_SIZE                = 9
+	DT_INTERFACE_SIZE             = 9
+	DT_ENDPOINT_SIZE              = 7
+	DT_ENDPOINT_AUDIO_SIZE        = 9
+	DT_SS_ENDPOINT_COMPANION_SIZE = 6
+	DT_BOS_SIZE                   = 5
+	DT_DEVICE_CAPABILITY_SIZE     = 3
+)
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the standard USB endpoint descriptor.
+// This descriptor is documented in section 9.6.6 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type Endpoint_Descriptor struct {
+	BLength          uint8  `json:"bLength"`
+	BDescriptorType  uint8  `json:"bDescriptorType"`
+	BEndpointAddress uint8  `json:"bEndpointAddress"`
+	BmAttributes     uint8  `json:"bmAttributes"`
+	WMaxPacketSize   uint16 `json:"wMaxPacketSize"`
+	BInterval        uint8  `json:"bInterval"`
+	BRefresh         uint8  `json:"bRefresh"`
+	BSynchAddress    uint8  `json:"bSynchAddress"`
+	Extra            []byte `json:"extra"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the standard USB interface descriptor.
+// This descriptor is documented in section 9.6.5 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type Interface_Descriptor struct {
+	BLength            uint8                  `json:"bLength"`
+	BDescriptorType    uint8                  `json:"bDescriptorType"`
+	BInterfaceNumber   uint8                  `json:"bInterfaceNumber"`
+	BAlternateSetting  uint8                  `json:"bAlternateSetting"`
+	BNumEndpoints      uint8                  `json:"bNumEndpoints"`
+	BInterfaceClass    uint8                  `json:"bInterfaceClass"`
+	BInterfaceSubClass uint8                  `json:"bInterfaceSubClass"`
+	BInterfaceProtocol uint8                  `json:"bInterfaceProtocol"`
+	IInterface         uint8                  `json:"iInterface"`
+	Endpoint           []*Endpoint_Descriptor `json:"endpoint"`
+	Extra              []byte                 `json:"extra"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A collection of alternate settings for a particular USB interface.
+type Interface struct {
+	Num_altsetting int                     `json:"num_altsetting"`
+	Altsetting     []*Interface_Descriptor `json:"altsetting"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the standard USB configuration descriptor.
+// This descriptor is documented in section 9.6.3 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type Config_Descriptor struct {
+	BLength             uint8        `json:"bLength"`
+	BDescriptorType     uint8        `json:"bDescriptorType"`
+	WTotalLength        uint16       `json:"wTotalLength"`
+	BNumInterfaces      uint8        `json:"bNumInterfaces"`
+	BConfigurationValue uint8        `json:"bConfigurationValue"`
+	IConfiguration      uint8        `json:"iConfiguration"`
+	BmAttributes        uint8        `json:"bmAttributes"`
+	MaxPower            uint8        `json:"MaxPower"`
+	Interface           []*Interface `json:"interface"`
+	Extra               []byte       `json:"extra"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the superspeed endpoint companion descriptor.
+// This descriptor is documented in section 9.6.7 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type SS_Endpoint_Companion_Descriptor struct {
+	BLength           uint8  `json:"bLength"`
+	BDescriptorType   uint8  `json:"bDescriptorType"`
+	BMaxBurst         uint8  `json:"bMaxBurst"`
+	BmAttributes      uint8  `json:"bmAttributes"`
+	WBytesPerInterval uint16 `json:"wBytesPerInterval"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A generic representation of a BOS Device Capability descriptor.
+// It is advised to check BDevCapabilityType and call the matching
+// Get_*_Descriptor function to get a structure fully matching the type.
+type BOS_Dev_Capability_Descriptor struct {
+	BLength             uint8  `json:"bLength"`
+	BDescriptorType     uint8  `json:"bDescriptorType"`
+	BDevCapabilityType  uint8  `json:"bDevCapabilityType"`
+	Dev_capability_data []byte `json:"dev_capability_data"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the Binary Device Object Store (BOS) descriptor.
+// This descriptor is documented in section 9.6.2 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type BOS_Descriptor struct {
+	BLength         uint8                            `json:"bLength"`
+	BDescriptorType uint8                            `json:"bDescriptorType"`
+	WTotalLength    uint16                           `json:"wTotalLength"`
+	Dev_capability  []*BOS_Dev_Capability_Descriptor `json:"dev_capability"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the USB 2.0 Extension descriptor
+// This descriptor is documented in section 9.6.2.1 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type USB_2_0_Extension_Descriptor struct {
+	BLength            uint8  `json:"bLength"`
+	BDescriptorType    uint8  `json:"bDescriptorType"`
+	BDevCapabilityType uint8  `json:"bDevCapabilityType"`
+	BmAttributes       uint32 `json:"bmAttributes"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the SuperSpeed USB Device Capability descriptor
+// This descriptor is documented in section 9.6.2.2 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type SS_USB_Device_Capability_Descriptor struct {
+	BLength               uint8  `json:"bLength"`
+	BDescriptorType       uint8  `json:"bDescriptorType"`
+	BDevCapabilityType    uint8  `json:"bDevCapabilityType"`
+	BmAttributes          uint8  `json:"bmAttributes"`
+	WSpeedSupported       uint16 `json:"wSpeedSupported"`
+	BFunctionalitySupport uint8  `json:"bFunctionalitySupport"`
+	BU1DevExitLat         uint8  `json:"bU1DevExitLat"`
+	BU2DevExitLat         uint16 `json:"bU2DevExitLat"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the Container ID descriptor.
+// This descriptor is documented in section 9.6.2.3 of the USB 3.0 specification.
+// All multiple-byte fields, except UUIDs, are represented in host-endian format.
+type Container_ID_Descriptor struct {
+	BLength            uint8  `json:"bLength"`
+	BDescriptorType    uint8  `json:"bDescriptorType"`
+	BDevCapabilityType uint8  `json:"bDevCapabilityType"`
+	BReserved          uint8  `json:"bReserved"`
+	ContainerID        []byte `json:"ContainerID"`
+}
+
+//-----------------------------------------------------------------------------
+
+// A structure representing the standard USB device descriptor.
+// This descriptor is documented in section 9.6.1 of the USB 3.0 specification.
+// All multiple-byte fields are represented in host-endian format.
+type Device_Descriptor struct {
+	BLength            uint8  `json:"bLength"`
+	BDescriptorType    uint8  `json:"bDescriptorType"`
+	BcdUSB             uint16 `json:"bcdUSB"`
+	BDeviceClass       uint8  `json:"bDeviceClass"`
+	BDeviceSubClass    uint8  `json:"bDeviceSubClass"`
+	BDeviceProtocol    uint8  `json:"bDeviceProtocol"`
+	BMaxPacketSize0    uint8  `json:"bMaxPacketSize0"`
+	IdVendor           uint16 `json:"idVendor"`
+	IdProduct          uint16 `json:"idProduct"`
+	BcdDevice          uint16 `json:"bcdDevice"`
+	IManufacturer      uint8  `json:"iManufacturer"`
+	IProduct           uint8  `json:"iProduct"`
+	ISerialNumber      uint8  `json:"iSerialNumber"`
+	BNumConfigurations uint8  `json:"bNumConfigurations"`
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,355 @@
+//-----------------------------------------------------------------------------
+/*
+
+Test functions for the descriptor parser and encoder
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+
+// a USB MIDI device
+var device_bytes = []byte{
+	0x12, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x40, 0x4c, 0x05, 0x57, 0x01, 0x00, 0x01, 0x01, 0x02, 0x00, 0x01,
+}
+
+var config_bytes = []byte{
+	// config
+	0x09, 0x02, 0x65, 0x00, 0x02, 0x01, 0x00, 0x80, 0x32,
+	// audio control interface
+	0x09, 0x04, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00,
+	0x09, 0x24, 0x01, 0x00, 0x01, 0x09, 0x00, 0x01, 0x01,
+	// midi streaming interface
+	0x09, 0x04, 0x01, 0x00, 0x02, 0x01, 0x03, 0x00, 0x00,
+	0x07, 0x24, 0x01, 0x00, 0x01, 0x41, 0x00,
+	0x06, 0x24, 0x02, 0x01, 0x01, 0x00,
+	0x06, 0x24, 0x02, 0x02, 0x02, 0x00,
+	0x09, 0x24, 0x03, 0x01, 0x03, 0x01, 0x02, 0x01, 0x00,
+	0x09, 0x24, 0x03, 0x02, 0x04, 0x01, 0x01, 0x01, 0x00,
+	// bulk out endpoint (audio size)
+	0x09, 0x05, 0x01, 0x02, 0x40, 0x00, 0x00, 0x00, 0x00,
+	0x05, 0x25, 0x01, 0x01, 0x01,
+	// bulk in endpoint (audio size)
+	0x09, 0x05, 0x81, 0x02, 0x40, 0x00, 0x00, 0x00, 0x00,
+	0x05, 0x25, 0x01, 0x01, 0x03,
+}
+
+var bos_bytes = []byte{
+	0x05, 0x0f, 0x16, 0x00, 0x02,
+	0x07, 0x10, 0x02, 0x06, 0x00, 0x00, 0x00,
+	0x0a, 0x10, 0x03, 0x00, 0x0e, 0x00, 0x01, 0x0a, 0xff, 0x07,
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Device_Descriptor(t *testing.T) {
+	dd, err := Parse_Device_Descriptor(device_bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd.IdVendor != 0x054c || dd.IdProduct != 0x0157 || dd.BcdUSB != 0x0200 {
+		t.Error("FAIL")
+	}
+	if !bytes.Equal(Encode_Device_Descriptor(dd), device_bytes) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Config_Descriptor(t *testing.T) {
+	cd, err := Parse_Config_Descriptor(config_bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cd.Interface) != 2 || len(cd.Extra) != 0 {
+		t.Fatal("FAIL")
+	}
+	ac := cd.Interface[0].Altsetting[0]
+	if ac.BInterfaceSubClass != 1 || len(ac.Extra) != 9 || len(ac.Endpoint) != 0 {
+		t.Error("FAIL")
+	}
+	ms := cd.Interface[1].Altsetting[0]
+	if len(ms.Extra) != 37 || len(ms.Endpoint) != 2 {
+		t.Fatal("FAIL")
+	}
+	ep := ms.Endpoint[1]
+	if ep.BEndpointAddress != 0x81 || ep.WMaxPacketSize != 64 || len(ep.Extra) != 5 {
+		t.Error("FAIL")
+	}
+	if !bytes.Equal(Encode_Config_Descriptor(cd), config_bytes) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_BOS_Descriptor(t *testing.T) {
+	bos, err := Parse_BOS_Descriptor(bos_bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bos.Dev_capability) != 2 || bos.Dev_capability[1].BDevCapabilityType != 3 {
+		t.Error("FAIL")
+	}
+	if !bytes.Equal(Encode_BOS_Descriptor(bos), bos_bytes) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Complete(t *testing.T) {
+	cd, _ := Parse_Config_Descriptor(config_bytes)
+	cd.WTotalLength = 0
+	cd.BNumInterfaces = 0
+	cd.Interface[1].Altsetting[0].BNumEndpoints = 0
+	Complete_Config_Descriptor(cd)
+	if !bytes.Equal(Encode_Config_Descriptor(cd), config_bytes) {
+		t.Error("FAIL")
+	}
+	bos, _ := Parse_BOS_Descriptor(bos_bytes)
+	bos.WTotalLength = 0
+	Complete_BOS_Descriptor(bos)
+	if !bytes.Equal(Encode_BOS_Descriptor(bos), bos_bytes) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Parse_Error(t *testing.T) {
+	// truncated config
+	_, err := Parse_Config_Descriptor(config_bytes[:50])
+	if err == nil {
+		t.Error("FAIL")
+	}
+	// endpoint bLength overruns wTotalLength
+	b := append([]byte{}, config_bytes...)
+	b[2] -= 1
+	_, err = Parse_Config_Descriptor(b)
+	if err == nil {
+		t.Error("FAIL")
+	}
+	// missing endpoint
+	b = append([]byte{}, config_bytes[:82]...)
+	b[2] = 82
+	_, err = Parse_Config_Descriptor(b)
+	if pe, ok := err.(*Parse_Error); !ok || pe.Offset != 82 {
+		t.Error("FAIL", err)
+	}
+	// wrong type
+	_, err = Parse_Device_Descriptor(config_bytes)
+	if err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Decode_Extra(t *testing.T) {
+	cd, _ := Parse_Config_Descriptor(config_bytes)
+	ms := cd.Interface[1].Altsetting[0]
+	list, err := Decode_Interface_Extra(ms)
+	if err != nil || len(list) != 5 {
+		t.Fatal("FAIL", err)
+	}
+	if hdr, ok := list[0].(*MS_Header_Descriptor); !ok || hdr.BcdMSC != 0x0100 {
+		t.Error("FAIL")
+	}
+	if jack, ok := list[3].(*MS_Out_Jack_Descriptor); !ok || len(jack.Sources) != 1 || jack.Sources[0].BaSourceID != 2 {
+		t.Error("FAIL")
+	}
+	list, err = Decode_Endpoint_Extra(ms, ms.Endpoint[1])
+	if err != nil || len(list) != 1 {
+		t.Fatal("FAIL", err)
+	}
+	if ep, ok := list[0].(*MS_Endpoint_Descriptor); !ok || len(ep.BaAssocJackID) != 1 || ep.BaAssocJackID[0] != 3 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Decode_Class(t *testing.T) {
+	// 0x21 is a HID descriptor for HID interfaces and a DFU descriptor for DFU interfaces
+	hid := []byte{0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22, 0x3f, 0x00}
+	list, err := Decode_Extra(int(CLASS_HID), 0, 0, hid)
+	if d, ok := list[0].(*HID_Descriptor); err != nil || !ok || d.Descriptors[0].WDescriptorLength != 63 {
+		t.Error("FAIL")
+	}
+	dfu := []byte{0x09, 0x21, 0x0b, 0xff, 0x00, 0x00, 0x04, 0x1a, 0x01}
+	list, err = Decode_Extra(int(CLASS_APPLICATION), SUBCLASS_DFU, 0, dfu)
+	if d, ok := list[0].(*DFU_Functional_Descriptor); err != nil || !ok || d.WTransferSize != 1024 || d.BcdDFUVersion != 0x011a {
+		t.Error("FAIL")
+	}
+	// unknown descriptors are generic
+	list, err = Decode_Extra(int(CLASS_VENDOR_SPEC), 0, 0, dfu)
+	if d, ok := list[0].(*Generic_Descriptor); err != nil || !ok || len(d.Data) != 7 {
+		t.Error("FAIL")
+	}
+	// audio 2.0 interfaces don't use the audio 1.0 layouts
+	ac := []byte{0x09, 0x24, 0x01, 0x00, 0x02, 0x08, 0x40, 0x00, 0x00}
+	list, err = Decode_Extra(int(CLASS_AUDIO), SUBCLASS_AUDIOCONTROL, PROTOCOL_UAC1, ac)
+	if _, ok := list[0].(*AC_Header_Descriptor); err != nil || !ok {
+		t.Error("FAIL")
+	}
+	list, err = Decode_Extra(int(CLASS_AUDIO), SUBCLASS_AUDIOCONTROL, PROTOCOL_UAC2, ac)
+	if _, ok := list[0].(*Generic_Descriptor); err != nil || !ok {
+		t.Error("FAIL")
+	}
+	// truncated class descriptor
+	_, err = Decode_Extra(int(CLASS_HID), 0, 0, []byte{0x04, 0x21, 0x11, 0x01})
+	if err == nil {
+		t.Error("FAIL")
+	}
+}
+
+type vendor_descriptor struct {
+	Header
+	Value uint8
+}
+
+func Test_Register_Decoder(t *testing.T) {
+	key := Decoder_Key{int(CLASS_VENDOR_SPEC), 0x42, MATCH_ANY, 0x41}
+	Register_Decoder(key, func(b []byte) (Class_Descriptor, error) {
+		return &vendor_descriptor{Header{b[0], b[1]}, b[2]}, nil
+	})
+	defer Register_Decoder(key, nil)
+	list, err := Decode_Extra(int(CLASS_VENDOR_SPEC), 0x42, 0, []byte{0x03, 0x41, 0x07})
+	if d, ok := list[0].(*vendor_descriptor); err != nil || !ok || d.Value != 7 || d.Descriptor_Type() != 0x41 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_JSON(t *testing.T) {
+	cd, _ := Parse_Config_Descriptor(config_bytes)
+	b, err := json.Marshal(cd.Interface[1].Altsetting[0].Endpoint[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(b)
+	for _, x := range []string{`"bEndpointAddress":129`, `"direction":"IN"`, `"transfer_type":"Bulk"`, `"extra":"0525010103"`} {
+		if !strings.Contains(s, x) {
+			t.Error("FAIL", x)
+		}
+	}
+	b, _ = json.Marshal(cd)
+	if !strings.Contains(string(b), `"bInterfaceClass_name":"Audio"`) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_YAML(t *testing.T) {
+	x := map[string]interface{}{
+		"a": []interface{}{1, map[string]interface{}{"b": "x", "c": []int{}}},
+		"d": map[int]string{1: "no"},
+		"e": nil,
+	}
+	b, err := Marshal_YAML(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `a:
+  - 1
+  - b: "x"
+    c: []
+d:
+  "1": "no"
+e: null
+`
+	if string(b) != expect {
+		t.Errorf("FAIL\n%s", b)
+	}
+}
+
+func Test_Endpoint_Accessors(t *testing.T) {
+	ep := &Endpoint_Descriptor{BEndpointAddress: 0x83, BmAttributes: 0x15, WMaxPacketSize: 0x1400}
+	if ep.Direction() != DIRECTION_IN || ep.Number() != 3 {
+		t.Error("FAIL")
+	}
+	if ep.TransferType() != TRANSFER_TYPE_ISOCHRONOUS || ep.SyncType() != ISO_SYNC_TYPE_ASYNC || ep.UsageType() != ISO_USAGE_TYPE_FEEDBACK {
+		t.Error("FAIL")
+	}
+	// 3 transactions of 1024 bytes per microframe
+	if ep.MaxPacketSize() != 3072 {
+		t.Error("FAIL", ep.MaxPacketSize())
+	}
+	// the multiplier does not apply to bulk endpoints
+	ep = &Endpoint_Descriptor{BEndpointAddress: 0x01, BmAttributes: 0x02, WMaxPacketSize: 0x0200}
+	if ep.Direction() != DIRECTION_OUT || ep.TransferType() != TRANSFER_TYPE_BULK || ep.MaxPacketSize() != 512 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Enum_String(t *testing.T) {
+	tests := []struct {
+		x      fmt.Stringer
+		expect string
+	}{
+		{Class(0x08), "Mass Storage"},
+		{Class(0x42), "Class(0x42)"},
+		{DIRECTION_IN, "IN"},
+		{TRANSFER_TYPE_INTERRUPT, "Interrupt"},
+		{Transfer_Type(9), "Transfer_Type(9)"},
+		{ISO_SYNC_TYPE_ADAPTIVE, "Adaptive"},
+		{ISO_USAGE_TYPE_IMPLICIT, "Implicit feedback Data"},
+	}
+	for _, x := range tests {
+		if x.x.String() != x.expect {
+			t.Error("FAIL", x.x.String())
+		}
+	}
+}
+
+func Test_String_Descriptor(t *testing.T) {
+	for _, x := range []string{"", "USB Programmer", "USB-Programmiergerät", "書き込み装置", "\U0001f50c"} {
+		b := Encode_String_Descriptor(x)
+		if int(b[0]) != len(b) || b[1] != DT_STRING {
+			t.Error("FAIL")
+		}
+		s, err := Parse_String_Descriptor(b)
+		if err != nil || s != x {
+			t.Error("FAIL", s)
+		}
+	}
+	// Ä as UTF-16LE, bLength is longer than the data
+	s, err := Parse_String_Descriptor([]byte{0x10, DT_STRING, 0xc4, 0x00})
+	if err != nil || s != "Ä" {
+		t.Error("FAIL")
+	}
+	_, err = Parse_String_Descriptor([]byte{0x04, DT_DEVICE, 0x41, 0x00})
+	if err == nil {
+		t.Error("FAIL")
+	}
+	// a long string is truncated without splitting a surrogate pair
+	b := Encode_String_Descriptor("x" + strings.Repeat("\U0001f50c", 100))
+	if len(b) != 252 {
+		t.Error("FAIL", len(b))
+	}
+	langids, err := Parse_Language_Descriptor(Encode_Language_Descriptor([]uint16{LANGID_ENGLISH_US, LANGID_JAPANESE}))
+	if err != nil || len(langids) != 2 || langids[1] != LANGID_JAPANESE {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Pick_Language(t *testing.T) {
+	tests := []struct {
+		supported, preferred []uint16
+		expect               uint16
+		ok                   bool
+	}{
+		{[]uint16{LANGID_ENGLISH_US, LANGID_GERMAN, LANGID_JAPANESE}, []uint16{LANGID_JAPANESE, LANGID_GERMAN}, LANGID_JAPANESE, true},
+		{[]uint16{LANGID_ENGLISH_US, LANGID_GERMAN}, []uint16{LANGID_JAPANESE, LANGID_GERMAN}, LANGID_GERMAN, true},
+		// Austrian German matches German
+		{[]uint16{LANGID_ENGLISH_US, LANGID_GERMAN}, []uint16{0x0c07}, LANGID_GERMAN, true},
+		{[]uint16{LANGID_ENGLISH_UK}, []uint16{LANGID_FRENCH}, LANGID_ENGLISH_UK, true},
+		{nil, []uint16{LANGID_FRENCH}, 0, false},
+	}
+	for _, x := range tests {
+		langid, ok := Pick_Language(x.supported, x.preferred)
+		if langid != x.expect || ok != x.ok {
+			t.Errorf("FAIL 0x%04x", langid)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,152 @@
+//-----------------------------------------------------------------------------
+/*
+
+Encode descriptors as raw bytes
+
+Header fields are written as they are, so a parsed descriptor encodes back
+to the same bytes. Use the Complete_* functions to fill in the lengths and
+counts of a descriptor built in code.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import "encoding/binary"
+
+//-----------------------------------------------------------------------------
+
+// return a buffer for a descriptor of at least size bytes
+func header(length uint8, size int) []byte {
+	if int(length) > size {
+		size = int(length)
+	}
+	b := make([]byte, size)
+	b[0] = length
+	return b
+}
+
+// Encode a device descriptor.
+func Encode_Device_Descriptor(x *Device_Descriptor) []byte {
+	b := header(x.BLength, DT_DEVICE_SIZE)
+	b[1] = x.BDescriptorType
+	binary.LittleEndian.PutUint16(b[2:], x.BcdUSB)
+	b[4] = x.BDeviceClass
+	b[5] = x.BDeviceSubClass
+	b[6] = x.BDeviceProtocol
+	b[7] = x.BMaxPacketSize0
+	binary.LittleEndian.PutUint16(b[8:], x.IdVendor)
+	binary.LittleEndian.PutUint16(b[10:], x.IdProduct)
+	binary.LittleEndian.PutUint16(b[12:], x.BcdDevice)
+	b[14] = x.IManufacturer
+	b[15] = x.IProduct
+	b[16] = x.ISerialNumber
+	b[17] = x.BNumConfigurations
+	return b
+}
+
+// Encode an endpoint descriptor followed by its extra bytes.
+func Encode_Endpoint_Descriptor(x *Endpoint_Descriptor) []byte {
+	b := header(x.BLength, DT_ENDPOINT_SIZE)
+	b[1] = x.BDescriptorType
+	b[2] = x.BEndpointAddress
+	b[3] = x.BmAttributes
+	binary.LittleEndian.PutUint16(b[4:], x.WMaxPacketSize)
+	b[6] = x.BInterval
+	if len(b) >= DT_ENDPOINT_AUDIO_SIZE {
+		b[7] = x.BRefresh
+		b[8] = x.BSynchAddress
+	}
+	return append(b, x.Extra...)
+}
+
+// Encode an interface descriptor followed by its extra bytes and endpoints.
+func Encode_Interface_Descriptor(x *Interface_Descriptor) []byte {
+	b := header(x.BLength, DT_INTERFACE_SIZE)
+	b[1] = x.BDescriptorType
+	b[2] = x.BInterfaceNumber
+	b[3] = x.BAlternateSetting
+	b[4] = x.BNumEndpoints
+	b[5] = x.BInterfaceClass
+	b[6] = x.BInterfaceSubClass
+	b[7] = x.BInterfaceProtocol
+	b[8] = x.IInterface
+	b = append(b, x.Extra...)
+	for _, ep := range x.Endpoint {
+		b = append(b, Encode_Endpoint_Descriptor(ep)...)
+	}
+	return b
+}
+
+// Encode a configuration descriptor followed by its extra bytes and interfaces.
+func Encode_Config_Descriptor(x *Config_Descriptor) []byte {
+	b := header(x.BLength, DT_CONFIG_SIZE)
+	b[1] = x.BDescriptorType
+	binary.LittleEndian.PutUint16(b[2:], x.WTotalLength)
+	b[4] = x.BNumInterfaces
+	b[5] = x.BConfigurationValue
+	b[6] = x.IConfiguration
+	b[7] = x.BmAttributes
+	b[8] = x.MaxPower
+	b = append(b, x.Extra...)
+	for _, itf := range x.Interface {
+		for _, id := range itf.Altsetting {
+			b = append(b, Encode_Interface_Descriptor(id)...)
+		}
+	}
+	return b
+}
+
+// Encode a BOS descriptor followed by its device capabilities.
+func Encode_BOS_Descriptor(x *BOS_Descriptor) []byte {
+	b := header(x.BLength, DT_BOS_SIZE)
+	b[1] = x.BDescriptorType
+	binary.LittleEndian.PutUint16(b[2:], x.WTotalLength)
+	b[4] = uint8(len(x.Dev_capability))
+	for _, dev_cap := range x.Dev_capability {
+		c := header(dev_cap.BLength, DT_DEVICE_CAPABILITY_SIZE+len(dev_cap.Dev_capability_data))
+		c[1] = dev_cap.BDescriptorType
+		c[2] = dev_cap.BDevCapabilityType
+		copy(c[3:], dev_cap.Dev_capability_data)
+		b = append(b, c...)
+	}
+	return b
+}
+
+//-----------------------------------------------------------------------------
+
+// Set the type, length and count fields of a configuration descriptor tree
+// from its contents.
+func Complete_Config_Descriptor(x *Config_Descriptor) {
+	x.BLength = DT_CONFIG_SIZE
+	x.BDescriptorType = DT_CONFIG
+	x.BNumInterfaces = uint8(len(x.Interface))
+	for _, itf := range x.Interface {
+		itf.Num_altsetting = len(itf.Altsetting)
+		for _, id := range itf.Altsetting {
+			id.BLength = DT_INTERFACE_SIZE
+			id.BDescriptorType = DT_INTERFACE
+			id.BNumEndpoints = uint8(len(id.Endpoint))
+			for _, ep := range id.Endpoint {
+				if ep.BLength != DT_ENDPOINT_AUDIO_SIZE {
+					ep.BLength = DT_ENDPOINT_SIZE
+				}
+				ep.BDescriptorType = DT_ENDPOINT
+			}
+		}
+	}
+	x.WTotalLength = uint16(len(Encode_Config_Descriptor(x)))
+}
+
+// Set the type, length and count fields of a BOS descriptor from its contents.
+func Complete_BOS_Descriptor(x *BOS_Descriptor) {
+	x.BLength = DT_BOS_SIZE
+	x.BDescriptorType = DT_BOS
+	for _, dev_cap := range x.Dev_capability {
+		dev_cap.BLength = uint8(DT_DEVICE_CAPABILITY_SIZE + len(dev_cap.Dev_capability_data))
+		dev_cap.BDescriptorType = DT_DEVICE_CAPABILITY
+	}
+	x.WTotalLength = uint16(len(Encode_BOS_Descriptor(x)))
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,219 @@
+//-----------------------------------------------------------------------------
+/*
+
+Enumerated descriptor values
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import "fmt"
+
+//-----------------------------------------------------------------------------
+
+// Class is a USB device or interface class code.
+type Class uint8
+
+// Class codes
+const (
+	CLASS_PER_INTERFACE       Class = 0x00 // the class is given by each interface
+	CLASS_AUDIO               Class = 0x01
+	CLASS_COMM                Class = 0x02
+	CLASS_HID                 Class = 0x03
+	CLASS_PHYSICAL            Class = 0x05
+	CLASS_IMAGE               Class = 0x06
+	CLASS_PTP                 Class = 0x06 // legacy name of CLASS_IMAGE
+	CLASS_PRINTER             Class = 0x07
+	CLASS_MASS_STORAGE        Class = 0x08
+	CLASS_HUB                 Class = 0x09
+	CLASS_DATA                Class = 0x0a
+	CLASS_SMART_CARD          Class = 0x0b
+	CLASS_CONTENT_SECURITY    Class = 0x0d
+	CLASS_VIDEO               Class = 0x0e
+	CLASS_PERSONAL_HEALTHCARE Class = 0x0f
+	CLASS_DIAGNOSTIC_DEVICE   Class = 0xdc
+	CLASS_WIRELESS            Class = 0xe0
+	CLASS_APPLICATION         Class = 0xfe
+	CLASS_VENDOR_SPEC         Class = 0xff
+)
+
+var class_names = map[Class]string{
+	0x00: "(Defined at Interface level)",
+	0x01: "Audio",
+	0x02: "Communications",
+	0x03: "Human Interface Device",
+	0x05: "Physical Interface Device",
+	0x06: "Imaging",
+	0x07: "Printer",
+	0x08: "Mass Storage",
+	0x09: "Hub",
+	0x0a: "CDC Data",
+	0x0b: "Chip/SmartCard",
+	0x0d: "Content Security",
+	0x0e: "Video",
+	0x0f: "Personal Healthcare",
+	0x10: "Audio/Video Devices",
+	0x11: "Billboard Device",
+	0xdc: "Diagnostic",
+	0xe0: "Wireless",
+	0xef: "Miscellaneous Device",
+	0xfe: "Application Specific Interface",
+	0xff: "Vendor Specific Class",
+}
+
+func (c Class) String() string {
+	if s, ok := class_names[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("Class(0x%02x)", uint8(c))
+}
+
+//-----------------------------------------------------------------------------
+
+// Direction is the direction of an endpoint, bit 7 of the endpoint address.
+type Direction uint8
+
+const (
+	DIRECTION_OUT Direction = 0x00 // host-to-device
+	DIRECTION_IN  Direction = 0x80 // device-to-host
+)
+
+func (d Direction) String() string {
+	if d == DIRECTION_IN {
+		return "IN"
+	}
+	return "OUT"
+}
+
+//-----------------------------------------------------------------------------
+
+// Transfer_Type is the transfer type of an endpoint, bits 0:1 of bmAttributes.
+type Transfer_Type uint8
+
+const (
+	TRANSFER_TYPE_CONTROL     Transfer_Type = 0
+	TRANSFER_TYPE_ISOCHRONOUS Transfer_Type = 1
+	TRANSFER_TYPE_BULK        Transfer_Type = 2
+	TRANSFER_TYPE_INTERRUPT   Transfer_Type = 3
+	TRANSFER_TYPE_BULK_STREAM Transfer_Type = 4 // libusb transfers only
+)
+
+var transfer_type_names = [...]string{"Control", "Isochronous", "Bulk", "Interrupt", "Bulk Stream"}
+
+func (t Transfer_Type) String() string {
+	if int(t) < len(transfer_type_names) {
+		return transfer_type_names[t]
+	}
+	return fmt.Sprintf("Transfer_Type(%d)", uint8(t))
+}
+
+//-----------------------------------------------------------------------------
+
+// Sync_Type is the synchronization type of an isochronous endpoint, bits 2:3 of bmAttributes.
+type Sync_Type uint8
+
+const (
+	ISO_SYNC_TYPE_NONE     Sync_Type = 0
+	ISO_SYNC_TYPE_ASYNC    Sync_Type = 1
+	ISO_SYNC_TYPE_ADAPTIVE Sync_Type = 2
+	ISO_SYNC_TYPE_SYNC     Sync_Type = 3
+)
+
+var sync_type_names = [4]string{"None", "Asynchronous", "Adaptive", "Synchronous"}
+
+func (t Sync_Type) String() string {
+	return sync_type_names[t&3]
+}
+
+//-----------------------------------------------------------------------------
+
+// Usage_Type is the usage type of an isochronous endpoint, bits 4:5 of bmAttributes.
+type Usage_Type uint8
+
+const (
+	ISO_USAGE_TYPE_DATA     Usage_Type = 0
+	ISO_USAGE_TYPE_FEEDBACK Usage_Type = 1
+	ISO_USAGE_TYPE_IMPLICIT Usage_Type = 2
+)
+
+var usage_type_names = [4]string{"Data", "Feedback", "Implicit feedback Data", "Reserved"}
+
+func (t Usage_Type) String() string {
+	return usage_type_names[t&3]
+}
+
+//-----------------------------------------------------------------------------
+// endpoint accessors
+
+// Direction returns the direction of the endpoint.
+func (x *Endpoint_Descriptor) Direction() Direction {
+	return Direction(x.BEndpointAddress & 0x80)
+}
+
+// Number returns the endpoint number.
+func (x *Endpoint_Descriptor) Number() int {
+	return int(x.BEndpointAddress & 0x0f)
+}
+
+// TransferType returns the transfer type of the endpoint.
+func (x *Endpoint_Descriptor) TransferType() Transfer_Type {
+	return Transfer_Type(x.BmAttributes & 3)
+}
+
+// SyncType returns the synchronization type of an isochronous endpoint.
+func (x *Endpoint_Descriptor) SyncType() Sync_Type {
+	return Sync_Type((x.BmAttributes >> 2) & 3)
+}
+
+// UsageType returns the usage type of an isochronous endpoint.
+func (x *Endpoint_Descriptor) UsageType() Usage_Type {
+	return Usage_Type((x.BmAttributes >> 4) & 3)
+}
+
+// MaxPacketSize returns the maximum number of bytes per service interval.
+// For high-bandwidth isochronous and interrupt endpoints this is the packet
+// size (bits 0:10 of wMaxPacketSize) times the transactions per microframe
+// (bits 11:12, plus one).
+func (x *Endpoint_Descriptor) MaxPacketSize() int {
+	size := int(x.WMaxPacketSize & 0x7ff)
+	switch x.TransferType() {
+	case TRANSFER_TYPE_ISOCHRONOUS, TRANSFER_TYPE_INTERRUPT:
+		size *= int((x.WMaxPacketSize>>11)&3) + 1
+	}
+	return size
+}
+
+//-----------------------------------------------------------------------------
+// class accessors
+
+// Class returns the device class.
+func (x *Device_Descriptor) Class() Class {
+	return Class(x.BDeviceClass)
+}
+
+// Class returns the interface class.
+func (x *Interface_Descriptor) Class() Class {
+	return Class(x.BInterfaceClass)
+}
+
+//-----------------------------------------------------------------------------
+
+var capability_names = map[uint8]string{
+	0x01: "Wireless USB",
+	0x02: "USB 2.0 Extension",
+	0x03: "SuperSpeed USB",
+	0x04: "Container ID",
+	0x05: "Platform",
+	0x0a: "SuperSpeedPlus USB",
+}
+
+// return the name of a device capability type
+func capability_name(t uint8) string {
+	if s, ok := capability_names[t]; ok {
+		return s
+	}
+	return "Unknown"
+}
+
+//-----------------------------------------------------------------------------
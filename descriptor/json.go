@@ -0,0 +1,112 @@
+//-----------------------------------------------------------------------------
+/*
+
+JSON encoding of descriptors
+
+Fields use the names from the USB specification. Enumerated values are
+followed by their decoded names and byte blobs are written as hex.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+//-----------------------------------------------------------------------------
+
+// bytes written as a hex string
+type hex_bytes []byte
+
+func (x hex_bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(x))
+}
+
+//-----------------------------------------------------------------------------
+
+func (x Endpoint_Descriptor) MarshalJSON() ([]byte, error) {
+	type raw Endpoint_Descriptor
+	return json.Marshal(struct {
+		raw
+		Direction     string    `json:"direction"`
+		Transfer_Type string    `json:"transfer_type"`
+		Sync_Type     string    `json:"sync_type"`
+		Usage_Type    string    `json:"usage_type"`
+		Extra         hex_bytes `json:"extra"`
+	}{
+		raw(x),
+		x.Direction().String(),
+		x.TransferType().String(),
+		x.SyncType().String(),
+		x.UsageType().String(),
+		x.Extra,
+	})
+}
+
+func (x Interface_Descriptor) MarshalJSON() ([]byte, error) {
+	type raw Interface_Descriptor
+	return json.Marshal(struct {
+		raw
+		Class_Name string    `json:"bInterfaceClass_name"`
+		Extra      hex_bytes `json:"extra"`
+	}{
+		raw(x),
+		x.Class().String(),
+		x.Extra,
+	})
+}
+
+func (x Config_Descriptor) MarshalJSON() ([]byte, error) {
+	type raw Config_Descriptor
+	return json.Marshal(struct {
+		raw
+		Self_Powered  bool      `json:"self_powered"`
+		Remote_Wakeup bool      `json:"remote_wakeup"`
+		Extra         hex_bytes `json:"extra"`
+	}{
+		raw(x),
+		x.BmAttributes&0x40 != 0,
+		x.BmAttributes&0x20 != 0,
+		x.Extra,
+	})
+}
+
+func (x BOS_Dev_Capability_Descriptor) MarshalJSON() ([]byte, error) {
+	type raw BOS_Dev_Capability_Descriptor
+	return json.Marshal(struct {
+		raw
+		Type_Name string    `json:"bDevCapabilityType_name"`
+		Data      hex_bytes `json:"dev_capability_data"`
+	}{
+		raw(x),
+		capability_name(x.BDevCapabilityType),
+		x.Dev_capability_data,
+	})
+}
+
+func (x Container_ID_Descriptor) MarshalJSON() ([]byte, error) {
+	type raw Container_ID_Descriptor
+	return json.Marshal(struct {
+		raw
+		ContainerID hex_bytes `json:"ContainerID"`
+	}{
+		raw(x),
+		x.ContainerID,
+	})
+}
+
+func (x Device_Descriptor) MarshalJSON() ([]byte, error) {
+	type raw Device_Descriptor
+	return json.Marshal(struct {
+		raw
+		Class_Name string `json:"bDeviceClass_name"`
+	}{
+		raw(x),
+		x.Class().String(),
+	})
+}
+
+//-----------------------------------------------------------------------------
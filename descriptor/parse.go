@@ -0,0 +1,268 @@
+//-----------------------------------------------------------------------------
+/*
+
+Parse raw descriptor bytes
+
+The parser follows libusb: class and vendor specific descriptors are kept
+in the Extra bytes of the preceding config, interface or endpoint descriptor.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------
+
+// Parse_Error reports a malformed descriptor.
+type Parse_Error struct {
+	Offset int // byte offset of the descriptor in the buffer
+	Msg    string
+}
+
+func (e *Parse_Error) Error() string {
+	return fmt.Sprintf("descriptor: offset %d: %s", e.Offset, e.Msg)
+}
+
+//-----------------------------------------------------------------------------
+
+type parser struct {
+	buf []byte
+	ofs int
+}
+
+func (p *parser) error(format string, a ...interface{}) error {
+	return &Parse_Error{Offset: p.ofs, Msg: fmt.Sprintf(format, a...)}
+}
+
+// return true if all bytes have been parsed
+func (p *parser) done() bool {
+	return p.ofs >= len(p.buf)
+}
+
+// return the length and type of the next descriptor
+func (p *parser) peek() (int, uint8, error) {
+	if len(p.buf)-p.ofs < 2 {
+		return 0, 0, p.error("truncated descriptor header")
+	}
+	n := int(p.buf[p.ofs])
+	if n < 2 {
+		return 0, 0, p.error("bLength %d is too short", n)
+	}
+	if p.ofs+n > len(p.buf) {
+		return 0, 0, p.error("bLength %d overruns the buffer", n)
+	}
+	return n, p.buf[p.ofs+1], nil
+}
+
+// return the next descriptor, checking its type and minimum length
+func (p *parser) next(dtype uint8, size int) ([]byte, error) {
+	n, t, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if t != dtype {
+		return nil, p.error("bDescriptorType 0x%02x, expected 0x%02x", t, dtype)
+	}
+	if n < size {
+		return nil, p.error("bLength %d, expected at least %d", n, size)
+	}
+	b := p.buf[p.ofs : p.ofs+n]
+	p.ofs += n
+	return b, nil
+}
+
+// return the bytes of any descriptors up to the next standard descriptor
+func (p *parser) extra() ([]byte, error) {
+	start := p.ofs
+	for !p.done() {
+		n, t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if t == DT_INTERFACE || t == DT_ENDPOINT || t == DT_CONFIG || t == DT_DEVICE {
+			break
+		}
+		p.ofs += n
+	}
+	return append([]byte{}, p.buf[start:p.ofs]...), nil
+}
+
+func (p *parser) endpoint() (*Endpoint_Descriptor, error) {
+	b, err := p.next(DT_ENDPOINT, DT_ENDPOINT_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	ep := &Endpoint_Descriptor{
+		BLength:          b[0],
+		BDescriptorType:  b[1],
+		BEndpointAddress: b[2],
+		BmAttributes:     b[3],
+		WMaxPacketSize:   binary.LittleEndian.Uint16(b[4:]),
+		BInterval:        b[6],
+	}
+	if len(b) >= DT_ENDPOINT_AUDIO_SIZE {
+		ep.BRefresh = b[7]
+		ep.BSynchAddress = b[8]
+	}
+	ep.Extra, err = p.extra()
+	if err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+func (p *parser) interface_descriptor() (*Interface_Descriptor, error) {
+	b, err := p.next(DT_INTERFACE, DT_INTERFACE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	id := &Interface_Descriptor{
+		BLength:            b[0],
+		BDescriptorType:    b[1],
+		BInterfaceNumber:   b[2],
+		BAlternateSetting:  b[3],
+		BNumEndpoints:      b[4],
+		BInterfaceClass:    b[5],
+		BInterfaceSubClass: b[6],
+		BInterfaceProtocol: b[7],
+		IInterface:         b[8],
+	}
+	id.Extra, err = p.extra()
+	if err != nil {
+		return nil, err
+	}
+	id.Endpoint = make([]*Endpoint_Descriptor, id.BNumEndpoints)
+	for i := range id.Endpoint {
+		id.Endpoint[i], err = p.endpoint()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Parse a device descriptor.
+func Parse_Device_Descriptor(buf []byte) (*Device_Descriptor, error) {
+	p := &parser{buf: buf}
+	b, err := p.next(DT_DEVICE, DT_DEVICE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	return &Device_Descriptor{
+		BLength:            b[0],
+		BDescriptorType:    b[1],
+		BcdUSB:             binary.LittleEndian.Uint16(b[2:]),
+		BDeviceClass:       b[4],
+		BDeviceSubClass:    b[5],
+		BDeviceProtocol:    b[6],
+		BMaxPacketSize0:    b[7],
+		IdVendor:           binary.LittleEndian.Uint16(b[8:]),
+		IdProduct:          binary.LittleEndian.Uint16(b[10:]),
+		BcdDevice:          binary.LittleEndian.Uint16(b[12:]),
+		IManufacturer:      b[14],
+		IProduct:           b[15],
+		ISerialNumber:      b[16],
+		BNumConfigurations: b[17],
+	}, nil
+}
+
+// Parse an endpoint descriptor and the class specific descriptors following it.
+// Returns the number of bytes parsed.
+func Parse_Endpoint_Descriptor(buf []byte) (*Endpoint_Descriptor, int, error) {
+	p := &parser{buf: buf}
+	ep, err := p.endpoint()
+	return ep, p.ofs, err
+}
+
+// Parse an interface descriptor, its endpoints, and the class specific descriptors following them.
+// Returns the number of bytes parsed.
+func Parse_Interface_Descriptor(buf []byte) (*Interface_Descriptor, int, error) {
+	p := &parser{buf: buf}
+	id, err := p.interface_descriptor()
+	return id, p.ofs, err
+}
+
+// Parse a configuration descriptor and all of its interfaces and endpoints.
+// Consecutive interface descriptors with the same interface number are
+// the alternate settings of one Interface.
+func Parse_Config_Descriptor(buf []byte) (*Config_Descriptor, error) {
+	p := &parser{buf: buf}
+	b, err := p.next(DT_CONFIG, DT_CONFIG_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	cd := &Config_Descriptor{
+		BLength:             b[0],
+		BDescriptorType:     b[1],
+		WTotalLength:        binary.LittleEndian.Uint16(b[2:]),
+		BNumInterfaces:      b[4],
+		BConfigurationValue: b[5],
+		IConfiguration:      b[6],
+		BmAttributes:        b[7],
+		MaxPower:            b[8],
+		Interface:           make([]*Interface, 0, b[4]),
+	}
+	if int(cd.WTotalLength) < len(b) || int(cd.WTotalLength) > len(buf) {
+		return nil, &Parse_Error{Offset: 0, Msg: fmt.Sprintf("wTotalLength %d, buffer length %d", cd.WTotalLength, len(buf))}
+	}
+	p.buf = buf[:cd.WTotalLength]
+	cd.Extra, err = p.extra()
+	if err != nil {
+		return nil, err
+	}
+	var itf *Interface
+	for !p.done() {
+		id, err := p.interface_descriptor()
+		if err != nil {
+			return nil, err
+		}
+		if itf == nil || itf.Altsetting[0].BInterfaceNumber != id.BInterfaceNumber {
+			itf = &Interface{}
+			cd.Interface = append(cd.Interface, itf)
+		}
+		itf.Altsetting = append(itf.Altsetting, id)
+		itf.Num_altsetting = len(itf.Altsetting)
+	}
+	return cd, nil
+}
+
+// Parse a BOS descriptor and its device capabilities.
+func Parse_BOS_Descriptor(buf []byte) (*BOS_Descriptor, error) {
+	p := &parser{buf: buf}
+	b, err := p.next(DT_BOS, DT_BOS_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	bos := &BOS_Descriptor{
+		BLength:         b[0],
+		BDescriptorType: b[1],
+		WTotalLength:    binary.LittleEndian.Uint16(b[2:]),
+		Dev_capability:  make([]*BOS_Dev_Capability_Descriptor, b[4]),
+	}
+	if int(bos.WTotalLength) < len(b) || int(bos.WTotalLength) > len(buf) {
+		return nil, &Parse_Error{Offset: 0, Msg: fmt.Sprintf("wTotalLength %d, buffer length %d", bos.WTotalLength, len(buf))}
+	}
+	p.buf = buf[:bos.WTotalLength]
+	for i := range bos.Dev_capability {
+		b, err := p.next(DT_DEVICE_CAPABILITY, DT_DEVICE_CAPABILITY_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		bos.Dev_capability[i] = &BOS_Dev_Capability_Descriptor{
+			BLength:             b[0],
+			BDescriptorType:     b[1],
+			BDevCapabilityType:  b[2],
+			Dev_capability_data: append([]byte{}, b[3:]...),
+		}
+	}
+	return bos, nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,144 @@
+//-----------------------------------------------------------------------------
+/*
+
+String descriptors
+
+String descriptors hold UTF-16LE text. String index 0 holds the list of
+language ids supported by the device.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+//-----------------------------------------------------------------------------
+
+// Language ids (USB LANGIDs).
+const (
+	LANGID_GERMAN      = 0x0407
+	LANGID_ENGLISH_US  = 0x0409
+	LANGID_ENGLISH_UK  = 0x0809
+	LANGID_SPANISH     = 0x0c0a
+	LANGID_FRENCH      = 0x040c
+	LANGID_ITALIAN     = 0x0410
+	LANGID_JAPANESE    = 0x0411
+	LANGID_KOREAN      = 0x0412
+	LANGID_CHINESE_PRC = 0x0804
+)
+
+// Primary_Language returns the primary language of a language id, bits 0:9.
+func Primary_Language(langid uint16) uint16 {
+	return langid & 0x3ff
+}
+
+//-----------------------------------------------------------------------------
+
+// return the UTF-16 code units of a string descriptor
+func string_units(buf []byte) ([]uint16, error) {
+	p := &parser{buf: buf}
+	if len(buf) < 2 {
+		return nil, p.error("truncated descriptor header")
+	}
+	if buf[1] != DT_STRING {
+		return nil, p.error("bDescriptorType 0x%02x, expected 0x%02x", buf[1], DT_STRING)
+	}
+	// some devices report a bLength longer than the data they return
+	n := int(buf[0])
+	if n < 2 {
+		return nil, p.error("bLength %d is too short", n)
+	}
+	if n > len(buf) {
+		n = len(buf)
+	}
+	u := make([]uint16, (n-2)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(buf[2+2*i:])
+	}
+	return u, nil
+}
+
+// Parse a string descriptor, decoding the UTF-16LE text.
+func Parse_String_Descriptor(buf []byte) (string, error) {
+	u, err := string_units(buf)
+	if err != nil {
+		return "", err
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// Parse the language ids of string descriptor 0.
+func Parse_Language_Descriptor(buf []byte) ([]uint16, error) {
+	return string_units(buf)
+}
+
+//-----------------------------------------------------------------------------
+
+// the maximum number of UTF-16 code units in a string descriptor
+const max_string_units = (255 - 2) / 2
+
+// encode UTF-16 code units as a string descriptor
+func encode_units(u []uint16) []byte {
+	b := make([]byte, 2+2*len(u))
+	b[0] = uint8(len(b))
+	b[1] = DT_STRING
+	for i, x := range u {
+		binary.LittleEndian.PutUint16(b[2+2*i:], x)
+	}
+	return b
+}
+
+// Encode a string descriptor as UTF-16LE.
+// The string is truncated to the 126 code units that fit in a descriptor.
+func Encode_String_Descriptor(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	if len(u) > max_string_units {
+		u = u[:max_string_units]
+		// don't split a surrogate pair
+		if x := u[len(u)-1]; x >= 0xd800 && x < 0xdc00 {
+			u = u[:len(u)-1]
+		}
+	}
+	return encode_units(u)
+}
+
+// Encode the language ids of string descriptor 0.
+func Encode_Language_Descriptor(langids []uint16) []byte {
+	if len(langids) > max_string_units {
+		langids = langids[:max_string_units]
+	}
+	return encode_units(langids)
+}
+
+//-----------------------------------------------------------------------------
+
+// Pick_Language returns the first preferred language supported by a device.
+// If none is supported exactly, the first preferred language with a supported
+// primary language is matched, and failing that the first supported language.
+// Returns false if the device supports no languages.
+func Pick_Language(supported []uint16, preferred []uint16) (uint16, bool) {
+	for _, p := range preferred {
+		for _, s := range supported {
+			if s == p {
+				return s, true
+			}
+		}
+	}
+	for _, p := range preferred {
+		for _, s := range supported {
+			if Primary_Language(s) == Primary_Language(p) {
+				return s, true
+			}
+		}
+	}
+	if len(supported) != 0 {
+		return supported[0], true
+	}
+	return 0, false
+}
+
+//-----------------------------------------------------------------------------
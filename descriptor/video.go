@@ -0,0 +1,254 @@
+//-----------------------------------------------------------------------------
+/*
+
+USB Video class descriptors
+
+Video control and video streaming descriptors as defined by the USB Device
+Class Definition for Video Devices 1.1 and the uncompressed and MJPEG payload
+specifications.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+//-----------------------------------------------------------------------------
+
+// Video interface subclasses.
+const (
+	SUBCLASS_VIDEOCONTROL   = 0x01
+	SUBCLASS_VIDEOSTREAMING = 0x02
+)
+
+// Video control interface descriptor subtypes.
+const (
+	VC_HEADER          = 0x01
+	VC_INPUT_TERMINAL  = 0x02
+	VC_OUTPUT_TERMINAL = 0x03
+	VC_SELECTOR_UNIT   = 0x04
+	VC_PROCESSING_UNIT = 0x05
+	VC_EXTENSION_UNIT  = 0x06
+)
+
+// Video control endpoint descriptor subtypes.
+const (
+	EP_INTERRUPT = 0x03
+)
+
+// Video streaming interface descriptor subtypes.
+const (
+	VS_INPUT_HEADER        = 0x01
+	VS_OUTPUT_HEADER       = 0x02
+	VS_FORMAT_UNCOMPRESSED = 0x04
+	VS_FRAME_UNCOMPRESSED  = 0x05
+	VS_FORMAT_MJPEG        = 0x06
+	VS_FRAME_MJPEG         = 0x07
+	VS_COLORFORMAT         = 0x0d
+)
+
+//-----------------------------------------------------------------------------
+// video control
+
+// VC_Header_Descriptor is the video control interface header.
+type VC_Header_Descriptor struct {
+	CS_Header
+	BcdUVC           uint16
+	WTotalLength     uint16
+	DwClockFrequency uint32
+	BInCollection    uint8
+	BaInterfaceNr    []uint8
+}
+
+// VC_Input_Terminal_Descriptor describes a video input terminal.
+// Data holds the fields specific to the terminal type.
+type VC_Input_Terminal_Descriptor struct {
+	CS_Header
+	BTerminalID    uint8
+	WTerminalType  uint16
+	BAssocTerminal uint8
+	ITerminal      uint8
+	Data           []byte
+}
+
+// VC_Output_Terminal_Descriptor describes a video output terminal.
+type VC_Output_Terminal_Descriptor struct {
+	CS_Header
+	BTerminalID    uint8
+	WTerminalType  uint16
+	BAssocTerminal uint8
+	BSourceID      uint8
+	ITerminal      uint8
+}
+
+// VC_Endpoint_Descriptor is the class specific video control interrupt endpoint descriptor.
+type VC_Endpoint_Descriptor struct {
+	CS_Header
+	WMaxTransferSize uint16
+}
+
+func decode_video_control(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	var d Class_Descriptor
+	switch cs_subtype(b) {
+	case VC_HEADER:
+		x := &VC_Header_Descriptor{
+			CS_Header:        r.cs_header(),
+			BcdUVC:           r.u16(),
+			WTotalLength:     r.u16(),
+			DwClockFrequency: r.u32(),
+			BInCollection:    r.u8(),
+		}
+		x.BaInterfaceNr = r.bytes(int(x.BInCollection))
+		d = x
+	case VC_INPUT_TERMINAL:
+		d = &VC_Input_Terminal_Descriptor{
+			CS_Header:      r.cs_header(),
+			BTerminalID:    r.u8(),
+			WTerminalType:  r.u16(),
+			BAssocTerminal: r.u8(),
+			ITerminal:      r.u8(),
+			Data:           r.rest(),
+		}
+	case VC_OUTPUT_TERMINAL:
+		d = &VC_Output_Terminal_Descriptor{
+			CS_Header:      r.cs_header(),
+			BTerminalID:    r.u8(),
+			WTerminalType:  r.u16(),
+			BAssocTerminal: r.u8(),
+			BSourceID:      r.u8(),
+			ITerminal:      r.u8(),
+		}
+	default:
+		return decode_cs(b)
+	}
+	return d, r.check("video control")
+}
+
+func decode_video_endpoint(b []byte) (Class_Descriptor, error) {
+	if cs_subtype(b) != EP_INTERRUPT {
+		return decode_cs(b)
+	}
+	r := &reader{b: b}
+	d := &VC_Endpoint_Descriptor{
+		CS_Header:        r.cs_header(),
+		WMaxTransferSize: r.u16(),
+	}
+	return d, r.check("video control endpoint")
+}
+
+//-----------------------------------------------------------------------------
+// video streaming
+
+// VS_Input_Header_Descriptor is the video streaming input header.
+type VS_Input_Header_Descriptor struct {
+	CS_Header
+	BNumFormats         uint8
+	WTotalLength        uint16
+	BEndpointAddress    uint8
+	BmInfo              uint8
+	BTerminalLink       uint8
+	BStillCaptureMethod uint8
+	BTriggerSupport     uint8
+	BTriggerUsage       uint8
+	BControlSize        uint8
+	BmaControls         [][]byte // per format
+}
+
+// VS_Format_Descriptor describes an uncompressed or MJPEG video format.
+// GuidFormat and BBitsPerPixel are only used by uncompressed formats, BmFlags only by MJPEG.
+type VS_Format_Descriptor struct {
+	CS_Header
+	BFormatIndex         uint8
+	BNumFrameDescriptors uint8
+	GuidFormat           []byte
+	BBitsPerPixel        uint8
+	BmFlags              uint8
+	BDefaultFrameIndex   uint8
+	BAspectRatioX        uint8
+	BAspectRatioY        uint8
+	BmInterlaceFlags     uint8
+	BCopyProtect         uint8
+}
+
+// VS_Frame_Descriptor describes a frame size of an uncompressed or MJPEG video format.
+type VS_Frame_Descriptor struct {
+	CS_Header
+	BFrameIndex               uint8
+	BmCapabilities            uint8
+	WWidth                    uint16
+	WHeight                   uint16
+	DwMinBitRate              uint32
+	DwMaxBitRate              uint32
+	DwMaxVideoFrameBufferSize uint32
+	DwDefaultFrameInterval    uint32
+	BFrameIntervalType        uint8    // 0 for a continuous range
+	DwFrameInterval           []uint32 // min/max/step for a continuous range
+}
+
+func decode_video_streaming(b []byte) (Class_Descriptor, error) {
+	r := &reader{b: b}
+	var d Class_Descriptor
+	switch cs_subtype(b) {
+	case VS_INPUT_HEADER:
+		x := &VS_Input_Header_Descriptor{
+			CS_Header:           r.cs_header(),
+			BNumFormats:         r.u8(),
+			WTotalLength:        r.u16(),
+			BEndpointAddress:    r.u8(),
+			BmInfo:              r.u8(),
+			BTerminalLink:       r.u8(),
+			BStillCaptureMethod: r.u8(),
+			BTriggerSupport:     r.u8(),
+			BTriggerUsage:       r.u8(),
+			BControlSize:        r.u8(),
+		}
+		for i := 0; i < int(x.BNumFormats); i++ {
+			x.BmaControls = append(x.BmaControls, r.bytes(int(x.BControlSize)))
+		}
+		d = x
+	case VS_FORMAT_UNCOMPRESSED, VS_FORMAT_MJPEG:
+		x := &VS_Format_Descriptor{
+			CS_Header:            r.cs_header(),
+			BFormatIndex:         r.u8(),
+			BNumFrameDescriptors: r.u8(),
+		}
+		if x.BDescriptorSubtype == VS_FORMAT_UNCOMPRESSED {
+			x.GuidFormat = r.bytes(16)
+			x.BBitsPerPixel = r.u8()
+		} else {
+			x.BmFlags = r.u8()
+		}
+		x.BDefaultFrameIndex = r.u8()
+		x.BAspectRatioX = r.u8()
+		x.BAspectRatioY = r.u8()
+		x.BmInterlaceFlags = r.u8()
+		x.BCopyProtect = r.u8()
+		d = x
+	case VS_FRAME_UNCOMPRESSED, VS_FRAME_MJPEG:
+		x := &VS_Frame_Descriptor{
+			CS_Header:                 r.cs_header(),
+			BFrameIndex:               r.u8(),
+			BmCapabilities:            r.u8(),
+			WWidth:                    r.u16(),
+			WHeight:                   r.u16(),
+			DwMinBitRate:              r.u32(),
+			DwMaxBitRate:              r.u32(),
+			DwMaxVideoFrameBufferSize: r.u32(),
+			DwDefaultFrameInterval:    r.u32(),
+			BFrameIntervalType:        r.u8(),
+		}
+		n := int(x.BFrameIntervalType)
+		if n == 0 {
+			n = 3
+		}
+		for i := 0; i < n; i++ {
+			x.DwFrameInterval = append(x.DwFrameInterval, r.u32())
+		}
+		d = x
+	default:
+		return decode_cs(b)
+	}
+	return d, r.check("video streaming")
+}
+
+//-----------------------------------------------------------------------------
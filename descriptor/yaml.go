@@ -0,0 +1,164 @@
+//-----------------------------------------------------------------------------
+/*
+
+YAML encoding
+
+Values are converted to JSON first, so the YAML output has the same field
+names, field order and value formatting as the JSON output. Strings are
+always double quoted.
+
+*/
+//-----------------------------------------------------------------------------
+
+package descriptor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+// JSON object with its key order
+type yaml_map struct {
+	keys   []string
+	values []interface{}
+}
+
+type yaml_list []interface{}
+
+// read a JSON value from the decoder
+func yaml_value(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		m := &yaml_map{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := yaml_value(dec)
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, k.(string))
+			m.values = append(m.values, v)
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		l := yaml_list{}
+		for dec.More() {
+			v, err := yaml_value(dec)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		_, err = dec.Token()
+		return l, err
+	}
+	return tok, nil
+}
+
+var yaml_plain_key = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// keys that YAML would read as something other than a string
+var yaml_reserved = map[string]bool{
+	"true": true, "false": true, "null": true, "yes": true, "no": true,
+	"on": true, "off": true, "y": true, "n": true,
+}
+
+func yaml_key(k string) string {
+	if yaml_plain_key.MatchString(k) && !yaml_reserved[strings.ToLower(k)] {
+		return k
+	}
+	return yaml_scalar(k)
+}
+
+func yaml_scalar(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		// a JSON string is a valid YAML double quoted scalar
+		b, _ := json.Marshal(x)
+		return string(b)
+	case *yaml_map:
+		return "{}"
+	case yaml_list:
+		return "[]"
+	}
+	return fmt.Sprint(v)
+}
+
+// return true for a non-empty map or list
+func yaml_collection(v interface{}) bool {
+	switch x := v.(type) {
+	case *yaml_map:
+		return len(x.keys) != 0
+	case yaml_list:
+		return len(x) != 0
+	}
+	return false
+}
+
+// write a non-empty collection, each line indented
+func yaml_emit(w *bytes.Buffer, v interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch x := v.(type) {
+	case *yaml_map:
+		for i, k := range x.keys {
+			if yaml_collection(x.values[i]) {
+				fmt.Fprintf(w, "%s%s:\n", pad, yaml_key(k))
+				yaml_emit(w, x.values[i], indent+2)
+			} else {
+				fmt.Fprintf(w, "%s%s: %s\n", pad, yaml_key(k), yaml_scalar(x.values[i]))
+			}
+		}
+	case yaml_list:
+		for _, item := range x {
+			if yaml_collection(item) {
+				// the first line of the item follows the "- "
+				var b bytes.Buffer
+				yaml_emit(&b, item, indent+2)
+				w.WriteString(pad + "- ")
+				w.Write(b.Bytes()[indent+2:])
+			} else {
+				fmt.Fprintf(w, "%s- %s\n", pad, yaml_scalar(item))
+			}
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// Marshal_YAML returns the YAML encoding of v, derived from its JSON encoding.
+func Marshal_YAML(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	x, err := yaml_value(dec)
+	if err != nil {
+		return nil, err
+	}
+	var w bytes.Buffer
+	if yaml_collection(x) {
+		yaml_emit(&w, x, 0)
+	} else {
+		w.WriteString(yaml_scalar(x) + "\n")
+	}
+	return w.Bytes(), nil
+}
+
+//-----------------------------------------------------------------------------
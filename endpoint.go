@@ -0,0 +1,233 @@
+//-----------------------------------------------------------------------------
+/*
+
+Endpoint readers and writers
+
+Adapt bulk and interrupt endpoints to io.Reader and io.Writer.
+
+A USB transfer ends with a short packet, so a short packet marks the end of
+a message. Messages that are a multiple of the maximum packet size are ended
+with a zero length packet.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"io"
+)
+
+//-----------------------------------------------------------------------------
+
+// synchronous transfer function for an endpoint, returning the data
+// transferred before an error along with the error
+type endpoint_transfer func(hdl Device_Handle, endpoint uint8, data []byte, timeout uint) ([]byte, error)
+
+// Return the transfer type of an endpoint in the active configuration.
+func endpoint_transfer_type(hdl Device_Handle, addr uint8) (Transfer_Type, error) {
+	cd, err := Get_Active_Config_Descriptor(Get_Device(hdl))
+	if err != nil {
+		return 0, err
+	}
+	for _, itf := range cd.Interface {
+		for _, id := range itf.Altsetting {
+			for _, ep := range id.Endpoint {
+				if ep.BEndpointAddress == addr {
+					return ep.TransferType(), nil
+				}
+			}
+		}
+	}
+	return 0, new_endpoint_error(ERROR_NOT_FOUND, "endpoint_transfer_type", hdl, addr)
+}
+
+// Return the transfer function for a bulk or interrupt endpoint in the active configuration.
+func endpoint_transfer_fn(hdl Device_Handle, addr uint8) (endpoint_transfer, error) {
+	transfer_type, err := endpoint_transfer_type(hdl, addr)
+	if err != nil {
+		return nil, err
+	}
+	switch transfer_type {
+	case TRANSFER_TYPE_BULK:
+		return bulk_transfer, nil
+	case TRANSFER_TYPE_INTERRUPT:
+		return interrupt_transfer, nil
+	}
+	return nil, new_endpoint_error(ERROR_NOT_SUPPORTED, "endpoint_transfer_fn", hdl, addr)
+}
+
+// Return the transfer function and maximum packet size for an endpoint.
+func open_endpoint(hdl Device_Handle, addr uint8, dir uint8) (endpoint_transfer, int, error) {
+	if addr&ENDPOINT_DIR_MASK != dir {
+		return nil, 0, new_endpoint_error(ERROR_INVALID_PARAM, "open_endpoint", hdl, addr)
+	}
+	fn, err := endpoint_transfer_fn(hdl, addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	mps := Get_Max_Packet_Size(Get_Device(hdl), addr)
+	if mps < 0 {
+		return nil, 0, new_endpoint_error(mps, "Get_Max_Packet_Size", hdl, addr)
+	}
+	return fn, mps, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// InEndpoint reads from a bulk or interrupt IN endpoint.
+// It implements io.ReadCloser.
+type InEndpoint struct {
+	hdl           Device_Handle
+	addr          uint8
+	transfer      endpoint_transfer
+	MaxPacketSize int
+	Timeout       uint   // transfer timeout in milliseconds, 0 for no timeout
+	buf           []byte // received data that has not been read
+	short         bool   // the buffered data ends with a short packet
+	data          []byte // transfer buffer
+	closed        bool
+}
+
+// OpenInEndpoint returns a reader for a bulk or interrupt IN endpoint.
+// The interface containing the endpoint should be claimed.
+func OpenInEndpoint(hdl Device_Handle, addr uint8) (*InEndpoint, error) {
+	fn, mps, err := open_endpoint(hdl, addr, ENDPOINT_IN)
+	if err != nil {
+		return nil, err
+	}
+	return &InEndpoint{
+		hdl:           hdl,
+		addr:          addr,
+		transfer:      fn,
+		MaxPacketSize: mps,
+	}, nil
+}
+
+// read a single transfer of at least n bytes, rounded up to a multiple of the packet size
+func (e *InEndpoint) read_transfer(n int) ([]byte, error) {
+	if e.closed {
+		return nil, io.ErrClosedPipe
+	}
+	if e.MaxPacketSize <= 0 {
+		return nil, new_endpoint_error(ERROR_INVALID_PARAM, "InEndpoint.Read", e.hdl, e.addr)
+	}
+	if n < e.MaxPacketSize {
+		n = e.MaxPacketSize
+	}
+	n = ((n + e.MaxPacketSize - 1) / e.MaxPacketSize) * e.MaxPacketSize
+	if cap(e.data) < n {
+		e.data = make([]byte, n)
+	}
+	data, err := e.transfer(e.hdl, e.addr, e.data[:n], e.Timeout)
+	e.short = len(data) < n
+	return data, err
+}
+
+// Read reads data from the endpoint. A single Read returns data from at most
+// one transfer, so it does not read past a short packet. Zero length packets are skipped.
+// Transfer errors are returned as is, with any data received before the error,
+// e.g. a timeout. Read doesn't return io.EOF: a device that has gone away gives
+// ErrNoDevice.
+func (e *InEndpoint) Read(p []byte) (int, error) {
+	var err error
+	for len(e.buf) == 0 && len(p) != 0 && err == nil {
+		e.buf, err = e.read_transfer(len(p))
+	}
+	n := copy(p, e.buf)
+	e.buf = e.buf[n:]
+	return n, err
+}
+
+// ReadMessage reads a complete message, ending with a short or zero length packet.
+// Any data buffered by Read is returned at the start of the message.
+func (e *InEndpoint) ReadMessage() ([]byte, error) {
+	msg := append([]byte(nil), e.buf...)
+	e.buf = nil
+	if len(msg) != 0 && e.short {
+		return msg, nil
+	}
+	for {
+		data, err := e.read_transfer(e.MaxPacketSize)
+		msg = append(msg, data...)
+		if err != nil {
+			return msg, err
+		}
+		if len(data) < e.MaxPacketSize {
+			return msg, nil
+		}
+	}
+}
+
+// Close the endpoint reader. The device handle is not closed.
+func (e *InEndpoint) Close() error {
+	e.closed = true
+	e.buf = nil
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// OutEndpoint writes to a bulk or interrupt OUT endpoint.
+// It implements io.WriteCloser.
+type OutEndpoint struct {
+	hdl           Device_Handle
+	addr          uint8
+	transfer      endpoint_transfer
+	MaxPacketSize int
+	Timeout       uint // transfer timeout in milliseconds, 0 for no timeout
+	// Send a zero length packet after a write that is a multiple of the
+	// packet size, so the device sees the end of the message.
+	// This matches the TRANSFER_ADD_ZERO_PACKET transfer flag.
+	ZeroLengthPacket bool
+	closed           bool
+}
+
+// OpenOutEndpoint returns a writer for a bulk or interrupt OUT endpoint.
+// The interface containing the endpoint should be claimed.
+func OpenOutEndpoint(hdl Device_Handle, addr uint8) (*OutEndpoint, error) {
+	fn, mps, err := open_endpoint(hdl, addr, ENDPOINT_OUT)
+	if err != nil {
+		return nil, err
+	}
+	return &OutEndpoint{
+		hdl:           hdl,
+		addr:          addr,
+		transfer:      fn,
+		MaxPacketSize: mps,
+	}, nil
+}
+
+// Write writes p to the endpoint as a single message.
+// If the transfer sends part of p it returns io.ErrShortWrite, or the transfer
+// error along with the number of bytes the transfer reports as sent.
+func (e *OutEndpoint) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if e.ZeroLengthPacket && e.MaxPacketSize <= 0 {
+		return 0, new_endpoint_error(ERROR_INVALID_PARAM, "OutEndpoint.Write", e.hdl, e.addr)
+	}
+	data, err := e.transfer(e.hdl, e.addr, p, e.Timeout)
+	if err != nil {
+		return len(data), err
+	}
+	if len(data) != len(p) {
+		return len(data), io.ErrShortWrite
+	}
+	if e.ZeroLengthPacket && len(p) != 0 && len(p)%e.MaxPacketSize == 0 {
+		_, err = e.transfer(e.hdl, e.addr, nil, e.Timeout)
+		if err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Close the endpoint writer. The device handle is not closed.
+func (e *OutEndpoint) Close() error {
+	e.closed = true
+	return nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,81 @@
+//-----------------------------------------------------------------------------
+/*
+
+String functions for enumerated types
+
+The descriptor enumerations (Class, Direction, Transfer_Type, Sync_Type,
+Usage_Type) are defined in the descriptor package.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import "fmt"
+
+//-----------------------------------------------------------------------------
+
+var speed_names = map[Speed]string{
+	SPEED_UNKNOWN: "Unknown",
+	SPEED_LOW:     "1.5 Mbit/s (USB LowSpeed)",
+	SPEED_FULL:    "12 Mbit/s (USB FullSpeed)",
+	SPEED_HIGH:    "480 Mbit/s (USB HighSpeed)",
+	SPEED_SUPER:   "5000 Mbit/s (USB SuperSpeed)",
+}
+
+func (s Speed) String() string {
+	if name, ok := speed_names[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Speed(%d)", int(s))
+}
+
+var speed_mbps = map[Speed]float64{
+	SPEED_LOW:   1.5,
+	SPEED_FULL:  12,
+	SPEED_HIGH:  480,
+	SPEED_SUPER: 5000,
+}
+
+// Mbps returns the signalling rate in Mbit/s, 0 if the speed is unknown.
+func (s Speed) Mbps() float64 {
+	return speed_mbps[s]
+}
+
+//-----------------------------------------------------------------------------
+
+var transfer_status_names = map[Transfer_Status]string{
+	TRANSFER_COMPLETED: "LIBUSB_TRANSFER_COMPLETED",
+	TRANSFER_ERROR:     "LIBUSB_TRANSFER_ERROR",
+	TRANSFER_TIMED_OUT: "LIBUSB_TRANSFER_TIMED_OUT",
+	TRANSFER_CANCELLED: "LIBUSB_TRANSFER_CANCELLED",
+	TRANSFER_STALL:     "LIBUSB_TRANSFER_STALL",
+	TRANSFER_NO_DEVICE: "LIBUSB_TRANSFER_NO_DEVICE",
+	TRANSFER_OVERFLOW:  "LIBUSB_TRANSFER_OVERFLOW",
+}
+
+func (s Transfer_Status) String() string {
+	if name, ok := transfer_status_names[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Transfer_Status(%d)", int(s))
+}
+
+//-----------------------------------------------------------------------------
+
+var log_level_names = map[Log_Level]string{
+	LOG_LEVEL_NONE:    "LIBUSB_LOG_LEVEL_NONE",
+	LOG_LEVEL_ERROR:   "LIBUSB_LOG_LEVEL_ERROR",
+	LOG_LEVEL_WARNING: "LIBUSB_LOG_LEVEL_WARNING",
+	LOG_LEVEL_INFO:    "LIBUSB_LOG_LEVEL_INFO",
+	LOG_LEVEL_DEBUG:   "LIBUSB_LOG_LEVEL_DEBUG",
+}
+
+func (l Log_Level) String() string {
+	if name, ok := log_level_names[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Log_Level(%d)", int(l))
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,88 @@
+//-----------------------------------------------------------------------------
+/*
+
+Event loop
+
+Asynchronous transfers and hotplug callbacks complete from within libusb
+event handling. StartEventLoop runs event handling for a context on a
+dedicated goroutine so the application doesn't have to.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// Back off after an event handling error so a persistent error doesn't spin.
+const event_loop_backoff = 10 * time.Millisecond
+
+type event_loop struct {
+	completed *int32 // set non-zero to stop event handling
+	done      chan struct{}
+}
+
+var event_loops = struct {
+	sync.Mutex
+	loop map[Context]*event_loop
+}{
+	loop: make(map[Context]*event_loop),
+}
+
+// handle events until completed is set
+func (l *event_loop) run(ctx Context) {
+	defer close(l.done)
+	for atomic.LoadInt32(l.completed) == 0 {
+		err := Handle_Events_Completed(ctx, l.completed)
+		if err != nil && !errors.Is(err, ErrInterrupted) {
+			time.Sleep(event_loop_backoff)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// StartEventLoop handles events for the context on a dedicated goroutine.
+// Only one event loop may run per context. A backend context has no events.
+func StartEventLoop(ctx Context) error {
+	if _, ok := lookup_backend(ctx); ok {
+		return new_error(ERROR_NOT_SUPPORTED, "StartEventLoop")
+	}
+	event_loops.Lock()
+	defer event_loops.Unlock()
+	if event_loops.loop[ctx] != nil {
+		return new_error(ERROR_BUSY, "StartEventLoop")
+	}
+	l := &event_loop{
+		completed: new(int32),
+		done:      make(chan struct{}),
+	}
+	event_loops.loop[ctx] = l
+	go l.run(ctx)
+	return nil
+}
+
+// StopEventLoop stops the event loop for the context and waits for it to exit.
+// It does nothing if no event loop is running.
+func StopEventLoop(ctx Context) {
+	event_loops.Lock()
+	l := event_loops.loop[ctx]
+	delete(event_loops.loop, ctx)
+	event_loops.Unlock()
+	if l == nil {
+		return
+	}
+	atomic.StoreInt32(l.completed, 1)
+	// wake the event handler if it is blocked waiting for events
+	Interrupt_Event_Handler(ctx)
+	<-l.done
+}
+
+//-----------------------------------------------------------------------------
@@ -1,15 +1,433 @@
+//-----------------------------------------------------------------------------
+/*
+
+List USB devices
+
+Usage: lsusb [-v] [-t] [-json] [-yaml] [-s [[bus]:][devnum]] [-d [vendor]:[product]]
+
+  -v     verbose, print the descriptors of each device
+  -t     print the device tree by port topology
+  -json  print a JSON snapshot of each device
+  -yaml  print a YAML snapshot of each device
+  -s  show devices with the bus and/or device number (decimal)
+  -d  show devices with the vendor and/or product id (hex)
+
+*/
+//-----------------------------------------------------------------------------
+
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/deadsy/libusb"
 	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/descriptor"
 )
 
+//-----------------------------------------------------------------------------
+
+var capability_name = map[uint8]string{
+	libusb.BT_WIRELESS_USB_DEVICE_CAPABILITY: "Wireless USB Device Capability",
+	libusb.BT_USB_2_0_EXTENSION:              "USB 2.0 Extension Device Capability",
+	libusb.BT_SS_USB_DEVICE_CAPABILITY:       "SuperSpeed USB Device Capability",
+	libusb.BT_CONTAINER_ID:                   "Container ID Device Capability",
+}
+
+func bcd(x uint16) string {
+	return fmt.Sprintf("%x.%02x", x>>8, x&0xff)
+}
+
+//-----------------------------------------------------------------------------
+
+// usb device with an optional open handle for reading strings
+type device struct {
+	dev  libusb.Device
+	hdl  libusb.Device_Handle
+	dd   *libusb.Device_Descriptor
+	path []byte
+}
+
+// return a string descriptor, or "" if it can't be read
+func (d *device) str(index uint8) string {
+	if index == 0 || d.hdl == nil {
+		return ""
+	}
+	s, err := libusb.Get_String_Descriptor_ASCII(d.hdl, index, make([]byte, 256))
+	if err != nil {
+		return ""
+	}
+	return string(s)
+}
+
+//-----------------------------------------------------------------------------
+// verbose output
+
+type printer struct {
+	indent int
+}
+
+func (p *printer) line(format string, a ...interface{}) {
+	fmt.Printf("%s%s\n", strings.Repeat(" ", p.indent), fmt.Sprintf(format, a...))
+}
+
+func (p *printer) field(name string, value interface{}, desc string) {
+	p.line("%-19s %5v %s", name, value, desc)
+}
+
+func (p *printer) push() { p.indent += 2 }
+func (p *printer) pop()  { p.indent -= 2 }
+
+// print a class specific descriptor with its field names
+func (p *printer) class_descriptor(d descriptor.Class_Descriptor) {
+	v := reflect.ValueOf(d).Elem()
+	p.line("%s:", strings.Replace(v.Type().Name(), "_", " ", -1))
+	p.push()
+	p.fields(v)
+	p.pop()
+}
+
+func (p *printer) fields(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if f.Anonymous {
+			p.fields(v.Field(i))
+			continue
+		}
+		name := strings.ToLower(f.Name[:1]) + f.Name[1:]
+		switch x := v.Field(i).Interface().(type) {
+		case []byte:
+			p.line("%-19s % x", name, x)
+		case uint16, uint32:
+			p.line("%-19s 0x%04x", name, x)
+		default:
+			p.line("%-19s %5v", name, x)
+		}
+	}
+}
+
+// print the class specific descriptors in an extra blob
+func (p *printer) extra(class int, subclass int, protocol int, extra []byte) {
+	list, err := descriptor.Decode_Extra(class, subclass, protocol, extra)
+	for _, d := range list {
+		if d.Descriptor_Type() == descriptor.DT_SS_ENDPOINT_COMPANION {
+			// printed with the endpoint
+			continue
+		}
+		p.class_descriptor(d)
+	}
+	if err != nil {
+		p.line("** %s", err)
+	}
+}
+
+func (p *printer) endpoint(d *device, id *libusb.Interface_Descriptor, ep *libusb.Endpoint_Descriptor) {
+	p.line("Endpoint Descriptor:")
+	p.push()
+	p.field("bLength", ep.BLength, "")
+	p.field("bDescriptorType", ep.BDescriptorType, "")
+	p.line("%-19s  0x%02x  EP %d %s", "bEndpointAddress", ep.BEndpointAddress, ep.Number(), ep.Direction())
+	p.field("bmAttributes", ep.BmAttributes, "")
+	p.line("  Transfer Type            %s", ep.TransferType())
+	p.line("  Synch Type               %s", ep.SyncType())
+	p.line("  Usage Type               %s", ep.UsageType())
+	mult := int((ep.WMaxPacketSize>>11)&3) + 1
+	p.line("%-19s 0x%04x  %dx %d bytes", "wMaxPacketSize", ep.WMaxPacketSize, mult, ep.WMaxPacketSize&0x7ff)
+	p.field("bInterval", ep.BInterval, "")
+	if ep.BLength >= libusb.DT_ENDPOINT_AUDIO_SIZE {
+		p.field("bRefresh", ep.BRefresh, "")
+		p.field("bSynchAddress", ep.BSynchAddress, "")
+	}
+	comp, err := libusb.Get_SS_Endpoint_Companion_Descriptor(nil, ep)
+	if err == nil {
+		p.line("SuperSpeed Endpoint Companion:")
+		p.push()
+		p.field("bLength", comp.BLength, "")
+		p.field("bDescriptorType", comp.BDescriptorType, "")
+		p.field("bMaxBurst", comp.BMaxBurst, "")
+		p.field("bmAttributes", comp.BmAttributes, "")
+		p.field("wBytesPerInterval", comp.WBytesPerInterval, "")
+		p.pop()
+	}
+	p.extra(int(id.BInterfaceClass), int(id.BInterfaceSubClass), int(id.BInterfaceProtocol), ep.Extra)
+	p.pop()
+}
+
+func (p *printer) interface_descriptor(d *device, id *libusb.Interface_Descriptor) {
+	p.line("Interface Descriptor:")
+	p.push()
+	p.field("bLength", id.BLength, "")
+	p.field("bDescriptorType", id.BDescriptorType, "")
+	p.field("bInterfaceNumber", id.BInterfaceNumber, "")
+	p.field("bAlternateSetting", id.BAlternateSetting, "")
+	p.field("bNumEndpoints", id.BNumEndpoints, "")
+	p.field("bInterfaceClass", id.BInterfaceClass, id.Class().String())
+	p.field("bInterfaceSubClass", id.BInterfaceSubClass, "")
+	p.field("bInterfaceProtocol", id.BInterfaceProtocol, "")
+	p.field("iInterface", id.IInterface, d.str(id.IInterface))
+	p.extra(int(id.BInterfaceClass), int(id.BInterfaceSubClass), int(id.BInterfaceProtocol), id.Extra)
+	for _, ep := range id.Endpoint {
+		p.endpoint(d, id, ep)
+	}
+	p.pop()
+}
+
+func (p *printer) config(d *device, cd *libusb.Config_Descriptor) {
+	p.line("Configuration Descriptor:")
+	p.push()
+	p.field("bLength", cd.BLength, "")
+	p.field("bDescriptorType", cd.BDescriptorType, "")
+	p.line("%-19s 0x%04x", "wTotalLength", cd.WTotalLength)
+	p.field("bNumInterfaces", cd.BNumInterfaces, "")
+	p.field("bConfigurationValue", cd.BConfigurationValue, "")
+	p.field("iConfiguration", cd.IConfiguration, d.str(cd.IConfiguration))
+	p.line("%-19s  0x%02x", "bmAttributes", cd.BmAttributes)
+	if cd.BmAttributes&0x40 != 0 {
+		p.line("  Self Powered")
+	} else {
+		p.line("  (Bus Powered)")
+	}
+	if cd.BmAttributes&0x20 != 0 {
+		p.line("  Remote Wakeup")
+	}
+	// units of 8mA for superspeed, 2mA otherwise
+	ma := 2 * int(cd.MaxPower)
+	if d.dd.BcdUSB >= 0x0300 {
+		ma = 8 * int(cd.MaxPower)
+	}
+	p.line("%-19s %5dmA", "MaxPower", ma)
+	p.extra(descriptor.MATCH_ANY, descriptor.MATCH_ANY, descriptor.MATCH_ANY, cd.Extra)
+	for _, itf := range cd.Interface {
+		for _, id := range itf.Altsetting {
+			p.interface_descriptor(d, id)
+		}
+	}
+	p.pop()
+}
+
+func (p *printer) bos(d *device) {
+	if d.hdl == nil || d.dd.BcdUSB < 0x0201 {
+		return
+	}
+	bos, err := libusb.Get_BOS_Descriptor(d.hdl)
+	if err != nil {
+		return
+	}
+	p.line("Binary Object Store Descriptor:")
+	p.push()
+	p.field("bLength", bos.BLength, "")
+	p.field("bDescriptorType", bos.BDescriptorType, "")
+	p.line("%-19s 0x%04x", "wTotalLength", bos.WTotalLength)
+	p.field("bNumDeviceCaps", len(bos.Dev_capability), "")
+	for _, dev_cap := range bos.Dev_capability {
+		name, ok := capability_name[dev_cap.BDevCapabilityType]
+		if !ok {
+			name = fmt.Sprintf("Device Capability 0x%02x", dev_cap.BDevCapabilityType)
+		}
+		p.line("%s:", name)
+		p.push()
+		p.field("bLength", dev_cap.BLength, "")
+		p.field("bDescriptorType", dev_cap.BDescriptorType, "")
+		p.field("bDevCapabilityType", dev_cap.BDevCapabilityType, "")
+		switch dev_cap.BDevCapabilityType {
+		case libusb.BT_USB_2_0_EXTENSION:
+			x, err := libusb.Get_USB_2_0_Extension_Descriptor(nil, dev_cap)
+			if err == nil {
+				p.line("%-19s 0x%08x", "bmAttributes", x.BmAttributes)
+				if x.BmAttributes&libusb.BM_LPM_SUPPORT != 0 {
+					p.line("  Link Power Management (LPM) Supported")
+				}
+			}
+		case libusb.BT_SS_USB_DEVICE_CAPABILITY:
+			x, err := libusb.Get_SS_USB_Device_Capability_Descriptor(nil, dev_cap)
+			if err == nil {
+				p.line("%-19s  0x%02x", "bmAttributes", x.BmAttributes)
+				p.line("%-19s 0x%04x", "wSpeedsSupported", x.WSpeedSupported)
+				p.field("bFunctionalitySupport", x.BFunctionalitySupport, "")
+				p.field("bU1DevExitLat", x.BU1DevExitLat, "micro seconds")
+				p.field("bU2DevExitLat", x.BU2DevExitLat, "micro seconds")
+			}
+		case libusb.BT_CONTAINER_ID:
+			x, err := libusb.Get_Container_ID_Descriptor(nil, dev_cap)
+			if err == nil {
+				p.field("bReserved", x.BReserved, "")
+				p.line("%-19s {%x}", "ContainerID", x.ContainerID)
+			}
+		default:
+			p.line("%-19s % x", "data", dev_cap.Dev_capability_data)
+		}
+		p.pop()
+	}
+	p.pop()
+}
+
+func (p *printer) device(d *device) {
+	dd := d.dd
+	p.line("Device Descriptor:")
+	p.push()
+	p.field("bLength", dd.BLength, "")
+	p.field("bDescriptorType", dd.BDescriptorType, "")
+	p.field("bcdUSB", bcd(dd.BcdUSB), "")
+	p.field("bDeviceClass", dd.BDeviceClass, dd.Class().String())
+	p.field("bDeviceSubClass", dd.BDeviceSubClass, "")
+	p.field("bDeviceProtocol", dd.BDeviceProtocol, "")
+	p.field("bMaxPacketSize0", dd.BMaxPacketSize0, "")
+	p.line("%-19s 0x%04x", "idVendor", dd.IdVendor)
+	p.line("%-19s 0x%04x", "idProduct", dd.IdProduct)
+	p.field("bcdDevice", bcd(dd.BcdDevice), "")
+	p.field("iManufacturer", dd.IManufacturer, d.str(dd.IManufacturer))
+	p.field("iProduct", dd.IProduct, d.str(dd.IProduct))
+	p.field("iSerial", dd.ISerialNumber, d.str(dd.ISerialNumber))
+	p.field("bNumConfigurations", dd.BNumConfigurations, "")
+	for i := uint8(0); i < dd.BNumConfigurations; i++ {
+		cd, err := libusb.Get_Config_Descriptor(d.dev, i)
+		if err != nil {
+			p.line("** can't get configuration descriptor %d: %s", i, err)
+			continue
+		}
+		p.config(d, cd)
+	}
+	p.pop()
+	p.bos(d)
+}
+
+//-----------------------------------------------------------------------------
+// tree output
+
+func (d *device) port() uint8 {
+	if len(d.path) == 0 {
+		return 0
+	}
+	return d.path[len(d.path)-1]
+}
+
+// print a device and its children
+func print_tree(d *device, children map[libusb.Device][]*device, depth int) {
+	speed := fmt.Sprintf("%gM", libusb.Get_Device_Speed(d.dev).Mbps())
+	dev := libusb.Get_Device_Address(d.dev)
+	prefix := strings.Repeat("    ", depth) + "|__ "
+	if len(d.path) == 0 {
+		// root hub
+		fmt.Printf("/:  Bus %02d.Port 1: Dev %d, Class=root_hub, %s\n", libusb.Get_Bus_Number(d.dev), dev, speed)
+	} else {
+		cd, err := libusb.Get_Active_Config_Descriptor(d.dev)
+		if err != nil || len(cd.Interface) == 0 {
+			fmt.Printf("%sPort %d: Dev %d, Class=%s, %s\n", prefix, d.port(), dev, d.dd.Class(), speed)
+		} else {
+			for _, itf := range cd.Interface {
+				if len(itf.Altsetting) == 0 {
+					continue
+				}
+				id := itf.Altsetting[0]
+				fmt.Printf("%sPort %d: Dev %d, If %d, Class=%s, %s\n", prefix, d.port(), dev, id.BInterfaceNumber, id.Class(), speed)
+			}
+		}
+	}
+	kids := children[d.dev]
+	sort.Slice(kids, func(i, j int) bool { return kids[i].port() < kids[j].port() })
+	for _, c := range kids {
+		print_tree(c, children, depth+1)
+	}
+}
+
+func tree(devices []*device) {
+	children := make(map[libusb.Device][]*device)
+	var roots []*device
+	for _, d := range devices {
+		parent := libusb.Get_Parent(d.dev)
+		if parent == nil {
+			roots = append(roots, d)
+		} else {
+			children[parent] = append(children[parent], d)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return libusb.Get_Bus_Number(roots[i].dev) < libusb.Get_Bus_Number(roots[j].dev)
+	})
+	for _, d := range roots {
+		print_tree(d, children, 0)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// device selection
+
+type selector struct {
+	bus, dev        int // -1 for any
+	vendor, product int // -1 for any
+}
+
+// parse "a:b", "a:", ":b" or "b" into two numbers, -1 for a missing number
+func parse_pair(s string, base int) (int, int, error) {
+	a, b := "", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		a, b = s[:i], s[i+1:]
+	}
+	num := func(x string) (int, error) {
+		if x == "" {
+			return -1, nil
+		}
+		n, err := strconv.ParseUint(x, base, 16)
+		return int(n), err
+	}
+	x, err := num(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	y, err := num(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return x, y, nil
+}
+
+func (s *selector) match(d *device) bool {
+	return (s.bus < 0 || s.bus == int(libusb.Get_Bus_Number(d.dev))) &&
+		(s.dev < 0 || s.dev == int(libusb.Get_Device_Address(d.dev))) &&
+		(s.vendor < 0 || s.vendor == int(d.dd.IdVendor)) &&
+		(s.product < 0 || s.product == int(d.dd.IdProduct))
+}
+
+//-----------------------------------------------------------------------------
+
 func main() {
+	verbose := flag.Bool("v", false, "verbose, print the descriptors of each device")
+	show_tree := flag.Bool("t", false, "print the device tree by port topology")
+	show_json := flag.Bool("json", false, "print a JSON snapshot of each device")
+	show_yaml := flag.Bool("yaml", false, "print a YAML snapshot of each device")
+	bus_dev := flag.String("s", "", "show devices with `[[bus]:][devnum]` (decimal)")
+	vid_pid := flag.String("d", "", "show devices with `[vendor]:[product]` (hex)")
+	flag.Parse()
+
+	sel := &selector{-1, -1, -1, -1}
+	var err error
+	if *bus_dev != "" {
+		sel.bus, sel.dev, err = parse_pair(*bus_dev, 10)
+		if err != nil {
+			log.Fatalf("bad -s argument %q", *bus_dev)
+		}
+	}
+	if *vid_pid != "" {
+		if !strings.Contains(*vid_pid, ":") {
+			log.Fatalf("bad -d argument %q", *vid_pid)
+		}
+		sel.vendor, sel.product, err = parse_pair(*vid_pid, 16)
+		if err != nil {
+			log.Fatalf("bad -d argument %q", *vid_pid)
+		}
+	}
 
 	var ctx libusb.Context
-	err := libusb.Init(&ctx)
+	err = libusb.Init(&ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -21,17 +439,78 @@ func main() {
 	}
 	defer libusb.Free_Device_List(list, 1)
 
+	devices := make([]*device, 0, len(list))
 	for _, dev := range list {
 		dd, err := libusb.Get_Device_Descriptor(dev)
 		if err != nil {
 			log.Fatal(err)
 		}
-		path := make([]byte, 8)
-		path, err = libusb.Get_Port_Numbers(dev, path)
+		path, err := libusb.Get_Port_Numbers(dev, make([]byte, 8))
+		if err != nil {
+			log.Fatal(err)
+		}
+		devices = append(devices, &device{dev: dev, dd: dd, path: path})
+	}
+
+	if *show_tree {
+		tree(devices)
+		return
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		bi, bj := libusb.Get_Bus_Number(devices[i].dev), libusb.Get_Bus_Number(devices[j].dev)
+		if bi != bj {
+			return bi < bj
+		}
+		return libusb.Get_Device_Address(devices[i].dev) < libusb.Get_Device_Address(devices[j].dev)
+	})
+
+	found := false
+	infos := make([]*libusb.Device_Info, 0)
+	for _, d := range devices {
+		if !sel.match(d) {
+			continue
+		}
+		found = true
+		if *show_json || *show_yaml {
+			// a partial snapshot records its error
+			info, err := libusb.Get_Device_Info(d.dev)
+			if info == nil {
+				log.Fatal(err)
+			}
+			infos = append(infos, info)
+			continue
+		}
+		fmt.Printf("Bus %03d Device %03d: ID %04x:%04x\n", libusb.Get_Bus_Number(d.dev), libusb.Get_Device_Address(d.dev), d.dd.IdVendor, d.dd.IdProduct)
+		if *verbose {
+			d.hdl, err = libusb.Open(d.dev)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Couldn't open device, some information will be missing\n")
+			}
+			p := &printer{}
+			p.device(d)
+			if d.hdl != nil {
+				libusb.Close(d.hdl)
+			}
+			fmt.Printf("\n")
+		}
+	}
+	if *show_json || *show_yaml {
+		var b []byte
+		if *show_json {
+			b, err = libusb.Device_Info_JSON(infos)
+			b = append(b, '\n')
+		} else {
+			b, err = libusb.Device_Info_YAML(infos)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Printf("Bus %03d Device %03d: ID %04x:%04x", libusb.Get_Bus_Number(dev), libusb.Get_Device_Address(dev), dd.IdVendor, dd.IdProduct)
-		fmt.Printf("%v %d\n", path, libusb.Get_Port_Number(dev))
+		os.Stdout.Write(b)
+	}
+	if !found && (*bus_dev != "" || *vid_pid != "") {
+		os.Exit(1)
 	}
 }
+
+//-----------------------------------------------------------------------------
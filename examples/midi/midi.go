@@ -6,6 +6,7 @@ Simple driver for a MIDI keyboard
 Open the device with the provided VID/PID.
 Bulk read from the first input endpoint.
 Interpret the data as USB MIDI events.
+Reads are cancelled on SIGINT/SIGTERM.
 
 */
 //-----------------------------------------------------------------------------
@@ -13,6 +14,7 @@ Interpret the data as USB MIDI events.
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/deadsy/libusb"
 	"os"
@@ -25,8 +27,6 @@ type ep_info struct {
 	ep  *libusb.Endpoint_Descriptor
 }
 
-var quit bool = false
-
 const NOTES_IN_OCTAVE = 12
 
 func midi_note_name(note byte, mode string) string {
@@ -72,7 +72,7 @@ func midi_event(event []byte) {
 	}
 }
 
-func midi_device(ctx libusb.Context, vid uint16, pid uint16) {
+func midi_device(done context.Context, ctx libusb.Context, vid uint16, pid uint16) {
 	fmt.Printf("Opening device %04X:%04X ", vid, pid)
 	hdl := libusb.Open_Device_With_VID_PID(ctx, vid, pid)
 	if hdl == nil {
@@ -102,11 +102,11 @@ func midi_device(ctx libusb.Context, vid uint16, pid uint16) {
 		// iterate across endpoints
 		for _, itf := range cd.Interface {
 			for _, id := range itf.Altsetting {
-				if id.BInterfaceClass == libusb.CLASS_AUDIO && id.BInterfaceSubClass == 3 {
+				if id.Class() == libusb.CLASS_AUDIO && id.BInterfaceSubClass == 3 {
 					midi_found = true
 				}
 				for _, ep := range id.Endpoint {
-					if ep.BEndpointAddress&libusb.ENDPOINT_IN != 0 {
+					if ep.Direction() == libusb.DIRECTION_IN {
 						ep_in = append(ep_in, ep_info{itf: i, ep: ep})
 					}
 				}
@@ -133,8 +133,8 @@ func midi_device(ctx libusb.Context, vid uint16, pid uint16) {
 	defer libusb.Release_Interface(hdl, ep_in[0].itf)
 
 	data := make([]byte, ep_in[0].ep.WMaxPacketSize)
-	for quit == false {
-		data, err := libusb.Bulk_Transfer(hdl, ep_in[0].ep.BEndpointAddress, data, 1000)
+	for done.Err() == nil {
+		data, err := libusb.BulkTransferContext(done, hdl, ep_in[0].ep.BEndpointAddress, data)
 		if err == nil {
 			for i := 0; i < len(data); i += 4 {
 				// each midi event is 4 bytes
@@ -144,7 +144,7 @@ func midi_device(ctx libusb.Context, vid uint16, pid uint16) {
 	}
 }
 
-func midi_main() int {
+func midi_main(done context.Context) int {
 	var ctx libusb.Context
 	err := libusb.Init(&ctx)
 	if err != nil {
@@ -153,8 +153,16 @@ func midi_main() int {
 	}
 	defer libusb.Exit(ctx)
 
-	midi_device(ctx, 0x0944, 0x0115) // Korg Nano Key 2
-	//midi_device(ctx, 0x041e, 0x3f0e) // Creative Technology, E-MU XMidi1X1 Tab
+	// the transfers complete in the event loop
+	err = libusb.StartEventLoop(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return -1
+	}
+	defer libusb.StopEventLoop(ctx)
+
+	midi_device(done, ctx, 0x0944, 0x0115) // Korg Nano Key 2
+	//midi_device(done, ctx, 0x041e, 0x3f0e) // Creative Technology, E-MU XMidi1X1 Tab
 
 	return 0
 }
@@ -164,11 +172,12 @@ func main() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	done, cancel := context.WithCancel(context.Background())
 	go func() {
 		sig := <-sigs
 		fmt.Printf("\n%s\n", sig)
-		quit = true
+		cancel()
 	}()
 
-	os.Exit(midi_main())
+	os.Exit(midi_main(done))
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/usb/hid"
 	"os"
 )
 
@@ -21,167 +23,87 @@ var test_mode int
 
 //-----------------------------------------------------------------------------
 
-// HID Class-Specific Requests values. See section 7.2 of the HID specifications
-const HID_GET_REPORT = 0x01
-const HID_GET_IDLE = 0x02
-const HID_GET_PROTOCOL = 0x03
-const HID_SET_REPORT = 0x09
-const HID_SET_IDLE = 0x0A
-const HID_SET_PROTOCOL = 0x0B
-const HID_REPORT_TYPE_INPUT = 0x01
-const HID_REPORT_TYPE_OUTPUT = 0x02
-const HID_REPORT_TYPE_FEATURE = 0x03
-
-/*
-
-func get_hid_record_size(hid_report_descriptor []byte, record_type int) int {
-{
-	//uint8_t i, j = 0;
-	//uint8_t offset;
-	//int record_size[3] = {0, 0, 0};
-	//int nb_bits = 0, nb_items = 0;
-	//bool found_record_marker;
-
-	found_record_marker := false
-	for i := hid_report_descriptor[0]+1; i < len(hid_report_descriptor); i += offset {
-		offset = (hid_report_descriptor[i]&0x03) + 1
-		if offset == 4 {
-			offset = 5
-    }
-		switch hid_report_descriptor[i] & 0xFC {
-		case 0x74:	// bitsize
-			nb_bits = hid_report_descriptor[i+1];
-
-		case 0x94:	// count
-			nb_items = 0
-			for j:=1; j<offset; j++ {
-				nb_items = ((uint32_t)hid_report_descriptor[i+j]) << (8*(j-1))
-			}
-
-		case 0x80:	// input
-			found_record_marker = true
-			j = 0
-
-		case 0x90:	// output
-			found_record_marker = true
-			j = 1
-
-		case 0xb0:	// feature
-			found_record_marker = true
-			j = 2
-
-		case 0xC0:	// end of collection
-			nb_items = 0
-			nb_bits = 0
-
-		default:
-			continue
-		}
-		if found_record_marker {
-			found_record_marker = false
-			record_size[j] += nb_items*nb_bits
-		}
+// hid_report_size returns the report ID and length of the first report of a type.
+// The length includes the report ID for numbered reports.
+func hid_report_size(report *hid.Report_Descriptor, t hid.Report_Type) (uint8, int) {
+	ids := report.Report_IDs()
+	if len(ids) == 0 {
+		return 0, report.Report_Length(t, 0)
 	}
-
-	if (record_type < HID_REPORT_TYPE_INPUT) || (record_type > HID_REPORT_TYPE_FEATURE) {
-		return 0
+	for _, id := range ids {
+		if n := report.Report_Length(t, id); n != 0 {
+			return id, n + 1
+		}
 	}
-	return record_size[record_type - HID_REPORT_TYPE_INPUT]+7)/8
+	return 0, 0
 }
 
-*/
-
 func test_hid(handle libusb.Device_Handle, endpoint_in uint8) int {
-	//int r, size, descriptor_size;
-	hid_report_descriptor := make([]byte, 256)
-	//uint8_t *report_buffer;
-	//FILE *fd;
-
 	fmt.Printf("\nReading HID Report Descriptors:\n")
 	hid_report_descriptor, err := libusb.Control_Transfer(handle, libusb.ENDPOINT_IN|libusb.REQUEST_TYPE_STANDARD|libusb.RECIPIENT_INTERFACE,
-		libusb.REQUEST_GET_DESCRIPTOR, libusb.DT_REPORT<<8, 0, hid_report_descriptor, 1000)
+		libusb.REQUEST_GET_DESCRIPTOR, libusb.DT_REPORT<<8, 0, make([]byte, 256), 1000)
 	if err != nil {
 		fmt.Printf("   Failed\n")
 		return -1
 	}
 	fmt.Printf("%s\n", hex.Dump(hid_report_descriptor))
+	report, err := hid.Parse_Report_Descriptor(hid_report_descriptor)
+	if err != nil {
+		fmt.Printf("   %s\n", err)
+		return -1
+	}
+	for _, f := range report.Fields {
+		fmt.Printf("   %s\n", f)
+	}
 
-	/*
-
-		size = get_hid_record_size(hid_report_descriptor, descriptor_size, HID_REPORT_TYPE_FEATURE);
-		if (size <= 0) {
-			printf("\nSkipping Feature Report readout (None detected)\n");
-		} else {
-			report_buffer = (uint8_t*) calloc(size, 1);
-			if (report_buffer == NULL) {
-				return -1;
-			}
-
-			printf("\nReading Feature Report (length %d)...\n", size);
-			r = libusb_control_transfer(handle, LIBUSB_ENDPOINT_IN|LIBUSB_REQUEST_TYPE_CLASS|LIBUSB_RECIPIENT_INTERFACE,
-				HID_GET_REPORT, (HID_REPORT_TYPE_FEATURE<<8)|0, 0, report_buffer, (uint16_t)size, 5000);
-			if (r >= 0) {
-				display_buffer_hex(report_buffer, size);
-			} else {
-				switch(r) {
-				case LIBUSB_ERROR_NOT_FOUND:
-					printf("   No Feature Report available for this device\n");
-					break;
-				case LIBUSB_ERROR_PIPE:
-					printf("   Detected stall - resetting pipe...\n");
-					libusb_clear_halt(handle, 0);
-					break;
-				default:
-					printf("   Error: %s\n", libusb_strerror((enum libusb_error)r));
-					break;
-				}
-			}
-			free(report_buffer);
-		}
-
-		size = get_hid_record_size(hid_report_descriptor, descriptor_size, HID_REPORT_TYPE_INPUT);
-		if (size <= 0) {
-			printf("\nSkipping Input Report readout (None detected)\n");
-		} else {
-			report_buffer = (uint8_t*) calloc(size, 1);
-			if (report_buffer == NULL) {
-				return -1;
-			}
-
-			printf("\nReading Input Report (length %d)...\n", size);
-			r = libusb_control_transfer(handle, LIBUSB_ENDPOINT_IN|LIBUSB_REQUEST_TYPE_CLASS|LIBUSB_RECIPIENT_INTERFACE,
-				HID_GET_REPORT, (HID_REPORT_TYPE_INPUT<<8)|0x00, 0, report_buffer, (uint16_t)size, 5000);
-			if (r >= 0) {
-				display_buffer_hex(report_buffer, size);
-			} else {
-				switch(r) {
-				case LIBUSB_ERROR_TIMEOUT:
-					printf("   Timeout! Please make sure you act on the device within the 5 seconds allocated...\n");
-					break;
-				case LIBUSB_ERROR_PIPE:
-					printf("   Detected stall - resetting pipe...\n");
-					libusb_clear_halt(handle, 0);
-					break;
-				default:
-					printf("   Error: %s\n", libusb_strerror((enum libusb_error)r));
-					break;
-				}
-			}
-
-			// Attempt a bulk read from endpoint 0 (this should just return a raw input report)
-			printf("\nTesting interrupt read using endpoint %02X...\n", endpoint_in);
-			r = libusb_interrupt_transfer(handle, endpoint_in, report_buffer, size, &size, 5000);
-			if (r >= 0) {
-				display_buffer_hex(report_buffer, size);
-			} else {
-				printf("   %s\n", libusb_strerror((enum libusb_error)r));
-			}
-
-			free(report_buffer);
+	id, size := hid_report_size(report, hid.REPORT_TYPE_FEATURE)
+	if size == 0 {
+		fmt.Printf("\nSkipping Feature Report readout (None detected)\n")
+	} else {
+		fmt.Printf("\nReading Feature Report (length %d)...\n", size)
+		buf, err := libusb.Control_Transfer(handle, libusb.ENDPOINT_IN|libusb.REQUEST_TYPE_CLASS|libusb.RECIPIENT_INTERFACE,
+			hid.GET_REPORT, uint16(hid.REPORT_TYPE_FEATURE)<<8|uint16(id), 0, make([]byte, size), 5000)
+		switch {
+		case err == nil:
+			fmt.Printf("%s", hex.Dump(buf))
+		case errors.Is(err, libusb.ErrNotFound):
+			fmt.Printf("   No Feature Report available for this device\n")
+		case errors.Is(err, libusb.ErrPipe):
+			fmt.Printf("   Detected stall - resetting pipe...\n")
+			libusb.Clear_Halt(handle, 0)
+		default:
+			fmt.Printf("   Error: %s\n", err)
 		}
+	}
 
-	*/
+	id, size = hid_report_size(report, hid.REPORT_TYPE_INPUT)
+	if size == 0 {
+		fmt.Printf("\nSkipping Input Report readout (None detected)\n")
+		return 0
+	}
+	fmt.Printf("\nReading Input Report (length %d)...\n", size)
+	buf, err := libusb.Control_Transfer(handle, libusb.ENDPOINT_IN|libusb.REQUEST_TYPE_CLASS|libusb.RECIPIENT_INTERFACE,
+		hid.GET_REPORT, uint16(hid.REPORT_TYPE_INPUT)<<8|uint16(id), 0, make([]byte, size), 5000)
+	switch {
+	case err == nil:
+		fmt.Printf("%s", hex.Dump(buf))
+	case errors.Is(err, libusb.ErrTimeout):
+		fmt.Printf("   Timeout! Please make sure you act on the device within the 5 seconds allocated...\n")
+	case errors.Is(err, libusb.ErrPipe):
+		fmt.Printf("   Detected stall - resetting pipe...\n")
+		libusb.Clear_Halt(handle, 0)
+	default:
+		fmt.Printf("   Error: %s\n", err)
+	}
 
+	// Attempt an interrupt read (this should just return a raw input report)
+	fmt.Printf("\nTesting interrupt read using endpoint %02X...\n", endpoint_in)
+	buf, err = libusb.Interrupt_Transfer(handle, endpoint_in, make([]byte, size), 5000)
+	if err == nil {
+		fmt.Printf("%s", hex.Dump(buf))
+	} else {
+		fmt.Printf("   %s\n", err)
+	}
 	return 0
 }
 
@@ -229,13 +151,6 @@ func print_device_cap(dev_cap *libusb.BOS_Dev_Capability_Descriptor) {
 
 func test_device(vid uint16, pid uint16) int {
 	port_path := make([]byte, 8)
-	speed_name := [5]string{
-		"Unknown",
-		"1.5 Mbit/s (USB LowSpeed)",
-		"12 Mbit/s (USB FullSpeed)",
-		"480 Mbit/s (USB HighSpeed)",
-		"5000 Mbit/s (USB SuperSpeed)",
-	}
 
 	string_index := make([]byte, 3) // indexes of the string descriptors
 	// default IN and OUT endpoints
@@ -263,11 +178,7 @@ func test_device(vid uint16, pid uint16) int {
 			}
 			fmt.Printf(" (from root hub)\n")
 		}
-		r := libusb.Get_Device_Speed(dev)
-		if (r < 0) || (r > 4) {
-			r = 0
-		}
-		fmt.Printf("             speed: %s\n", speed_name[r])
+		fmt.Printf("             speed: %s\n", libusb.Get_Device_Speed(dev))
 	}
 
 	fmt.Printf("\nReading device descriptor:\n")
@@ -321,7 +232,7 @@ func test_device(vid uint16, pid uint16) int {
 				conf_desc.Interface[i].Altsetting[j].BInterfaceClass,
 				conf_desc.Interface[i].Altsetting[j].BInterfaceSubClass,
 				conf_desc.Interface[i].Altsetting[j].BInterfaceProtocol)
-			if (conf_desc.Interface[i].Altsetting[j].BInterfaceClass == libusb.CLASS_MASS_STORAGE) &&
+			if (conf_desc.Interface[i].Altsetting[j].Class() == libusb.CLASS_MASS_STORAGE) &&
 				((conf_desc.Interface[i].Altsetting[j].BInterfaceSubClass == 0x01) ||
 					(conf_desc.Interface[i].Altsetting[j].BInterfaceSubClass == 0x06)) &&
 				(conf_desc.Interface[i].Altsetting[j].BInterfaceProtocol == 0x50) {
@@ -333,7 +244,7 @@ func test_device(vid uint16, pid uint16) int {
 				endpoint := conf_desc.Interface[i].Altsetting[j].Endpoint[k]
 				fmt.Printf("       endpoint[%d].address: %02X\n", k, endpoint.BEndpointAddress)
 				// Use the first interrupt or bulk IN/OUT endpoints as default for testing
-				if (endpoint.BmAttributes&libusb.TRANSFER_TYPE_MASK)&(libusb.TRANSFER_TYPE_BULK|libusb.TRANSFER_TYPE_INTERRUPT) != 0 {
+				if endpoint.TransferType()&(libusb.TRANSFER_TYPE_BULK|libusb.TRANSFER_TYPE_INTERRUPT) != 0 {
 					if endpoint.BEndpointAddress&libusb.ENDPOINT_IN != 0 {
 						if endpoint_in == 0 {
 							endpoint_in = endpoint.BEndpointAddress
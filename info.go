@@ -0,0 +1,174 @@
+//-----------------------------------------------------------------------------
+/*
+
+Device snapshots
+
+A Device_Info holds everything known about a device in Go memory, and has
+stable JSON and YAML encodings for storing and comparing device trees.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/deadsy/libusb/descriptor"
+)
+
+//-----------------------------------------------------------------------------
+
+// Device_Location identifies a device on the system.
+type Device_Location struct {
+	Bus       uint8 `json:"bus"`
+	Address   uint8 `json:"address"`
+	Port_Path []int `json:"port_path"`
+}
+
+// Device_Info is a snapshot of a device and its descriptors.
+type Device_Info struct {
+	Device_Location
+	Parent       *Device_Location     `json:"parent"` // nil for a root hub
+	Speed        Speed                `json:"speed"`
+	Speed_Name   string               `json:"speed_name"`
+	Manufacturer string               `json:"manufacturer"`
+	Product      string               `json:"product"`
+	Serial       string               `json:"serial"`
+	Strings      map[uint8]string     `json:"strings"` // all string descriptors referenced by the descriptors
+	Device       *Device_Descriptor   `json:"device"`
+	Config       []*Config_Descriptor `json:"config"`
+	BOS          *BOS_Descriptor      `json:"bos"`             // nil if the device has none or can't be opened
+	Error        string               `json:"error,omitempty"` // the first error reading the descriptors
+}
+
+// return the location of a device
+func device_location(dev Device) *Device_Location {
+	path, _ := Get_Port_Numbers(dev, make([]byte, 8))
+	loc := &Device_Location{
+		Bus:       Get_Bus_Number(dev),
+		Address:   Get_Device_Address(dev),
+		Port_Path: make([]int, len(path)),
+	}
+	for i, p := range path {
+		loc.Port_Path[i] = int(p)
+	}
+	return loc
+}
+
+// return the string descriptor indices used by the descriptors
+func string_indices(info *Device_Info) []uint8 {
+	idx := []uint8{info.Device.IManufacturer, info.Device.IProduct, info.Device.ISerialNumber}
+	for _, cd := range info.Config {
+		idx = append(idx, cd.IConfiguration)
+		for _, itf := range cd.Interface {
+			for _, id := range itf.Altsetting {
+				idx = append(idx, id.IInterface)
+			}
+		}
+	}
+	return idx
+}
+
+// Get_Device_Info returns a snapshot of a device.
+// String and BOS descriptors are only read if the device can be opened.
+// Configurations that can't be read are left out, the snapshot is returned
+// along with the first error, which is also recorded in the Error field.
+func Get_Device_Info(dev Device) (*Device_Info, error) {
+	dd, err := Get_Device_Descriptor(dev)
+	if err != nil {
+		return nil, err
+	}
+	speed := Get_Device_Speed(dev)
+	info := &Device_Info{
+		Device_Location: *device_location(dev),
+		Speed:           speed,
+		Speed_Name:      speed.String(),
+		Strings:         make(map[uint8]string),
+		Device:          dd,
+		Config:          make([]*Config_Descriptor, 0, dd.BNumConfigurations),
+	}
+	if parent := Get_Parent(dev); parent != nil {
+		info.Parent = device_location(parent)
+	}
+	var cfg_err error
+	for i := uint8(0); i < dd.BNumConfigurations; i++ {
+		cd, err := Get_Config_Descriptor(dev, i)
+		if err != nil {
+			if cfg_err == nil {
+				cfg_err = err
+				info.Error = err.Error()
+			}
+			continue
+		}
+		info.Config = append(info.Config, cd)
+	}
+
+	hdl, err := Open(dev)
+	if err != nil {
+		return info, cfg_err
+	}
+	defer Close(hdl)
+	// strings are read in the first language, see GetStringPreferred
+	langids, _ := GetLanguages(hdl)
+	langid, ok := descriptor.Pick_Language(langids, nil)
+	for _, i := range string_indices(info) {
+		if !ok || i == 0 {
+			continue
+		}
+		if _, ok := info.Strings[i]; ok {
+			continue
+		}
+		s, err := GetString(hdl, i, langid)
+		if err == nil {
+			info.Strings[i] = s
+		}
+	}
+	info.Manufacturer = info.Strings[dd.IManufacturer]
+	info.Product = info.Strings[dd.IProduct]
+	info.Serial = info.Strings[dd.ISerialNumber]
+	if dd.BcdUSB >= 0x0201 {
+		info.BOS, _ = Get_BOS_Descriptor(hdl)
+	}
+	return info, cfg_err
+}
+
+// Get_Device_Infos returns a snapshot of all devices, ordered by bus and address.
+// Devices that can't be read in full are included with their Error field set,
+// devices without a device descriptor are left out.
+func Get_Device_Infos(ctx Context) ([]*Device_Info, error) {
+	list, err := Get_Device_List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer Free_Device_List(list, 1)
+	infos := make([]*Device_Info, 0, len(list))
+	for _, dev := range list {
+		info, _ := Get_Device_Info(dev)
+		if info != nil {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Bus != infos[j].Bus {
+			return infos[i].Bus < infos[j].Bus
+		}
+		return infos[i].Address < infos[j].Address
+	})
+	return infos, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Device_Info_JSON returns the indented JSON encoding of device snapshots.
+func Device_Info_JSON(infos []*Device_Info) ([]byte, error) {
+	return json.MarshalIndent(infos, "", "  ")
+}
+
+// Device_Info_YAML returns the YAML encoding of device snapshots.
+func Device_Info_YAML(infos []*Device_Info) ([]byte, error) {
+	return descriptor.Marshal_YAML(infos)
+}
+
+//-----------------------------------------------------------------------------
@@ -12,6 +12,7 @@ package libusb
 /*
 #cgo LDFLAGS: -lusb-1.0
 #include <libusb-1.0/libusb.h>
+#include <stdlib.h>
 
 // When a C struct ends with a zero-sized field, but the struct itself is not zero-sized,
 // Go code can no longer refer to the zero-sized field. Any such references will have to be rewritten.
@@ -26,14 +27,62 @@ static struct libusb_bos_dev_capability_descriptor **dev_capability_ptr(struct l
   return &x->dev_capability[0];
 }
 
+static struct libusb_iso_packet_descriptor *iso_packet_desc_ptr(struct libusb_transfer *x) {
+  return &x->iso_packet_desc[0];
+}
+
+// Go callbacks are exported from callback.go. The user_data pointer carries
+// an integer id used to find the Go function, so no Go pointers are passed to C.
+
+extern int go_hotplug_callback(struct libusb_context *ctx, struct libusb_device *dev, libusb_hotplug_event event, void *user_data);
+
+static int hotplug_register_callback(libusb_context *ctx, int events, int flags, int vendor_id, int product_id, int dev_class, uintptr_t id, libusb_hotplug_callback_handle *handle) {
+  return libusb_hotplug_register_callback(ctx, (libusb_hotplug_event)events, (libusb_hotplug_flag)flags, vendor_id, product_id, dev_class,
+    (libusb_hotplug_callback_fn)go_hotplug_callback, (void *)id, handle);
+}
+
+extern void go_transfer_callback(struct libusb_transfer *transfer);
+
+static void fill_control_transfer(struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char *buffer, uintptr_t id, unsigned int timeout) {
+  libusb_fill_control_transfer(transfer, dev_handle, buffer, go_transfer_callback, (void *)id, timeout);
+}
+
+static void fill_bulk_transfer(struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, unsigned char *buffer, int length, uintptr_t id, unsigned int timeout) {
+  libusb_fill_bulk_transfer(transfer, dev_handle, endpoint, buffer, length, go_transfer_callback, (void *)id, timeout);
+}
+
+static void fill_bulk_stream_transfer(struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, uint32_t stream_id, unsigned char *buffer, int length, uintptr_t id, unsigned int timeout) {
+  libusb_fill_bulk_stream_transfer(transfer, dev_handle, endpoint, stream_id, buffer, length, go_transfer_callback, (void *)id, timeout);
+}
+
+static void fill_interrupt_transfer(struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, unsigned char *buffer, int length, uintptr_t id, unsigned int timeout) {
+  libusb_fill_interrupt_transfer(transfer, dev_handle, endpoint, buffer, length, go_transfer_callback, (void *)id, timeout);
+}
+
+extern void go_pollfd_added(int fd, short events, void *user_data);
+extern void go_pollfd_removed(int fd, void *user_data);
+
+static void set_pollfd_notifiers(libusb_context *ctx, uintptr_t id) {
+  libusb_set_pollfd_notifiers(ctx, go_pollfd_added, go_pollfd_removed, (void *)id);
+}
+
+static void fill_iso_transfer(struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, unsigned char *buffer, int length, int num_iso_packets, uintptr_t id, unsigned int timeout) {
+  libusb_fill_iso_transfer(transfer, dev_handle, endpoint, buffer, length, num_iso_packets, go_transfer_callback, (void *)id, timeout);
+}
+
 */
 import "C"
 
 import (
+	"encoding/binary"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 	"unsafe"
+
+	"github.com/deadsy/libusb/descriptor"
 )
 
 //-----------------------------------------------------------------------------
@@ -55,6 +104,14 @@ func indent(s string) string {
 	return strings.Join(x, "\n")
 }
 
+// return a C pointer to the slice data, nil for an empty slice
+func go2c_Data(x []byte) *C.uchar {
+	if len(x) == 0 {
+		return nil
+	}
+	return (*C.uchar)(&x[0])
+}
+
 // return a string for the extra buffer
 func Extra_str(x []byte) string {
 	s := make([]string, len(x))
@@ -70,26 +127,28 @@ func Extra_str(x []byte) string {
 const API_VERSION = C.LIBUSB_API_VERSION
 
 // Device and/or Interface Class codes.
+type Class = descriptor.Class
+
 const (
-	CLASS_PER_INTERFACE       = C.LIBUSB_CLASS_PER_INTERFACE
-	CLASS_AUDIO               = C.LIBUSB_CLASS_AUDIO
-	CLASS_COMM                = C.LIBUSB_CLASS_COMM
-	CLASS_HID                 = C.LIBUSB_CLASS_HID
-	CLASS_PHYSICAL            = C.LIBUSB_CLASS_PHYSICAL
-	CLASS_PRINTER             = C.LIBUSB_CLASS_PRINTER
-	CLASS_PTP                 = C.LIBUSB_CLASS_PTP
-	CLASS_IMAGE               = C.LIBUSB_CLASS_IMAGE
-	CLASS_MASS_STORAGE        = C.LIBUSB_CLASS_MASS_STORAGE
-	CLASS_HUB                 = C.LIBUSB_CLASS_HUB
-	CLASS_DATA                = C.LIBUSB_CLASS_DATA
-	CLASS_SMART_CARD          = C.LIBUSB_CLASS_SMART_CARD
-	CLASS_CONTENT_SECURITY    = C.LIBUSB_CLASS_CONTENT_SECURITY
-	CLASS_VIDEO               = C.LIBUSB_CLASS_VIDEO
-	CLASS_PERSONAL_HEALTHCARE = C.LIBUSB_CLASS_PERSONAL_HEALTHCARE
-	CLASS_DIAGNOSTIC_DEVICE   = C.LIBUSB_CLASS_DIAGNOSTIC_DEVICE
-	CLASS_WIRELESS            = C.LIBUSB_CLASS_WIRELESS
-	CLASS_APPLICATION         = C.LIBUSB_CLASS_APPLICATION
-	CLASS_VENDOR_SPEC         = C.LIBUSB_CLASS_VENDOR_SPEC
+	CLASS_PER_INTERFACE       = descriptor.CLASS_PER_INTERFACE
+	CLASS_AUDIO               = descriptor.CLASS_AUDIO
+	CLASS_COMM                = descriptor.CLASS_COMM
+	CLASS_HID                 = descriptor.CLASS_HID
+	CLASS_PHYSICAL            = descriptor.CLASS_PHYSICAL
+	CLASS_PRINTER             = descriptor.CLASS_PRINTER
+	CLASS_PTP                 = descriptor.CLASS_PTP
+	CLASS_IMAGE               = descriptor.CLASS_IMAGE
+	CLASS_MASS_STORAGE        = descriptor.CLASS_MASS_STORAGE
+	CLASS_HUB                 = descriptor.CLASS_HUB
+	CLASS_DATA                = descriptor.CLASS_DATA
+	CLASS_SMART_CARD          = descriptor.CLASS_SMART_CARD
+	CLASS_CONTENT_SECURITY    = descriptor.CLASS_CONTENT_SECURITY
+	CLASS_VIDEO               = descriptor.CLASS_VIDEO
+	CLASS_PERSONAL_HEALTHCARE = descriptor.CLASS_PERSONAL_HEALTHCARE
+	CLASS_DIAGNOSTIC_DEVICE   = descriptor.CLASS_DIAGNOSTIC_DEVICE
+	CLASS_WIRELESS            = descriptor.CLASS_WIRELESS
+	CLASS_APPLICATION         = descriptor.CLASS_APPLICATION
+	CLASS_VENDOR_SPEC         = descriptor.CLASS_VENDOR_SPEC
 )
 
 // Descriptor types as defined by the USB specification.
@@ -128,22 +187,33 @@ const DT_BOS_MAX_SIZE = C.LIBUSB_DT_BOS_MAX_SIZE
 const ENDPOINT_ADDRESS_MASK = C.LIBUSB_ENDPOINT_ADDRESS_MASK
 const ENDPOINT_DIR_MASK = C.LIBUSB_ENDPOINT_DIR_MASK
 
-// Endpoint direction. Values for bit 7 of Endpoint_Descriptor.BEndpointAddress.
+// Endpoint direction bit values for endpoint addresses and request types.
+// Use Direction to compare with Endpoint_Descriptor.Direction().
 const (
 	ENDPOINT_IN  = C.LIBUSB_ENDPOINT_IN  // In: device-to-host.
 	ENDPOINT_OUT = C.LIBUSB_ENDPOINT_OUT // Out: host-to-device.
 )
 
+// Endpoint direction, bit 7 of Endpoint_Descriptor.BEndpointAddress.
+type Direction = descriptor.Direction
+
+const (
+	DIRECTION_IN  = descriptor.DIRECTION_IN
+	DIRECTION_OUT = descriptor.DIRECTION_OUT
+)
+
 // in BmAttributes
 const TRANSFER_TYPE_MASK = C.LIBUSB_TRANSFER_TYPE_MASK
 
 // Endpoint transfer type. Values for bits 0:1 of Endpoint_Descriptor.BmAttributes.
+type Transfer_Type = descriptor.Transfer_Type
+
 const (
-	TRANSFER_TYPE_CONTROL     = C.LIBUSB_TRANSFER_TYPE_CONTROL
-	TRANSFER_TYPE_ISOCHRONOUS = C.LIBUSB_TRANSFER_TYPE_ISOCHRONOUS
-	TRANSFER_TYPE_BULK        = C.LIBUSB_TRANSFER_TYPE_BULK
-	TRANSFER_TYPE_INTERRUPT   = C.LIBUSB_TRANSFER_TYPE_INTERRUPT
-	TRANSFER_TYPE_BULK_STREAM = C.LIBUSB_TRANSFER_TYPE_BULK_STREAM
+	TRANSFER_TYPE_CONTROL     Transfer_Type = C.LIBUSB_TRANSFER_TYPE_CONTROL
+	TRANSFER_TYPE_ISOCHRONOUS Transfer_Type = C.LIBUSB_TRANSFER_TYPE_ISOCHRONOUS
+	TRANSFER_TYPE_BULK        Transfer_Type = C.LIBUSB_TRANSFER_TYPE_BULK
+	TRANSFER_TYPE_INTERRUPT   Transfer_Type = C.LIBUSB_TRANSFER_TYPE_INTERRUPT
+	TRANSFER_TYPE_BULK_STREAM Transfer_Type = C.LIBUSB_TRANSFER_TYPE_BULK_STREAM
 )
 
 // Standard requests, as defined in table 9-5 of the USB 3.0 specifications.
@@ -184,32 +254,38 @@ const ISO_SYNC_TYPE_MASK = C.LIBUSB_ISO_SYNC_TYPE_MASK
 
 // Synchronization type for isochronous endpoints.
 // Values for bits 2:3 of Endpoint_Descriptor.BmAttributes.
+type Sync_Type = descriptor.Sync_Type
+
 const (
-	ISO_SYNC_TYPE_NONE     = C.LIBUSB_ISO_SYNC_TYPE_NONE
-	ISO_SYNC_TYPE_ASYNC    = C.LIBUSB_ISO_SYNC_TYPE_ASYNC
-	ISO_SYNC_TYPE_ADAPTIVE = C.LIBUSB_ISO_SYNC_TYPE_ADAPTIVE
-	ISO_SYNC_TYPE_SYNC     = C.LIBUSB_ISO_SYNC_TYPE_SYNC
+	ISO_SYNC_TYPE_NONE     Sync_Type = C.LIBUSB_ISO_SYNC_TYPE_NONE
+	ISO_SYNC_TYPE_ASYNC    Sync_Type = C.LIBUSB_ISO_SYNC_TYPE_ASYNC
+	ISO_SYNC_TYPE_ADAPTIVE Sync_Type = C.LIBUSB_ISO_SYNC_TYPE_ADAPTIVE
+	ISO_SYNC_TYPE_SYNC     Sync_Type = C.LIBUSB_ISO_SYNC_TYPE_SYNC
 )
 
 const ISO_USAGE_TYPE_MASK = C.LIBUSB_ISO_USAGE_TYPE_MASK
 
 // Usage type for isochronous endpoints.
 // Values for bits 4:5 of Endpoint_Descriptor.BmAttributes.
+type Usage_Type = descriptor.Usage_Type
+
 const (
-	ISO_USAGE_TYPE_DATA     = C.LIBUSB_ISO_USAGE_TYPE_DATA
-	ISO_USAGE_TYPE_FEEDBACK = C.LIBUSB_ISO_USAGE_TYPE_FEEDBACK
-	ISO_USAGE_TYPE_IMPLICIT = C.LIBUSB_ISO_USAGE_TYPE_IMPLICIT
+	ISO_USAGE_TYPE_DATA     Usage_Type = C.LIBUSB_ISO_USAGE_TYPE_DATA
+	ISO_USAGE_TYPE_FEEDBACK Usage_Type = C.LIBUSB_ISO_USAGE_TYPE_FEEDBACK
+	ISO_USAGE_TYPE_IMPLICIT Usage_Type = C.LIBUSB_ISO_USAGE_TYPE_IMPLICIT
 )
 
 const CONTROL_SETUP_SIZE = C.LIBUSB_CONTROL_SETUP_SIZE
 
 // Speed codes. Indicates the speed at which the device is operating.
+type Speed int
+
 const (
-	SPEED_UNKNOWN = C.LIBUSB_SPEED_UNKNOWN
-	SPEED_LOW     = C.LIBUSB_SPEED_LOW
-	SPEED_FULL    = C.LIBUSB_SPEED_FULL
-	SPEED_HIGH    = C.LIBUSB_SPEED_HIGH
-	SPEED_SUPER   = C.LIBUSB_SPEED_SUPER
+	SPEED_UNKNOWN Speed = C.LIBUSB_SPEED_UNKNOWN
+	SPEED_LOW     Speed = C.LIBUSB_SPEED_LOW
+	SPEED_FULL    Speed = C.LIBUSB_SPEED_FULL
+	SPEED_HIGH    Speed = C.LIBUSB_SPEED_HIGH
+	SPEED_SUPER   Speed = C.LIBUSB_SPEED_SUPER
 )
 
 // Supported speeds (WSpeedSupported) bitfield. Indicates what speeds the device supports.
@@ -260,14 +336,16 @@ const (
 const ERROR_COUNT = C.LIBUSB_ERROR_COUNT
 
 // Transfer status codes.
+type Transfer_Status int
+
 const (
-	TRANSFER_COMPLETED = C.LIBUSB_TRANSFER_COMPLETED
-	TRANSFER_ERROR     = C.LIBUSB_TRANSFER_ERROR
-	TRANSFER_TIMED_OUT = C.LIBUSB_TRANSFER_TIMED_OUT
-	TRANSFER_CANCELLED = C.LIBUSB_TRANSFER_CANCELLED
-	TRANSFER_STALL     = C.LIBUSB_TRANSFER_STALL
-	TRANSFER_NO_DEVICE = C.LIBUSB_TRANSFER_NO_DEVICE
-	TRANSFER_OVERFLOW  = C.LIBUSB_TRANSFER_OVERFLOW
+	TRANSFER_COMPLETED Transfer_Status = C.LIBUSB_TRANSFER_COMPLETED
+	TRANSFER_ERROR     Transfer_Status = C.LIBUSB_TRANSFER_ERROR
+	TRANSFER_TIMED_OUT Transfer_Status = C.LIBUSB_TRANSFER_TIMED_OUT
+	TRANSFER_CANCELLED Transfer_Status = C.LIBUSB_TRANSFER_CANCELLED
+	TRANSFER_STALL     Transfer_Status = C.LIBUSB_TRANSFER_STALL
+	TRANSFER_NO_DEVICE Transfer_Status = C.LIBUSB_TRANSFER_NO_DEVICE
+	TRANSFER_OVERFLOW  Transfer_Status = C.LIBUSB_TRANSFER_OVERFLOW
 )
 
 // Transfer.Flags values.
@@ -288,12 +366,14 @@ const (
 )
 
 // Log message levels.
+type Log_Level int
+
 const (
-	LOG_LEVEL_NONE    = C.LIBUSB_LOG_LEVEL_NONE
-	LOG_LEVEL_ERROR   = C.LIBUSB_LOG_LEVEL_ERROR
-	LOG_LEVEL_WARNING = C.LIBUSB_LOG_LEVEL_WARNING
-	LOG_LEVEL_INFO    = C.LIBUSB_LOG_LEVEL_INFO
-	LOG_LEVEL_DEBUG   = C.LIBUSB_LOG_LEVEL_DEBUG
+	LOG_LEVEL_NONE    Log_Level = C.LIBUSB_LOG_LEVEL_NONE
+	LOG_LEVEL_ERROR   Log_Level = C.LIBUSB_LOG_LEVEL_ERROR
+	LOG_LEVEL_WARNING Log_Level = C.LIBUSB_LOG_LEVEL_WARNING
+	LOG_LEVEL_INFO    Log_Level = C.LIBUSB_LOG_LEVEL_INFO
+	LOG_LEVEL_DEBUG   Log_Level = C.LIBUSB_LOG_LEVEL_DEBUG
 )
 
 // Flags for hotplug events.
@@ -313,25 +393,11 @@ const HOTPLUG_MATCH_ANY = C.LIBUSB_HOTPLUG_MATCH_ANY
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the standard USB endpoint descriptor.
-// This descriptor is documented in section 9.6.6 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type Endpoint_Descriptor struct {
-	ptr              *C.struct_libusb_endpoint_descriptor
-	BLength          uint8
-	BDescriptorType  uint8
-	BEndpointAddress uint8
-	BmAttributes     uint8
-	WMaxPacketSize   uint16
-	BInterval        uint8
-	BRefresh         uint8
-	BSynchAddress    uint8
-	Extra            []byte
-}
+// Endpoint_Descriptor is defined in the pure-Go descriptor package.
+type Endpoint_Descriptor = descriptor.Endpoint_Descriptor
 
 func c2go_Endpoint_Descriptor(x *C.struct_libusb_endpoint_descriptor) *Endpoint_Descriptor {
 	return &Endpoint_Descriptor{
-		ptr:              x,
 		BLength:          uint8(x.bLength),
 		BDescriptorType:  uint8(x.bDescriptorType),
 		BEndpointAddress: uint8(x.bEndpointAddress),
@@ -361,23 +427,8 @@ func Endpoint_Descriptor_str(x *Endpoint_Descriptor) string {
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the standard USB interface descriptor.
-// This descriptor is documented in section 9.6.5 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type Interface_Descriptor struct {
-	ptr                *C.struct_libusb_interface_descriptor
-	BLength            uint8
-	BDescriptorType    uint8
-	BInterfaceNumber   uint8
-	BAlternateSetting  uint8
-	BNumEndpoints      uint8
-	BInterfaceClass    uint8
-	BInterfaceSubClass uint8
-	BInterfaceProtocol uint8
-	IInterface         uint8
-	Endpoint           []*Endpoint_Descriptor
-	Extra              []byte
-}
+// Interface_Descriptor is defined in the pure-Go descriptor package.
+type Interface_Descriptor = descriptor.Interface_Descriptor
 
 func c2go_Interface_Descriptor(x *C.struct_libusb_interface_descriptor) *Interface_Descriptor {
 	var list []C.struct_libusb_endpoint_descriptor
@@ -390,7 +441,6 @@ func c2go_Interface_Descriptor(x *C.struct_libusb_interface_descriptor) *Interfa
 		endpoints[i] = c2go_Endpoint_Descriptor(&list[i])
 	}
 	return &Interface_Descriptor{
-		ptr:                x,
 		BLength:            uint8(x.bLength),
 		BDescriptorType:    uint8(x.bDescriptorType),
 		BInterfaceNumber:   uint8(x.bInterfaceNumber),
@@ -427,12 +477,8 @@ func Interface_Descriptor_str(x *Interface_Descriptor) string {
 
 //-----------------------------------------------------------------------------
 
-// A collection of alternate settings for a particular USB interface.
-type Interface struct {
-	ptr            *C.struct_libusb_interface
-	Num_altsetting int
-	Altsetting     []*Interface_Descriptor
-}
+// Interface is defined in the pure-Go descriptor package.
+type Interface = descriptor.Interface
 
 func c2go_Interface(x *C.struct_libusb_interface) *Interface {
 	var list []C.struct_libusb_interface_descriptor
@@ -445,7 +491,6 @@ func c2go_Interface(x *C.struct_libusb_interface) *Interface {
 		altsetting[i] = c2go_Interface_Descriptor(&list[i])
 	}
 	return &Interface{
-		ptr:            x,
 		Num_altsetting: int(x.num_altsetting),
 		Altsetting:     altsetting,
 	}
@@ -464,22 +509,8 @@ func Interface_str(x *Interface) string {
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the standard USB configuration descriptor.
-// This descriptor is documented in section 9.6.3 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type Config_Descriptor struct {
-	ptr                 *C.struct_libusb_config_descriptor
-	BLength             uint8
-	BDescriptorType     uint8
-	WTotalLength        uint16
-	BNumInterfaces      uint8
-	BConfigurationValue uint8
-	IConfiguration      uint8
-	BmAttributes        uint8
-	MaxPower            uint8
-	Interface           []*Interface
-	Extra               []byte
-}
+// Config_Descriptor is defined in the pure-Go descriptor package.
+type Config_Descriptor = descriptor.Config_Descriptor
 
 func c2go_Config_Descriptor(x *C.struct_libusb_config_descriptor) *Config_Descriptor {
 	var list []C.struct_libusb_interface
@@ -492,7 +523,6 @@ func c2go_Config_Descriptor(x *C.struct_libusb_config_descriptor) *Config_Descri
 		interfaces[i] = c2go_Interface(&list[i])
 	}
 	return &Config_Descriptor{
-		ptr:                 x,
 		BLength:             uint8(x.bLength),
 		BDescriptorType:     uint8(x.bDescriptorType),
 		WTotalLength:        uint16(x.wTotalLength),
@@ -527,45 +557,16 @@ func Config_Descriptor_str(x *Config_Descriptor) string {
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the superspeed endpoint companion descriptor.
-// This descriptor is documented in section 9.6.7 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type SS_Endpoint_Companion_Descriptor struct {
-	ptr               *C.struct_libusb_ss_endpoint_companion_descriptor
-	BLength           uint8
-	BDescriptorType   uint8
-	BMaxBurst         uint8
-	BmAttributes      uint8
-	WBytesPerInterval uint16
-}
-
-func c2go_SS_Endpoint_Companion_Descriptor(x *C.struct_libusb_ss_endpoint_companion_descriptor) *SS_Endpoint_Companion_Descriptor {
-	return &SS_Endpoint_Companion_Descriptor{
-		ptr:               x,
-		BLength:           uint8(x.bLength),
-		BDescriptorType:   uint8(x.bDescriptorType),
-		BMaxBurst:         uint8(x.bMaxBurst),
-		BmAttributes:      uint8(x.bmAttributes),
-		WBytesPerInterval: uint16(x.wBytesPerInterval),
-	}
-}
+// SS_Endpoint_Companion_Descriptor is defined in the pure-Go descriptor package.
+type SS_Endpoint_Companion_Descriptor = descriptor.SS_Endpoint_Companion_Descriptor
 
 //-----------------------------------------------------------------------------
 
-// A generic representation of a BOS Device Capability descriptor.
-// It is advised to check BDevCapabilityType and call the matching
-// Get_*_Descriptor function to get a structure fully matching the type.
-type BOS_Dev_Capability_Descriptor struct {
-	ptr                 *C.struct_libusb_bos_dev_capability_descriptor
-	BLength             uint8
-	BDescriptorType     uint8
-	BDevCapabilityType  uint8
-	Dev_capability_data []byte
-}
+// BOS_Dev_Capability_Descriptor is defined in the pure-Go descriptor package.
+type BOS_Dev_Capability_Descriptor = descriptor.BOS_Dev_Capability_Descriptor
 
 func c2go_BOS_Dev_Capability_Descriptor(x *C.struct_libusb_bos_dev_capability_descriptor) *BOS_Dev_Capability_Descriptor {
 	return &BOS_Dev_Capability_Descriptor{
-		ptr:                 x,
 		BLength:             uint8(x.bLength),
 		BDescriptorType:     uint8(x.bDescriptorType),
 		BDevCapabilityType:  uint8(x.bDevCapabilityType),
@@ -575,16 +576,8 @@ func c2go_BOS_Dev_Capability_Descriptor(x *C.struct_libusb_bos_dev_capability_de
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the Binary Device Object Store (BOS) descriptor.
-// This descriptor is documented in section 9.6.2 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type BOS_Descriptor struct {
-	ptr             *C.struct_libusb_bos_descriptor
-	BLength         uint8
-	BDescriptorType uint8
-	WTotalLength    uint16
-	Dev_capability  []*BOS_Dev_Capability_Descriptor
-}
+// BOS_Descriptor is defined in the pure-Go descriptor package.
+type BOS_Descriptor = descriptor.BOS_Descriptor
 
 func c2go_BOS_Descriptor(x *C.struct_libusb_bos_descriptor) *BOS_Descriptor {
 	var list []*C.struct_libusb_bos_dev_capability_descriptor
@@ -597,7 +590,6 @@ func c2go_BOS_Descriptor(x *C.struct_libusb_bos_descriptor) *BOS_Descriptor {
 		dev_capability[i] = c2go_BOS_Dev_Capability_Descriptor(list[i])
 	}
 	return &BOS_Descriptor{
-		ptr:             x,
 		BLength:         uint8(x.bLength),
 		BDescriptorType: uint8(x.bDescriptorType),
 		WTotalLength:    uint16(x.wTotalLength),
@@ -607,122 +599,60 @@ func c2go_BOS_Descriptor(x *C.struct_libusb_bos_descriptor) *BOS_Descriptor {
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the USB 2.0 Extension descriptor
-// This descriptor is documented in section 9.6.2.1 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type USB_2_0_Extension_Descriptor struct {
-	ptr                *C.struct_libusb_usb_2_0_extension_descriptor
-	BLength            uint8
-	BDescriptorType    uint8
-	BDevCapabilityType uint8
-	BmAttributes       uint32
-}
-
-func c2go_USB_2_0_Extension_Descriptor(x *C.struct_libusb_usb_2_0_extension_descriptor) *USB_2_0_Extension_Descriptor {
-	return &USB_2_0_Extension_Descriptor{
-		ptr:                x,
-		BLength:            uint8(x.bLength),
-		BDescriptorType:    uint8(x.bDescriptorType),
-		BDevCapabilityType: uint8(x.bDevCapabilityType),
-		BmAttributes:       uint32(x.bmAttributes),
-	}
-}
+// USB_2_0_Extension_Descriptor is defined in the pure-Go descriptor package.
+type USB_2_0_Extension_Descriptor = descriptor.USB_2_0_Extension_Descriptor
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the SuperSpeed USB Device Capability descriptor
-// This descriptor is documented in section 9.6.2.2 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type SS_USB_Device_Capability_Descriptor struct {
-	ptr                   *C.struct_libusb_ss_usb_device_capability_descriptor
-	BLength               uint8
-	BDescriptorType       uint8
-	BDevCapabilityType    uint8
-	BmAttributes          uint8
-	WSpeedSupported       uint16
-	BFunctionalitySupport uint8
-	BU1DevExitLat         uint8
-	BU2DevExitLat         uint16
-}
-
-func c2go_SS_USB_Device_Capability_Descriptor(x *C.struct_libusb_ss_usb_device_capability_descriptor) *SS_USB_Device_Capability_Descriptor {
-	return &SS_USB_Device_Capability_Descriptor{
-		ptr:                   x,
-		BLength:               uint8(x.bLength),
-		BDescriptorType:       uint8(x.bDescriptorType),
-		BDevCapabilityType:    uint8(x.bDevCapabilityType),
-		BmAttributes:          uint8(x.bmAttributes),
-		WSpeedSupported:       uint16(x.wSpeedSupported),
-		BFunctionalitySupport: uint8(x.bFunctionalitySupport),
-		BU1DevExitLat:         uint8(x.bU1DevExitLat),
-		BU2DevExitLat:         uint16(x.bU2DevExitLat),
-	}
-}
+// SS_USB_Device_Capability_Descriptor is defined in the pure-Go descriptor package.
+type SS_USB_Device_Capability_Descriptor = descriptor.SS_USB_Device_Capability_Descriptor
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the Container ID descriptor.
-// This descriptor is documented in section 9.6.2.3 of the USB 3.0 specification.
-// All multiple-byte fields, except UUIDs, are represented in host-endian format.
-type Container_ID_Descriptor struct {
-	ptr                *C.struct_libusb_container_id_descriptor
-	BLength            uint8
-	BDescriptorType    uint8
-	BDevCapabilityType uint8
-	BReserved          uint8
-	ContainerID        []byte
-}
-
-func c2go_Container_ID_Descriptor(x *C.struct_libusb_container_id_descriptor) *Container_ID_Descriptor {
-	return &Container_ID_Descriptor{
-		ptr:                x,
-		BLength:            uint8(x.bLength),
-		BDescriptorType:    uint8(x.bDescriptorType),
-		BDevCapabilityType: uint8(x.bDevCapabilityType),
-		BReserved:          uint8(x.bReserved),
-		ContainerID:        C.GoBytes(unsafe.Pointer(&x.ContainerID[0]), 16),
-	}
-}
+// Container_ID_Descriptor is defined in the pure-Go descriptor package.
+type Container_ID_Descriptor = descriptor.Container_ID_Descriptor
 
 //-----------------------------------------------------------------------------
 
-/*
 // Setup packet for control transfers.
-struct libusb_control_setup {
-	uint8_t  bmRequestType;
-	uint8_t  bRequest;
-	uint16_t wValue;
-	uint16_t wIndex;
-	uint16_t wLength;
-};
-*/
+// All multiple-byte fields are represented in host-endian format.
+type Control_Setup struct {
+	BmRequestType uint8
+	BRequest      uint8
+	WValue        uint16
+	WIndex        uint16
+	WLength       uint16
+}
+
+// the setup packet is little-endian on the wire
+func bytes2go_Control_Setup(x []byte) *Control_Setup {
+	return &Control_Setup{
+		BmRequestType: x[0],
+		BRequest:      x[1],
+		WValue:        binary.LittleEndian.Uint16(x[2:]),
+		WIndex:        binary.LittleEndian.Uint16(x[4:]),
+		WLength:       binary.LittleEndian.Uint16(x[6:]),
+	}
+}
+
+// return a string for a Control_Setup
+func Control_Setup_str(x *Control_Setup) string {
+	s := make([]string, 0, 1)
+	s = append(s, fmt.Sprintf("bmRequestType 0x%02x", x.BmRequestType))
+	s = append(s, fmt.Sprintf("bRequest %d", x.BRequest))
+	s = append(s, fmt.Sprintf("wValue 0x%04x", x.WValue))
+	s = append(s, fmt.Sprintf("wIndex 0x%04x", x.WIndex))
+	s = append(s, fmt.Sprintf("wLength %d", x.WLength))
+	return strings.Join(s, "\n")
+}
 
 //-----------------------------------------------------------------------------
 
-// A structure representing the standard USB device descriptor.
-// This descriptor is documented in section 9.6.1 of the USB 3.0 specification.
-// All multiple-byte fields are represented in host-endian format.
-type Device_Descriptor struct {
-	ptr                *C.struct_libusb_device_descriptor
-	BLength            uint8
-	BDescriptorType    uint8
-	BcdUSB             uint16
-	BDeviceClass       uint8
-	BDeviceSubClass    uint8
-	BDeviceProtocol    uint8
-	BMaxPacketSize0    uint8
-	IdVendor           uint16
-	IdProduct          uint16
-	BcdDevice          uint16
-	IManufacturer      uint8
-	IProduct           uint8
-	ISerialNumber      uint8
-	BNumConfigurations uint8
-}
+// Device_Descriptor is defined in the pure-Go descriptor package.
+type Device_Descriptor = descriptor.Device_Descriptor
 
 func c2go_Device_Descriptor(x *C.struct_libusb_device_descriptor) *Device_Descriptor {
 	return &Device_Descriptor{
-		ptr:                x,
 		BLength:            uint8(x.bLength),
 		BDescriptorType:    uint8(x.bDescriptorType),
 		BcdUSB:             uint16(x.bcdUSB),
@@ -786,9 +716,25 @@ struct libusb_transfer {
 // then submits it in order to request a transfer. After the transfer has
 // completed, the library populates the transfer with the results and passes
 // it back to the user.
+//
+// The transfer buffer is allocated in C memory, so libusb can use it while
+// the transfer is in flight without breaking the cgo pointer rules.
 type Transfer struct {
-	ptr *C.struct_libusb_transfer
-}
+	ptr         *C.struct_libusb_transfer
+	id          uintptr           // key for the transfer registry
+	callback    Transfer_Callback // called on completion
+	buffer      []byte            // C allocated transfer buffer
+	iso_packets int               // number of isochronous packets allocated
+}
+
+// Asynchronous transfer callback function. Called on completion with the transfer
+// status (TRANSFER_COMPLETED, TRANSFER_STALL, etc.) and the transferred data.
+// For control transfers the data excludes the setup packet.
+// For isochronous transfers the data is the whole transfer buffer,
+// use Get_Iso_Packet_Descriptors for the per-packet results.
+// The data references the transfer buffer, copy it if it is needed after
+// the transfer is filled again or freed.
+type Transfer_Callback func(transfer *Transfer, status Transfer_Status, data []byte)
 
 func c2go_Transfer(x *C.struct_libusb_transfer) *Transfer {
 	return &Transfer{
@@ -800,14 +746,51 @@ func go2c_Transfer(x *Transfer) *C.struct_libusb_transfer {
 	return x.ptr
 }
 
-// return a string for a Device_Descriptor
+// Isochronous packet descriptor.
+type Iso_Packet_Descriptor struct {
+	Length        uint            // length of data to request in this packet
+	Actual_Length uint            // amount of data that was actually transferred
+	Status        Transfer_Status // status code for this packet
+	Data          []byte          // the packet buffer, Actual_Length bytes of the transfer buffer
+}
+
+// return a string for a Transfer
 func Transfer_str(x *Transfer) string {
 	s := make([]string, 0, 1)
+	s = append(s, fmt.Sprintf("flags 0x%02x", x.ptr.flags))
+	s = append(s, fmt.Sprintf("endpoint 0x%02x", x.ptr.endpoint))
+	s = append(s, fmt.Sprintf("type %d", x.ptr._type))
+	s = append(s, fmt.Sprintf("timeout %d", x.ptr.timeout))
+	s = append(s, fmt.Sprintf("status %d", x.ptr.status))
+	s = append(s, fmt.Sprintf("length %d", x.ptr.length))
+	s = append(s, fmt.Sprintf("actual_length %d", x.ptr.actual_length))
+	s = append(s, fmt.Sprintf("num_iso_packets %d", x.ptr.num_iso_packets))
 	return strings.Join(s, "\n")
 }
 
 //-----------------------------------------------------------------------------
 
+// File descriptor for polling.
+type Pollfd struct {
+	Fd     int   // numeric file descriptor
+	Events int16 // event flags to poll for from <poll.h>
+}
+
+func c2go_Pollfd(x *C.struct_libusb_pollfd) *Pollfd {
+	return &Pollfd{
+		Fd:     int(x.fd),
+		Events: int16(x.events),
+	}
+}
+
+// Callback function, invoked when a new file descriptor should be added to the set polled by the application.
+type Pollfd_Added_Cb func(fd int, events int16)
+
+// Callback function, invoked when a file descriptor should be removed from the set polled by the application.
+type Pollfd_Removed_Cb func(fd int)
+
+//-----------------------------------------------------------------------------
+
 // Structure providing the version of the libusb runtime.
 type Version struct {
 	ptr      *C.struct_libusb_version
@@ -842,35 +825,103 @@ type Device *C.struct_libusb_device
 // Structure representing a handle on a USB device.
 type Device_Handle *C.struct_libusb_device_handle
 
-//type Hotplug_Callback *C.struct_libusb_hotplug_callback
+// Callback handle. Returned by Hotplug_Register_Callback to identify the callback.
+type Hotplug_Callback_Handle int
+
+// Hotplug callback function. Called with the device and the hotplug event
+// (HOTPLUG_EVENT_DEVICE_ARRIVED or HOTPLUG_EVENT_DEVICE_LEFT).
+// Return true to deregister the callback.
+type Hotplug_Callback_Fn func(ctx Context, dev Device, event int) bool
 
 //-----------------------------------------------------------------------------
 // errors
 
-type libusb_error struct {
-	Code int
+// Error is the error type returned by the libusb functions.
+// Use errors.Is with the Err* values to test for a particular error code,
+// or errors.As to get the Error.
+type Error struct {
+	Code     int           // libusb error code (ERROR_*)
+	Op       string        // the failed operation, e.g. "Bulk_Transfer"
+	Endpoint int           // endpoint address, -1 if not applicable
+	Handle   Device_Handle // device handle, nil if not applicable
+}
+
+func (e *Error) Error() string {
+	s := make([]string, 0, 1)
+	s = append(s, "libusb")
+	if e.Op != "" {
+		s = append(s, e.Op)
+	}
+	if e.Handle != nil {
+		s = append(s, fmt.Sprintf("handle %p", e.Handle))
+	}
+	if e.Endpoint >= 0 {
+		s = append(s, fmt.Sprintf("endpoint 0x%02x", e.Endpoint))
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(s, " "), Error_Name(e.Code))
+}
+
+// Is reports whether the target is an Error with the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
 }
 
-func (e *libusb_error) Error() string {
-	return Error_Name(e.Code)
+// return an error for a failed operation
+func new_error(code int, op string) error {
+	return &Error{Code: code, Op: op, Endpoint: -1}
 }
 
+// return an error for a failed operation on a device handle
+func new_handle_error(code int, op string, hdl Device_Handle) error {
+	return &Error{Code: code, Op: op, Endpoint: -1, Handle: hdl}
+}
+
+// return an error for a failed operation on an endpoint
+func new_endpoint_error(code int, op string, hdl Device_Handle, endpoint uint8) error {
+	return &Error{Code: code, Op: op, Endpoint: int(endpoint), Handle: hdl}
+}
+
+// Error values for use with errors.Is.
+var (
+	ErrIO           = new_error(ERROR_IO, "")
+	ErrInvalidParam = new_error(ERROR_INVALID_PARAM, "")
+	ErrAccess       = new_error(ERROR_ACCESS, "")
+	ErrNoDevice     = new_error(ERROR_NO_DEVICE, "")
+	ErrNotFound     = new_error(ERROR_NOT_FOUND, "")
+	ErrBusy         = new_error(ERROR_BUSY, "")
+	ErrTimeout      = new_error(ERROR_TIMEOUT, "")
+	ErrOverflow     = new_error(ERROR_OVERFLOW, "")
+	ErrPipe         = new_error(ERROR_PIPE, "")
+	ErrInterrupted  = new_error(ERROR_INTERRUPTED, "")
+	ErrNoMem        = new_error(ERROR_NO_MEM, "")
+	ErrNotSupported = new_error(ERROR_NOT_SUPPORTED, "")
+	ErrOther        = new_error(ERROR_OTHER, "")
+)
+
 //-----------------------------------------------------------------------------
 // Library initialization/deinitialization
 
-func Set_Debug(ctx Context, level int) {
+func Set_Debug(ctx Context, level Log_Level) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
 	C.libusb_set_debug(ctx, C.int(level))
 }
 
 func Init(ctx *Context) error {
 	rc := int(C.libusb_init((**C.struct_libusb_context)(ctx)))
 	if rc != 0 {
-		return &libusb_error{rc}
+		return new_error(rc, "Init")
 	}
 	return nil
 }
 
 func Exit(ctx Context) {
+	if b, ok := lookup_backend(ctx); ok {
+		backend_exit(ctx, b)
+		return
+	}
 	C.libusb_exit(ctx)
 }
 
@@ -878,181 +929,267 @@ func Exit(ctx Context) {
 // Device handling and enumeration
 
 func Get_Device_List(ctx Context) ([]Device, error) {
+	if b, ok := lookup_backend(ctx); ok {
+		return backend_get_device_list(ctx, b)
+	}
 	var hdl **C.struct_libusb_device
 	rc := int(C.libusb_get_device_list(ctx, (***C.struct_libusb_device)(&hdl)))
 	if rc < 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_error(rc, "Get_Device_List")
 	}
 	// turn the c array into a slice of device pointers
+	// the capacity includes the NULL terminator, so an empty list can still be freed
 	var list []Device
 	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&list))
-	hdr.Cap = rc
+	hdr.Cap = rc + 1
 	hdr.Len = rc
 	hdr.Data = uintptr(unsafe.Pointer(hdl))
 	return list, nil
 }
 
 func Free_Device_List(list []Device, unref_devices int) {
-	if list == nil {
+	if cap(list) == 0 || backend_free_device_list(list, unref_devices) {
 		return
 	}
-	C.libusb_free_device_list((**C.struct_libusb_device)(&list[0]), C.int(unref_devices))
+	C.libusb_free_device_list((**C.struct_libusb_device)(&list[:1][0]), C.int(unref_devices))
 }
 
 func Get_Bus_Number(dev Device) uint8 {
+	if d, ok := lookup_device(dev); ok {
+		return d.dev.Bus()
+	}
 	return uint8(C.libusb_get_bus_number(dev))
 }
 
 func Get_Port_Number(dev Device) uint8 {
+	if d, ok := lookup_device(dev); ok {
+		return backend_get_port_number(d)
+	}
 	return uint8(C.libusb_get_port_number(dev))
 }
 
 func Get_Port_Numbers(dev Device, ports []byte) ([]byte, error) {
+	if d, ok := lookup_device(dev); ok {
+		return backend_get_port_numbers(d, ports)
+	}
 	rc := int(C.libusb_get_port_numbers(dev, (*C.uint8_t)(&ports[0]), (C.int)(len(ports))))
 	if rc < 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_error(rc, "Get_Port_Numbers")
 	}
 	return ports[:rc], nil
 }
 
 func Get_Parent(dev Device) Device {
+	if d, ok := lookup_device(dev); ok {
+		return backend_get_parent(dev, d)
+	}
 	return C.libusb_get_parent(dev)
 }
 
 func Get_Device_Address(dev Device) uint8 {
+	if d, ok := lookup_device(dev); ok {
+		return d.dev.Address()
+	}
 	return uint8(C.libusb_get_device_address(dev))
 }
 
-func Get_Device_Speed(dev Device) int {
-	return int(C.libusb_get_device_speed(dev))
+func Get_Device_Speed(dev Device) Speed {
+	if d, ok := lookup_device(dev); ok {
+		return d.dev.Speed()
+	}
+	return Speed(C.libusb_get_device_speed(dev))
 }
 
 func Get_Max_Packet_Size(dev Device, endpoint uint8) int {
+	if d, ok := lookup_device(dev); ok {
+		return backend_get_max_packet_size(d, endpoint)
+	}
 	return int(C.libusb_get_max_packet_size(dev, (C.uchar)(endpoint)))
 }
 
 func Get_Max_ISO_Packet_Size(dev Device, endpoint uint8) int {
+	if d, ok := lookup_device(dev); ok {
+		return backend_get_max_iso_packet_size(d, endpoint)
+	}
 	return int(C.libusb_get_max_iso_packet_size(dev, (C.uchar)(endpoint)))
 }
 
 func Ref_Device(dev Device) Device {
+	if _, ok := lookup_device(dev); ok {
+		backend_ref_device(dev)
+		return dev
+	}
 	return C.libusb_ref_device(dev)
 }
 
 func Unref_Device(dev Device) {
+	if _, ok := lookup_device(dev); ok {
+		backend_unref_device(dev)
+		return
+	}
 	C.libusb_unref_device(dev)
 }
 
 func Open(dev Device) (Device_Handle, error) {
+	if d, ok := lookup_device(dev); ok {
+		return backend_open(dev, d)
+	}
 	var hdl Device_Handle
 	rc := int(C.libusb_open(dev, (**C.struct_libusb_device_handle)(&hdl)))
 	if rc < 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_error(rc, "Open")
 	}
 	return hdl, nil
 }
 
 func Open_Device_With_VID_PID(ctx Context, vendor_id uint16, product_id uint16) Device_Handle {
+	if b, ok := lookup_backend(ctx); ok {
+		return backend_open_device_with_vid_pid(ctx, b, vendor_id, product_id)
+	}
 	return C.libusb_open_device_with_vid_pid(ctx, (C.uint16_t)(vendor_id), (C.uint16_t)(product_id))
 }
 
 func Close(hdl Device_Handle) {
+	if h, ok := lookup_handle(hdl); ok {
+		backend_close(hdl, h)
+		return
+	}
 	C.libusb_close(hdl)
 }
 
 func Get_Device(hdl Device_Handle) Device {
+	if h, ok := lookup_handle(hdl); ok {
+		return h.dev
+	}
 	return C.libusb_get_device(hdl)
 }
 
 func Get_Configuration(hdl Device_Handle) (int, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		config, err := h.hdl.Configuration()
+		if err != nil {
+			return 0, backend_error(err, "Get_Configuration", hdl, -1)
+		}
+		return config, nil
+	}
 	var config C.int
 	rc := int(C.libusb_get_configuration(hdl, &config))
 	if rc < 0 {
-		return 0, &libusb_error{rc}
+		return 0, new_handle_error(rc, "Get_Configuration", hdl)
 	}
 	return int(config), nil
 }
 
 func Set_Configuration(hdl Device_Handle, configuration int) error {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_error(h.hdl.SetConfiguration(configuration), "Set_Configuration", hdl, -1)
+	}
 	rc := int(C.libusb_set_configuration(hdl, (C.int)(configuration)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Set_Configuration", hdl)
 	}
 	return nil
 }
 
 func Claim_Interface(hdl Device_Handle, interface_number int) error {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_error(h.hdl.Claim(interface_number), "Claim_Interface", hdl, -1)
+	}
 	rc := int(C.libusb_claim_interface(hdl, (C.int)(interface_number)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Claim_Interface", hdl)
 	}
 	return nil
 }
 
 func Release_Interface(hdl Device_Handle, interface_number int) error {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_error(h.hdl.Release(interface_number), "Release_Interface", hdl, -1)
+	}
 	rc := int(C.libusb_release_interface(hdl, (C.int)(interface_number)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Release_Interface", hdl)
 	}
 	return nil
 }
 
 func Set_Interface_Alt_Setting(hdl Device_Handle, interface_number int, alternate_setting int) error {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_error(h.hdl.SetAltSetting(interface_number, alternate_setting), "Set_Interface_Alt_Setting", hdl, -1)
+	}
 	rc := int(C.libusb_set_interface_alt_setting(hdl, (C.int)(interface_number), (C.int)(alternate_setting)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Set_Interface_Alt_Setting", hdl)
 	}
 	return nil
 }
 
 func Clear_Halt(hdl Device_Handle, endpoint uint8) error {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_error(h.hdl.ClearHalt(endpoint), "Clear_Halt", hdl, int(endpoint))
+	}
 	rc := int(C.libusb_clear_halt(hdl, (C.uchar)(endpoint)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_endpoint_error(rc, "Clear_Halt", hdl, endpoint)
 	}
 	return nil
 }
 
 func Reset_Device(hdl Device_Handle) error {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_error(h.hdl.Reset(), "Reset_Device", hdl, -1)
+	}
 	rc := int(C.libusb_reset_device(hdl))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Reset_Device", hdl)
 	}
 	return nil
 }
 
 func Kernel_Driver_Active(hdl Device_Handle, interface_number int) (bool, error) {
+	if _, ok := lookup_handle(hdl); ok {
+		return false, new_handle_error(ERROR_NOT_SUPPORTED, "Kernel_Driver_Active", hdl)
+	}
 	rc := int(C.libusb_kernel_driver_active(hdl, (C.int)(interface_number)))
 	if rc < 0 {
-		return false, &libusb_error{rc}
+		return false, new_handle_error(rc, "Kernel_Driver_Active", hdl)
 	}
 	return rc != 0, nil
 }
 
 func Detach_Kernel_Driver(hdl Device_Handle, interface_number int) error {
+	if _, ok := lookup_handle(hdl); ok {
+		return new_handle_error(ERROR_NOT_SUPPORTED, "Detach_Kernel_Driver", hdl)
+	}
 	rc := int(C.libusb_detach_kernel_driver(hdl, (C.int)(interface_number)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Detach_Kernel_Driver", hdl)
 	}
 	return nil
 }
 
 func Attach_Kernel_Driver(hdl Device_Handle, interface_number int) error {
+	if _, ok := lookup_handle(hdl); ok {
+		return new_handle_error(ERROR_NOT_SUPPORTED, "Attach_Kernel_Driver", hdl)
+	}
 	rc := int(C.libusb_attach_kernel_driver(hdl, (C.int)(interface_number)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Attach_Kernel_Driver", hdl)
 	}
 	return nil
 }
 
 func Set_Auto_Detach_Kernel_Driver(hdl Device_Handle, enable bool) error {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_error(h.hdl.SetAutoDetachKernelDriver(enable), "Set_Auto_Detach_Kernel_Driver", hdl, -1)
+	}
 	enable_int := 0
 	if enable {
 		enable_int = 1
 	}
 	rc := int(C.libusb_set_auto_detach_kernel_driver(hdl, (C.int)(enable_int)))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Set_Auto_Detach_Kernel_Driver", hdl)
 	}
 	return nil
 }
@@ -1082,7 +1219,7 @@ func Setlocale(locale string) error {
 	cstr := C.CString(locale)
 	rc := int(C.libusb_setlocale(cstr))
 	if rc < 0 {
-		return &libusb_error{rc}
+		return new_error(rc, "Setlocale")
 	}
 	return nil
 }
@@ -1095,130 +1232,231 @@ func Strerror(errcode int) string {
 // USB descriptors
 
 func Get_Device_Descriptor(dev Device) (*Device_Descriptor, error) {
+	if d, ok := lookup_device(dev); ok {
+		dd, err := d.dev.Descriptor()
+		if err != nil {
+			return nil, backend_error(err, "Get_Device_Descriptor", nil, -1)
+		}
+		return dd, nil
+	}
 	var desc C.struct_libusb_device_descriptor
 	rc := int(C.libusb_get_device_descriptor(dev, &desc))
 	if rc != 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_error(rc, "Get_Device_Descriptor")
 	}
 	return c2go_Device_Descriptor(&desc), nil
 }
 
+// Descriptors are copied into Go memory and the libusb copy is freed,
+// so they remain valid with no matching Free_* call.
+
 func Get_Active_Config_Descriptor(dev Device) (*Config_Descriptor, error) {
+	if d, ok := lookup_device(dev); ok {
+		cd, err := d.dev.ActiveConfig()
+		if err != nil {
+			return nil, backend_error(err, "Get_Active_Config_Descriptor", nil, -1)
+		}
+		return cd, nil
+	}
 	var desc *C.struct_libusb_config_descriptor
 	rc := int(C.libusb_get_active_config_descriptor(dev, &desc))
 	if rc != 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_error(rc, "Get_Active_Config_Descriptor")
 	}
+	defer C.libusb_free_config_descriptor(desc)
 	return c2go_Config_Descriptor(desc), nil
 }
 
 func Get_Config_Descriptor(dev Device, config_index uint8) (*Config_Descriptor, error) {
+	if d, ok := lookup_device(dev); ok {
+		cd, err := d.dev.Config(config_index)
+		if err != nil {
+			return nil, backend_error(err, "Get_Config_Descriptor", nil, -1)
+		}
+		return cd, nil
+	}
 	var desc *C.struct_libusb_config_descriptor
 	rc := int(C.libusb_get_config_descriptor(dev, (C.uint8_t)(config_index), &desc))
 	if rc != 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_error(rc, "Get_Config_Descriptor")
 	}
+	defer C.libusb_free_config_descriptor(desc)
 	return c2go_Config_Descriptor(desc), nil
 }
 
 func Get_Config_Descriptor_By_Value(dev Device, bConfigurationValue uint8) (*Config_Descriptor, error) {
+	if d, ok := lookup_device(dev); ok {
+		return backend_get_config_descriptor_by_value(d, bConfigurationValue)
+	}
 	var desc *C.struct_libusb_config_descriptor
 	rc := int(C.libusb_get_config_descriptor_by_value(dev, (C.uint8_t)(bConfigurationValue), &desc))
 	if rc != 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_error(rc, "Get_Config_Descriptor_By_Value")
 	}
+	defer C.libusb_free_config_descriptor(desc)
 	return c2go_Config_Descriptor(desc), nil
 }
 
+// Retained for compatibility. The descriptor is Go memory, there is nothing to free.
 func Free_Config_Descriptor(config *Config_Descriptor) {
-	C.libusb_free_config_descriptor(config.ptr)
 }
 
+// Get the superspeed endpoint companion descriptor from the extra bytes of an endpoint descriptor.
+// The ctx argument is unused and retained for compatibility.
 func Get_SS_Endpoint_Companion_Descriptor(ctx Context, endpoint *Endpoint_Descriptor) (*SS_Endpoint_Companion_Descriptor, error) {
-	var desc *C.struct_libusb_ss_endpoint_companion_descriptor
-	rc := int(C.libusb_get_ss_endpoint_companion_descriptor(ctx, endpoint.ptr, &desc))
-	if rc != 0 {
-		return nil, &libusb_error{rc}
+	extra := endpoint.Extra
+	for len(extra) >= 2 {
+		n := int(extra[0])
+		if n < 2 || n > len(extra) {
+			return nil, new_error(ERROR_IO, "Get_SS_Endpoint_Companion_Descriptor")
+		}
+		if extra[1] == DT_SS_ENDPOINT_COMPANION {
+			if n < DT_SS_ENDPOINT_COMPANION_SIZE {
+				return nil, new_error(ERROR_IO, "Get_SS_Endpoint_Companion_Descriptor")
+			}
+			return &SS_Endpoint_Companion_Descriptor{
+				BLength:           extra[0],
+				BDescriptorType:   extra[1],
+				BMaxBurst:         extra[2],
+				BmAttributes:      extra[3],
+				WBytesPerInterval: binary.LittleEndian.Uint16(extra[4:]),
+			}, nil
+		}
+		extra = extra[n:]
 	}
-	return c2go_SS_Endpoint_Companion_Descriptor(desc), nil
+	return nil, new_error(ERROR_NOT_FOUND, "Get_SS_Endpoint_Companion_Descriptor")
 }
 
+// Retained for compatibility. The descriptor is Go memory, there is nothing to free.
 func Free_SS_Endpoint_Companion_Descriptor(ep_comp *SS_Endpoint_Companion_Descriptor) {
-	C.libusb_free_ss_endpoint_companion_descriptor(ep_comp.ptr)
 }
 
 func Get_BOS_Descriptor(hdl Device_Handle) (*BOS_Descriptor, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_get_bos_descriptor(hdl, h)
+	}
 	var desc *C.struct_libusb_bos_descriptor
 	rc := int(C.libusb_get_bos_descriptor(hdl, &desc))
 	if rc != 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_handle_error(rc, "Get_BOS_Descriptor", hdl)
 	}
+	defer C.libusb_free_bos_descriptor(desc)
 	return c2go_BOS_Descriptor(desc), nil
 }
 
+// Retained for compatibility. The descriptor is Go memory, there is nothing to free.
 func Free_BOS_Descriptor(bos *BOS_Descriptor) {
-	C.libusb_free_bos_descriptor(bos.ptr)
 }
 
+// Return the first device capability of a given type (BT_*) in a BOS descriptor, nil if there is none.
+func Get_BOS_Dev_Capability(bos *BOS_Descriptor, cap_type uint8) *BOS_Dev_Capability_Descriptor {
+	for _, dev_cap := range bos.Dev_capability {
+		if dev_cap.BDevCapabilityType == cap_type {
+			return dev_cap
+		}
+	}
+	return nil
+}
+
+// Check the type and size of a device capability, return the capability specific data.
+func dev_capability_data(dev_cap *BOS_Dev_Capability_Descriptor, cap_type uint8, size int, op string) ([]byte, error) {
+	if dev_cap.BDevCapabilityType != cap_type {
+		return nil, new_error(ERROR_INVALID_PARAM, op)
+	}
+	if int(dev_cap.BLength) < size || len(dev_cap.Dev_capability_data) < size-3 {
+		return nil, new_error(ERROR_IO, op)
+	}
+	return dev_cap.Dev_capability_data, nil
+}
+
+// The ctx argument is unused and retained for compatibility.
 func Get_USB_2_0_Extension_Descriptor(ctx Context, dev_cap *BOS_Dev_Capability_Descriptor) (*USB_2_0_Extension_Descriptor, error) {
-	var desc *C.struct_libusb_usb_2_0_extension_descriptor
-	rc := int(C.libusb_get_usb_2_0_extension_descriptor(ctx, dev_cap.ptr, &desc))
-	if rc != 0 {
-		return nil, &libusb_error{rc}
+	data, err := dev_capability_data(dev_cap, BT_USB_2_0_EXTENSION, BT_USB_2_0_EXTENSION_SIZE, "Get_USB_2_0_Extension_Descriptor")
+	if err != nil {
+		return nil, err
 	}
-	return c2go_USB_2_0_Extension_Descriptor(desc), nil
+	return &USB_2_0_Extension_Descriptor{
+		BLength:            dev_cap.BLength,
+		BDescriptorType:    dev_cap.BDescriptorType,
+		BDevCapabilityType: dev_cap.BDevCapabilityType,
+		BmAttributes:       binary.LittleEndian.Uint32(data),
+	}, nil
 }
 
+// Retained for compatibility. The descriptor is Go memory, there is nothing to free.
 func Free_USB_2_0_Extension_Descriptor(usb_2_0_extension *USB_2_0_Extension_Descriptor) {
-	C.libusb_free_usb_2_0_extension_descriptor(usb_2_0_extension.ptr)
 }
 
+// The ctx argument is unused and retained for compatibility.
 func Get_SS_USB_Device_Capability_Descriptor(ctx Context, dev_cap *BOS_Dev_Capability_Descriptor) (*SS_USB_Device_Capability_Descriptor, error) {
-	var desc *C.struct_libusb_ss_usb_device_capability_descriptor
-	rc := int(C.libusb_get_ss_usb_device_capability_descriptor(ctx, dev_cap.ptr, &desc))
-	if rc != 0 {
-		return nil, &libusb_error{rc}
+	data, err := dev_capability_data(dev_cap, BT_SS_USB_DEVICE_CAPABILITY, BT_SS_USB_DEVICE_CAPABILITY_SIZE, "Get_SS_USB_Device_Capability_Descriptor")
+	if err != nil {
+		return nil, err
 	}
-	return c2go_SS_USB_Device_Capability_Descriptor(desc), nil
-}
-
+	return &SS_USB_Device_Capability_Descriptor{
+		BLength:               dev_cap.BLength,
+		BDescriptorType:       dev_cap.BDescriptorType,
+		BDevCapabilityType:    dev_cap.BDevCapabilityType,
+		BmAttributes:          data[0],
+		WSpeedSupported:       binary.LittleEndian.Uint16(data[1:]),
+		BFunctionalitySupport: data[3],
+		BU1DevExitLat:         data[4],
+		BU2DevExitLat:         binary.LittleEndian.Uint16(data[5:]),
+	}, nil
+}
+
+// Retained for compatibility. The descriptor is Go memory, there is nothing to free.
 func Free_SS_USB_Device_Capability_Descriptor(ss_usb_device_cap *SS_USB_Device_Capability_Descriptor) {
-	C.libusb_free_ss_usb_device_capability_descriptor(ss_usb_device_cap.ptr)
 }
 
+// The ctx argument is unused and retained for compatibility.
 func Get_Container_ID_Descriptor(ctx Context, dev_cap *BOS_Dev_Capability_Descriptor) (*Container_ID_Descriptor, error) {
-	var desc *C.struct_libusb_container_id_descriptor
-	rc := int(C.libusb_get_container_id_descriptor(ctx, dev_cap.ptr, &desc))
-	if rc != 0 {
-		return nil, &libusb_error{rc}
+	data, err := dev_capability_data(dev_cap, BT_CONTAINER_ID, BT_CONTAINER_ID_SIZE, "Get_Container_ID_Descriptor")
+	if err != nil {
+		return nil, err
 	}
-	return c2go_Container_ID_Descriptor(desc), nil
+	return &Container_ID_Descriptor{
+		BLength:            dev_cap.BLength,
+		BDescriptorType:    dev_cap.BDescriptorType,
+		BDevCapabilityType: dev_cap.BDevCapabilityType,
+		BReserved:          data[0],
+		ContainerID:        append([]byte(nil), data[1:17]...),
+	}, nil
 }
 
+// Retained for compatibility. The descriptor is Go memory, there is nothing to free.
 func Free_Container_ID_Descriptor(container_id *Container_ID_Descriptor) {
-	C.libusb_free_container_id_descriptor(container_id.ptr)
 }
 
 func Get_String_Descriptor_ASCII(hdl Device_Handle, desc_index uint8, data []byte) ([]byte, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_get_string_descriptor_ascii(hdl, h, desc_index, data)
+	}
 	rc := int(C.libusb_get_string_descriptor_ascii(hdl, (C.uint8_t)(desc_index), (*C.uchar)(&data[0]), (C.int)(len(data))))
 	if rc < 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_handle_error(rc, "Get_String_Descriptor_ASCII", hdl)
 	}
 	return data[:rc], nil
 }
 
 func Get_Descriptor(hdl Device_Handle, desc_type uint8, desc_index uint8, data []byte) ([]byte, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_get_descriptor(hdl, h, desc_type, desc_index, 0, data, "Get_Descriptor")
+	}
 	rc := int(C.libusb_get_descriptor(hdl, (C.uint8_t)(desc_type), (C.uint8_t)(desc_index), (*C.uchar)(&data[0]), (C.int)(len(data))))
 	if rc < 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_handle_error(rc, "Get_Descriptor", hdl)
 	}
 	return data[:rc], nil
 }
 
 func Get_String_Descriptor(hdl Device_Handle, desc_index uint8, langid uint16, data []byte) ([]byte, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_get_descriptor(hdl, h, DT_STRING, desc_index, langid, data, "Get_String_Descriptor")
+	}
 	rc := int(C.libusb_get_string_descriptor(hdl, (C.uint8_t)(desc_index), (C.uint16_t)(langid), (*C.uchar)(&data[0]), (C.int)(len(data))))
 	if rc < 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_handle_error(rc, "Get_String_Descriptor", hdl)
 	}
 	return data[:rc], nil
 }
@@ -1226,52 +1464,222 @@ func Get_String_Descriptor(hdl Device_Handle, desc_index uint8, langid uint16, d
 //-----------------------------------------------------------------------------
 // Device hotplug event notification
 
-//int 	libusb_hotplug_register_callback (libusb_context *ctx, libusb_hotplug_event events, libusb_hotplug_flag flags, int vendor_id, int product_id, int dev_class, libusb_hotplug_callback_fn cb_fn, void *user_data, libusb_hotplug_callback_handle *handle)
-//void 	libusb_hotplug_deregister_callback (libusb_context *ctx, libusb_hotplug_callback_handle handle)
+// The C callback can't hold a Go function, so registered callbacks are
+// kept here and looked up by id when libusb calls go_hotplug_callback.
+
+type hotplug_key struct {
+	ctx    Context
+	handle Hotplug_Callback_Handle
+}
+
+var hotplug = struct {
+	sync.Mutex
+	id     uintptr
+	fn     map[uintptr]Hotplug_Callback_Fn
+	handle map[hotplug_key]uintptr
+}{
+	fn:     make(map[uintptr]Hotplug_Callback_Fn),
+	handle: make(map[hotplug_key]uintptr),
+}
+
+// remove a callback from the hotplug registry
+func hotplug_remove(id uintptr) {
+	hotplug.Lock()
+	defer hotplug.Unlock()
+	delete(hotplug.fn, id)
+	for k, v := range hotplug.handle {
+		if v == id {
+			delete(hotplug.handle, k)
+		}
+	}
+}
+
+// call the Go function for a hotplug event
+func hotplug_callback(ctx Context, dev Device, event int, id uintptr) bool {
+	hotplug.Lock()
+	fn := hotplug.fn[id]
+	hotplug.Unlock()
+	if fn == nil {
+		return false
+	}
+	if fn(ctx, dev, event) {
+		hotplug_remove(id)
+		return true
+	}
+	return false
+}
+
+func Hotplug_Register_Callback(ctx Context, events int, flags int, vendor_id int, product_id int, dev_class int, cb_fn Hotplug_Callback_Fn) (Hotplug_Callback_Handle, error) {
+	if _, ok := lookup_backend(ctx); ok {
+		return 0, new_error(ERROR_NOT_SUPPORTED, "Hotplug_Register_Callback")
+	}
+	// register the function first, HOTPLUG_ENUMERATE calls it before we have the handle
+	hotplug.Lock()
+	hotplug.id++
+	id := hotplug.id
+	hotplug.fn[id] = cb_fn
+	hotplug.Unlock()
+	var handle C.libusb_hotplug_callback_handle
+	rc := int(C.hotplug_register_callback(ctx, (C.int)(events), (C.int)(flags), (C.int)(vendor_id), (C.int)(product_id), (C.int)(dev_class), (C.uintptr_t)(id), &handle))
+	if rc != 0 {
+		hotplug_remove(id)
+		return 0, new_error(rc, "Hotplug_Register_Callback")
+	}
+	hotplug.Lock()
+	if hotplug.fn[id] != nil {
+		hotplug.handle[hotplug_key{ctx, Hotplug_Callback_Handle(handle)}] = id
+	}
+	hotplug.Unlock()
+	return Hotplug_Callback_Handle(handle), nil
+}
+
+func Hotplug_Deregister_Callback(ctx Context, handle Hotplug_Callback_Handle) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
+	C.libusb_hotplug_deregister_callback(ctx, (C.libusb_hotplug_callback_handle)(handle))
+	hotplug.Lock()
+	id, ok := hotplug.handle[hotplug_key{ctx, handle}]
+	hotplug.Unlock()
+	if ok {
+		hotplug_remove(id)
+	}
+}
 
 //-----------------------------------------------------------------------------
 //Asynchronous device I/O
 
 func Alloc_Streams(dev Device_Handle, num_streams uint32, endpoints []byte) (int, error) {
+	if _, ok := lookup_handle(dev); ok {
+		return 0, new_handle_error(ERROR_NOT_SUPPORTED, "Alloc_Streams", dev)
+	}
 	rc := int(C.libusb_alloc_streams(dev, (C.uint32_t)(num_streams), (*C.uchar)(&endpoints[0]), (C.int)(len(endpoints))))
 	if rc < 0 {
-		return 0, &libusb_error{rc}
+		return 0, new_handle_error(rc, "Alloc_Streams", dev)
 	}
 	return rc, nil
 }
 
 func Free_Streams(dev Device_Handle, endpoints []byte) error {
+	if _, ok := lookup_handle(dev); ok {
+		return new_handle_error(ERROR_NOT_SUPPORTED, "Free_Streams", dev)
+	}
 	rc := int(C.libusb_free_streams(dev, (*C.uchar)(&endpoints[0]), (C.int)(len(endpoints))))
 	if rc != 0 {
-		return &libusb_error{rc}
+		return new_handle_error(rc, "Free_Streams", dev)
 	}
 	return nil
 }
 
+// The C transfer can't hold a Go pointer, so allocated transfers are kept
+// here and looked up by id when libusb calls go_transfer_callback.
+// This also keeps the Go side of an in-flight transfer reachable.
+
+var transfers = struct {
+	sync.Mutex
+	id       uintptr
+	transfer map[uintptr]*Transfer
+}{
+	transfer: make(map[uintptr]*Transfer),
+}
+
+// call the Go function for a completed transfer
+func transfer_callback(id uintptr) {
+	transfers.Lock()
+	transfer := transfers.transfer[id]
+	transfers.Unlock()
+	if transfer == nil || transfer.callback == nil {
+		return
+	}
+	transfer.callback(transfer, Transfer_Status(transfer.ptr.status), transfer_data(transfer))
+}
+
+// return the transferred data
+func transfer_data(transfer *Transfer) []byte {
+	data := transfer.buffer
+	switch Transfer_Type(transfer.ptr._type) {
+	case TRANSFER_TYPE_CONTROL:
+		data = Control_Transfer_Get_Data(transfer)
+	case TRANSFER_TYPE_ISOCHRONOUS:
+		return data
+	}
+	n := int(transfer.ptr.actual_length)
+	if n > len(data) {
+		n = len(data)
+	}
+	return data[:n]
+}
+
+// copy the data into the C allocated transfer buffer
+func transfer_buffer(transfer *Transfer, data []byte) *C.uchar {
+	if len(data) > cap(transfer.buffer) {
+		free_transfer_buffer(transfer)
+		ptr := C.malloc(C.size_t(len(data)))
+		hdr := (*reflect.SliceHeader)(unsafe.Pointer(&transfer.buffer))
+		hdr.Cap = len(data)
+		hdr.Data = uintptr(ptr)
+	}
+	transfer.buffer = transfer.buffer[:len(data)]
+	copy(transfer.buffer, data)
+	if cap(transfer.buffer) == 0 {
+		return nil
+	}
+	return (*C.uchar)(&transfer.buffer[:1][0])
+}
+
+// free the C allocated transfer buffer
+func free_transfer_buffer(transfer *Transfer) {
+	if cap(transfer.buffer) != 0 {
+		C.free(unsafe.Pointer(&transfer.buffer[:1][0]))
+	}
+	transfer.buffer = nil
+}
+
 func Alloc_Transfer(iso_packets int) (*Transfer, error) {
 	ptr := C.libusb_alloc_transfer((C.int)(iso_packets))
 	if ptr == nil {
-		return nil, &libusb_error{ERROR_OTHER}
+		return nil, new_error(ERROR_OTHER, "Alloc_Transfer")
 	}
-	return c2go_Transfer(ptr), nil
+	transfer := c2go_Transfer(ptr)
+	transfer.iso_packets = iso_packets
+	transfers.Lock()
+	transfers.id++
+	transfer.id = transfers.id
+	transfers.transfer[transfer.id] = transfer
+	transfers.Unlock()
+	return transfer, nil
 }
 
+// Free a transfer and its buffer. Don't free a transfer that is in flight.
 func Free_Transfer(transfer *Transfer) {
+	transfers.Lock()
+	delete(transfers.transfer, transfer.id)
+	transfers.Unlock()
 	C.libusb_free_transfer(transfer.ptr)
+	free_transfer_buffer(transfer)
 }
 
 func Submit_Transfer(transfer *Transfer) error {
+	if _, ok := lookup_handle(transfer.ptr.dev_handle); ok {
+		return new_endpoint_error(ERROR_NOT_SUPPORTED, "Submit_Transfer", transfer.ptr.dev_handle, uint8(transfer.ptr.endpoint))
+	}
+	if !iso_packets_fit(transfer) {
+		return new_endpoint_error(ERROR_INVALID_PARAM, "Submit_Transfer", transfer.ptr.dev_handle, uint8(transfer.ptr.endpoint))
+	}
 	rc := int(C.libusb_submit_transfer(go2c_Transfer(transfer)))
 	if rc != 0 {
-		return &libusb_error{rc}
+		return new_endpoint_error(rc, "Submit_Transfer", transfer.ptr.dev_handle, uint8(transfer.ptr.endpoint))
 	}
 	return nil
 }
 
 func Cancel_Transfer(transfer *Transfer) error {
+	if _, ok := lookup_handle(transfer.ptr.dev_handle); ok {
+		return new_endpoint_error(ERROR_NOT_FOUND, "Cancel_Transfer", transfer.ptr.dev_handle, uint8(transfer.ptr.endpoint))
+	}
 	rc := int(C.libusb_cancel_transfer(go2c_Transfer(transfer)))
 	if rc != 0 {
-		return &libusb_error{rc}
+		return new_endpoint_error(rc, "Cancel_Transfer", transfer.ptr.dev_handle, uint8(transfer.ptr.endpoint))
 	}
 	return nil
 }
@@ -1284,70 +1692,485 @@ func Transfer_Get_Stream_ID(transfer *Transfer) uint32 {
 	return uint32(C.libusb_transfer_get_stream_id(go2c_Transfer(transfer)))
 }
 
-func Control_Transfer_Get_Data(transfer *Transfer) *byte {
-  // should this return a slice? - what's the length?
-	return (*byte)(C.libusb_control_transfer_get_data(go2c_Transfer(transfer)))
+// Set the transfer flags (TRANSFER_SHORT_NOT_OK, TRANSFER_ADD_ZERO_PACKET).
+// The transfer and its buffer are owned by the Go wrapper,
+// so TRANSFER_FREE_BUFFER and TRANSFER_FREE_TRANSFER are ignored.
+func Transfer_Set_Flags(transfer *Transfer, flags uint8) {
+	transfer.ptr.flags = (C.uint8_t)(flags &^ (TRANSFER_FREE_BUFFER | TRANSFER_FREE_TRANSFER))
+}
+
+// Return the data stage of a control transfer (following the setup packet).
+// Returns nil if the transfer buffer has no setup packet.
+func Control_Transfer_Get_Data(transfer *Transfer) []byte {
+	if len(transfer.buffer) < CONTROL_SETUP_SIZE {
+		return nil
+	}
+	return transfer.buffer[CONTROL_SETUP_SIZE:]
+}
+
+// Return the setup packet of a control transfer.
+func Control_Transfer_Get_Setup(transfer *Transfer) *Control_Setup {
+	return bytes2go_Control_Setup(transfer.buffer)
+}
+
+// Fill the setup packet at the start of a control transfer buffer.
+// The buffer must be at least CONTROL_SETUP_SIZE bytes.
+func Fill_Control_Setup(buffer []byte, bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, wLength uint16) {
+	buffer[0] = bmRequestType
+	buffer[1] = bRequest
+	binary.LittleEndian.PutUint16(buffer[2:], wValue)
+	binary.LittleEndian.PutUint16(buffer[4:], wIndex)
+	binary.LittleEndian.PutUint16(buffer[6:], wLength)
 }
 
-// static struct libusb_control_setup * 	libusb_control_transfer_get_setup (struct libusb_transfer *transfer)
-// static void 	libusb_fill_control_setup (unsigned char *buffer, uint8_t bmRequestType, uint8_t bRequest, uint16_t wValue, uint16_t wIndex, uint16_t wLength)
-// static void 	libusb_fill_control_transfer (struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char *buffer, libusb_transfer_cb_fn callback, void *user_data, unsigned int timeout)
-// static void 	libusb_fill_bulk_transfer (struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, unsigned char *buffer, int length, libusb_transfer_cb_fn callback, void *user_data, unsigned int timeout)
-// static void 	libusb_fill_bulk_stream_transfer (struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, uint32_t stream_id, unsigned char *buffer, int length, libusb_transfer_cb_fn callback, void *user_data, unsigned int timeout)
-// static void 	libusb_fill_interrupt_transfer (struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, unsigned char *buffer, int length, libusb_transfer_cb_fn callback, void *user_data, unsigned int timeout)
-// static void 	libusb_fill_iso_transfer (struct libusb_transfer *transfer, libusb_device_handle *dev_handle, unsigned char endpoint, unsigned char *buffer, int length, int num_iso_packets, libusb_transfer_cb_fn callback, void *user_data, unsigned int timeout)
-// static void 	libusb_set_iso_packet_lengths (struct libusb_transfer *transfer, unsigned int length)
-// static unsigned char * 	libusb_get_iso_packet_buffer (struct libusb_transfer *transfer, unsigned int packet)
-// static unsigned char * 	libusb_get_iso_packet_buffer_simple (struct libusb_transfer *transfer, unsigned int packet)
+// Fill a control transfer. The buffer holds the setup packet followed by
+// wLength bytes for the data stage. It is copied into the transfer buffer.
+// Returns ERROR_INVALID_PARAM if the buffer is too short for the setup packet and data stage.
+func Fill_Control_Transfer(transfer *Transfer, hdl Device_Handle, buffer []byte, callback Transfer_Callback, timeout uint) error {
+	if len(buffer) < CONTROL_SETUP_SIZE || len(buffer) < CONTROL_SETUP_SIZE+int(binary.LittleEndian.Uint16(buffer[6:])) {
+		return new_handle_error(ERROR_INVALID_PARAM, "Fill_Control_Transfer", hdl)
+	}
+	transfer.callback = callback
+	C.fill_control_transfer(transfer.ptr, hdl, transfer_buffer(transfer, buffer), (C.uintptr_t)(transfer.id), (C.uint)(timeout))
+	return nil
+}
+
+// Fill a bulk transfer. The buffer is copied into the transfer buffer,
+// for IN endpoints only its length matters.
+func Fill_Bulk_Transfer(transfer *Transfer, hdl Device_Handle, endpoint uint8, buffer []byte, callback Transfer_Callback, timeout uint) {
+	transfer.callback = callback
+	C.fill_bulk_transfer(transfer.ptr, hdl, (C.uchar)(endpoint), transfer_buffer(transfer, buffer), (C.int)(len(buffer)), (C.uintptr_t)(transfer.id), (C.uint)(timeout))
+}
+
+// Fill a bulk transfer using bulk streams. The buffer is copied into the transfer buffer.
+func Fill_Bulk_Stream_Transfer(transfer *Transfer, hdl Device_Handle, endpoint uint8, stream_id uint32, buffer []byte, callback Transfer_Callback, timeout uint) {
+	transfer.callback = callback
+	C.fill_bulk_stream_transfer(transfer.ptr, hdl, (C.uchar)(endpoint), (C.uint32_t)(stream_id), transfer_buffer(transfer, buffer), (C.int)(len(buffer)), (C.uintptr_t)(transfer.id), (C.uint)(timeout))
+}
+
+// Fill an interrupt transfer. The buffer is copied into the transfer buffer,
+// for IN endpoints only its length matters.
+func Fill_Interrupt_Transfer(transfer *Transfer, hdl Device_Handle, endpoint uint8, buffer []byte, callback Transfer_Callback, timeout uint) {
+	transfer.callback = callback
+	C.fill_interrupt_transfer(transfer.ptr, hdl, (C.uchar)(endpoint), transfer_buffer(transfer, buffer), (C.int)(len(buffer)), (C.uintptr_t)(transfer.id), (C.uint)(timeout))
+}
+
+// Fill an isochronous transfer. The buffer is copied into the transfer buffer,
+// for IN endpoints only its length matters. Set the packet lengths before submitting,
+// the packets must fit in the buffer.
+// Returns ERROR_INVALID_PARAM if the transfer was allocated with fewer than num_iso_packets packets.
+func Fill_Iso_Transfer(transfer *Transfer, hdl Device_Handle, endpoint uint8, buffer []byte, num_iso_packets int, callback Transfer_Callback, timeout uint) error {
+	if num_iso_packets < 0 || num_iso_packets > transfer.iso_packets {
+		return new_endpoint_error(ERROR_INVALID_PARAM, "Fill_Iso_Transfer", hdl, endpoint)
+	}
+	transfer.callback = callback
+	C.fill_iso_transfer(transfer.ptr, hdl, (C.uchar)(endpoint), transfer_buffer(transfer, buffer), (C.int)(len(buffer)), (C.int)(num_iso_packets), (C.uintptr_t)(transfer.id), (C.uint)(timeout))
+	return nil
+}
+
+// Set the length of all packets in an isochronous transfer.
+func Set_Iso_Packet_Lengths(transfer *Transfer, length uint) {
+	C.libusb_set_iso_packet_lengths(transfer.ptr, (C.uint)(length))
+}
+
+// return the slice of the transfer buffer at a C buffer pointer, nil if it's outside the buffer
+func iso_packet_buffer(transfer *Transfer, ptr *C.uchar, length int) []byte {
+	if ptr == nil || len(transfer.buffer) == 0 {
+		return nil
+	}
+	offset := int(uintptr(unsafe.Pointer(ptr))) - int(uintptr(unsafe.Pointer(&transfer.buffer[:1][0])))
+	if offset < 0 || offset+length > len(transfer.buffer) {
+		return nil
+	}
+	return transfer.buffer[offset : offset+length]
+}
+
+// return true if the isochronous packets of a transfer fit in its buffer
+func iso_packets_fit(transfer *Transfer) bool {
+	if Transfer_Type(transfer.ptr._type) != TRANSFER_TYPE_ISOCHRONOUS {
+		return true
+	}
+	if int(transfer.ptr.num_iso_packets) > transfer.iso_packets {
+		return false
+	}
+	n := 0
+	for _, x := range iso_packet_desc(transfer) {
+		n += int(x.length)
+	}
+	return n <= len(transfer.buffer)
+}
+
+// return the C isochronous packet descriptors as a slice
+func iso_packet_desc(transfer *Transfer) []C.struct_libusb_iso_packet_descriptor {
+	var list []C.struct_libusb_iso_packet_descriptor
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&list))
+	hdr.Cap = int(transfer.ptr.num_iso_packets)
+	hdr.Len = int(transfer.ptr.num_iso_packets)
+	hdr.Data = uintptr(unsafe.Pointer(C.iso_packet_desc_ptr(transfer.ptr)))
+	return list
+}
+
+// Return the buffer for a packet in an isochronous transfer.
+// The slice is the requested length of the packet. Returns nil for an invalid packet.
+func Get_Iso_Packet_Buffer(transfer *Transfer, packet uint) []byte {
+	ptr := C.libusb_get_iso_packet_buffer(transfer.ptr, (C.uint)(packet))
+	if ptr == nil {
+		return nil
+	}
+	return iso_packet_buffer(transfer, ptr, int(iso_packet_desc(transfer)[packet].length))
+}
+
+// Return the buffer for a packet in an isochronous transfer,
+// assuming all packets are the same length as the first packet.
+// Returns nil for an invalid packet.
+func Get_Iso_Packet_Buffer_Simple(transfer *Transfer, packet uint) []byte {
+	ptr := C.libusb_get_iso_packet_buffer_simple(transfer.ptr, (C.uint)(packet))
+	if ptr == nil {
+		return nil
+	}
+	return iso_packet_buffer(transfer, ptr, int(iso_packet_desc(transfer)[0].length))
+}
+
+// Return the per-packet results of an isochronous transfer.
+// The packet data slices reference the transfer buffer.
+// Packets that don't fit in the transfer buffer have no data.
+func Get_Iso_Packet_Descriptors(transfer *Transfer) []*Iso_Packet_Descriptor {
+	list := iso_packet_desc(transfer)
+	if len(list) > transfer.iso_packets {
+		list = list[:transfer.iso_packets]
+	}
+	packets := make([]*Iso_Packet_Descriptor, len(list))
+	offset := 0
+	for i, x := range list {
+		p := &Iso_Packet_Descriptor{
+			Length:        uint(x.length),
+			Actual_Length: uint(x.actual_length),
+			Status:        Transfer_Status(x.status),
+		}
+		if p.Actual_Length <= p.Length && offset+int(x.length) <= len(transfer.buffer) {
+			p.Data = transfer.buffer[offset : offset+int(x.actual_length)]
+		}
+		packets[i] = p
+		offset += int(x.length)
+	}
+	return packets
+}
 
 //-----------------------------------------------------------------------------
 // Polling and timing
 
-// int 	libusb_try_lock_events (libusb_context *ctx)
-// void 	libusb_lock_events (libusb_context *ctx)
-// void 	libusb_unlock_events (libusb_context *ctx)
-// int 	libusb_event_handling_ok (libusb_context *ctx)
-// int 	libusb_event_handler_active (libusb_context *ctx)
-// void 	libusb_lock_event_waiters (libusb_context *ctx)
-// void 	libusb_unlock_event_waiters (libusb_context *ctx)
-// int 	libusb_wait_for_event (libusb_context *ctx, struct timeval *tv)
-// int 	libusb_handle_events_timeout_completed (libusb_context *ctx, struct timeval *tv, int *completed)
-// int 	libusb_handle_events_timeout (libusb_context *ctx, struct timeval *tv)
-// int 	libusb_handle_events (libusb_context *ctx)
-// int 	libusb_handle_events_completed (libusb_context *ctx, int *completed)
-// int 	libusb_handle_events_locked (libusb_context *ctx, struct timeval *tv)
-// int 	libusb_pollfds_handle_timeouts (libusb_context *ctx)
-// int 	libusb_get_next_timeout (libusb_context *ctx, struct timeval *tv)
-// void 	libusb_set_pollfd_notifiers (libusb_context *ctx, libusb_pollfd_added_cb added_cb, libusb_pollfd_removed_cb removed_cb, void *user_data)
-// const struct libusb_pollfd ** 	libusb_get_pollfds (libusb_context *ctx)
-// void 	libusb_free_pollfds (const struct libusb_pollfd **pollfds)
+// convert a duration to a C timeval
+func go2c_Timeval(x time.Duration) C.struct_timeval {
+	return C.struct_timeval{
+		tv_sec:  C.time_t(x / time.Second),
+		tv_usec: C.suseconds_t((x % time.Second) / time.Microsecond),
+	}
+}
+
+// convert a C timeval to a duration
+func c2go_Timeval(x *C.struct_timeval) time.Duration {
+	return time.Duration(x.tv_sec)*time.Second + time.Duration(x.tv_usec)*time.Microsecond
+}
+
+// Attempt to acquire the event handling lock.
+// Returns true if the lock was obtained (libusb returns 0 in this case).
+func Try_Lock_Events(ctx Context) bool {
+	if _, ok := lookup_backend(ctx); ok {
+		return false
+	}
+	return int(C.libusb_try_lock_events(ctx)) == 0
+}
+
+func Lock_Events(ctx Context) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
+	C.libusb_lock_events(ctx)
+}
+
+func Unlock_Events(ctx Context) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
+	C.libusb_unlock_events(ctx)
+}
+
+func Event_Handling_Ok(ctx Context) bool {
+	if _, ok := lookup_backend(ctx); ok {
+		return false
+	}
+	return int(C.libusb_event_handling_ok(ctx)) != 0
+}
+
+func Event_Handler_Active(ctx Context) bool {
+	if _, ok := lookup_backend(ctx); ok {
+		return false
+	}
+	return int(C.libusb_event_handler_active(ctx)) != 0
+}
+
+func Interrupt_Event_Handler(ctx Context) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
+	C.libusb_interrupt_event_handler(ctx)
+}
+
+func Lock_Event_Waiters(ctx Context) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
+	C.libusb_lock_event_waiters(ctx)
+}
+
+func Unlock_Event_Waiters(ctx Context) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
+	C.libusb_unlock_event_waiters(ctx)
+}
+
+// Wait for another thread to signal completion of an event.
+// Returns true if the timeout expired.
+func Wait_For_Event(ctx Context, tv time.Duration) bool {
+	if _, ok := lookup_backend(ctx); ok {
+		return true
+	}
+	c_tv := go2c_Timeval(tv)
+	return int(C.libusb_wait_for_event(ctx, &c_tv)) != 0
+}
+
+// Handle any pending events. Event handling stops when *completed is non-zero.
+func Handle_Events_Timeout_Completed(ctx Context, tv time.Duration, completed *int32) error {
+	if _, ok := lookup_backend(ctx); ok {
+		return new_error(ERROR_NOT_SUPPORTED, "Handle_Events_Timeout_Completed")
+	}
+	c_tv := go2c_Timeval(tv)
+	rc := int(C.libusb_handle_events_timeout_completed(ctx, &c_tv, (*C.int)(unsafe.Pointer(completed))))
+	if rc != 0 {
+		return new_error(rc, "Handle_Events_Timeout_Completed")
+	}
+	return nil
+}
+
+func Handle_Events_Timeout(ctx Context, tv time.Duration) error {
+	if _, ok := lookup_backend(ctx); ok {
+		return new_error(ERROR_NOT_SUPPORTED, "Handle_Events_Timeout")
+	}
+	c_tv := go2c_Timeval(tv)
+	rc := int(C.libusb_handle_events_timeout(ctx, &c_tv))
+	if rc != 0 {
+		return new_error(rc, "Handle_Events_Timeout")
+	}
+	return nil
+}
+
+func Handle_Events(ctx Context) error {
+	if _, ok := lookup_backend(ctx); ok {
+		return new_error(ERROR_NOT_SUPPORTED, "Handle_Events")
+	}
+	rc := int(C.libusb_handle_events(ctx))
+	if rc != 0 {
+		return new_error(rc, "Handle_Events")
+	}
+	return nil
+}
+
+// Handle any pending events in blocking mode. Event handling stops when *completed is non-zero.
+func Handle_Events_Completed(ctx Context, completed *int32) error {
+	if _, ok := lookup_backend(ctx); ok {
+		return new_error(ERROR_NOT_SUPPORTED, "Handle_Events_Completed")
+	}
+	rc := int(C.libusb_handle_events_completed(ctx, (*C.int)(unsafe.Pointer(completed))))
+	if rc != 0 {
+		return new_error(rc, "Handle_Events_Completed")
+	}
+	return nil
+}
+
+// Handle any pending events by polling file descriptors. Call with the event lock held.
+func Handle_Events_Locked(ctx Context, tv time.Duration) error {
+	if _, ok := lookup_backend(ctx); ok {
+		return new_error(ERROR_NOT_SUPPORTED, "Handle_Events_Locked")
+	}
+	c_tv := go2c_Timeval(tv)
+	rc := int(C.libusb_handle_events_locked(ctx, &c_tv))
+	if rc != 0 {
+		return new_error(rc, "Handle_Events_Locked")
+	}
+	return nil
+}
+
+// Determine the next internal timeout that libusb needs to handle.
+// Returns false if there are no pending timeouts.
+func Get_Next_Timeout(ctx Context) (time.Duration, bool, error) {
+	if _, ok := lookup_backend(ctx); ok {
+		return 0, false, new_error(ERROR_NOT_SUPPORTED, "Get_Next_Timeout")
+	}
+	var tv C.struct_timeval
+	rc := int(C.libusb_get_next_timeout(ctx, &tv))
+	if rc < 0 {
+		return 0, false, new_error(rc, "Get_Next_Timeout")
+	}
+	if rc == 0 {
+		return 0, false, nil
+	}
+	return c2go_Timeval(&tv), true, nil
+}
+
+// Returns true if libusb handles its timeouts through the polled file descriptors (timerfd),
+// false if the application must also call Get_Next_Timeout.
+func Pollfds_Handle_Timeouts(ctx Context) bool {
+	if _, ok := lookup_backend(ctx); ok {
+		return false
+	}
+	return int(C.libusb_pollfds_handle_timeouts(ctx)) != 0
+}
+
+// The C notifiers can't hold a Go function, so the notifiers are kept
+// here and looked up by id when libusb calls go_pollfd_added/removed.
+
+type pollfd_notifier struct {
+	added   Pollfd_Added_Cb
+	removed Pollfd_Removed_Cb
+}
+
+var pollfd_notifiers = struct {
+	sync.Mutex
+	id       uintptr
+	notifier map[uintptr]*pollfd_notifier
+	ctx      map[Context]uintptr
+}{
+	notifier: make(map[uintptr]*pollfd_notifier),
+	ctx:      make(map[Context]uintptr),
+}
+
+// return the pollfd notifier for an id
+func get_pollfd_notifier(id uintptr) *pollfd_notifier {
+	pollfd_notifiers.Lock()
+	defer pollfd_notifiers.Unlock()
+	return pollfd_notifiers.notifier[id]
+}
+
+// call the Go function for an added file descriptor
+func pollfd_added(fd int, events int16, id uintptr) {
+	n := get_pollfd_notifier(id)
+	if n != nil && n.added != nil {
+		n.added(fd, events)
+	}
+}
+
+// call the Go function for a removed file descriptor
+func pollfd_removed(fd int, id uintptr) {
+	n := get_pollfd_notifier(id)
+	if n != nil && n.removed != nil {
+		n.removed(fd)
+	}
+}
+
+// Register notification functions for file descriptor additions/removals.
+// Pass nil functions to remove the notifiers.
+func Set_Pollfd_Notifiers(ctx Context, added_cb Pollfd_Added_Cb, removed_cb Pollfd_Removed_Cb) {
+	if _, ok := lookup_backend(ctx); ok {
+		return
+	}
+	pollfd_notifiers.Lock()
+	delete(pollfd_notifiers.notifier, pollfd_notifiers.ctx[ctx])
+	delete(pollfd_notifiers.ctx, ctx)
+	if added_cb == nil && removed_cb == nil {
+		pollfd_notifiers.Unlock()
+		C.libusb_set_pollfd_notifiers(ctx, nil, nil, nil)
+		return
+	}
+	pollfd_notifiers.id++
+	id := pollfd_notifiers.id
+	pollfd_notifiers.notifier[id] = &pollfd_notifier{added_cb, removed_cb}
+	pollfd_notifiers.ctx[ctx] = id
+	pollfd_notifiers.Unlock()
+	C.set_pollfd_notifiers(ctx, (C.uintptr_t)(id))
+}
+
+// Get the file descriptors that libusb needs to poll.
+// The returned list is a Go copy, the C list is freed with libusb_free_pollfds.
+func Get_Pollfds(ctx Context) ([]*Pollfd, error) {
+	if _, ok := lookup_backend(ctx); ok {
+		return nil, new_error(ERROR_NOT_SUPPORTED, "Get_Pollfds")
+	}
+	ptr := C.libusb_get_pollfds(ctx)
+	if ptr == nil {
+		return nil, new_error(ERROR_NOT_SUPPORTED, "Get_Pollfds")
+	}
+	defer C.libusb_free_pollfds(ptr)
+	// the C array is NULL terminated
+	n := 0
+	for p := ptr; *p != nil; n++ {
+		p = (**C.struct_libusb_pollfd)(unsafe.Pointer(uintptr(unsafe.Pointer(p)) + unsafe.Sizeof(*p)))
+	}
+	var list []*C.struct_libusb_pollfd
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&list))
+	hdr.Cap = n
+	hdr.Len = n
+	hdr.Data = uintptr(unsafe.Pointer(ptr))
+	pollfds := make([]*Pollfd, n)
+	for i := range pollfds {
+		pollfds[i] = c2go_Pollfd(list[i])
+	}
+	return pollfds, nil
+}
 
 //-----------------------------------------------------------------------------
 // Synchronous device I/O
 
 func Control_Transfer(hdl Device_Handle, bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout uint) ([]byte, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_control_transfer(hdl, h, bmRequestType, bRequest, wValue, wIndex, data, timeout, "Control_Transfer")
+	}
 	rc := int(C.libusb_control_transfer(hdl, (C.uint8_t)(bmRequestType), (C.uint8_t)(bRequest), (C.uint16_t)(wValue), (C.uint16_t)(wIndex),
-		(*C.uchar)(&data[0]), (C.uint16_t)(len(data)), (C.uint)(timeout)))
+		go2c_Data(data), (C.uint16_t)(len(data)), (C.uint)(timeout)))
 	if rc < 0 {
-		return nil, &libusb_error{rc}
+		return nil, new_handle_error(rc, "Control_Transfer", hdl)
 	}
 	return data[:rc], nil
 }
 
 func Bulk_Transfer(hdl Device_Handle, endpoint uint8, data []byte, timeout uint) ([]byte, error) {
+	data, err := bulk_transfer(hdl, endpoint, data, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func Interrupt_Transfer(hdl Device_Handle, endpoint uint8, data []byte, timeout uint) ([]byte, error) {
+	data, err := interrupt_transfer(hdl, endpoint, data, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// bulk transfer, returning the data transferred before an error along with the error
+func bulk_transfer(hdl Device_Handle, endpoint uint8, data []byte, timeout uint) ([]byte, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_transfer(hdl, h.hdl.Bulk, "Bulk_Transfer", endpoint, data, timeout)
+	}
 	var transferred C.int
-	rc := int(C.libusb_bulk_transfer(hdl, (C.uchar)(endpoint), (*C.uchar)(&data[0]), (C.int)(len(data)), &transferred, (C.uint)(timeout)))
+	rc := int(C.libusb_bulk_transfer(hdl, (C.uchar)(endpoint), go2c_Data(data), (C.int)(len(data)), &transferred, (C.uint)(timeout)))
 	if rc != 0 {
-		return nil, &libusb_error{rc}
+		return data[:int(transferred)], new_endpoint_error(rc, "Bulk_Transfer", hdl, endpoint)
 	}
 	return data[:int(transferred)], nil
 }
 
-func Interrupt_Transfer(hdl Device_Handle, endpoint uint8, data []byte, timeout uint) ([]byte, error) {
+// interrupt transfer, returning the data transferred before an error along with the error
+func interrupt_transfer(hdl Device_Handle, endpoint uint8, data []byte, timeout uint) ([]byte, error) {
+	if h, ok := lookup_handle(hdl); ok {
+		return backend_transfer(hdl, h.hdl.Interrupt, "Interrupt_Transfer", endpoint, data, timeout)
+	}
 	var transferred C.int
-	rc := int(C.libusb_interrupt_transfer(hdl, (C.uchar)(endpoint), (*C.uchar)(&data[0]), (C.int)(len(data)), &transferred, (C.uint)(timeout)))
+	rc := int(C.libusb_interrupt_transfer(hdl, (C.uchar)(endpoint), go2c_Data(data), (C.int)(len(data)), &transferred, (C.uint)(timeout)))
 	if rc != 0 {
-		return nil, &libusb_error{rc}
+		return data[:int(transferred)], new_endpoint_error(rc, "Interrupt_Transfer", hdl, endpoint)
 	}
 	return data[:int(transferred)], nil
 }
@@ -9,10 +9,18 @@ Test functions for libusb-1.0
 package libusb
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"log"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 	"unsafe"
+
+	"github.com/deadsy/libusb/descriptor"
 )
 
 //-----------------------------------------------------------------------------
@@ -27,6 +35,60 @@ func Test_Error_Name(t *testing.T) {
 	}
 }
 
+func Test_Error(t *testing.T) {
+	err := new_endpoint_error(ERROR_PIPE, "Bulk_Transfer", nil, 0x81)
+	if !errors.Is(err, ErrPipe) || errors.Is(err, ErrTimeout) {
+		t.Error("FAIL")
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Code != ERROR_PIPE || e.Endpoint != 0x81 {
+		t.Error("FAIL")
+	}
+	if err.Error() != "libusb Bulk_Transfer endpoint 0x81: LIBUSB_ERROR_PIPE" {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Companion_Descriptor(t *testing.T) {
+	ep := &Endpoint_Descriptor{
+		Extra: []byte{0x06, DT_SS_ENDPOINT_COMPANION, 0x0f, 0x00, 0x00, 0x04},
+	}
+	comp, err := Get_SS_Endpoint_Companion_Descriptor(nil, ep)
+	if err != nil || comp.BMaxBurst != 15 || comp.WBytesPerInterval != 0x400 {
+		t.Error("FAIL")
+	}
+	ep.Extra = nil
+	_, err = Get_SS_Endpoint_Companion_Descriptor(nil, ep)
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_BOS_Capability(t *testing.T) {
+	bos := &BOS_Descriptor{
+		Dev_capability: []*BOS_Dev_Capability_Descriptor{
+			{
+				BLength:             BT_USB_2_0_EXTENSION_SIZE,
+				BDescriptorType:     DT_DEVICE_CAPABILITY,
+				BDevCapabilityType:  BT_USB_2_0_EXTENSION,
+				Dev_capability_data: []byte{BM_LPM_SUPPORT, 0, 0, 0},
+			},
+		},
+	}
+	if Get_BOS_Dev_Capability(bos, BT_CONTAINER_ID) != nil {
+		t.Error("FAIL")
+	}
+	dev_cap := Get_BOS_Dev_Capability(bos, BT_USB_2_0_EXTENSION)
+	ext, err := Get_USB_2_0_Extension_Descriptor(nil, dev_cap)
+	if err != nil || ext.BmAttributes != BM_LPM_SUPPORT {
+		t.Error("FAIL")
+	}
+	_, err = Get_Container_ID_Descriptor(nil, dev_cap)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+}
+
 func Test_Device_List(t *testing.T) {
 	var ctx Context
 	err := Init(&ctx)
@@ -58,6 +120,499 @@ func Test_Device_List(t *testing.T) {
 	Free_Device_List(list, 1)
 }
 
+// check the raw descriptors of each device round-trip through the descriptor package
+func Test_Raw_Descriptors(t *testing.T) {
+	var ctx Context
+	err := Init(&ctx)
+	defer Exit(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	list, err := Get_Device_List(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	defer Free_Device_List(list, 1)
+
+	for _, dev := range list {
+		hdl, err := Open(dev)
+		if err != nil {
+			// no access
+			continue
+		}
+		raw, err := Get_Descriptor(hdl, DT_CONFIG, 0, make([]byte, 4096))
+		if err == nil {
+			cd, err := descriptor.Parse_Config_Descriptor(raw)
+			if err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(descriptor.Encode_Config_Descriptor(cd), raw) {
+				t.Error("FAIL")
+			}
+		}
+		cd, err := Get_Config_Descriptor(dev, 0)
+		if err == nil && raw != nil && !bytes.Equal(descriptor.Encode_Config_Descriptor(cd), raw) {
+			t.Error("FAIL")
+		}
+		Close(hdl)
+	}
+}
+
+func Test_Device_Info(t *testing.T) {
+	var ctx Context
+	err := Init(&ctx)
+	defer Exit(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	infos, err := Get_Device_Infos(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	_, err = Device_Info_JSON(infos)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	// the encodings of a snapshot
+	infos = []*Device_Info{{
+		Device_Location: Device_Location{Bus: 1, Address: 4, Port_Path: []int{1, 2}},
+		Parent:          &Device_Location{Bus: 1, Address: 1, Port_Path: []int{}},
+		Speed:           SPEED_HIGH,
+		Speed_Name:      SPEED_HIGH.String(),
+		Serial:          "A1",
+		Strings:         map[uint8]string{3: "A1"},
+		Device:          &Device_Descriptor{IdVendor: 0x1d50, IdProduct: 0x6018, ISerialNumber: 3},
+		Config:          []*Config_Descriptor{},
+		Error:           "LIBUSB_ERROR_IO",
+	}}
+	b, err := Device_Info_JSON(infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []string{`"bus": 1`, `"port_path": [
+      1,
+      2
+    ]`, `"parent": {
+      "bus": 1,
+      "address": 1,
+      "port_path": []
+    }`, `"speed": 3`, `"speed_name": "480 Mbit/s (USB HighSpeed)"`, `"serial": "A1"`, `"3": "A1"`,
+		`"idVendor": 7504`, `"config": []`, `"bos": null`, `"error": "LIBUSB_ERROR_IO"`} {
+		if !bytes.Contains(b, []byte(x)) {
+			t.Error("FAIL", x)
+		}
+	}
+	b, err = Device_Info_YAML(infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []string{"- bus: 1\n  address: 4\n  port_path:\n    - 1\n    - 2\n  parent:\n    bus: 1\n",
+		"  speed_name: \"480 Mbit/s (USB HighSpeed)\"\n", "  strings:\n    \"3\": \"A1\"\n", "    idProduct: 24600\n",
+		"  config: []\n", "  bos: null\n", "  error: \"LIBUSB_ERROR_IO\"\n"} {
+		if !bytes.Contains(b, []byte(x)) {
+			t.Error("FAIL", x)
+		}
+	}
+	// no error field without an error
+	infos[0].Error = ""
+	b, _ = Device_Info_JSON(infos)
+	if bytes.Contains(b, []byte(`"error"`)) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Hotplug(t *testing.T) {
+	if !Has_Capability(CAP_HAS_HOTPLUG) {
+		t.Skip("hotplug not supported")
+	}
+	var ctx Context
+	err := Init(&ctx)
+	defer Exit(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	// HOTPLUG_ENUMERATE reports the attached devices during registration
+	n := 0
+	handle, err := Hotplug_Register_Callback(ctx, HOTPLUG_EVENT_DEVICE_ARRIVED, HOTPLUG_ENUMERATE, HOTPLUG_MATCH_ANY, HOTPLUG_MATCH_ANY, HOTPLUG_MATCH_ANY,
+		func(ctx Context, dev Device, event int) bool {
+			if event != HOTPLUG_EVENT_DEVICE_ARRIVED {
+				t.Error("FAIL")
+			}
+			n += 1
+			return false
+		})
+	if err != nil {
+		t.Error("FAIL")
+	}
+	logger.Printf("hotplug enumerated %d devices", n)
+	Hotplug_Deregister_Callback(ctx, handle)
+}
+
+func Test_Watch(t *testing.T) {
+	var ctx Context
+	err := Init(&ctx)
+	defer Exit(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	events, stop, err := Watch(ctx, nil)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	stop()
+	// stop closes the channel
+	for e := range events {
+		logger.Printf("Bus %03d Device %03d: ID %04x:%04x event %d", e.Bus, e.Address, e.Descriptor.IdVendor, e.Descriptor.IdProduct, e.Event)
+	}
+	stop()
+}
+
+func Test_Watch_Filter(t *testing.T) {
+	dd := &Device_Descriptor{IdVendor: 0x1d50, IdProduct: 0x6018, BDeviceClass: 0xef}
+	// zero fields match any value
+	f := &WatchFilter{}
+	if !f.Match(dd) {
+		t.Error("FAIL")
+	}
+	f = &WatchFilter{Vendor: 0x1d50, Product: HOTPLUG_MATCH_ANY}
+	if !f.Match(dd) {
+		t.Error("FAIL")
+	}
+	f = &WatchFilter{Vendor: 0x1d50, Class: 0x09}
+	if f.Match(dd) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Transfer(t *testing.T) {
+	transfer, err := Alloc_Transfer(0)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	defer Free_Transfer(transfer)
+
+	buffer := make([]byte, CONTROL_SETUP_SIZE+18)
+	Fill_Control_Setup(buffer, ENDPOINT_IN, REQUEST_GET_DESCRIPTOR, DT_DEVICE<<8, 0, 18)
+	err = Fill_Control_Transfer(transfer, nil, buffer, func(transfer *Transfer, status Transfer_Status, data []byte) {}, 1000)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	setup := Control_Transfer_Get_Setup(transfer)
+	if setup.BmRequestType != ENDPOINT_IN || setup.BRequest != REQUEST_GET_DESCRIPTOR || setup.WValue != DT_DEVICE<<8 || setup.WLength != 18 {
+		t.Error("FAIL")
+	}
+	if len(Control_Transfer_Get_Data(transfer)) != 18 {
+		t.Error("FAIL")
+	}
+	logger.Printf("\n%s", Transfer_str(transfer))
+
+	// the buffer must hold the setup packet and wLength bytes
+	err = Fill_Control_Transfer(transfer, nil, buffer[:CONTROL_SETUP_SIZE-1], nil, 1000)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+	err = Fill_Control_Transfer(transfer, nil, buffer[:CONTROL_SETUP_SIZE+17], nil, 1000)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Iso_Transfer(t *testing.T) {
+	transfer, err := Alloc_Transfer(4)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	defer Free_Transfer(transfer)
+
+	buffer := make([]byte, 4*64)
+	for i := range buffer {
+		buffer[i] = byte(i / 64)
+	}
+	err = Fill_Iso_Transfer(transfer, nil, ENDPOINT_IN|1, buffer, 5, nil, 1000)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+	err = Fill_Iso_Transfer(transfer, nil, ENDPOINT_IN|1, buffer, 4, func(transfer *Transfer, status Transfer_Status, data []byte) {}, 1000)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	Set_Iso_Packet_Lengths(transfer, 64)
+	for i := uint(0); i < 4; i++ {
+		x := Get_Iso_Packet_Buffer(transfer, i)
+		if len(x) != 64 || x[0] != byte(i) {
+			t.Error("FAIL")
+		}
+		x = Get_Iso_Packet_Buffer_Simple(transfer, i)
+		if len(x) != 64 || x[63] != byte(i) {
+			t.Error("FAIL")
+		}
+	}
+	if Get_Iso_Packet_Buffer(transfer, 4) != nil {
+		t.Error("FAIL")
+	}
+	if len(Get_Iso_Packet_Descriptors(transfer)) != 4 {
+		t.Error("FAIL")
+	}
+
+	// packets beyond the end of the buffer
+	Set_Iso_Packet_Lengths(transfer, 100)
+	if Get_Iso_Packet_Buffer(transfer, 2) != nil || Get_Iso_Packet_Buffer(transfer, 1) == nil {
+		t.Error("FAIL")
+	}
+	packets := Get_Iso_Packet_Descriptors(transfer)
+	if len(packets) != 4 || packets[0].Data == nil || packets[3].Data != nil {
+		t.Error("FAIL")
+	}
+	if !errors.Is(Submit_Transfer(transfer), ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Transfer_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := BulkTransferContext(ctx, nil, ENDPOINT_IN|1, make([]byte, 64))
+	if !errors.Is(err, context.Canceled) {
+		t.Error("FAIL")
+	}
+
+	// a cancelled transfer reports both the status and the context error
+	transfer, err := Alloc_Transfer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Free_Transfer(transfer)
+	Fill_Bulk_Transfer(transfer, nil, ENDPOINT_IN|1, make([]byte, 64), nil, 0)
+	err = transfer_cancel_error(TRANSFER_CANCELLED, "BulkTransferContext", transfer, context.Canceled)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrInterrupted) {
+		t.Error("FAIL")
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Endpoint != ENDPOINT_IN|1 || e.Op != "BulkTransferContext" {
+		t.Error("FAIL")
+	}
+	err = transfer_cancel_error(TRANSFER_STALL, "BulkTransferContext", transfer, context.DeadlineExceeded)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrPipe) {
+		t.Error("FAIL")
+	}
+	if transfer_cancel_error(TRANSFER_COMPLETED, "BulkTransferContext", transfer, context.Canceled) != nil {
+		t.Error("FAIL")
+	}
+}
+
+// an endpoint transfer function returning queued results
+type fake_transfer struct {
+	results []fake_result
+	writes  [][]byte
+}
+
+type fake_result struct {
+	n   int   // bytes transferred
+	err error // transfer error
+}
+
+func (f *fake_transfer) transfer(hdl Device_Handle, endpoint uint8, data []byte, timeout uint) ([]byte, error) {
+	f.writes = append(f.writes, append([]byte(nil), data...))
+	if len(f.results) == 0 {
+		return nil, ErrTimeout
+	}
+	r := f.results[0]
+	f.results = f.results[1:]
+	if r.n > len(data) {
+		r.n = len(data)
+	}
+	for i := range data[:r.n] {
+		data[i] = byte(len(f.writes))
+	}
+	return data[:r.n], r.err
+}
+
+func Test_InEndpoint(t *testing.T) {
+	// the direction is checked before the handle is used
+	_, err := OpenInEndpoint(nil, ENDPOINT_OUT|1)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+
+	f := &fake_transfer{results: []fake_result{{8, nil}, {0, nil}, {3, nil}, {8, nil}, {8, nil}, {2, nil}, {3, ErrTimeout}, {0, ErrNoDevice}}}
+	e := &InEndpoint{addr: ENDPOINT_IN | 1, transfer: f.transfer, MaxPacketSize: 8}
+	// a full packet read in parts
+	p := make([]byte, 5)
+	n, err := e.Read(p)
+	if n != 5 || err != nil || len(f.writes[0]) != 8 {
+		t.Error("FAIL")
+	}
+	n, err = e.Read(p)
+	if n != 3 || err != nil || p[0] != 1 {
+		t.Error("FAIL")
+	}
+	// the zero length packet is skipped, then a short packet
+	q := make([]byte, 20)
+	n, err = e.Read(q)
+	if n != 3 || err != nil || q[0] != 3 || len(f.writes[2]) != 24 {
+		t.Error("FAIL")
+	}
+	// a message ends with a short packet
+	msg, err := e.ReadMessage()
+	if len(msg) != 18 || err != nil || msg[17] != 6 {
+		t.Error("FAIL")
+	}
+	// data received before a timeout is returned with the error
+	n, err = e.Read(p)
+	if n != 3 || !errors.Is(err, ErrTimeout) || p[0] != 7 {
+		t.Error("FAIL")
+	}
+	// transfer errors are returned as is, not io.EOF
+	n, err = e.Read(p)
+	if n != 0 || !errors.Is(err, ErrNoDevice) {
+		t.Error("FAIL")
+	}
+	e.MaxPacketSize = 0
+	_, err = e.Read(p)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+	e.Close()
+	_, err = e.Read(p)
+	if err != io.ErrClosedPipe {
+		t.Error("FAIL")
+	}
+}
+
+func Test_OutEndpoint(t *testing.T) {
+	_, err := OpenOutEndpoint(nil, ENDPOINT_IN|1)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+
+	f := &fake_transfer{results: []fake_result{{16, nil}, {0, nil}, {5, nil}, {3, ErrTimeout}}}
+	e := &OutEndpoint{addr: ENDPOINT_OUT | 1, transfer: f.transfer, MaxPacketSize: 8, ZeroLengthPacket: true}
+	// a multiple of the packet size is followed by a zero length packet
+	n, err := e.Write(make([]byte, 16))
+	if n != 16 || err != nil || len(f.writes) != 2 || len(f.writes[1]) != 0 {
+		t.Error("FAIL")
+	}
+	// partial writes
+	n, err = e.Write(make([]byte, 10))
+	if n != 5 || err != io.ErrShortWrite {
+		t.Error("FAIL")
+	}
+	n, err = e.Write(make([]byte, 10))
+	if n != 3 || !errors.Is(err, ErrTimeout) {
+		t.Error("FAIL")
+	}
+	e.MaxPacketSize = 0
+	_, err = e.Write(make([]byte, 8))
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+	e.Close()
+	_, err = e.Write(nil)
+	if err != io.ErrClosedPipe {
+		t.Error("FAIL")
+	}
+}
+
+func Test_StreamReader(t *testing.T) {
+	// arguments are checked before the handle is used
+	for _, x := range []struct {
+		addr                         uint8
+		num_transfers, transfer_size int
+	}{
+		{ENDPOINT_OUT | 1, 4, 64},
+		{ENDPOINT_IN | 1, 0, 64},
+		{ENDPOINT_IN | 1, 4, 0},
+	} {
+		_, err := OpenStreamReader(nil, x.addr, x.num_transfers, x.transfer_size)
+		if !errors.Is(err, ErrInvalidParam) {
+			t.Error("FAIL")
+		}
+	}
+
+	// completed transfers are delivered in order with their status
+	transfer, err := Alloc_Transfer(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Free_Transfer(transfer)
+	Fill_Bulk_Transfer(transfer, nil, ENDPOINT_IN|1, make([]byte, 64), nil, 0)
+	s := &StreamReader{addr: ENDPOINT_IN | 1, done: make(chan *stream_buffer, 3), queued: 3, in_flight: 3}
+	s.callback(transfer, TRANSFER_COMPLETED, []byte{1, 2, 3})
+	s.callback(transfer, TRANSFER_STALL, nil)
+	data, err := s.Next()
+	if !bytes.Equal(data, []byte{1, 2, 3}) || err != nil || s.Starved() != 0 {
+		t.Error("FAIL")
+	}
+	p := make([]byte, 2)
+	n, err := s.Read(p)
+	if n != 2 || err != nil || p[1] != 2 {
+		t.Error("FAIL")
+	}
+
+	// close waits for the cancelled transfers
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.callback(transfer, TRANSFER_CANCELLED, nil)
+	}()
+	if s.Close() != nil || s.queued != 0 || s.Starved() != 0 {
+		t.Error("FAIL")
+	}
+	_, err = s.Next()
+	if err != io.ErrClosedPipe {
+		t.Error("FAIL")
+	}
+	_, err = s.Read(p)
+	if err != io.ErrClosedPipe {
+		t.Error("FAIL")
+	}
+	if s.Close() != nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Event_Loop(t *testing.T) {
+	var ctx Context
+	err := Init(&ctx)
+	defer Exit(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	err = StartEventLoop(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	if StartEventLoop(ctx) == nil {
+		t.Error("FAIL")
+	}
+	StopEventLoop(ctx)
+	StopEventLoop(ctx)
+}
+
+func Test_Pollfds(t *testing.T) {
+	var ctx Context
+	err := Init(&ctx)
+	defer Exit(ctx)
+	if err != nil {
+		t.Error("FAIL")
+	}
+
+	Set_Pollfd_Notifiers(ctx, func(fd int, events int16) {}, func(fd int) {})
+	defer Set_Pollfd_Notifiers(ctx, nil, nil)
+
+	list, err := Get_Pollfds(ctx)
+	if err != nil {
+		t.Skip("pollfds not supported")
+	}
+	for _, x := range list {
+		logger.Printf("fd %d events 0x%04x", x.Fd, x.Events)
+	}
+	logger.Printf("pollfds handle timeouts %v", Pollfds_Handle_Timeouts(ctx))
+}
+
 func Test_Init_Exit(t *testing.T) {
 	var ctx Context
 	err := Init(&ctx)
@@ -67,4 +622,255 @@ func Test_Init_Exit(t *testing.T) {
 	}
 }
 
+// a backend with one device, unimplemented methods panic
+type fake_backend struct {
+	Backend
+	dev    *fake_device
+	closed bool
+}
+
+func (b *fake_backend) Devices() ([]Backend_Device, error) {
+	b.dev.refs++
+	return []Backend_Device{b.dev}, nil
+}
+
+func (b *fake_backend) Close() error {
+	b.closed = true
+	return nil
+}
+
+type fake_device struct {
+	Backend_Device
+	refs int
+	hdl  *fake_handle
+}
+
+func (d *fake_device) Descriptor() (*Device_Descriptor, error) {
+	return &Device_Descriptor{IdVendor: 0x1d50, IdProduct: 0x6018}, nil
+}
+
+func (d *fake_device) ActiveConfig() (*Config_Descriptor, error) {
+	ep := &Endpoint_Descriptor{BEndpointAddress: 0x81, BmAttributes: 0x02, WMaxPacketSize: 64}
+	id := &Interface_Descriptor{Endpoint: []*Endpoint_Descriptor{ep}}
+	return &Config_Descriptor{Interface: []*Interface{{Altsetting: []*Interface_Descriptor{id}}}}, nil
+}
+
+func (d *fake_device) Bus() uint8 {
+	return 3
+}
+
+func (d *fake_device) Open() (Backend_Handle, error) {
+	return d.hdl, nil
+}
+
+func (d *fake_device) Close() error {
+	d.refs--
+	return nil
+}
+
+type fake_handle struct {
+	Backend_Handle
+	data   [][]byte
+	closed bool
+}
+
+func (h *fake_handle) Claim(n int) error {
+	if n != 0 {
+		return ErrBusy
+	}
+	return nil
+}
+
+func (h *fake_handle) Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	if len(h.data) == 0 {
+		return nil, ErrTimeout
+	}
+	n := copy(data, h.data[0])
+	h.data = h.data[1:]
+	return data[:n], nil
+}
+
+func (h *fake_handle) Close() error {
+	h.closed = true
+	return nil
+}
+
+func Test_Backend(t *testing.T) {
+	if Init_Backend(new(Context), nil) == nil {
+		t.Error("FAIL")
+	}
+	h := &fake_handle{data: [][]byte{[]byte("hello")}}
+	b := &fake_backend{dev: &fake_device{hdl: h}}
+	var ctx Context
+	err := Init_Backend(&ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := Get_Device_List(ctx)
+	if err != nil || len(list) != 1 {
+		t.Fatal("FAIL")
+	}
+	dev := list[0]
+	if Get_Bus_Number(dev) != 3 || Get_Max_Packet_Size(dev, 0x81) != 64 || Get_Max_Packet_Size(dev, 0x82) != ERROR_NOT_FOUND {
+		t.Error("FAIL")
+	}
+	hdl, err := Open(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handle holds a reference to the device
+	Free_Device_List(list, 1)
+	if Get_Device(hdl) != dev || b.dev.refs != 1 {
+		t.Error("FAIL")
+	}
+
+	if Claim_Interface(hdl, 0) != nil {
+		t.Error("FAIL")
+	}
+	err = Claim_Interface(hdl, 1)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != ERROR_BUSY || e.Op != "Claim_Interface" || e.Handle != hdl {
+		t.Error("FAIL", err)
+	}
+	data, err := Bulk_Transfer(hdl, 0x81, make([]byte, 64), 100)
+	if err != nil || string(data) != "hello" {
+		t.Error("FAIL")
+	}
+	_, err = Bulk_Transfer(hdl, 0x81, make([]byte, 64), 100)
+	if !errors.As(err, &e) || e.Code != ERROR_TIMEOUT || e.Op != "Bulk_Transfer" || e.Endpoint != 0x81 {
+		t.Error("FAIL", err)
+	}
+	if _, err := Kernel_Driver_Active(hdl, 0); !errors.Is(err, ErrNotSupported) {
+		t.Error("FAIL")
+	}
+	if _, err := Hotplug_Register_Callback(ctx, 0, 0, 0, 0, 0, nil); !errors.Is(err, ErrNotSupported) {
+		t.Error("FAIL")
+	}
+
+	Close(hdl)
+	if !h.closed || b.dev.refs != 0 {
+		t.Error("FAIL")
+	}
+	Exit(ctx)
+	if !b.closed || len(backends.contexts) != 0 || len(backends.devices) != 0 || len(backends.handles) != 0 || len(backends.lists) != 0 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Enum_String(t *testing.T) {
+	if SPEED_HIGH.String() != "480 Mbit/s (USB HighSpeed)" || SPEED_LOW.Mbps() != 1.5 {
+		t.Error("FAIL")
+	}
+	if TRANSFER_STALL.String() != "LIBUSB_TRANSFER_STALL" || Transfer_Status(99).String() != "Transfer_Status(99)" {
+		t.Error("FAIL")
+	}
+	if LOG_LEVEL_DEBUG.String() != "LIBUSB_LOG_LEVEL_DEBUG" {
+		t.Error("FAIL")
+	}
+	if CLASS_HID.String() != "Human Interface Device" {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Selector(t *testing.T) {
+	tests := []struct {
+		s      string
+		sel    Selector
+		expect string
+	}{
+		{"1d50:6018", Selector{Vendor: 0x1d50, Product: 0x6018}, "1d50:6018"},
+		{"1d50::ABC123", Selector{Vendor: 0x1d50, Serial: "ABC123"}, "1d50:,serial=ABC123"},
+		{"3-1.4.2", Selector{Bus: 3, Port_Path: []int{1, 4, 2}}, "3-1.4.2"},
+		{"class=0x03, :6018", Selector{Product: 0x6018, Class: CLASS_HID}, ":6018,class=0x03"},
+	}
+	for _, x := range tests {
+		sel, err := Parse_Selector(x.s)
+		if err != nil {
+			t.Error("FAIL", err)
+			continue
+		}
+		if !reflect.DeepEqual(*sel, x.sel) || sel.String() != x.expect {
+			t.Error("FAIL", x.s, sel)
+		}
+	}
+	for _, s := range []string{"xyz", "1-a", "g:1", "class=0x300", "port=1"} {
+		_, err := Parse_Selector(s)
+		if !errors.Is(err, ErrInvalidParam) {
+			t.Error("FAIL", s)
+		}
+	}
+	// devices are ordered by bus and port path
+	a := &Device_Location{Bus: 1, Port_Path: []int{2}}
+	b := &Device_Location{Bus: 1, Port_Path: []int{2, 1}}
+	c := &Device_Location{Bus: 2, Port_Path: []int{1}}
+	if !a.Less(b) || b.Less(a) || !b.Less(c) || c.Less(a) || a.Less(a) {
+		t.Error("FAIL")
+	}
+	// exactly one device must match
+	sel := &Selector{Vendor: 0x1d50}
+	if Check_One_Match(sel, []*Device_Location{a}) != nil {
+		t.Error("FAIL")
+	}
+	err := Check_One_Match(sel, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("FAIL")
+	}
+	err = Check_One_Match(sel, []*Device_Location{a, c})
+	var se *Selector_Error
+	if errors.Is(err, ErrNotFound) || !errors.As(err, &se) || len(se.Matches) != 2 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_OpenOne(t *testing.T) {
+	var ctx Context
+	err := Init(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Exit(ctx)
+	// no device should have this serial number
+	sel := &Selector{Product: 0xffff, Serial: "no such device"}
+	hdl, err := OpenOne(ctx, sel)
+	if hdl != nil || !errors.Is(err, ErrNotFound) {
+		t.Error("FAIL", err)
+	}
+	var e *Selector_Error
+	if !errors.As(err, &e) || e.Selector != sel {
+		t.Error("FAIL")
+	}
+}
+
+func Test_OpenBySerial(t *testing.T) {
+	var ctx Context
+	err := Init(&ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Exit(ctx)
+	_, err = OpenBySerial(ctx, 0x1d50, 0x6018, "")
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+	_, err = OpenBySerial(ctx, 0x1d50, 0xffff, "no such device")
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("FAIL", err)
+	}
+	// list the serial numbers, a second lookup is cached
+	list, err := Get_Device_List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Free_Device_List(list, 1)
+	cache := New_String_Cache()
+	for _, dev := range list {
+		s0, err0 := cache.Serial(dev)
+		s1, err1 := cache.Serial(dev)
+		if s0 != s1 || err0 != err1 {
+			t.Error("FAIL")
+		}
+		logger.Printf("%s serial %q %v", device_location(dev), s0, err0)
+	}
+}
+
 //-----------------------------------------------------------------------------
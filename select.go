@@ -0,0 +1,362 @@
+//-----------------------------------------------------------------------------
+/*
+
+Device selectors
+
+A selector picks devices by vendor/product id, serial number, position on the
+bus or class. The string form is a comma separated list of terms:
+
+	vid:pid          hex vendor and product id, either may be empty
+	vid:pid:serial   as above with a serial number
+	serial=xyz       serial number
+	bus-port.port    bus number and port path, e.g. 1-2.3
+	class=0x03       device class or any interface class
+
+A device must match all terms.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+// Selector matches devices. Zero value fields match any device.
+type Selector struct {
+	Vendor    uint16 // vendor id
+	Product   uint16 // product id
+	Serial    string // serial number string, read from sysfs or by opening the device
+	Bus       uint8  // bus number
+	Port_Path []int  // port numbers from the root hub, Bus should also be set
+	Class     Class  // device class or the class of any interface in the active configuration
+}
+
+// String returns the selector in the form accepted by Parse_Selector.
+func (sel *Selector) String() string {
+	s := make([]string, 0, 3)
+	if sel.Vendor != 0 || sel.Product != 0 {
+		s = append(s, vid_pid_string(sel.Vendor, sel.Product))
+	}
+	if sel.Serial != "" {
+		s = append(s, "serial="+sel.Serial)
+	}
+	if sel.Bus != 0 || len(sel.Port_Path) != 0 {
+		s = append(s, port_path_string(int(sel.Bus), sel.Port_Path))
+	}
+	if sel.Class != 0 {
+		s = append(s, fmt.Sprintf("class=0x%02x", uint8(sel.Class)))
+	}
+	return strings.Join(s, ",")
+}
+
+// return vid:pid, a zero id is left empty
+func vid_pid_string(vid, pid uint16) string {
+	s := [2]string{}
+	if vid != 0 {
+		s[0] = fmt.Sprintf("%04x", vid)
+	}
+	if pid != 0 {
+		s[1] = fmt.Sprintf("%04x", pid)
+	}
+	return s[0] + ":" + s[1]
+}
+
+// return bus-port.port
+func port_path_string(bus int, path []int) string {
+	s := make([]string, len(path))
+	for i, p := range path {
+		s[i] = strconv.Itoa(p)
+	}
+	return fmt.Sprintf("%d-%s", bus, strings.Join(s, "."))
+}
+
+// String returns the location in bus-port.port form.
+func (loc *Device_Location) String() string {
+	return port_path_string(int(loc.Bus), loc.Port_Path)
+}
+
+//-----------------------------------------------------------------------------
+
+// parse a hex id, empty for any
+func parse_id(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	x, err := strconv.ParseUint(s, 16, 16)
+	return uint16(x), err
+}
+
+// parse a vid:pid[:serial] term
+func (sel *Selector) parse_vid_pid(s string) error {
+	x := strings.SplitN(s, ":", 3)
+	var err error
+	sel.Vendor, err = parse_id(x[0])
+	if err != nil {
+		return err
+	}
+	sel.Product, err = parse_id(x[1])
+	if err != nil {
+		return err
+	}
+	if len(x) == 3 {
+		sel.Serial = x[2]
+	}
+	return nil
+}
+
+// parse a bus-port.port term
+func (sel *Selector) parse_port_path(s string) error {
+	i := strings.Index(s, "-")
+	bus, err := strconv.ParseUint(s[:i], 10, 8)
+	if err != nil {
+		return err
+	}
+	sel.Bus = uint8(bus)
+	sel.Port_Path = nil
+	if s[i+1:] == "" {
+		return nil
+	}
+	for _, x := range strings.Split(s[i+1:], ".") {
+		p, err := strconv.ParseUint(x, 10, 8)
+		if err != nil {
+			return err
+		}
+		sel.Port_Path = append(sel.Port_Path, int(p))
+	}
+	return nil
+}
+
+// parse a single selector term
+func (sel *Selector) parse_term(s string) error {
+	if i := strings.Index(s, "="); i >= 0 {
+		key, val := s[:i], s[i+1:]
+		switch key {
+		case "serial":
+			sel.Serial = val
+			return nil
+		case "class":
+			x, err := strconv.ParseUint(val, 0, 8)
+			if err != nil {
+				return err
+			}
+			sel.Class = Class(x)
+			return nil
+		}
+		return fmt.Errorf("unknown key %q", key)
+	}
+	if strings.Contains(s, ":") {
+		return sel.parse_vid_pid(s)
+	}
+	if strings.Contains(s, "-") {
+		return sel.parse_port_path(s)
+	}
+	return fmt.Errorf("unknown term")
+}
+
+// Parse_Selector parses the string form of a selector.
+func Parse_Selector(s string) (*Selector, error) {
+	sel := &Selector{}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		err := sel.parse_term(term)
+		if err != nil {
+			return nil, fmt.Errorf("libusb Parse_Selector: bad term %q: %v: %w", term, err, ErrInvalidParam)
+		}
+	}
+	return sel, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// return true if the device or any interface of the configuration has the class
+func has_class(dd *Device_Descriptor, cd *Config_Descriptor, class Class) bool {
+	if dd.Class() == class {
+		return true
+	}
+	if cd == nil {
+		return false
+	}
+	for _, itf := range cd.Interface {
+		for _, id := range itf.Altsetting {
+			if id.Class() == class {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match_Device reports whether a device matches the selector, given its
+// device descriptor and location. The active configuration and serial number
+// are read with the functions, and only when the selector needs them.
+// An error is returned if the serial number is needed and can't be read.
+func (sel *Selector) Match_Device(dd *Device_Descriptor, loc *Device_Location, active_config func() (*Config_Descriptor, error), serial func() (string, error)) (bool, error) {
+	if (sel.Vendor != 0 && sel.Vendor != dd.IdVendor) || (sel.Product != 0 && sel.Product != dd.IdProduct) {
+		return false, nil
+	}
+	if sel.Bus != 0 && sel.Bus != loc.Bus {
+		return false, nil
+	}
+	if sel.Port_Path != nil {
+		if len(loc.Port_Path) != len(sel.Port_Path) {
+			return false, nil
+		}
+		for i := range loc.Port_Path {
+			if loc.Port_Path[i] != sel.Port_Path[i] {
+				return false, nil
+			}
+		}
+	}
+	if sel.Class != 0 {
+		// a device without an active configuration may still match on the device class
+		cd, _ := active_config()
+		if !has_class(dd, cd, sel.Class) {
+			return false, nil
+		}
+	}
+	if sel.Serial != "" {
+		s, err := serial()
+		if err != nil {
+			return false, err
+		}
+		return s == sel.Serial, nil
+	}
+	return true, nil
+}
+
+// Match reports whether the device matches the selector.
+// An error is returned if the serial number is needed and can't be read.
+func (sel *Selector) Match(dev Device) (bool, error) {
+	return sel.match(dev, New_String_Cache())
+}
+
+// match a device, reading the serial number through the cache
+func (sel *Selector) match(dev Device, cache *String_Cache) (bool, error) {
+	dd, err := Get_Device_Descriptor(dev)
+	if err != nil {
+		return false, err
+	}
+	active_config := func() (*Config_Descriptor, error) {
+		return Get_Active_Config_Descriptor(dev)
+	}
+	serial := func() (string, error) {
+		return cache.Serial(dev)
+	}
+	return sel.Match_Device(dd, device_location(dev), active_config, serial)
+}
+
+//-----------------------------------------------------------------------------
+
+// Less reports whether the location sorts before another, by bus and then port path.
+func (loc *Device_Location) Less(x *Device_Location) bool {
+	if loc.Bus != x.Bus {
+		return loc.Bus < x.Bus
+	}
+	for i := 0; i < len(loc.Port_Path) && i < len(x.Port_Path); i++ {
+		if loc.Port_Path[i] != x.Port_Path[i] {
+			return loc.Port_Path[i] < x.Port_Path[i]
+		}
+	}
+	return len(loc.Port_Path) < len(x.Port_Path)
+}
+
+// Find returns the devices that match a selector, ordered by bus and port path.
+// Each device is referenced and must be released with Unref_Device.
+// Devices whose serial number can't be read don't match, if no device
+// matches the error from reading a serial number is returned.
+func Find(ctx Context, sel *Selector) ([]Device, error) {
+	list, err := Get_Device_List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer Free_Device_List(list, 1)
+	var match_err error
+	cache := New_String_Cache()
+	devices := make([]Device, 0, 1)
+	for _, dev := range list {
+		ok, err := sel.match(dev, cache)
+		if err != nil {
+			if match_err == nil {
+				match_err = err
+			}
+			continue
+		}
+		if ok {
+			devices = append(devices, Ref_Device(dev))
+		}
+	}
+	if len(devices) == 0 && match_err != nil {
+		return nil, match_err
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return device_location(devices[i]).Less(device_location(devices[j]))
+	})
+	return devices, nil
+}
+
+// Selector_Error is returned by OpenOne when a selector does not match
+// exactly one device. It matches ErrNotFound with errors.Is when no device matches.
+type Selector_Error struct {
+	Selector *Selector
+	Matches  []*Device_Location // the matching devices
+}
+
+func (e *Selector_Error) Error() string {
+	if len(e.Matches) == 0 {
+		return fmt.Sprintf("libusb OpenOne: no device matches %q", e.Selector)
+	}
+	s := make([]string, len(e.Matches))
+	for i, loc := range e.Matches {
+		s[i] = loc.String()
+	}
+	return fmt.Sprintf("libusb OpenOne: %d devices match %q: %s", len(e.Matches), e.Selector, strings.Join(s, ", "))
+}
+
+// Is reports whether the target is ErrNotFound and no device matched.
+func (e *Selector_Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == ERROR_NOT_FOUND && len(e.Matches) == 0
+}
+
+// Check_One_Match returns a Selector_Error unless exactly one device matched the selector.
+// The locations are those of the matching devices.
+func Check_One_Match(sel *Selector, matches []*Device_Location) error {
+	if len(matches) != 1 {
+		return &Selector_Error{Selector: sel, Matches: matches}
+	}
+	return nil
+}
+
+// OpenOne opens the single device that matches a selector.
+// A Selector_Error is returned if no device or more than one device matches.
+func OpenOne(ctx Context, sel *Selector) (Device_Handle, error) {
+	devices, err := Find(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, dev := range devices {
+			Unref_Device(dev)
+		}
+	}()
+	matches := make([]*Device_Location, len(devices))
+	for i, dev := range devices {
+		matches[i] = device_location(dev)
+	}
+	if err := Check_One_Match(sel, matches); err != nil {
+		return nil, err
+	}
+	return Open(devices[0])
+}
+
+//-----------------------------------------------------------------------------
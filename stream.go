@@ -0,0 +1,186 @@
+//-----------------------------------------------------------------------------
+/*
+
+Streaming reader
+
+Keep a number of transfers queued on a bulk or interrupt IN endpoint so the
+device can be read at full rate. Completed transfers are delivered in order
+and their buffers are recycled once they have been consumed.
+
+Transfers complete from within libusb event handling. Events for the libusb
+context of the device handle must be handled while the stream is open,
+for example by calling StartEventLoop.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+//-----------------------------------------------------------------------------
+
+// a completed stream transfer
+type stream_buffer struct {
+	transfer *Transfer
+	status   Transfer_Status
+	data     []byte
+}
+
+// StreamReader reads from an IN endpoint with multiple transfers in flight.
+// It implements io.ReadCloser.
+type StreamReader struct {
+	starved   uint64 // count of times no transfers were in flight, first for 64-bit alignment
+	hdl       Device_Handle
+	addr      uint8
+	transfers []*Transfer
+	done      chan *stream_buffer // completed transfers, in order
+	queued    int                 // transfers submitted and not yet received from done
+	in_flight int32               // transfers submitted and not yet completed
+	current   *stream_buffer      // buffer returned to the caller, recycled on the next call
+	buf       []byte              // unread data of the current buffer
+	closing   int32
+	closed    bool
+}
+
+// OpenStreamReader starts reading a bulk or interrupt IN endpoint with
+// num_transfers transfers of transfer_size bytes queued.
+// The interface containing the endpoint should be claimed.
+func OpenStreamReader(hdl Device_Handle, addr uint8, num_transfers int, transfer_size int) (*StreamReader, error) {
+	if addr&ENDPOINT_DIR_MASK != ENDPOINT_IN || num_transfers < 1 || transfer_size < 1 {
+		return nil, new_endpoint_error(ERROR_INVALID_PARAM, "OpenStreamReader", hdl, addr)
+	}
+	transfer_type, err := endpoint_transfer_type(hdl, addr)
+	if err != nil {
+		return nil, err
+	}
+	if transfer_type != TRANSFER_TYPE_BULK && transfer_type != TRANSFER_TYPE_INTERRUPT {
+		return nil, new_endpoint_error(ERROR_NOT_SUPPORTED, "OpenStreamReader", hdl, addr)
+	}
+
+	s := &StreamReader{
+		hdl:       hdl,
+		addr:      addr,
+		transfers: make([]*Transfer, 0, num_transfers),
+		done:      make(chan *stream_buffer, num_transfers),
+	}
+	buffer := make([]byte, transfer_size)
+	for i := 0; i < num_transfers; i++ {
+		transfer, err := Alloc_Transfer(0)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.transfers = append(s.transfers, transfer)
+		if transfer_type == TRANSFER_TYPE_BULK {
+			Fill_Bulk_Transfer(transfer, hdl, addr, buffer, s.callback, 0)
+		} else {
+			Fill_Interrupt_Transfer(transfer, hdl, addr, buffer, s.callback, 0)
+		}
+	}
+	for _, transfer := range s.transfers {
+		err := s.submit(transfer)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// transfer completion callback
+func (s *StreamReader) callback(transfer *Transfer, status Transfer_Status, data []byte) {
+	if atomic.AddInt32(&s.in_flight, -1) == 0 && atomic.LoadInt32(&s.closing) == 0 {
+		// the consumer has fallen behind, the endpoint is not being read
+		atomic.AddUint64(&s.starved, 1)
+	}
+	s.done <- &stream_buffer{transfer, status, data}
+}
+
+// submit a transfer
+func (s *StreamReader) submit(transfer *Transfer) error {
+	atomic.AddInt32(&s.in_flight, 1)
+	err := Submit_Transfer(transfer)
+	if err != nil {
+		atomic.AddInt32(&s.in_flight, -1)
+		return err
+	}
+	s.queued++
+	return nil
+}
+
+// Next returns the data of the next completed transfer.
+// The data is valid until the next call to Next, Read or Close, after which the
+// transfer is resubmitted. Transfer errors (e.g. ERROR_PIPE for a stalled endpoint,
+// ERROR_OVERFLOW for a babbling device) are returned with the data received.
+func (s *StreamReader) Next() ([]byte, error) {
+	if s.closed {
+		return nil, io.ErrClosedPipe
+	}
+	if s.current != nil {
+		err := s.submit(s.current.transfer)
+		s.current = nil
+		s.buf = nil
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.queued == 0 {
+		return nil, new_endpoint_error(ERROR_IO, "StreamReader.Next", s.hdl, s.addr)
+	}
+	s.current = <-s.done
+	s.queued--
+	s.buf = s.current.data
+	return s.current.data, transfer_status_error(s.current.status, "StreamReader.Next", s.current.transfer)
+}
+
+// Read reads the stream data. Transfer boundaries are not preserved.
+func (s *StreamReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		_, err := s.Next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Starved returns the number of times all transfers had completed before the
+// consumer recycled them. While starved the endpoint is not read, and devices
+// that can't hold off data may have lost some.
+func (s *StreamReader) Starved() uint64 {
+	return atomic.LoadUint64(&s.starved)
+}
+
+// Close cancels the queued transfers, waits for them to complete and frees them.
+// The device handle is not closed.
+func (s *StreamReader) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	atomic.StoreInt32(&s.closing, 1)
+	for _, transfer := range s.transfers {
+		// transfers that are not in flight can't be cancelled
+		Cancel_Transfer(transfer)
+	}
+	for ; s.queued > 0; s.queued-- {
+		<-s.done
+	}
+	for _, transfer := range s.transfers {
+		Free_Transfer(transfer)
+	}
+	s.current = nil
+	s.buf = nil
+	return nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,187 @@
+//-----------------------------------------------------------------------------
+/*
+
+String descriptors
+
+GetString decodes the UTF-16LE text of a string descriptor in a given
+language, GetLanguages returns the languages supported by the device.
+A String_Reader does the same over any way of reading a descriptor, so
+other device APIs share the decoding and language choice.
+
+Reading a string descriptor means opening the device, which is slow and
+fails for devices claimed by another process. A String_Cache reads each
+string once per enumeration. On Linux the serial number is read from sysfs
+when available, so the device is not opened.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"sync"
+
+	"github.com/deadsy/libusb/descriptor"
+)
+
+//-----------------------------------------------------------------------------
+
+// String_Reader reads the string descriptor at index in a language into data,
+// e.g. with a GET_DESCRIPTOR control request.
+type String_Reader func(index uint8, langid uint16, data []byte) ([]byte, error)
+
+// Languages returns the language ids supported by the device, read from string index 0.
+func (read String_Reader) Languages() ([]uint16, error) {
+	buf, err := read(0, 0, make([]byte, 255))
+	if err != nil {
+		return nil, err
+	}
+	return descriptor.Parse_Language_Descriptor(buf)
+}
+
+// String returns the string descriptor at index in a language.
+func (read String_Reader) String(index uint8, langid uint16) (string, error) {
+	if index == 0 {
+		return "", new_error(ERROR_INVALID_PARAM, "GetString")
+	}
+	buf, err := read(index, langid, make([]byte, 255))
+	if err != nil {
+		return "", err
+	}
+	return descriptor.Parse_String_Descriptor(buf)
+}
+
+// String_Preferred returns the string descriptor at index in the best
+// language from a priority list, see descriptor.Pick_Language.
+// Returns the string and the language id it was read in.
+func (read String_Reader) String_Preferred(index uint8, preferred []uint16) (string, uint16, error) {
+	langids, err := read.Languages()
+	if err != nil {
+		return "", 0, err
+	}
+	langid, ok := descriptor.Pick_Language(langids, preferred)
+	if !ok {
+		return "", 0, new_error(ERROR_NOT_FOUND, "GetStringPreferred")
+	}
+	s, err := read.String(index, langid)
+	return s, langid, err
+}
+
+// return a string reader for a device handle
+func handle_string_reader(hdl Device_Handle) String_Reader {
+	return func(index uint8, langid uint16, data []byte) ([]byte, error) {
+		return Get_String_Descriptor(hdl, index, langid, data)
+	}
+}
+
+// GetLanguages returns the language ids supported by the device, read from string index 0.
+func GetLanguages(hdl Device_Handle) ([]uint16, error) {
+	return handle_string_reader(hdl).Languages()
+}
+
+// GetString returns the string descriptor at index in a language.
+func GetString(hdl Device_Handle, index uint8, langid uint16) (string, error) {
+	return handle_string_reader(hdl).String(index, langid)
+}
+
+// GetStringPreferred returns the string descriptor at index in the best
+// language from a priority list, see descriptor.Pick_Language.
+// Returns the string and the language id it was read in.
+func GetStringPreferred(hdl Device_Handle, index uint8, preferred []uint16) (string, uint16, error) {
+	return handle_string_reader(hdl).String_Preferred(index, preferred)
+}
+
+//-----------------------------------------------------------------------------
+
+type string_key struct {
+	bus, address, index uint8
+}
+
+type string_value struct {
+	s   string
+	err error
+}
+
+// String_Cache caches string descriptors by bus, address and index.
+// Device addresses are reused when devices are unplugged, so a cache
+// should only be used with a single device list.
+type String_Cache struct {
+	mu      sync.Mutex
+	strings map[string_key]string_value
+}
+
+// New_String_Cache returns an empty string descriptor cache.
+func New_String_Cache() *String_Cache {
+	return &String_Cache{strings: make(map[string_key]string_value)}
+}
+
+// lookup a string, reading it with fn if it is not cached
+func (c *String_Cache) lookup(dev Device, index uint8, fn func() (string, error)) (string, error) {
+	k := string_key{Get_Bus_Number(dev), Get_Device_Address(dev), index}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.strings[k]; ok {
+		return v.s, v.err
+	}
+	s, err := fn()
+	c.strings[k] = string_value{s, err}
+	return s, err
+}
+
+// read a string descriptor from a device
+func read_string(dev Device, index uint8) (string, error) {
+	hdl, err := Open(dev)
+	if err != nil {
+		return "", err
+	}
+	defer Close(hdl)
+	// the first language, as for Get_String_Descriptor_ASCII, but decoded from UTF-16
+	s, _, err := GetStringPreferred(hdl, index, nil)
+	return s, err
+}
+
+// String returns the string descriptor at index, opening the device if it is not cached.
+// Index 0 returns an empty string.
+func (c *String_Cache) String(dev Device, index uint8) (string, error) {
+	if index == 0 {
+		return "", nil
+	}
+	return c.lookup(dev, index, func() (string, error) {
+		return read_string(dev, index)
+	})
+}
+
+// Serial returns the serial number string of a device, an empty string if it has none.
+// The serial number is read from sysfs if possible, otherwise the device is opened.
+// A backend device reads it with Backend_Device.Serial.
+func (c *String_Cache) Serial(dev Device) (string, error) {
+	dd, err := Get_Device_Descriptor(dev)
+	if err != nil {
+		return "", err
+	}
+	if dd.ISerialNumber == 0 {
+		return "", nil
+	}
+	return c.lookup(dev, dd.ISerialNumber, func() (string, error) {
+		if d, ok := lookup_device(dev); ok {
+			return d.dev.Serial()
+		}
+		if s, ok := sysfs_serial(dev); ok {
+			return s, nil
+		}
+		return read_string(dev, dd.ISerialNumber)
+	})
+}
+
+//-----------------------------------------------------------------------------
+
+// OpenBySerial opens the single device with the vendor id, product id and serial number.
+// A zero vendor or product id matches any device. See OpenOne for the errors.
+func OpenBySerial(ctx Context, vid uint16, pid uint16, serial string) (Device_Handle, error) {
+	if serial == "" {
+		return nil, new_error(ERROR_INVALID_PARAM, "OpenBySerial")
+	}
+	return OpenOne(ctx, &Selector{Vendor: vid, Product: pid, Serial: serial})
+}
+
+//-----------------------------------------------------------------------------
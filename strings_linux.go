@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+//-----------------------------------------------------------------------------
+/*
+
+Linux sysfs string lookup
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+
+const sysfs_usb_devices = "/sys/bus/usb/devices"
+
+// read a sysfs attribute
+func sysfs_read(dir, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// return the sysfs directory of a device
+func sysfs_dir(dev Device) (string, bool) {
+	loc := device_location(dev)
+	name := loc.String()
+	if len(loc.Port_Path) == 0 {
+		name = fmt.Sprintf("usb%d", loc.Bus)
+	}
+	dir := filepath.Join(sysfs_usb_devices, name)
+	// the directory is named by port, check it is still the same device
+	for _, x := range []struct {
+		name string
+		val  uint8
+	}{{"busnum", loc.Bus}, {"devnum", loc.Address}} {
+		s, err := sysfs_read(dir, x.name)
+		if err != nil {
+			return "", false
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n != int(x.val) {
+			return "", false
+		}
+	}
+	return dir, true
+}
+
+// return the serial number of a device from sysfs
+func sysfs_serial(dev Device) (string, bool) {
+	dir, ok := sysfs_dir(dev)
+	if !ok {
+		return "", false
+	}
+	s, err := sysfs_read(dir, "serial")
+	if err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+//-----------------------------------------------------------------------------
+/*
+
+String lookup without sysfs
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+//-----------------------------------------------------------------------------
+
+// sysfs is not available, the device must be opened
+func sysfs_serial(dev Device) (string, bool) {
+	return "", false
+}
+
+//-----------------------------------------------------------------------------
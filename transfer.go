@@ -0,0 +1,151 @@
+//-----------------------------------------------------------------------------
+/*
+
+Cancellable transfers
+
+Synchronous style transfers built on the asynchronous API, so an in-flight
+transfer can be cancelled when a context.Context is done.
+
+Transfers complete from within libusb event handling. Events for the libusb
+context of the device handle must be handled while these functions wait,
+for example by calling StartEventLoop.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"context"
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------
+
+// return the error for a transfer status, mapped as for the libusb synchronous API
+func transfer_status_error(status Transfer_Status, op string, transfer *Transfer) error {
+	code := ERROR_IO
+	switch status {
+	case TRANSFER_COMPLETED:
+		return nil
+	case TRANSFER_TIMED_OUT:
+		code = ERROR_TIMEOUT
+	case TRANSFER_STALL:
+		code = ERROR_PIPE
+	case TRANSFER_NO_DEVICE:
+		code = ERROR_NO_DEVICE
+	case TRANSFER_OVERFLOW:
+		code = ERROR_OVERFLOW
+	case TRANSFER_CANCELLED:
+		code = ERROR_INTERRUPTED
+	}
+	return new_endpoint_error(code, op, transfer.ptr.dev_handle, uint8(transfer.ptr.endpoint))
+}
+
+// return the error for a transfer cancelled when the context is done.
+// It wraps both the status error and the context error.
+func transfer_cancel_error(status Transfer_Status, op string, transfer *Transfer, err error) error {
+	if status == TRANSFER_COMPLETED {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", transfer_status_error(status, op, transfer), err)
+}
+
+//-----------------------------------------------------------------------------
+
+// Submit a transfer and wait for it to complete or for the context to be done.
+// The fill function fills the transfer with the given callback.
+// Received data is copied into data, the number of bytes copied is returned.
+func transfer_context(ctx context.Context, op string, data []byte, fill func(*Transfer, Transfer_Callback) error) (int, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	transfer, err := Alloc_Transfer(0)
+	if err != nil {
+		return 0, err
+	}
+	defer Free_Transfer(transfer)
+
+	var n int
+	done := make(chan Transfer_Status, 1)
+	err = fill(transfer, func(transfer *Transfer, status Transfer_Status, x []byte) {
+		n = copy(data, x)
+		done <- status
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = Submit_Transfer(transfer)
+	if err != nil {
+		return 0, err
+	}
+
+	var status Transfer_Status
+	select {
+	case status = <-done:
+	case <-ctx.Done():
+		// the transfer may complete before it can be cancelled
+		Cancel_Transfer(transfer)
+		status = <-done
+		return n, transfer_cancel_error(status, op, transfer, ctx.Err())
+	}
+	return n, transfer_status_error(status, op, transfer)
+}
+
+//-----------------------------------------------------------------------------
+
+// ControlTransferContext performs a control transfer that is cancelled when the context is done.
+// For IN transfers the received data is copied into data.
+// Returns the transferred part of data. When the context is done the error wraps both
+// ctx.Err() and an *Error for the transfer status, and the data transferred before
+// the cancellation is returned.
+func ControlTransferContext(ctx context.Context, hdl Device_Handle, bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte) ([]byte, error) {
+	if len(data) > 0xffff {
+		return nil, new_handle_error(ERROR_INVALID_PARAM, "ControlTransferContext", hdl)
+	}
+	n, err := transfer_context(ctx, "ControlTransferContext", data, func(transfer *Transfer, callback Transfer_Callback) error {
+		buffer := make([]byte, CONTROL_SETUP_SIZE+len(data))
+		Fill_Control_Setup(buffer, bmRequestType, bRequest, wValue, wIndex, uint16(len(data)))
+		copy(buffer[CONTROL_SETUP_SIZE:], data)
+		return Fill_Control_Transfer(transfer, hdl, buffer, callback, 0)
+	})
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return data[:n], err
+}
+
+// BulkTransferContext performs a bulk transfer that is cancelled when the context is done.
+// For IN endpoints the received data is copied into data.
+// Returns the transferred part of data. When the context is done the error wraps both
+// ctx.Err() and an *Error for the transfer status, and the data transferred before
+// the cancellation is returned.
+func BulkTransferContext(ctx context.Context, hdl Device_Handle, endpoint uint8, data []byte) ([]byte, error) {
+	n, err := transfer_context(ctx, "BulkTransferContext", data, func(transfer *Transfer, callback Transfer_Callback) error {
+		Fill_Bulk_Transfer(transfer, hdl, endpoint, data, callback, 0)
+		return nil
+	})
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return data[:n], err
+}
+
+// InterruptTransferContext performs an interrupt transfer that is cancelled when the context is done.
+// For IN endpoints the received data is copied into data.
+// Returns the transferred part of data. When the context is done the error wraps both
+// ctx.Err() and an *Error for the transfer status, and the data transferred before
+// the cancellation is returned.
+func InterruptTransferContext(ctx context.Context, hdl Device_Handle, endpoint uint8, data []byte) ([]byte, error) {
+	n, err := transfer_context(ctx, "InterruptTransferContext", data, func(transfer *Transfer, callback Transfer_Callback) error {
+		Fill_Interrupt_Transfer(transfer, hdl, endpoint, data, callback, 0)
+		return nil
+	})
+	if err != nil && n == 0 {
+		return nil, err
+	}
+	return data[:n], err
+}
+
+//-----------------------------------------------------------------------------
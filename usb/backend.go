@@ -0,0 +1,197 @@
+//-----------------------------------------------------------------------------
+/*
+
+Backends
+
+A Backend provides device access for a Context. NewContext uses libusb,
+NewContextWithBackend takes any implementation, e.g. the in-memory fake
+in package usbtest, so code built on the object API can be tested without
+hardware. The interfaces are those of libusb.Init_Backend, so a backend
+also serves the 1-1 libusb functions.
+
+Backends return libusb errors (*libusb.Error), so errors.Is with the
+libusb.Err* values works whatever the backend.
+
+*/
+//-----------------------------------------------------------------------------
+
+package usb
+
+import (
+	"time"
+
+	"github.com/deadsy/libusb"
+)
+
+//-----------------------------------------------------------------------------
+
+// Backend enumerates and watches devices, see libusb.Backend.
+type Backend = libusb.Backend
+
+// BackendDevice is a referenced device, see libusb.Backend_Device.
+type BackendDevice = libusb.Backend_Device
+
+// BackendHandle is an open device, see libusb.Backend_Handle.
+type BackendHandle = libusb.Backend_Handle
+
+//-----------------------------------------------------------------------------
+// libusb backend
+
+type libusb_backend struct {
+	ctx libusb.Context
+}
+
+// return a libusb backend for a new libusb session
+func new_libusb_backend() (*libusb_backend, error) {
+	var ctx libusb.Context
+	err := libusb.Init(&ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &libusb_backend{ctx: ctx}, nil
+}
+
+func (b *libusb_backend) Devices() ([]BackendDevice, error) {
+	list, err := libusb.Get_Device_List(b.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer libusb.Free_Device_List(list, 1)
+	// strings are cached for the lifetime of the enumeration
+	cache := libusb.New_String_Cache()
+	devices := make([]BackendDevice, len(list))
+	for i, dev := range list {
+		devices[i] = &libusb_device{dev: libusb.Ref_Device(dev), cache: cache}
+	}
+	return devices, nil
+}
+
+func (b *libusb_backend) Watch(filter *libusb.WatchFilter) (<-chan libusb.DeviceEvent, func(), error) {
+	return libusb.Watch(b.ctx, filter)
+}
+
+func (b *libusb_backend) Close() error {
+	libusb.Exit(b.ctx)
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+type libusb_device struct {
+	dev   libusb.Device
+	cache *libusb.String_Cache
+}
+
+func (d *libusb_device) Descriptor() (*libusb.Device_Descriptor, error) {
+	return libusb.Get_Device_Descriptor(d.dev)
+}
+
+func (d *libusb_device) Config(index uint8) (*libusb.Config_Descriptor, error) {
+	return libusb.Get_Config_Descriptor(d.dev, index)
+}
+
+func (d *libusb_device) ActiveConfig() (*libusb.Config_Descriptor, error) {
+	return libusb.Get_Active_Config_Descriptor(d.dev)
+}
+
+func (d *libusb_device) Bus() uint8 {
+	return libusb.Get_Bus_Number(d.dev)
+}
+
+func (d *libusb_device) Address() uint8 {
+	return libusb.Get_Device_Address(d.dev)
+}
+
+func (d *libusb_device) PortPath() ([]byte, error) {
+	return libusb.Get_Port_Numbers(d.dev, make([]byte, 8))
+}
+
+func (d *libusb_device) Speed() libusb.Speed {
+	return libusb.Get_Device_Speed(d.dev)
+}
+
+func (d *libusb_device) Serial() (string, error) {
+	return d.cache.Serial(d.dev)
+}
+
+func (d *libusb_device) Parent() BackendDevice {
+	parent := libusb.Get_Parent(d.dev)
+	if parent == nil {
+		return nil
+	}
+	return &libusb_device{dev: libusb.Ref_Device(parent), cache: d.cache}
+}
+
+func (d *libusb_device) Ref() BackendDevice {
+	return &libusb_device{dev: libusb.Ref_Device(d.dev), cache: d.cache}
+}
+
+func (d *libusb_device) Open() (BackendHandle, error) {
+	hdl, err := libusb.Open(d.dev)
+	if err != nil {
+		return nil, err
+	}
+	return &libusb_handle{hdl: hdl}, nil
+}
+
+func (d *libusb_device) Close() error {
+	libusb.Unref_Device(d.dev)
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+type libusb_handle struct {
+	hdl libusb.Device_Handle
+}
+
+func (h *libusb_handle) Claim(n int) error {
+	return libusb.Claim_Interface(h.hdl, n)
+}
+
+func (h *libusb_handle) Release(n int) error {
+	return libusb.Release_Interface(h.hdl, n)
+}
+
+func (h *libusb_handle) Configuration() (int, error) {
+	return libusb.Get_Configuration(h.hdl)
+}
+
+func (h *libusb_handle) SetConfiguration(cfg int) error {
+	return libusb.Set_Configuration(h.hdl, cfg)
+}
+
+func (h *libusb_handle) SetAltSetting(n int, alt int) error {
+	return libusb.Set_Interface_Alt_Setting(h.hdl, n, alt)
+}
+
+func (h *libusb_handle) ClearHalt(endpoint uint8) error {
+	return libusb.Clear_Halt(h.hdl, endpoint)
+}
+
+func (h *libusb_handle) Reset() error {
+	return libusb.Reset_Device(h.hdl)
+}
+
+func (h *libusb_handle) SetAutoDetachKernelDriver(enable bool) error {
+	return libusb.Set_Auto_Detach_Kernel_Driver(h.hdl, enable)
+}
+
+func (h *libusb_handle) Control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout time.Duration) ([]byte, error) {
+	return libusb.Control_Transfer(h.hdl, bmRequestType, bRequest, wValue, wIndex, data, timeout_ms(timeout))
+}
+
+func (h *libusb_handle) Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return libusb.Bulk_Transfer(h.hdl, endpoint, data, timeout_ms(timeout))
+}
+
+func (h *libusb_handle) Interrupt(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return libusb.Interrupt_Transfer(h.hdl, endpoint, data, timeout_ms(timeout))
+}
+
+func (h *libusb_handle) Close() error {
+	libusb.Close(h.hdl)
+	return nil
+}
+
+//-----------------------------------------------------------------------------
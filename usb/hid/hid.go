@@ -0,0 +1,404 @@
+//-----------------------------------------------------------------------------
+/*
+
+HID host driver
+
+Find lists the HID interfaces of a device, Open claims one and reads its
+report descriptor. Input reports are read from the interrupt IN endpoint,
+output reports are written to the interrupt OUT endpoint, or with a
+SET_REPORT request if the interface has none. Feature reports are read and
+written with GET_REPORT and SET_REPORT.
+
+If the report descriptor declares report IDs the reports are numbered: the
+first byte of each report on the wire is the report ID. The methods take
+and return the report ID and the report data separately, a report ID of 0
+is used for an interface with unnumbered reports.
+
+See the Device Class Definition for HID 1.11, section 7.
+
+*/
+//-----------------------------------------------------------------------------
+
+// Package hid is a HID host driver built on the usb object API.
+package hid
+
+import (
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/descriptor"
+	"github.com/deadsy/libusb/usb"
+)
+
+//-----------------------------------------------------------------------------
+
+// HID class requests
+const (
+	GET_REPORT   = 0x01
+	GET_IDLE     = 0x02
+	GET_PROTOCOL = 0x03
+	SET_REPORT   = 0x09
+	SET_IDLE     = 0x0a
+	SET_PROTOCOL = 0x0b
+)
+
+// Report_Type is the type of a report in a GET_REPORT or SET_REPORT request.
+type Report_Type uint8
+
+// Report types
+const (
+	REPORT_TYPE_INPUT   Report_Type = 0x01
+	REPORT_TYPE_OUTPUT  Report_Type = 0x02
+	REPORT_TYPE_FEATURE Report_Type = 0x03
+)
+
+func (t Report_Type) String() string {
+	switch t {
+	case REPORT_TYPE_INPUT:
+		return "input"
+	case REPORT_TYPE_OUTPUT:
+		return "output"
+	case REPORT_TYPE_FEATURE:
+		return "feature"
+	}
+	return "unknown"
+}
+
+// Protocols for SET_PROTOCOL, boot devices only
+const (
+	PROTOCOL_BOOT   = 0
+	PROTOCOL_REPORT = 1
+)
+
+// Interface subclass and protocol
+const (
+	SUBCLASS_BOOT               = 0x01
+	INTERFACE_PROTOCOL_KEYBOARD = 0x01
+	INTERFACE_PROTOCOL_MOUSE    = 0x02
+)
+
+// timeout for control requests
+const request_timeout = time.Second
+
+// maximum length of a report descriptor without a HID descriptor
+const max_report_descriptor = 4096
+
+//-----------------------------------------------------------------------------
+
+// Interface is a HID interface of a device.
+type Interface struct {
+	Number     int                        // interface number
+	Alt        int                        // alternate setting
+	SubClass   uint8                      // SUBCLASS_BOOT for a boot device
+	Protocol   uint8                      // boot device protocol
+	Descriptor *descriptor.HID_Descriptor // nil if the device has none
+	In         uint8                      // interrupt IN endpoint
+	In_Size    int                        // max packet size of In
+	Out        uint8                      // interrupt OUT endpoint, 0 if none
+	Out_Size   int                        // max packet size of Out
+}
+
+// return the HID interface for an interface descriptor
+func new_interface(id *libusb.Interface_Descriptor) (*Interface, error) {
+	intf := &Interface{
+		Number:   int(id.BInterfaceNumber),
+		Alt:      int(id.BAlternateSetting),
+		SubClass: id.BInterfaceSubClass,
+		Protocol: id.BInterfaceProtocol,
+	}
+	list, err := descriptor.Decode_Interface_Extra(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range list {
+		if d, ok := x.(*descriptor.HID_Descriptor); ok {
+			intf.Descriptor = d
+			break
+		}
+	}
+	for _, ep := range id.Endpoint {
+		if ep.TransferType() != libusb.TRANSFER_TYPE_INTERRUPT {
+			continue
+		}
+		if ep.Direction() == libusb.DIRECTION_IN {
+			if intf.In == 0 {
+				intf.In = ep.BEndpointAddress
+				intf.In_Size = ep.MaxPacketSize()
+			}
+		} else if intf.Out == 0 {
+			intf.Out = ep.BEndpointAddress
+			intf.Out_Size = ep.MaxPacketSize()
+		}
+	}
+	return intf, nil
+}
+
+// report descriptor length from the HID descriptor
+func (intf *Interface) report_descriptor_length() int {
+	if intf.Descriptor != nil {
+		for _, x := range intf.Descriptor.Descriptors {
+			if x.BDescriptorType == libusb.DT_REPORT {
+				return int(x.WDescriptorLength)
+			}
+		}
+	}
+	return max_report_descriptor
+}
+
+// Find returns the HID interfaces in the active configuration of a device.
+func Find(dev *usb.Device) ([]*Interface, error) {
+	cd, err := dev.ActiveConfig()
+	if err != nil {
+		return nil, err
+	}
+	var list []*Interface
+	for _, x := range cd.Interface {
+		for _, id := range x.Altsetting {
+			if libusb.Class(id.BInterfaceClass) != libusb.CLASS_HID {
+				continue
+			}
+			intf, err := new_interface(id)
+			if err != nil {
+				return nil, err
+			}
+			if intf.In == 0 {
+				// an interrupt IN endpoint is required
+				continue
+			}
+			list = append(list, intf)
+		}
+	}
+	return list, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Device is an open HID interface.
+type Device struct {
+	h       *usb.DeviceHandle
+	intf    *Interface
+	report  []byte             // report descriptor
+	parsed  *Report_Descriptor // nil if the report descriptor could not be parsed
+	ids     []uint8            // report IDs, nil for unnumbered reports
+	Timeout time.Duration      // timeout for writes, zero waits forever
+}
+
+// Open claims a HID interface and reads its report descriptor.
+// The handle is used by the device, it is not closed by Close.
+func Open(h *usb.DeviceHandle, intf *Interface) (*Device, error) {
+	// a HID interface is usually bound to the kernel driver
+	h.SetAutoDetachKernelDriver(true)
+	err := h.Claim(intf.Number)
+	if err != nil {
+		return nil, err
+	}
+	if intf.Alt != 0 {
+		err = h.SetAltSetting(intf.Number, intf.Alt)
+		if err != nil {
+			h.Release(intf.Number)
+			return nil, err
+		}
+	}
+	d := &Device{h: h, intf: intf, Timeout: request_timeout}
+	d.report, err = d.get_report_descriptor()
+	if err != nil {
+		h.Release(intf.Number)
+		return nil, err
+	}
+	d.parsed, err = Parse_Report_Descriptor(d.report)
+	if err == nil {
+		d.ids = d.parsed.Report_IDs()
+	} else {
+		// reports can still be read and written
+		d.ids = report_ids(d.report)
+	}
+	return d, nil
+}
+
+// Close releases the interface.
+func (d *Device) Close() error {
+	return d.h.Release(d.intf.Number)
+}
+
+// Interface returns the HID interface of the device.
+func (d *Device) Interface() *Interface {
+	return d.intf
+}
+
+// Raw_Report_Descriptor returns the report descriptor.
+func (d *Device) Raw_Report_Descriptor() []byte {
+	return d.report
+}
+
+// Report returns the parsed report descriptor, nil if it could not be parsed.
+func (d *Device) Report() *Report_Descriptor {
+	return d.parsed
+}
+
+// Report_IDs returns the report IDs, nil if the reports are not numbered.
+func (d *Device) Report_IDs() []uint8 {
+	return d.ids
+}
+
+// Numbered returns true if the reports have report IDs.
+func (d *Device) Numbered() bool {
+	return len(d.ids) != 0
+}
+
+// read the report descriptor with a GET_DESCRIPTOR request to the interface
+func (d *Device) get_report_descriptor() ([]byte, error) {
+	buf := make([]byte, d.intf.report_descriptor_length())
+	bmRequestType := uint8(libusb.ENDPOINT_IN | libusb.REQUEST_TYPE_STANDARD | libusb.RECIPIENT_INTERFACE)
+	return d.h.Control(bmRequestType, libusb.REQUEST_GET_DESCRIPTOR, libusb.DT_REPORT<<8, uint16(d.intf.Number), buf, request_timeout)
+}
+
+// return an error for an invalid report ID
+func (d *Device) check_id(op string, id uint8) error {
+	if d.Numbered() == (id != 0) {
+		return nil
+	}
+	return &libusb.Error{Code: libusb.ERROR_INVALID_PARAM, Op: op, Endpoint: -1}
+}
+
+// return the report on the wire
+func (d *Device) frame(id uint8, data []byte) []byte {
+	if !d.Numbered() {
+		return data
+	}
+	return append([]byte{id}, data...)
+}
+
+// split a report on the wire into the report ID and data
+func (d *Device) split(buf []byte) (uint8, []byte) {
+	if !d.Numbered() || len(buf) == 0 {
+		return 0, buf
+	}
+	return buf[0], buf[1:]
+}
+
+//-----------------------------------------------------------------------------
+
+// return the buffer size for input reports, a multiple of the packet size
+func (d *Device) input_size() int {
+	n := d.intf.In_Size
+	if n == 0 {
+		n = 64
+	}
+	if d.parsed != nil {
+		if m := d.parsed.Max_Report_Length(REPORT_TYPE_INPUT); m > n {
+			n = (m + n - 1) / n * n
+		}
+	}
+	return n
+}
+
+// Read reads an input report from the interrupt IN endpoint.
+// A zero timeout waits forever.
+func (d *Device) Read(timeout time.Duration) (uint8, []byte, error) {
+	buf, err := d.h.Interrupt(d.intf.In, make([]byte, d.input_size()), timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, data := d.split(buf)
+	return id, data, nil
+}
+
+// Write writes an output report to the interrupt OUT endpoint, or with a
+// SET_REPORT request if the interface has no OUT endpoint.
+func (d *Device) Write(id uint8, data []byte) error {
+	if err := d.check_id("Write", id); err != nil {
+		return err
+	}
+	if d.intf.Out == 0 {
+		return d.Set_Report(REPORT_TYPE_OUTPUT, id, data)
+	}
+	_, err := d.h.Interrupt(d.intf.Out, d.frame(id, data), d.Timeout)
+	return err
+}
+
+// Get_Report reads a report of up to n bytes, not counting the report ID,
+// with a GET_REPORT request.
+func (d *Device) Get_Report(t Report_Type, id uint8, n int) ([]byte, error) {
+	if err := d.check_id("Get_Report", id); err != nil {
+		return nil, err
+	}
+	if d.Numbered() {
+		n++
+	}
+	bmRequestType := uint8(libusb.ENDPOINT_IN | libusb.REQUEST_TYPE_CLASS | libusb.RECIPIENT_INTERFACE)
+	buf, err := d.h.Control(bmRequestType, GET_REPORT, uint16(t)<<8|uint16(id), uint16(d.intf.Number), make([]byte, n), d.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	_, data := d.split(buf)
+	return data, nil
+}
+
+// Set_Report writes a report with a SET_REPORT request.
+func (d *Device) Set_Report(t Report_Type, id uint8, data []byte) error {
+	if err := d.check_id("Set_Report", id); err != nil {
+		return err
+	}
+	bmRequestType := uint8(libusb.ENDPOINT_OUT | libusb.REQUEST_TYPE_CLASS | libusb.RECIPIENT_INTERFACE)
+	_, err := d.h.Control(bmRequestType, SET_REPORT, uint16(t)<<8|uint16(id), uint16(d.intf.Number), d.frame(id, data), d.Timeout)
+	return err
+}
+
+// Get_Feature reads a feature report of up to n bytes.
+func (d *Device) Get_Feature(id uint8, n int) ([]byte, error) {
+	return d.Get_Report(REPORT_TYPE_FEATURE, id, n)
+}
+
+// Set_Feature writes a feature report.
+func (d *Device) Set_Feature(id uint8, data []byte) error {
+	return d.Set_Report(REPORT_TYPE_FEATURE, id, data)
+}
+
+// Get_Idle returns the idle rate of an input report, 0 if it is only
+// reported on change.
+func (d *Device) Get_Idle(id uint8) (time.Duration, error) {
+	bmRequestType := uint8(libusb.ENDPOINT_IN | libusb.REQUEST_TYPE_CLASS | libusb.RECIPIENT_INTERFACE)
+	buf, err := d.h.Control(bmRequestType, GET_IDLE, uint16(id), uint16(d.intf.Number), make([]byte, 1), request_timeout)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 1 {
+		return 0, &libusb.Error{Code: libusb.ERROR_IO, Op: "Get_Idle", Endpoint: -1}
+	}
+	return time.Duration(buf[0]) * 4 * time.Millisecond, nil
+}
+
+// Set_Idle sets the idle rate of an input report, or of all input reports
+// for report ID 0. The rate has a resolution of 4ms and a maximum of 1020ms,
+// a zero rate reports only on change.
+func (d *Device) Set_Idle(rate time.Duration, id uint8) error {
+	n := rate / (4 * time.Millisecond)
+	if n < 0 || n > 255 {
+		return &libusb.Error{Code: libusb.ERROR_INVALID_PARAM, Op: "Set_Idle", Endpoint: -1}
+	}
+	bmRequestType := uint8(libusb.ENDPOINT_OUT | libusb.REQUEST_TYPE_CLASS | libusb.RECIPIENT_INTERFACE)
+	_, err := d.h.Control(bmRequestType, SET_IDLE, uint16(n)<<8|uint16(id), uint16(d.intf.Number), nil, request_timeout)
+	return err
+}
+
+// Get_Protocol returns the active protocol of a boot device.
+func (d *Device) Get_Protocol() (int, error) {
+	bmRequestType := uint8(libusb.ENDPOINT_IN | libusb.REQUEST_TYPE_CLASS | libusb.RECIPIENT_INTERFACE)
+	buf, err := d.h.Control(bmRequestType, GET_PROTOCOL, 0, uint16(d.intf.Number), make([]byte, 1), request_timeout)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) != 1 {
+		return 0, &libusb.Error{Code: libusb.ERROR_IO, Op: "Get_Protocol", Endpoint: -1}
+	}
+	return int(buf[0]), nil
+}
+
+// Set_Protocol selects the boot or report protocol of a boot device.
+func (d *Device) Set_Protocol(protocol int) error {
+	bmRequestType := uint8(libusb.ENDPOINT_OUT | libusb.REQUEST_TYPE_CLASS | libusb.RECIPIENT_INTERFACE)
+	_, err := d.h.Control(bmRequestType, SET_PROTOCOL, uint16(protocol), uint16(d.intf.Number), nil, request_timeout)
+	return err
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,275 @@
+//-----------------------------------------------------------------------------
+/*
+
+Test functions for the HID driver
+
+*/
+//-----------------------------------------------------------------------------
+
+package hid
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/usb"
+	"github.com/deadsy/libusb/usb/usbtest"
+)
+
+//-----------------------------------------------------------------------------
+
+// a vendor defined control panel with input report 1, output report 2 and feature report 3
+var panel_report = []byte{
+	0x06, 0x00, 0xff, // Usage Page (Vendor Defined 0xFF00)
+	0x09, 0x01, // Usage (0x01)
+	0xa1, 0x01, // Collection (Application)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x26, 0xff, 0x00, //   Logical Maximum (255)
+	0x75, 0x08, //   Report Size (8)
+	0x85, 0x01, //   Report ID (1)
+	0x95, 0x04, //   Report Count (4)
+	0x09, 0x02, //   Usage (0x02)
+	0x81, 0x02, //   Input (Data,Var,Abs)
+	0x85, 0x02, //   Report ID (2)
+	0x95, 0x02, //   Report Count (2)
+	0x09, 0x03, //   Usage (0x03)
+	0x91, 0x02, //   Output (Data,Var,Abs)
+	0x85, 0x03, //   Report ID (3)
+	0x95, 0x01, //   Report Count (1)
+	0x09, 0x04, //   Usage (0x04)
+	0xb1, 0x02, //   Feature (Data,Var,Abs)
+	0xc0, // End Collection
+}
+
+// return a control panel with a vendor interface and a HID interface
+func new_panel(out bool) (*usbtest.Device, *panel) {
+	p := &panel{feature: []byte{7}, idle: 0xff}
+	eps := []*libusb.Endpoint_Descriptor{
+		{BEndpointAddress: 0x83, BmAttributes: 0x03, WMaxPacketSize: 8, BInterval: 10},
+	}
+	if out {
+		eps = append(eps, &libusb.Endpoint_Descriptor{BEndpointAddress: 0x04, BmAttributes: 0x03, WMaxPacketSize: 8, BInterval: 10})
+	}
+	n := len(panel_report)
+	d := &usbtest.Device{
+		Descriptor: &libusb.Device_Descriptor{BcdUSB: 0x0200, IdVendor: 0x16c0, IdProduct: 0x05df},
+		Configs: []*libusb.Config_Descriptor{{
+			Interface: []*libusb.Interface{
+				{Altsetting: []*libusb.Interface_Descriptor{{
+					BInterfaceNumber: 0,
+					BInterfaceClass:  uint8(libusb.CLASS_VENDOR_SPEC),
+				}}},
+				{Altsetting: []*libusb.Interface_Descriptor{{
+					BInterfaceNumber: 1,
+					BInterfaceClass:  uint8(libusb.CLASS_HID),
+					Endpoint:         eps,
+					Extra:            []byte{0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22, uint8(n), uint8(n >> 8)},
+				}}},
+			},
+		}},
+		Control: p.control,
+	}
+	return d, p
+}
+
+// panel is the HID request handler of a control panel.
+type panel struct {
+	output   []byte // last output report
+	feature  []byte
+	idle     uint8
+	protocol uint8
+	requests []usbtest.Setup
+}
+
+func (p *panel) control(s *usbtest.Setup, data []byte) ([]byte, error) {
+	p.requests = append(p.requests, *s)
+	if s.Index != 1 {
+		return nil, libusb.ErrPipe
+	}
+	id := uint8(s.Value)
+	switch {
+	case s.RequestType == 0x81 && s.Request == libusb.REQUEST_GET_DESCRIPTOR && s.Value == libusb.DT_REPORT<<8:
+		return panel_report, nil
+	case s.RequestType == 0xa1 && s.Request == GET_REPORT && s.Value == uint16(REPORT_TYPE_FEATURE)<<8|3:
+		return append([]byte{id}, p.feature...), nil
+	case s.RequestType == 0xa1 && s.Request == GET_REPORT && s.Value == uint16(REPORT_TYPE_INPUT)<<8|1:
+		return []byte{id, 1, 2, 3, 4}, nil
+	case s.RequestType == 0x21 && s.Request == SET_REPORT && len(data) != 0 && data[0] == id:
+		if Report_Type(s.Value>>8) == REPORT_TYPE_FEATURE {
+			p.feature = append([]byte(nil), data[1:]...)
+		} else {
+			p.output = append([]byte(nil), data...)
+		}
+		return nil, nil
+	case s.RequestType == 0xa1 && s.Request == GET_IDLE:
+		return []byte{p.idle}, nil
+	case s.RequestType == 0x21 && s.Request == SET_IDLE:
+		p.idle = uint8(s.Value >> 8)
+		return nil, nil
+	case s.RequestType == 0xa1 && s.Request == GET_PROTOCOL:
+		return []byte{p.protocol}, nil
+	case s.RequestType == 0x21 && s.Request == SET_PROTOCOL:
+		p.protocol = uint8(s.Value)
+		return nil, nil
+	}
+	return nil, libusb.ErrPipe
+}
+
+// open the HID interface of a control panel
+func open_panel(t *testing.T, d *usbtest.Device) (*Device, func()) {
+	b := usbtest.NewBackend()
+	b.Add(d)
+	ctx := usb.NewContextWithBackend(b)
+	h, err := ctx.OpenDeviceWithVIDPID(0x16c0, 0x05df)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := Find(h.Device())
+	if err != nil || len(list) != 1 {
+		t.Fatal("FAIL", err)
+	}
+	hd, err := Open(h, list[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hd, func() {
+		hd.Close()
+		h.Close()
+		ctx.Close()
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Find(t *testing.T) {
+	d, _ := new_panel(true)
+	hd, done := open_panel(t, d)
+	defer done()
+	intf := hd.Interface()
+	if intf.Number != 1 || intf.In != 0x83 || intf.In_Size != 8 || intf.Out != 0x04 || intf.Out_Size != 8 {
+		t.Error("FAIL")
+	}
+	if intf.Descriptor == nil || intf.Descriptor.BcdHID != 0x0111 {
+		t.Error("FAIL")
+	}
+	if !bytes.Equal(hd.Raw_Report_Descriptor(), panel_report) {
+		t.Error("FAIL")
+	}
+	if !bytes.Equal(hd.Report_IDs(), []byte{1, 2, 3}) || !hd.Numbered() {
+		t.Error("FAIL")
+	}
+	if hd.Report() == nil || hd.Report().Report_Length(REPORT_TYPE_FEATURE, 3) != 1 || hd.input_size() != 8 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Reports(t *testing.T) {
+	d, p := new_panel(true)
+	hd, done := open_panel(t, d)
+	defer done()
+
+	// input reports
+	d.Queue(0x83, usbtest.Response{Data: []byte{1, 10, 20, 30, 40}})
+	id, data, err := hd.Read(time.Second)
+	if err != nil || id != 1 || !bytes.Equal(data, []byte{10, 20, 30, 40}) {
+		t.Error("FAIL")
+	}
+	_, _, err = hd.Read(time.Millisecond)
+	if !errors.Is(err, libusb.ErrTimeout) {
+		t.Error("FAIL")
+	}
+	data, err = hd.Get_Report(REPORT_TYPE_INPUT, 1, 4)
+	if err != nil || !bytes.Equal(data, []byte{1, 2, 3, 4}) {
+		t.Error("FAIL")
+	}
+
+	// output reports on the interrupt endpoint
+	err = hd.Write(2, []byte{0xaa, 0x55})
+	if err != nil {
+		t.Error("FAIL")
+	}
+	x := d.Transfers()
+	if len(x) == 0 || x[len(x)-1].Endpoint != 0x04 || !bytes.Equal(x[len(x)-1].Data, []byte{2, 0xaa, 0x55}) {
+		t.Error("FAIL")
+	}
+	if !errors.Is(hd.Write(0, []byte{1}), libusb.ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+
+	// feature reports
+	data, err = hd.Get_Feature(3, 1)
+	if err != nil || !bytes.Equal(data, []byte{7}) {
+		t.Error("FAIL")
+	}
+	err = hd.Set_Feature(3, []byte{9})
+	if err != nil || !bytes.Equal(p.feature, []byte{9}) {
+		t.Error("FAIL")
+	}
+	_, err = hd.Get_Feature(4, 1)
+	if !errors.Is(err, libusb.ErrPipe) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_SetReport(t *testing.T) {
+	// output reports without an interrupt OUT endpoint
+	d, p := new_panel(false)
+	hd, done := open_panel(t, d)
+	defer done()
+	if hd.Interface().Out != 0 {
+		t.Error("FAIL")
+	}
+	err := hd.Write(2, []byte{0xaa, 0x55})
+	if err != nil || !bytes.Equal(p.output, []byte{2, 0xaa, 0x55}) {
+		t.Error("FAIL")
+	}
+	s := p.requests[len(p.requests)-1]
+	if s.Value != uint16(REPORT_TYPE_OUTPUT)<<8|2 || s.Length != 3 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Idle(t *testing.T) {
+	d, p := new_panel(true)
+	hd, done := open_panel(t, d)
+	defer done()
+	err := hd.Set_Idle(500*time.Millisecond, 0)
+	if err != nil || p.idle != 125 {
+		t.Error("FAIL")
+	}
+	rate, err := hd.Get_Idle(0)
+	if err != nil || rate != 500*time.Millisecond {
+		t.Error("FAIL")
+	}
+	if !errors.Is(hd.Set_Idle(2*time.Second, 0), libusb.ErrInvalidParam) {
+		t.Error("FAIL")
+	}
+	err = hd.Set_Protocol(PROTOCOL_REPORT)
+	if err != nil || p.protocol != PROTOCOL_REPORT {
+		t.Error("FAIL")
+	}
+	protocol, err := hd.Get_Protocol()
+	if err != nil || protocol != PROTOCOL_REPORT {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Report_IDs(t *testing.T) {
+	// unnumbered reports, a long item and a truncated item
+	desc := []byte{0x05, 0x01, 0xfe, 0x02, 0x10, 0x85, 0x07, 0x75, 0x08, 0x85}
+	if report_ids(desc) != nil {
+		t.Error("FAIL")
+	}
+	if !bytes.Equal(report_ids([]byte{0x85, 0x02, 0x85, 0x01, 0x85, 0x02}), []byte{2, 1}) {
+		t.Error("FAIL")
+	}
+	// report ID 0 is reserved and doesn't number the reports
+	if report_ids([]byte{0x85, 0x00, 0x75, 0x08}) != nil {
+		t.Error("FAIL")
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,882 @@
+//-----------------------------------------------------------------------------
+/*
+
+HID report descriptors
+
+A report descriptor is a list of items. Main items (Input, Output, Feature)
+declare the fields of the reports, global items (Usage Page, Logical
+Minimum, Report Size, Report ID, ...) set state that applies to all the
+following main items and can be saved with Push and restored with Pop,
+local items (Usage, Usage Minimum, ...) apply only to the next main item.
+Collections group main items.
+
+Parse_Report_Descriptor returns the fields of each report with their bit
+offset, size, count, ranges and usages. A report can be decoded to a map of
+usage values and encoded from one. Variable fields have a value per usage,
+array fields report the usages that are active with a value of 1. When the
+elements of a field outnumber its usages the last usage is shared, e.g. a
+buffer of vendor bytes, Decode_Elements and Encode_Elements keep a value per
+element and Field.Value reads a single element.
+
+The data of a report does not include the report ID byte. Field values are
+int32, a descriptor with a Report Size over 32 bits or a report longer than
+64 KiB is rejected.
+
+See the Device Class Definition for HID 1.11, section 6.2.2.
+
+*/
+//-----------------------------------------------------------------------------
+
+package hid
+
+import (
+	"fmt"
+)
+
+//-----------------------------------------------------------------------------
+// items
+
+// Item_Type is the type of a report descriptor item.
+type Item_Type uint8
+
+// Item types
+const (
+	ITEM_MAIN   Item_Type = 0
+	ITEM_GLOBAL Item_Type = 1
+	ITEM_LOCAL  Item_Type = 2
+	ITEM_LONG   Item_Type = 3
+)
+
+func (t Item_Type) String() string {
+	switch t {
+	case ITEM_MAIN:
+		return "main"
+	case ITEM_GLOBAL:
+		return "global"
+	case ITEM_LOCAL:
+		return "local"
+	case ITEM_LONG:
+		return "long"
+	}
+	return "unknown"
+}
+
+// Main item tags
+const (
+	TAG_INPUT          = 0x8
+	TAG_OUTPUT         = 0x9
+	TAG_COLLECTION     = 0xa
+	TAG_FEATURE        = 0xb
+	TAG_END_COLLECTION = 0xc
+)
+
+// Global item tags
+const (
+	TAG_USAGE_PAGE       = 0x0
+	TAG_LOGICAL_MINIMUM  = 0x1
+	TAG_LOGICAL_MAXIMUM  = 0x2
+	TAG_PHYSICAL_MINIMUM = 0x3
+	TAG_PHYSICAL_MAXIMUM = 0x4
+	TAG_UNIT_EXPONENT    = 0x5
+	TAG_UNIT             = 0x6
+	TAG_REPORT_SIZE      = 0x7
+	TAG_REPORT_ID        = 0x8
+	TAG_REPORT_COUNT     = 0x9
+	TAG_PUSH             = 0xa
+	TAG_POP              = 0xb
+)
+
+// Local item tags
+const (
+	TAG_USAGE              = 0x0
+	TAG_USAGE_MINIMUM      = 0x1
+	TAG_USAGE_MAXIMUM      = 0x2
+	TAG_DESIGNATOR_INDEX   = 0x3
+	TAG_DESIGNATOR_MINIMUM = 0x4
+	TAG_DESIGNATOR_MAXIMUM = 0x5
+	TAG_STRING_INDEX       = 0x7
+	TAG_STRING_MINIMUM     = 0x8
+	TAG_STRING_MAXIMUM     = 0x9
+	TAG_DELIMITER          = 0xa
+)
+
+// Flags of Input, Output and Feature items
+const (
+	FLAG_CONSTANT       = 1 << 0 // else data
+	FLAG_VARIABLE       = 1 << 1 // else array
+	FLAG_RELATIVE       = 1 << 2 // else absolute
+	FLAG_WRAP           = 1 << 3
+	FLAG_NONLINEAR      = 1 << 4
+	FLAG_NO_PREFERRED   = 1 << 5
+	FLAG_NULL_STATE     = 1 << 6
+	FLAG_VOLATILE       = 1 << 7
+	FLAG_BUFFERED_BYTES = 1 << 8 // else bit field
+)
+
+// Collection types
+const (
+	COLLECTION_PHYSICAL       = 0x00
+	COLLECTION_APPLICATION    = 0x01
+	COLLECTION_LOGICAL        = 0x02
+	COLLECTION_REPORT         = 0x03
+	COLLECTION_NAMED_ARRAY    = 0x04
+	COLLECTION_USAGE_SWITCH   = 0x05
+	COLLECTION_USAGE_MODIFIER = 0x06
+)
+
+// Item is a report descriptor item.
+type Item struct {
+	Type Item_Type
+	Tag  uint8
+	Data []byte
+}
+
+// Unsigned returns the item data as an unsigned value.
+func (x *Item) Unsigned() uint32 {
+	var v uint32
+	for i, b := range x.Data {
+		v |= uint32(b) << (8 * uint(i))
+	}
+	return v
+}
+
+// Signed returns the item data as a two's complement value.
+func (x *Item) Signed() int32 {
+	switch len(x.Data) {
+	case 1:
+		return int32(int8(x.Data[0]))
+	case 2:
+		return int32(int16(x.Unsigned()))
+	}
+	return int32(x.Unsigned())
+}
+
+func (x *Item) String() string {
+	return fmt.Sprintf("%s 0x%x % x", x.Type, x.Tag, x.Data)
+}
+
+// Parse_Items returns the items of a report descriptor.
+func Parse_Items(desc []byte) ([]Item, error) {
+	var items []Item
+	for i := 0; i < len(desc); {
+		prefix := desc[i]
+		if prefix == 0xfe {
+			// long item: prefix, size, tag, data
+			if i+3 > len(desc) {
+				return nil, fmt.Errorf("hid: truncated long item at offset %d", i)
+			}
+			n := int(desc[i+1])
+			if i+3+n > len(desc) {
+				return nil, fmt.Errorf("hid: truncated long item at offset %d", i)
+			}
+			items = append(items, Item{Type: ITEM_LONG, Tag: desc[i+2], Data: desc[i+3 : i+3+n]})
+			i += 3 + n
+			continue
+		}
+		n := int(prefix & 3)
+		if n == 3 {
+			n = 4
+		}
+		if i+1+n > len(desc) {
+			return nil, fmt.Errorf("hid: truncated item at offset %d", i)
+		}
+		items = append(items, Item{Type: Item_Type((prefix >> 2) & 3), Tag: prefix >> 4, Data: desc[i+1 : i+1+n]})
+		i += 1 + n
+	}
+	return items, nil
+}
+
+// return the report IDs declared by the items of a report descriptor that
+// can't be parsed, nil if the items can't be read
+func report_ids(desc []byte) []uint8 {
+	items, err := Parse_Items(desc)
+	if err != nil {
+		return nil
+	}
+	var ids []uint8
+	seen := make(map[uint8]bool)
+	for i := range items {
+		x := &items[i]
+		if x.Type != ITEM_GLOBAL || x.Tag != TAG_REPORT_ID {
+			continue
+		}
+		// as for the parser, report ID 0 is reserved
+		id := x.Unsigned()
+		if id == 0 || id > 255 || seen[uint8(id)] {
+			continue
+		}
+		seen[uint8(id)] = true
+		ids = append(ids, uint8(id))
+	}
+	return ids
+}
+
+//-----------------------------------------------------------------------------
+// usages
+
+// Usage is a usage page in the upper 16 bits and a usage ID in the lower 16 bits.
+type Usage uint32
+
+// Usage pages
+const (
+	PAGE_GENERIC_DESKTOP = 0x01
+	PAGE_SIMULATION      = 0x02
+	PAGE_KEYBOARD        = 0x07
+	PAGE_LED             = 0x08
+	PAGE_BUTTON          = 0x09
+	PAGE_ORDINAL         = 0x0a
+	PAGE_CONSUMER        = 0x0c
+	PAGE_DIGITIZER       = 0x0d
+	PAGE_VENDOR          = 0xff00 // start of the vendor defined pages
+)
+
+// New_Usage returns the usage for a usage page and ID.
+func New_Usage(page, id uint16) Usage {
+	return Usage(uint32(page)<<16 | uint32(id))
+}
+
+// Page returns the usage page.
+func (u Usage) Page() uint16 {
+	return uint16(u >> 16)
+}
+
+// ID returns the usage ID.
+func (u Usage) ID() uint16 {
+	return uint16(u)
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("%04x:%04x", u.Page(), u.ID())
+}
+
+//-----------------------------------------------------------------------------
+// fields
+
+// Collection is a collection of main items.
+type Collection struct {
+	Type   uint8 // COLLECTION_*
+	Usage  Usage
+	Parent *Collection // nil for a top level collection
+}
+
+// Field is a field of a report declared by an Input, Output or Feature item.
+type Field struct {
+	Report_Type      Report_Type
+	Report_ID        uint8 // 0 for unnumbered reports
+	Flags            uint32
+	Bit_Offset       int // from the start of the report data, after the report ID
+	Bit_Size         int // size of each element
+	Count            int // number of elements
+	Logical_Minimum  int32
+	Logical_Maximum  int32
+	Physical_Minimum int32
+	Physical_Maximum int32
+	Unit             uint32
+	Unit_Exponent    int
+	// Usages holds the usage of each element of a variable field, the last
+	// usage applies to any further elements. For an array field it holds the
+	// usage for each value from Logical_Minimum.
+	Usages     []Usage
+	Collection *Collection // innermost collection, nil if none
+}
+
+// Constant returns true for a constant field, e.g. padding.
+func (f *Field) Constant() bool {
+	return f.Flags&FLAG_CONSTANT != 0
+}
+
+// Variable returns true for a field with a value per element, false for an array.
+func (f *Field) Variable() bool {
+	return f.Flags&FLAG_VARIABLE != 0
+}
+
+// Relative returns true for a field with values relative to the last report.
+func (f *Field) Relative() bool {
+	return f.Flags&FLAG_RELATIVE != 0
+}
+
+// return an error if an element is not in the field or the data
+func (f *Field) check(data []byte, i int) error {
+	if i < 0 || i >= f.Count {
+		return fmt.Errorf("hid: element %d is outside a field of %d", i, f.Count)
+	}
+	if f.Bit_Size == 0 || f.Bit_Size > 32 {
+		return fmt.Errorf("hid: can't access %d bit elements", f.Bit_Size)
+	}
+	if f.Bit_Offset+(i+1)*f.Bit_Size > len(data)*8 {
+		return fmt.Errorf("hid: element %d is outside %d bytes of report data", i, len(data))
+	}
+	return nil
+}
+
+// Value returns element i of the field in report data.
+func (f *Field) Value(data []byte, i int) (int32, error) {
+	err := f.check(data, i)
+	if err != nil {
+		return 0, err
+	}
+	return get_bits(data, f.Bit_Offset+i*f.Bit_Size, f.Bit_Size, f.signed()), nil
+}
+
+// Set_Value sets element i of the field in report data.
+// The value must be in the logical range of the field.
+func (f *Field) Set_Value(data []byte, i int, v int32) error {
+	err := f.check(data, i)
+	if err != nil {
+		return err
+	}
+	if f.Logical_Minimum < f.Logical_Maximum && (v < f.Logical_Minimum || v > f.Logical_Maximum) {
+		return fmt.Errorf("hid: usage %s value %d is outside %d..%d", f.Usage(i), v, f.Logical_Minimum, f.Logical_Maximum)
+	}
+	set_bits(data, f.Bit_Offset+i*f.Bit_Size, f.Bit_Size, v)
+	return nil
+}
+
+// Usage returns the usage of an element of a variable field.
+func (f *Field) Usage(i int) Usage {
+	if len(f.Usages) == 0 {
+		return 0
+	}
+	if i >= len(f.Usages) {
+		i = len(f.Usages) - 1
+	}
+	return f.Usages[i]
+}
+
+// return the value for an element with no value given, 0 or the logical
+// value closest to it
+func (f *Field) zero() int32 {
+	if f.Logical_Minimum < f.Logical_Maximum {
+		if f.Logical_Minimum > 0 {
+			return f.Logical_Minimum
+		}
+		if f.Logical_Maximum < 0 {
+			return f.Logical_Maximum
+		}
+	}
+	return 0
+}
+
+// signed returns true if the field values are signed
+func (f *Field) signed() bool {
+	return f.Logical_Minimum < 0
+}
+
+func (f *Field) String() string {
+	kind := "array"
+	if f.Constant() {
+		kind = "constant"
+	} else if f.Variable() {
+		kind = "variable"
+	}
+	return fmt.Sprintf("%s report %d: bit %d size %d count %d %s logical %d..%d usages %v",
+		f.Report_Type, f.Report_ID, f.Bit_Offset, f.Bit_Size, f.Count, kind, f.Logical_Minimum, f.Logical_Maximum, f.Usages)
+}
+
+//-----------------------------------------------------------------------------
+// parser
+
+// global item state
+type global_state struct {
+	usage_page       uint16
+	logical_minimum  Item
+	logical_maximum  Item
+	physical_minimum Item
+	physical_maximum Item
+	unit_exponent    int
+	unit             uint32
+	report_size      int
+	report_id        uint8
+	report_count     int
+}
+
+// a local usage, with the usage page if it was given
+type local_usage struct {
+	usage    uint32
+	extended bool // the usage page is in the upper 16 bits
+}
+
+// local item state
+type local_state struct {
+	usages        []local_usage
+	usage_minimum *local_usage
+	delimiter     int // delimiter depth, only the first usage of a set is used
+	in_set        bool
+}
+
+// maximum number of usages from a usage range
+const max_usage_range = 1 << 16
+
+// maximum size of a field element and of a report, in bits
+const (
+	max_report_size = 32
+	max_report_bits = 64 * 1024 * 8
+)
+
+// return the usage for the usage page in effect
+func (u local_usage) resolve(page uint16) Usage {
+	if u.extended {
+		return Usage(u.usage)
+	}
+	return New_Usage(page, uint16(u.usage))
+}
+
+// report key
+type report_key struct {
+	t  Report_Type
+	id uint8
+}
+
+// Report_Descriptor is a parsed report descriptor.
+type Report_Descriptor struct {
+	Fields      []*Field
+	Collections []*Collection
+	ids         []uint8
+	bits        map[report_key]int // report length in bits
+}
+
+// return the value of a range item, a maximum is unsigned if the minimum is not negative
+func range_value(min, max *Item) (int32, int32) {
+	lo, hi := min.Signed(), max.Signed()
+	if lo >= 0 && hi < lo {
+		hi = int32(max.Unsigned())
+	}
+	return lo, hi
+}
+
+// Parse_Report_Descriptor parses a report descriptor.
+func Parse_Report_Descriptor(desc []byte) (*Report_Descriptor, error) {
+	items, err := Parse_Items(desc)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report_Descriptor{bits: make(map[report_key]int)}
+	var g global_state
+	var stack []global_state
+	var l local_state
+	var collection *Collection
+	seen := make(map[uint8]bool)
+
+	for i := range items {
+		x := &items[i]
+		switch x.Type {
+		case ITEM_MAIN:
+			usages, err := l.resolve(g.usage_page)
+			if err != nil {
+				return nil, err
+			}
+			switch x.Tag {
+			case TAG_INPUT:
+				err = r.add_field(REPORT_TYPE_INPUT, x.Unsigned(), &g, usages, collection)
+			case TAG_OUTPUT:
+				err = r.add_field(REPORT_TYPE_OUTPUT, x.Unsigned(), &g, usages, collection)
+			case TAG_FEATURE:
+				err = r.add_field(REPORT_TYPE_FEATURE, x.Unsigned(), &g, usages, collection)
+			case TAG_COLLECTION:
+				c := &Collection{Type: uint8(x.Unsigned()), Parent: collection}
+				if len(usages) != 0 {
+					c.Usage = usages[0]
+				}
+				r.Collections = append(r.Collections, c)
+				collection = c
+			case TAG_END_COLLECTION:
+				if collection == nil {
+					return nil, fmt.Errorf("hid: end collection without a collection")
+				}
+				collection = collection.Parent
+			default:
+				return nil, fmt.Errorf("hid: unknown main item tag 0x%x", x.Tag)
+			}
+			if err != nil {
+				return nil, err
+			}
+			l = local_state{}
+
+		case ITEM_GLOBAL:
+			switch x.Tag {
+			case TAG_USAGE_PAGE:
+				g.usage_page = uint16(x.Unsigned())
+			case TAG_LOGICAL_MINIMUM:
+				g.logical_minimum = *x
+			case TAG_LOGICAL_MAXIMUM:
+				g.logical_maximum = *x
+			case TAG_PHYSICAL_MINIMUM:
+				g.physical_minimum = *x
+			case TAG_PHYSICAL_MAXIMUM:
+				g.physical_maximum = *x
+			case TAG_UNIT_EXPONENT:
+				// a 4 bit two's complement value
+				g.unit_exponent = int(x.Unsigned() & 0xf)
+				if g.unit_exponent > 7 {
+					g.unit_exponent -= 16
+				}
+			case TAG_UNIT:
+				g.unit = x.Unsigned()
+			case TAG_REPORT_SIZE:
+				if x.Unsigned() > max_report_size {
+					return nil, fmt.Errorf("hid: report size %d is over %d bits", x.Unsigned(), max_report_size)
+				}
+				g.report_size = int(x.Unsigned())
+			case TAG_REPORT_ID:
+				id := x.Unsigned()
+				if id == 0 || id > 255 {
+					return nil, fmt.Errorf("hid: invalid report ID %d", id)
+				}
+				g.report_id = uint8(id)
+				if !seen[g.report_id] {
+					seen[g.report_id] = true
+					r.ids = append(r.ids, g.report_id)
+				}
+			case TAG_REPORT_COUNT:
+				if x.Unsigned() > max_report_bits {
+					return nil, fmt.Errorf("hid: report count %d is too large", x.Unsigned())
+				}
+				g.report_count = int(x.Unsigned())
+			case TAG_PUSH:
+				stack = append(stack, g)
+			case TAG_POP:
+				if len(stack) == 0 {
+					return nil, fmt.Errorf("hid: pop without a push")
+				}
+				g = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			default:
+				return nil, fmt.Errorf("hid: unknown global item tag 0x%x", x.Tag)
+			}
+
+		case ITEM_LOCAL:
+			err := l.add(x)
+			if err != nil {
+				return nil, err
+			}
+
+		case ITEM_LONG:
+			// no long items are defined
+		}
+	}
+	if collection != nil {
+		return nil, fmt.Errorf("hid: unterminated collection")
+	}
+	if len(r.ids) != 0 {
+		// reports without a report ID are not allowed with numbered reports
+		for k := range r.bits {
+			if k.id == 0 {
+				return nil, fmt.Errorf("hid: %s report without a report ID", k.t)
+			}
+		}
+	}
+	return r, nil
+}
+
+// add a local item
+func (l *local_state) add(x *Item) error {
+	u := local_usage{usage: x.Unsigned(), extended: len(x.Data) == 4}
+	switch x.Tag {
+	case TAG_USAGE:
+		if l.delimiter > 0 {
+			// alternative usages of a delimited set, use the first
+			if l.in_set {
+				return nil
+			}
+			l.in_set = true
+		}
+		l.usages = append(l.usages, u)
+	case TAG_USAGE_MINIMUM:
+		l.usage_minimum = &u
+	case TAG_USAGE_MAXIMUM:
+		if l.usage_minimum == nil {
+			return fmt.Errorf("hid: usage maximum without a usage minimum")
+		}
+		min := *l.usage_minimum
+		l.usage_minimum = nil
+		if u.extended != min.extended || u.usage>>16 != min.usage>>16 {
+			return fmt.Errorf("hid: usage range with different usage pages")
+		}
+		if u.usage < min.usage || u.usage-min.usage >= max_usage_range {
+			return fmt.Errorf("hid: invalid usage range 0x%x..0x%x", min.usage, u.usage)
+		}
+		for v := min.usage; v <= u.usage; v++ {
+			l.usages = append(l.usages, local_usage{usage: v, extended: min.extended})
+		}
+	case TAG_DELIMITER:
+		switch x.Unsigned() {
+		case 1:
+			l.delimiter++
+			l.in_set = false
+		case 0:
+			if l.delimiter == 0 {
+				return fmt.Errorf("hid: close delimiter without an open delimiter")
+			}
+			l.delimiter--
+		}
+	case TAG_DESIGNATOR_INDEX, TAG_DESIGNATOR_MINIMUM, TAG_DESIGNATOR_MAXIMUM,
+		TAG_STRING_INDEX, TAG_STRING_MINIMUM, TAG_STRING_MAXIMUM:
+		// physical descriptors and strings are not used
+	default:
+		return fmt.Errorf("hid: unknown local item tag 0x%x", x.Tag)
+	}
+	return nil
+}
+
+// return the usages for the usage page in effect
+func (l *local_state) resolve(page uint16) ([]Usage, error) {
+	if l.usage_minimum != nil {
+		return nil, fmt.Errorf("hid: usage minimum without a usage maximum")
+	}
+	if l.delimiter != 0 {
+		return nil, fmt.Errorf("hid: unterminated delimiter")
+	}
+	usages := make([]Usage, len(l.usages))
+	for i, u := range l.usages {
+		usages[i] = u.resolve(page)
+	}
+	return usages, nil
+}
+
+// add a field for an Input, Output or Feature item
+func (r *Report_Descriptor) add_field(t Report_Type, flags uint32, g *global_state, usages []Usage, c *Collection) error {
+	k := report_key{t, g.report_id}
+	if r.bits[k]+g.report_size*g.report_count > max_report_bits {
+		return fmt.Errorf("hid: %s report %d is over %d bytes", t, g.report_id, max_report_bits/8)
+	}
+	f := &Field{
+		Report_Type:   t,
+		Report_ID:     g.report_id,
+		Flags:         flags,
+		Bit_Offset:    r.bits[k],
+		Bit_Size:      g.report_size,
+		Count:         g.report_count,
+		Unit:          g.unit,
+		Unit_Exponent: g.unit_exponent,
+		Usages:        usages,
+		Collection:    c,
+	}
+	f.Logical_Minimum, f.Logical_Maximum = range_value(&g.logical_minimum, &g.logical_maximum)
+	f.Physical_Minimum, f.Physical_Maximum = range_value(&g.physical_minimum, &g.physical_maximum)
+	if f.Physical_Minimum == 0 && f.Physical_Maximum == 0 {
+		// the physical range is the logical range if not given
+		f.Physical_Minimum, f.Physical_Maximum = f.Logical_Minimum, f.Logical_Maximum
+	}
+	r.bits[k] += f.Bit_Size * f.Count
+	r.Fields = append(r.Fields, f)
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Report_IDs returns the report IDs, nil if the reports are not numbered.
+func (r *Report_Descriptor) Report_IDs() []uint8 {
+	return r.ids
+}
+
+// Report_Length returns the length in bytes of a report, not counting the report ID.
+func (r *Report_Descriptor) Report_Length(t Report_Type, id uint8) int {
+	return (r.bits[report_key{t, id}] + 7) / 8
+}
+
+// Max_Report_Length returns the length in bytes of the longest report of a
+// type, counting the report ID of numbered reports.
+func (r *Report_Descriptor) Max_Report_Length(t Report_Type) int {
+	n := 0
+	for k := range r.bits {
+		if k.t == t && r.Report_Length(t, k.id) > n {
+			n = r.Report_Length(t, k.id)
+		}
+	}
+	if n != 0 && len(r.ids) != 0 {
+		n++
+	}
+	return n
+}
+
+// Report_Fields returns the fields of a report.
+func (r *Report_Descriptor) Report_Fields(t Report_Type, id uint8) []*Field {
+	var fields []*Field
+	for _, f := range r.Fields {
+		if f.Report_Type == t && f.Report_ID == id {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// return an error if a report is not declared or data is too short
+func (r *Report_Descriptor) check(t Report_Type, id uint8, data []byte) error {
+	bits, ok := r.bits[report_key{t, id}]
+	if !ok {
+		return fmt.Errorf("hid: no %s report %d", t, id)
+	}
+	if len(data)*8 < bits {
+		return fmt.Errorf("hid: %s report %d is %d bytes, need %d", t, id, len(data), (bits+7)/8)
+	}
+	return nil
+}
+
+// return true if the values of a field are decoded
+func (f *Field) decoded() bool {
+	return !f.Constant() && f.Bit_Size != 0 && f.Bit_Size <= 32 && len(f.Usages) != 0
+}
+
+// call fn with the usage and value of each element of a report
+func (r *Report_Descriptor) decode(t Report_Type, id uint8, data []byte, fn func(u Usage, v int32)) error {
+	err := r.check(t, id, data)
+	if err != nil {
+		return err
+	}
+	for _, f := range r.Report_Fields(t, id) {
+		if !f.decoded() {
+			continue
+		}
+		for i := 0; i < f.Count; i++ {
+			v := get_bits(data, f.Bit_Offset+i*f.Bit_Size, f.Bit_Size, f.signed())
+			if f.Variable() {
+				fn(f.Usage(i), v)
+				continue
+			}
+			// an array element is the index of an active usage
+			if v < f.Logical_Minimum || v > f.Logical_Maximum {
+				continue
+			}
+			k := int(v - f.Logical_Minimum)
+			if k < len(f.Usages) && f.Usages[k].ID() != 0 {
+				fn(f.Usages[k], 1)
+			}
+		}
+	}
+	return nil
+}
+
+// Decode returns the usage values of a report. Elements that share a usage
+// have the value of the last element, see Decode_Elements.
+func (r *Report_Descriptor) Decode(t Report_Type, id uint8, data []byte) (map[Usage]int32, error) {
+	values := make(map[Usage]int32)
+	err := r.decode(t, id, data, func(u Usage, v int32) {
+		values[u] = v
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Decode_Elements returns the values of a report with a value for each
+// element of a usage, in report order. An active usage of an array field
+// has a value of 1.
+func (r *Report_Descriptor) Decode_Elements(t Report_Type, id uint8, data []byte) (map[Usage][]int32, error) {
+	values := make(map[Usage][]int32)
+	err := r.decode(t, id, data, func(u Usage, v int32) {
+		values[u] = append(values[u], v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// return a report with the values of variable field elements from value and
+// the usages of array fields that are active, value returns false for a
+// missing element
+func (r *Report_Descriptor) encode(t Report_Type, id uint8, value func(u Usage) (int32, bool), active func(u Usage) bool) ([]byte, error) {
+	data := make([]byte, r.Report_Length(t, id))
+	err := r.check(t, id, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range r.Report_Fields(t, id) {
+		if !f.decoded() {
+			continue
+		}
+		if f.Variable() {
+			for i := 0; i < f.Count; i++ {
+				v, ok := value(f.Usage(i))
+				if !ok {
+					v = f.zero()
+				}
+				err := f.Set_Value(data, i, v)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		i := 0
+		for k, u := range f.Usages {
+			if !active(u) || u.ID() == 0 {
+				continue
+			}
+			if i == f.Count {
+				return nil, fmt.Errorf("hid: more than %d active usages in an array", f.Count)
+			}
+			set_bits(data, f.Bit_Offset+i*f.Bit_Size, f.Bit_Size, f.Logical_Minimum+int32(k))
+			i++
+		}
+	}
+	return data, nil
+}
+
+// Encode returns a report with the usage values. Usages not in the map
+// are 0, or the logical value closest to 0 if 0 is outside the range of the
+// field. An array field holds the usages with non-zero values. Elements
+// that share a usage all have its value, see Encode_Elements.
+func (r *Report_Descriptor) Encode(t Report_Type, id uint8, values map[Usage]int32) ([]byte, error) {
+	value := func(u Usage) (int32, bool) {
+		v, ok := values[u]
+		return v, ok
+	}
+	active := func(u Usage) bool {
+		return values[u] != 0
+	}
+	return r.encode(t, id, value, active)
+}
+
+// Encode_Elements returns a report with a value for each element of a usage,
+// as returned by Decode_Elements. Missing elements are set as for Encode, an
+// array field holds the usages with a non-zero value.
+func (r *Report_Descriptor) Encode_Elements(t Report_Type, id uint8, values map[Usage][]int32) ([]byte, error) {
+	next := make(map[Usage]int)
+	value := func(u Usage) (int32, bool) {
+		i := next[u]
+		next[u]++
+		if i < len(values[u]) {
+			return values[u][i], true
+		}
+		return 0, false
+	}
+	active := func(u Usage) bool {
+		for _, v := range values[u] {
+			if v != 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return r.encode(t, id, value, active)
+}
+
+// return a little endian bit field
+func get_bits(data []byte, offset, size int, signed bool) int32 {
+	var v uint32
+	for i := 0; i < size; i++ {
+		bit := offset + i
+		v |= uint32(data[bit/8]>>(uint(bit)%8)&1) << uint(i)
+	}
+	if signed && size < 32 && v&(1<<uint(size-1)) != 0 {
+		v |= ^uint32(0) << uint(size)
+	}
+	return int32(v)
+}
+
+// set a little endian bit field
+func set_bits(data []byte, offset, size int, v int32) {
+	for i := 0; i < size; i++ {
+		bit := offset + i
+		mask := byte(1) << (uint(bit) % 8)
+		if uint32(v)>>uint(i)&1 != 0 {
+			data[bit/8] |= mask
+		} else {
+			data[bit/8] &^= mask
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
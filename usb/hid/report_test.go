@@ -0,0 +1,400 @@
+//-----------------------------------------------------------------------------
+/*
+
+Test functions for report descriptors
+
+*/
+//-----------------------------------------------------------------------------
+
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+
+// boot keyboard, HID 1.11 appendix B.1
+var keyboard_report = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x06, // Usage (Keyboard)
+	0xa1, 0x01, // Collection (Application)
+	0x05, 0x07, //   Usage Page (Keyboard)
+	0x19, 0xe0, //   Usage Minimum (Left Control)
+	0x29, 0xe7, //   Usage Maximum (Right GUI)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x75, 0x01, //   Report Size (1)
+	0x95, 0x08, //   Report Count (8)
+	0x81, 0x02, //   Input (Data,Var,Abs)
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x08, //   Report Size (8)
+	0x81, 0x01, //   Input (Const)
+	0x95, 0x05, //   Report Count (5)
+	0x75, 0x01, //   Report Size (1)
+	0x05, 0x08, //   Usage Page (LED)
+	0x19, 0x01, //   Usage Minimum (Num Lock)
+	0x29, 0x05, //   Usage Maximum (Kana)
+	0x91, 0x02, //   Output (Data,Var,Abs)
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x03, //   Report Size (3)
+	0x91, 0x01, //   Output (Const)
+	0x95, 0x06, //   Report Count (6)
+	0x75, 0x08, //   Report Size (8)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x65, //   Logical Maximum (101)
+	0x05, 0x07, //   Usage Page (Keyboard)
+	0x19, 0x00, //   Usage Minimum (0)
+	0x29, 0x65, //   Usage Maximum (101)
+	0x81, 0x00, //   Input (Data,Array,Abs)
+	0xc0, // End Collection
+}
+
+// mouse with signed relative axes in a pushed global state
+var mouse_report = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x02, // Usage (Mouse)
+	0xa1, 0x01, // Collection (Application)
+	0x09, 0x01, //   Usage (Pointer)
+	0xa1, 0x00, //   Collection (Physical)
+	0x05, 0x09, //     Usage Page (Button)
+	0x19, 0x01, //     Usage Minimum (1)
+	0x29, 0x03, //     Usage Maximum (3)
+	0x15, 0x00, //     Logical Minimum (0)
+	0x25, 0x01, //     Logical Maximum (1)
+	0x95, 0x03, //     Report Count (3)
+	0x75, 0x01, //     Report Size (1)
+	0x81, 0x02, //     Input (Data,Var,Abs)
+	0x95, 0x01, //     Report Count (1)
+	0x75, 0x05, //     Report Size (5)
+	0x81, 0x01, //     Input (Const)
+	0xa4,       //     Push
+	0x05, 0x01, //     Usage Page (Generic Desktop)
+	0x09, 0x30, //     Usage (X)
+	0x09, 0x31, //     Usage (Y)
+	0x15, 0x81, //     Logical Minimum (-127)
+	0x25, 0x7f, //     Logical Maximum (127)
+	0x75, 0x08, //     Report Size (8)
+	0x95, 0x02, //     Report Count (2)
+	0x81, 0x06, //     Input (Data,Var,Rel)
+	0xb4,       //     Pop
+	0x09, 0x04, //     Usage (Button 4)
+	0x81, 0x02, //     Input (Data,Var,Abs)
+	0xc0, //   End Collection
+	0xc0, // End Collection
+}
+
+// vendor buffer with more elements than usages
+var buffer_report = []byte{
+	0x06, 0x00, 0xff, // Usage Page (Vendor)
+	0x09, 0x01, // Usage (1)
+	0xa1, 0x01, // Collection (Application)
+	0x09, 0x02, //   Usage (Length)
+	0x09, 0x03, //   Usage (Data)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x26, 0xff, 0x00, //   Logical Maximum (255)
+	0x75, 0x08, //   Report Size (8)
+	0x95, 0x04, //   Report Count (4)
+	0x81, 0x02, //   Input (Data,Var,Abs)
+	0xc0, // End Collection
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Items(t *testing.T) {
+	items, err := Parse_Items([]byte{0x05, 0x01, 0x27, 0xff, 0xff, 0x00, 0x00, 0xfe, 0x01, 0x42, 0x99, 0xc0})
+	if err != nil || len(items) != 4 {
+		t.Fatal("FAIL", err)
+	}
+	if items[0].Type != ITEM_GLOBAL || items[0].Tag != TAG_USAGE_PAGE || items[0].Unsigned() != 1 {
+		t.Error("FAIL")
+	}
+	if items[1].Tag != TAG_LOGICAL_MAXIMUM || items[1].Unsigned() != 0xffff || items[1].Signed() != 0xffff {
+		t.Error("FAIL")
+	}
+	if items[2].Type != ITEM_LONG || items[2].Tag != 0x42 || !bytes.Equal(items[2].Data, []byte{0x99}) {
+		t.Error("FAIL")
+	}
+	if items[3].Type != ITEM_MAIN || items[3].Tag != TAG_END_COLLECTION || len(items[3].Data) != 0 {
+		t.Error("FAIL")
+	}
+	x := Item{Data: []byte{0x81}}
+	if x.Signed() != -127 || x.Unsigned() != 0x81 {
+		t.Error("FAIL")
+	}
+	for _, b := range [][]byte{{0x26, 0xff}, {0xfe}, {0xfe, 0x02, 0x00, 0x01}} {
+		_, err = Parse_Items(b)
+		if err == nil {
+			t.Error("FAIL", b)
+		}
+	}
+}
+
+func Test_Keyboard(t *testing.T) {
+	r, err := Parse_Report_Descriptor(keyboard_report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Report_IDs() != nil || r.Report_Length(REPORT_TYPE_INPUT, 0) != 8 || r.Report_Length(REPORT_TYPE_OUTPUT, 0) != 1 {
+		t.Error("FAIL")
+	}
+	if r.Report_Length(REPORT_TYPE_FEATURE, 0) != 0 || r.Max_Report_Length(REPORT_TYPE_INPUT) != 8 {
+		t.Error("FAIL")
+	}
+	if len(r.Collections) != 1 || r.Collections[0].Usage != New_Usage(PAGE_GENERIC_DESKTOP, 0x06) {
+		t.Error("FAIL")
+	}
+
+	fields := r.Report_Fields(REPORT_TYPE_INPUT, 0)
+	if len(fields) != 3 {
+		t.Fatal("FAIL")
+	}
+	f := fields[0]
+	if !f.Variable() || f.Bit_Offset != 0 || f.Bit_Size != 1 || f.Count != 8 || len(f.Usages) != 8 || f.Usage(1) != New_Usage(PAGE_KEYBOARD, 0xe1) {
+		t.Error("FAIL")
+	}
+	if f.Collection != r.Collections[0] || f.Physical_Maximum != 1 {
+		t.Error("FAIL")
+	}
+	if !fields[1].Constant() || fields[1].Bit_Offset != 8 {
+		t.Error("FAIL")
+	}
+	f = fields[2]
+	if f.Variable() || f.Bit_Offset != 16 || f.Count != 6 || f.Logical_Maximum != 0x65 || len(f.Usages) != 0x66 {
+		t.Error("FAIL", f)
+	}
+	fields = r.Report_Fields(REPORT_TYPE_OUTPUT, 0)
+	if len(fields) != 2 || fields[0].Usage(4) != New_Usage(PAGE_LED, 5) || fields[1].Bit_Offset != 5 {
+		t.Error("FAIL")
+	}
+
+	// left shift with a and b pressed
+	data := []byte{0x02, 0x00, 0x04, 0x05, 0, 0, 0, 0}
+	values, err := r.Decode(REPORT_TYPE_INPUT, 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 10 || values[New_Usage(PAGE_KEYBOARD, 0xe1)] != 1 || values[New_Usage(PAGE_KEYBOARD, 0xe0)] != 0 {
+		t.Error("FAIL", values)
+	}
+	if values[New_Usage(PAGE_KEYBOARD, 0x04)] != 1 || values[New_Usage(PAGE_KEYBOARD, 0x05)] != 1 {
+		t.Error("FAIL")
+	}
+	buf, err := r.Encode(REPORT_TYPE_INPUT, 0, values)
+	if err != nil || !bytes.Equal(buf, data) {
+		t.Error("FAIL", buf)
+	}
+
+	// caps lock LED
+	buf, err = r.Encode(REPORT_TYPE_OUTPUT, 0, map[Usage]int32{New_Usage(PAGE_LED, 2): 1})
+	if err != nil || !bytes.Equal(buf, []byte{0x02}) {
+		t.Error("FAIL")
+	}
+
+	// errors
+	_, err = r.Decode(REPORT_TYPE_INPUT, 0, data[:7])
+	if err == nil {
+		t.Error("FAIL")
+	}
+	_, err = r.Decode(REPORT_TYPE_FEATURE, 0, data)
+	if err == nil {
+		t.Error("FAIL")
+	}
+	_, err = r.Encode(REPORT_TYPE_OUTPUT, 0, map[Usage]int32{New_Usage(PAGE_LED, 1): 2})
+	if err == nil {
+		t.Error("FAIL")
+	}
+	keys := make(map[Usage]int32)
+	for i := uint16(4); i < 11; i++ {
+		keys[New_Usage(PAGE_KEYBOARD, i)] = 1
+	}
+	_, err = r.Encode(REPORT_TYPE_INPUT, 0, keys)
+	if err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Mouse(t *testing.T) {
+	r, err := Parse_Report_Descriptor(mouse_report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Collections) != 2 || r.Collections[1].Parent != r.Collections[0] || r.Collections[1].Type != COLLECTION_PHYSICAL {
+		t.Error("FAIL")
+	}
+	fields := r.Report_Fields(REPORT_TYPE_INPUT, 0)
+	if len(fields) != 4 || r.Report_Length(REPORT_TYPE_INPUT, 0) != 4 {
+		t.Fatal("FAIL")
+	}
+	f := fields[2]
+	if !f.Relative() || f.Bit_Offset != 8 || f.Logical_Minimum != -127 || f.Physical_Minimum != -127 || f.Usage(1) != New_Usage(PAGE_GENERIC_DESKTOP, 0x31) {
+		t.Error("FAIL")
+	}
+	// the global state before the push
+	f = fields[3]
+	if f.Bit_Offset != 24 || f.Bit_Size != 5 || f.Count != 1 || f.Logical_Minimum != 0 || f.Usage(0) != New_Usage(PAGE_BUTTON, 4) {
+		t.Error("FAIL", f)
+	}
+
+	data := []byte{0x05, 0xff, 0x10, 0x01}
+	values, err := r.Decode(REPORT_TYPE_INPUT, 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := New_Usage(PAGE_GENERIC_DESKTOP, 0x30)
+	y := New_Usage(PAGE_GENERIC_DESKTOP, 0x31)
+	if values[x] != -1 || values[y] != 16 || values[New_Usage(PAGE_BUTTON, 1)] != 1 || values[New_Usage(PAGE_BUTTON, 2)] != 0 || values[New_Usage(PAGE_BUTTON, 4)] != 1 {
+		t.Error("FAIL", values)
+	}
+	buf, err := r.Encode(REPORT_TYPE_INPUT, 0, values)
+	if err != nil || !bytes.Equal(buf, data) {
+		t.Error("FAIL", buf)
+	}
+	_, err = r.Encode(REPORT_TYPE_INPUT, 0, map[Usage]int32{x: -128})
+	if err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Elements(t *testing.T) {
+	r, err := Parse_Report_Descriptor(buffer_report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length := New_Usage(0xff00, 2)
+	buffer := New_Usage(0xff00, 3)
+	data := []byte{3, 10, 20, 30}
+	values, err := r.Decode_Elements(REPORT_TYPE_INPUT, 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values[length]) != 1 || values[length][0] != 3 || len(values[buffer]) != 3 || values[buffer][0] != 10 || values[buffer][2] != 30 {
+		t.Error("FAIL", values)
+	}
+	buf, err := r.Encode_Elements(REPORT_TYPE_INPUT, 0, values)
+	if err != nil || !bytes.Equal(buf, data) {
+		t.Error("FAIL", buf)
+	}
+	// missing elements are 0
+	buf, err = r.Encode_Elements(REPORT_TYPE_INPUT, 0, map[Usage][]int32{buffer: {7}})
+	if err != nil || !bytes.Equal(buf, []byte{0, 7, 0, 0}) {
+		t.Error("FAIL", buf)
+	}
+	// the shared usage has the last element
+	x, err := r.Decode(REPORT_TYPE_INPUT, 0, data)
+	if err != nil || x[buffer] != 30 {
+		t.Error("FAIL", x)
+	}
+
+	f := r.Report_Fields(REPORT_TYPE_INPUT, 0)[0]
+	v, err := f.Value(data, 2)
+	if err != nil || v != 20 {
+		t.Error("FAIL", v)
+	}
+	err = f.Set_Value(data, 3, 40)
+	if err != nil || data[3] != 40 {
+		t.Error("FAIL")
+	}
+	if _, err = f.Value(data, 4); err == nil {
+		t.Error("FAIL")
+	}
+	if _, err = f.Value(data[:2], 2); err == nil {
+		t.Error("FAIL")
+	}
+	if err = f.Set_Value(data, 0, 256); err == nil {
+		t.Error("FAIL")
+	}
+
+	// a missing usage is the logical minimum when 0 is outside the range
+	r, err = Parse_Report_Descriptor([]byte{
+		0x06, 0x00, 0xff, // Usage Page (Vendor)
+		0x09, 0x01, // Usage (1)
+		0x09, 0x02, // Usage (2)
+		0x15, 0x01, // Logical Minimum (1)
+		0x25, 0x03, // Logical Maximum (3)
+		0x75, 0x04, // Report Size (4)
+		0x95, 0x02, // Report Count (2)
+		0xb1, 0x02, // Feature (Data,Var,Abs)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = r.Encode(REPORT_TYPE_FEATURE, 0, map[Usage]int32{New_Usage(0xff00, 2): 3})
+	if err != nil || !bytes.Equal(buf, []byte{0x31}) {
+		t.Error("FAIL", buf, err)
+	}
+	buf, err = r.Encode_Elements(REPORT_TYPE_FEATURE, 0, nil)
+	if err != nil || !bytes.Equal(buf, []byte{0x11}) {
+		t.Error("FAIL", buf, err)
+	}
+}
+
+func Test_Numbered(t *testing.T) {
+	r, err := Parse_Report_Descriptor(panel_report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Report_IDs(), []byte{1, 2, 3}) || r.Max_Report_Length(REPORT_TYPE_INPUT) != 5 {
+		t.Error("FAIL")
+	}
+	f := r.Report_Fields(REPORT_TYPE_OUTPUT, 2)
+	if len(f) != 1 || f[0].Bit_Offset != 0 || f[0].Logical_Maximum != 255 || f[0].Usage(0) != New_Usage(0xff00, 3) {
+		t.Error("FAIL")
+	}
+	values, err := r.Decode(REPORT_TYPE_FEATURE, 3, []byte{200})
+	if err != nil || values[New_Usage(0xff00, 4)] != 200 {
+		t.Error("FAIL")
+	}
+	_, err = r.Decode(REPORT_TYPE_FEATURE, 0, []byte{200})
+	if err == nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Parse_Errors(t *testing.T) {
+	// extended usages, an unsigned maximum and delimited alternatives
+	r, err := Parse_Report_Descriptor([]byte{
+		0x05, 0x01, // Usage Page (Generic Desktop)
+		0x0b, 0x01, 0x00, 0x0c, 0x00, // Usage (Consumer Control)
+		0xa9, 0x01, // Delimiter (Open)
+		0x09, 0x30, // Usage (X)
+		0x09, 0x31, // Usage (Y)
+		0xa9, 0x00, // Delimiter (Close)
+		0x15, 0x00, // Logical Minimum (0)
+		0x25, 0xff, // Logical Maximum (255)
+		0x75, 0x08, // Report Size (8)
+		0x95, 0x02, // Report Count (2)
+		0xb1, 0x02, // Feature (Data,Var,Abs)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := r.Fields[0]
+	if len(f.Usages) != 2 || f.Usages[0] != New_Usage(PAGE_CONSUMER, 1) || f.Usages[1] != New_Usage(PAGE_GENERIC_DESKTOP, 0x30) || f.Logical_Maximum != 255 {
+		t.Error("FAIL", f)
+	}
+
+	bad := [][]byte{
+		{0xb4},                               // pop without push
+		{0xc0},                               // end collection without a collection
+		{0xa1, 0x01},                         // unterminated collection
+		{0x85, 0x00},                         // report ID 0
+		{0x19, 0x05, 0x29, 0x01, 0x81},       // truncated
+		{0x19, 0x05, 0x29, 0x01, 0x81, 0x02}, // reversed usage range
+		{0x29, 0x01},                         // usage maximum without a minimum
+		{0x19, 0x01, 0x81, 0x02},             // usage minimum without a maximum
+		{0x75, 0x08, 0x95, 0x01, 0x81, 0x02, 0x85, 0x01, 0x81, 0x02},             // report without an ID
+		{0x77, 0xff, 0xff, 0xff, 0x7f, 0x97, 0xff, 0xff, 0xff, 0x7f, 0x81, 0x02}, // report size over 32 bits
+		{0x75, 0x20, 0x97, 0xff, 0xff, 0xff, 0x7f, 0x81, 0x02},                   // report count too large
+		{0x75, 0x20, 0x96, 0x00, 0x40, 0x81, 0x02, 0x81, 0x02},                   // report over 64 KiB
+	}
+	for _, b := range bad {
+		_, err = Parse_Report_Descriptor(b)
+		if err == nil {
+			t.Errorf("FAIL % x", b)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
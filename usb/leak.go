@@ -0,0 +1,106 @@
+//-----------------------------------------------------------------------------
+/*
+
+Leak detector
+
+When enabled, each Context, Device and DeviceHandle is tracked from creation
+until it is closed. Tests enable it and check that nothing is left open.
+
+*/
+//-----------------------------------------------------------------------------
+
+package usb
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+//-----------------------------------------------------------------------------
+
+type leak_id uint64
+
+type leak_record struct {
+	kind      string
+	stack     string
+	finalized bool // collected without being closed
+}
+
+var leaks = struct {
+	sync.Mutex
+	enabled bool
+	id      leak_id
+	record  map[leak_id]*leak_record
+}{
+	record: make(map[leak_id]*leak_record),
+}
+
+// start tracking an object, returns 0 if the leak detector is disabled
+func track(kind string) leak_id {
+	leaks.Lock()
+	defer leaks.Unlock()
+	if !leaks.enabled {
+		return 0
+	}
+	leaks.id++
+	leaks.record[leaks.id] = &leak_record{kind: kind, stack: string(debug.Stack())}
+	return leaks.id
+}
+
+// stop tracking a closed object
+func untrack(id leak_id) {
+	leaks.Lock()
+	defer leaks.Unlock()
+	r := leaks.record[id]
+	if r != nil && !r.finalized {
+		delete(leaks.record, id)
+	}
+}
+
+// mark an object as collected without being closed
+func finalized(id leak_id) {
+	leaks.Lock()
+	defer leaks.Unlock()
+	r := leaks.record[id]
+	if r != nil {
+		r.finalized = true
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// EnableLeakCheck turns the leak detector on or off and clears any records.
+// Only objects created while it is on are tracked.
+func EnableLeakCheck(enable bool) {
+	leaks.Lock()
+	defer leaks.Unlock()
+	leaks.enabled = enable
+	leaks.record = make(map[leak_id]*leak_record)
+}
+
+// Leaks returns a description of each tracked object that was not closed,
+// including objects released by the garbage collector. Each description
+// holds the stack that created the object.
+func Leaks() []string {
+	leaks.Lock()
+	defer leaks.Unlock()
+	ids := make([]leak_id, 0, len(leaks.record))
+	for id := range leaks.record {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		r := leaks.record[id]
+		state := "not closed"
+		if r.finalized {
+			state = "garbage collected without Close"
+		}
+		s[i] = fmt.Sprintf("%s %s, created at:\n%s", r.kind, state, r.stack)
+	}
+	return s
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,541 @@
+//-----------------------------------------------------------------------------
+/*
+
+Object API for libusb-1.0
+
+Context, Device and DeviceHandle own their libusb references. Close releases
+them and is safe to call more than once. Objects that are garbage collected
+without being closed are released by a finalizer and reported by the leak
+detector.
+
+The 1:1 libusb functions remain available in the libusb package, use the
+Raw() accessors to mix the two.
+
+*/
+//-----------------------------------------------------------------------------
+
+// Package usb provides an object API over the libusb package.
+package usb
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deadsy/libusb"
+)
+
+//-----------------------------------------------------------------------------
+
+// return a timeout in milliseconds for libusb, 0 is no timeout
+func timeout_ms(timeout time.Duration) uint {
+	if timeout <= 0 {
+		return 0
+	}
+	ms := uint(timeout / time.Millisecond)
+	if ms == 0 {
+		ms = 1
+	}
+	return ms
+}
+
+// timeout for the standard requests made by a DeviceHandle
+const request_timeout = time.Second
+
+//-----------------------------------------------------------------------------
+
+// Context is a libusb session.
+type Context struct {
+	mu      sync.Mutex
+	backend Backend
+	closed  bool
+	leak    leak_id
+}
+
+// NewContext initialises a new libusb session.
+func NewContext() (*Context, error) {
+	b, err := new_libusb_backend()
+	if err != nil {
+		return nil, err
+	}
+	return NewContextWithBackend(b), nil
+}
+
+// NewContextWithBackend returns a context using a backend.
+// Closing the context closes the backend.
+func NewContextWithBackend(b Backend) *Context {
+	c := &Context{backend: b}
+	c.leak = track("Context")
+	runtime.SetFinalizer(c, func(c *Context) { finalized(c.leak); c.Close() })
+	return c
+}
+
+// Raw returns the libusb context, nil if the context does not use libusb.
+func (c *Context) Raw() libusb.Context {
+	if b, ok := c.backend.(*libusb_backend); ok {
+		return b.ctx
+	}
+	return nil
+}
+
+// Backend returns the backend of the context.
+func (c *Context) Backend() Backend {
+	return c.backend
+}
+
+// Close ends the libusb session.
+// Devices and handles from the context should be closed first. Closing them
+// afterwards only marks them closed, their references ended with the session.
+func (c *Context) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.backend.Close()
+	untrack(c.leak)
+	runtime.SetFinalizer(c, nil)
+	return err
+}
+
+// return true if the context has been closed
+func (c *Context) is_closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Devices returns the devices currently attached to the system.
+// Each device holds a reference and must be closed.
+func (c *Context) Devices() ([]*Device, error) {
+	list, err := c.backend.Devices()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*Device, len(list))
+	for i, dev := range list {
+		devices[i] = new_device(c, dev)
+	}
+	return devices, nil
+}
+
+// Watch reports devices arriving and leaving on the returned channel, see libusb.Watch.
+// Call the returned stop function to end the watch.
+func (c *Context) Watch(filter *libusb.WatchFilter) (<-chan libusb.DeviceEvent, func(), error) {
+	return c.backend.Watch(filter)
+}
+
+// OpenDeviceWithVIDPID opens the first device with the vendor and product id.
+func (c *Context) OpenDeviceWithVIDPID(vid uint16, pid uint16) (*DeviceHandle, error) {
+	devices, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+	defer CloseDevices(devices)
+	for _, d := range devices {
+		dd, err := d.Descriptor()
+		if err != nil {
+			continue
+		}
+		if dd.IdVendor == vid && dd.IdProduct == pid {
+			return d.Open()
+		}
+	}
+	return nil, libusb.ErrNotFound
+}
+
+// Find returns the devices that match a selector, ordered by bus and port path.
+// Each device holds a reference and must be closed. See libusb.Find.
+func (c *Context) Find(sel *libusb.Selector) ([]*Device, error) {
+	devices, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+	var match_err error
+	matches := make([]*Device, 0, 1)
+	for _, d := range devices {
+		ok, err := d.match(sel)
+		if err != nil && match_err == nil {
+			match_err = err
+		}
+		if ok {
+			matches = append(matches, d)
+		} else {
+			d.Close()
+		}
+	}
+	if len(matches) == 0 && match_err != nil {
+		return nil, match_err
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].location().Less(matches[j].location())
+	})
+	return matches, nil
+}
+
+// OpenOne opens the single device that matches a selector.
+// A libusb.Selector_Error is returned if no device or more than one device matches.
+func (c *Context) OpenOne(sel *libusb.Selector) (*DeviceHandle, error) {
+	devices, err := c.Find(sel)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseDevices(devices)
+	matches := make([]*libusb.Device_Location, len(devices))
+	for i, d := range devices {
+		matches[i] = d.location()
+	}
+	if err := libusb.Check_One_Match(sel, matches); err != nil {
+		return nil, err
+	}
+	return devices[0].Open()
+}
+
+// OpenBySerial opens the single device with the vendor id, product id and serial number,
+// see libusb.OpenBySerial.
+func (c *Context) OpenBySerial(vid uint16, pid uint16, serial string) (*DeviceHandle, error) {
+	if serial == "" {
+		return nil, &libusb.Error{Code: libusb.ERROR_INVALID_PARAM, Op: "OpenBySerial", Endpoint: -1}
+	}
+	return c.OpenOne(&libusb.Selector{Vendor: vid, Product: pid, Serial: serial})
+}
+
+//-----------------------------------------------------------------------------
+
+// Device is a USB device detected on the system.
+type Device struct {
+	mu     sync.Mutex
+	ctx    *Context
+	dev    BackendDevice
+	closed bool
+	leak   leak_id
+}
+
+// return a device for a referenced backend device
+func new_device(ctx *Context, dev BackendDevice) *Device {
+	d := &Device{ctx: ctx, dev: dev}
+	d.leak = track("Device")
+	runtime.SetFinalizer(d, func(d *Device) { finalized(d.leak); d.Close() })
+	return d
+}
+
+// CloseDevices closes each device in a list.
+func CloseDevices(devices []*Device) {
+	for _, d := range devices {
+		d.Close()
+	}
+}
+
+// Raw returns the libusb device, nil if the device does not use libusb.
+func (d *Device) Raw() libusb.Device {
+	if dev, ok := d.dev.(*libusb_device); ok {
+		return dev.dev
+	}
+	return nil
+}
+
+// Context returns the context of the device.
+func (d *Device) Context() *Context {
+	return d.ctx
+}
+
+// Close releases the device reference.
+func (d *Device) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	var err error
+	if !d.ctx.is_closed() {
+		err = d.dev.Close()
+	}
+	untrack(d.leak)
+	runtime.SetFinalizer(d, nil)
+	return err
+}
+
+// Descriptor returns the device descriptor.
+func (d *Device) Descriptor() (*libusb.Device_Descriptor, error) {
+	return d.dev.Descriptor()
+}
+
+// ActiveConfig returns the descriptor for the active configuration.
+func (d *Device) ActiveConfig() (*libusb.Config_Descriptor, error) {
+	return d.dev.ActiveConfig()
+}
+
+// Config returns the descriptor for a configuration by index.
+func (d *Device) Config(index uint8) (*libusb.Config_Descriptor, error) {
+	return d.dev.Config(index)
+}
+
+// Bus returns the number of the bus the device is connected to.
+func (d *Device) Bus() uint8 {
+	return d.dev.Bus()
+}
+
+// Address returns the address of the device on the bus.
+func (d *Device) Address() uint8 {
+	return d.dev.Address()
+}
+
+// Port returns the number of the port the device is connected to, 0 for a root hub.
+func (d *Device) Port() uint8 {
+	path, err := d.dev.PortPath()
+	if err != nil || len(path) == 0 {
+		return 0
+	}
+	return path[len(path)-1]
+}
+
+// PortPath returns the port numbers from the root hub to the device.
+func (d *Device) PortPath() ([]byte, error) {
+	return d.dev.PortPath()
+}
+
+// Speed returns the negotiated connection speed.
+func (d *Device) Speed() libusb.Speed {
+	return d.dev.Speed()
+}
+
+// Serial returns the serial number string, an empty string if the device has none.
+// The device is not opened if the serial number can be read from the system.
+func (d *Device) Serial() (string, error) {
+	return d.dev.Serial()
+}
+
+// MaxPacketSize returns the wMaxPacketSize value for an endpoint in the active configuration.
+// The first alternate setting of an interface with the endpoint is used.
+func (d *Device) MaxPacketSize(endpoint uint8) (int, error) {
+	cd, err := d.dev.ActiveConfig()
+	if err != nil {
+		return 0, err
+	}
+	for _, itf := range cd.Interface {
+		for _, id := range itf.Altsetting {
+			for _, ep := range id.Endpoint {
+				if ep.BEndpointAddress == endpoint {
+					return int(ep.WMaxPacketSize), nil
+				}
+			}
+		}
+	}
+	return 0, &libusb.Error{Code: libusb.ERROR_NOT_FOUND, Op: "MaxPacketSize", Endpoint: int(endpoint)}
+}
+
+// Parent returns the parent of the device, nil for a root hub.
+// The parent holds its own reference and must be closed.
+func (d *Device) Parent() *Device {
+	parent := d.dev.Parent()
+	if parent == nil {
+		return nil
+	}
+	return new_device(d.ctx, parent)
+}
+
+// Open opens the device. The handle holds a reference to the device.
+func (d *Device) Open() (*DeviceHandle, error) {
+	hdl, err := d.dev.Open()
+	if err != nil {
+		return nil, err
+	}
+	h := &DeviceHandle{
+		dev:     new_device(d.ctx, d.dev.Ref()),
+		hdl:     hdl,
+		claimed: make(map[int]bool),
+	}
+	h.leak = track("DeviceHandle")
+	runtime.SetFinalizer(h, func(h *DeviceHandle) { finalized(h.leak); h.Close() })
+	return h, nil
+}
+
+// return the location of the device
+func (d *Device) location() *libusb.Device_Location {
+	path, _ := d.dev.PortPath()
+	loc := &libusb.Device_Location{
+		Bus:       d.dev.Bus(),
+		Address:   d.dev.Address(),
+		Port_Path: make([]int, len(path)),
+	}
+	for i, p := range path {
+		loc.Port_Path[i] = int(p)
+	}
+	return loc
+}
+
+// report whether the device matches a selector
+func (d *Device) match(sel *libusb.Selector) (bool, error) {
+	dd, err := d.dev.Descriptor()
+	if err != nil {
+		return false, err
+	}
+	return sel.Match_Device(dd, d.location(), d.dev.ActiveConfig, d.dev.Serial)
+}
+
+//-----------------------------------------------------------------------------
+
+// DeviceHandle is an open USB device.
+type DeviceHandle struct {
+	mu      sync.Mutex
+	dev     *Device
+	hdl     BackendHandle
+	claimed map[int]bool
+	closed  bool
+	leak    leak_id
+}
+
+// Raw returns the libusb device handle, nil if the handle does not use libusb.
+func (h *DeviceHandle) Raw() libusb.Device_Handle {
+	if hdl, ok := h.hdl.(*libusb_handle); ok {
+		return hdl.hdl
+	}
+	return nil
+}
+
+// Device returns the device for the handle.
+// It is owned by the handle, don't close it.
+func (h *DeviceHandle) Device() *Device {
+	return h.dev
+}
+
+// Close releases any claimed interfaces and closes the handle.
+func (h *DeviceHandle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	var err error
+	if !h.dev.ctx.is_closed() {
+		for n := range h.claimed {
+			h.hdl.Release(n)
+		}
+		err = h.hdl.Close()
+	}
+	h.claimed = nil
+	h.dev.Close()
+	untrack(h.leak)
+	runtime.SetFinalizer(h, nil)
+	return err
+}
+
+// Claim claims an interface. Claimed interfaces are released when the handle is closed.
+func (h *DeviceHandle) Claim(n int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	err := h.hdl.Claim(n)
+	if err != nil {
+		return err
+	}
+	h.claimed[n] = true
+	return nil
+}
+
+// Release releases a claimed interface.
+func (h *DeviceHandle) Release(n int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	err := h.hdl.Release(n)
+	delete(h.claimed, n)
+	return err
+}
+
+// Configuration returns the active configuration value.
+func (h *DeviceHandle) Configuration() (int, error) {
+	return h.hdl.Configuration()
+}
+
+// SetConfiguration sets the active configuration.
+func (h *DeviceHandle) SetConfiguration(cfg int) error {
+	return h.hdl.SetConfiguration(cfg)
+}
+
+// SetAltSetting activates an alternate setting for a claimed interface.
+func (h *DeviceHandle) SetAltSetting(n int, alt int) error {
+	return h.hdl.SetAltSetting(n, alt)
+}
+
+// ClearHalt clears the halt/stall condition for an endpoint.
+func (h *DeviceHandle) ClearHalt(endpoint uint8) error {
+	return h.hdl.ClearHalt(endpoint)
+}
+
+// Reset performs a USB port reset of the device.
+func (h *DeviceHandle) Reset() error {
+	return h.hdl.Reset()
+}
+
+// SetAutoDetachKernelDriver enables automatic kernel driver detach when claiming interfaces.
+func (h *DeviceHandle) SetAutoDetachKernelDriver(enable bool) error {
+	return h.hdl.SetAutoDetachKernelDriver(enable)
+}
+
+// Descriptor reads a descriptor from the device.
+func (h *DeviceHandle) Descriptor(desc_type uint8, index uint8, data []byte) ([]byte, error) {
+	return h.get_descriptor(desc_type, index, 0, data)
+}
+
+// read a descriptor with a GET_DESCRIPTOR request
+func (h *DeviceHandle) get_descriptor(desc_type uint8, index uint8, langid uint16, data []byte) ([]byte, error) {
+	return h.hdl.Control(libusb.ENDPOINT_IN, libusb.REQUEST_GET_DESCRIPTOR, uint16(desc_type)<<8|uint16(index), langid, data, request_timeout)
+}
+
+// read a string descriptor, see libusb.String_Reader
+func (h *DeviceHandle) read_string(index uint8, langid uint16, data []byte) ([]byte, error) {
+	return h.get_descriptor(libusb.DT_STRING, index, langid, data)
+}
+
+// Languages returns the language ids supported by the device.
+func (h *DeviceHandle) Languages() ([]uint16, error) {
+	return libusb.String_Reader(h.read_string).Languages()
+}
+
+// StringDescriptor returns the string descriptor at index in a language.
+func (h *DeviceHandle) StringDescriptor(index uint8, langid uint16) (string, error) {
+	return libusb.String_Reader(h.read_string).String(index, langid)
+}
+
+// StringDescriptorPreferred returns the string descriptor at index in the best
+// language from a priority list, and the language id it was read in.
+func (h *DeviceHandle) StringDescriptorPreferred(index uint8, preferred []uint16) (string, uint16, error) {
+	return libusb.String_Reader(h.read_string).String_Preferred(index, preferred)
+}
+
+// StringDescriptorASCII reads a string descriptor in the first language of the device.
+// As for libusb, characters outside of ASCII are replaced with '?'.
+func (h *DeviceHandle) StringDescriptorASCII(index uint8) (string, error) {
+	s, _, err := h.StringDescriptorPreferred(index, nil)
+	if err != nil {
+		return "", err
+	}
+	b := []byte{}
+	for _, r := range s {
+		if r > 0x7f {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return string(b), nil
+}
+
+// Control performs a control transfer. A zero timeout waits forever.
+func (h *DeviceHandle) Control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.hdl.Control(bmRequestType, bRequest, wValue, wIndex, data, timeout)
+}
+
+// Bulk performs a bulk transfer. A zero timeout waits forever.
+func (h *DeviceHandle) Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.hdl.Bulk(endpoint, data, timeout)
+}
+
+// Interrupt performs an interrupt transfer. A zero timeout waits forever.
+func (h *DeviceHandle) Interrupt(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.hdl.Interrupt(endpoint, data, timeout)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,164 @@
+//-----------------------------------------------------------------------------
+/*
+
+Test functions for the object API
+
+*/
+//-----------------------------------------------------------------------------
+
+package usb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/deadsy/libusb"
+)
+
+//-----------------------------------------------------------------------------
+
+// a backend that counts the releases of its devices and handles
+type count_backend struct {
+	Backend
+	closes int
+}
+
+func (b *count_backend) Close() error {
+	return nil
+}
+
+type count_device struct {
+	BackendDevice
+	b *count_backend
+}
+
+func (d *count_device) Close() error {
+	d.b.closes++
+	return nil
+}
+
+func (d *count_device) Ref() BackendDevice {
+	return &count_device{b: d.b}
+}
+
+func (d *count_device) Open() (BackendHandle, error) {
+	return &count_handle{b: d.b}, nil
+}
+
+type count_handle struct {
+	BackendHandle
+	b *count_backend
+}
+
+func (h *count_handle) Close() error {
+	h.b.closes++
+	return nil
+}
+
+// a device with an active configuration
+type config_device struct {
+	BackendDevice
+	cd *libusb.Config_Descriptor
+}
+
+func (d *config_device) ActiveConfig() (*libusb.Config_Descriptor, error) {
+	return d.cd, nil
+}
+
+func (d *config_device) Close() error {
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Devices(t *testing.T) {
+	EnableLeakCheck(true)
+	defer EnableLeakCheck(false)
+
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := ctx.Devices()
+	if err != nil {
+		t.Error(err)
+	}
+	for _, d := range devices {
+		dd, err := d.Descriptor()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		t.Logf("Bus %03d Device %03d: ID %04x:%04x", d.Bus(), d.Address(), dd.IdVendor, dd.IdProduct)
+	}
+	CloseDevices(devices)
+	CloseDevices(devices)
+	ctx.Close()
+	ctx.Close()
+
+	for _, s := range Leaks() {
+		t.Error(s)
+	}
+}
+
+func Test_Close_After_Context(t *testing.T) {
+	b := &count_backend{}
+	ctx := NewContextWithBackend(b)
+	d := new_device(ctx, &count_device{b: b})
+	h, err := d.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	if b.closes != 1 {
+		t.Error("FAIL")
+	}
+	// the references ended with the context
+	ctx.Close()
+	h.Close()
+	if b.closes != 1 || !h.closed || !h.dev.closed {
+		t.Error("FAIL")
+	}
+}
+
+func Test_MaxPacketSize(t *testing.T) {
+	// an interface without altsettings and an endpoint only in altsetting 1
+	cd := &libusb.Config_Descriptor{
+		Interface: []*libusb.Interface{
+			{},
+			{Altsetting: []*libusb.Interface_Descriptor{
+				{},
+				{Endpoint: []*libusb.Endpoint_Descriptor{{BEndpointAddress: 0x81, WMaxPacketSize: 1024}}},
+			}},
+		},
+	}
+	ctx := NewContextWithBackend(&count_backend{})
+	defer ctx.Close()
+	d := new_device(ctx, &config_device{cd: cd})
+	defer d.Close()
+	if n, err := d.MaxPacketSize(0x81); err != nil || n != 1024 {
+		t.Error("FAIL", n, err)
+	}
+	if _, err := d.MaxPacketSize(0x02); !errors.Is(err, libusb.ErrNotFound) {
+		t.Error("FAIL", err)
+	}
+}
+
+func Test_Leak_Check(t *testing.T) {
+	EnableLeakCheck(true)
+	defer EnableLeakCheck(false)
+
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(Leaks()) != 1 {
+		t.Error("FAIL")
+	}
+	ctx.Close()
+	if len(Leaks()) != 0 {
+		t.Error("FAIL")
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,399 @@
+//-----------------------------------------------------------------------------
+/*
+
+Packet capture of USB transfers
+
+A Tap wraps a usb.Backend and writes the transfers made through its device
+handles to a pcapng file in the Linux usbmon memory mapped format
+(LINKTYPE_USB_LINUX_MMAPPED), which the Wireshark USB dissectors read.
+
+Each transfer is written as a submission and a completion packet with the
+setup packet, data, status, timestamps, bus, device and endpoint, as usbmon
+would capture them. Configuration, alternate setting and clear halt calls
+are written as the standard control requests they make. No root access or
+usbmon kernel module is needed, only the transfers made by this process
+are captured.
+
+*/
+//-----------------------------------------------------------------------------
+
+// Package usbpcap captures the USB transfers of an application to a pcapng file.
+package usbpcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/usb"
+)
+
+//-----------------------------------------------------------------------------
+
+// LINKTYPE_USB_LINUX_MMAPPED is the pcap link type of the capture.
+const LINKTYPE_USB_LINUX_MMAPPED = 220
+
+// pcapng block types
+const (
+	block_shb = 0x0a0d0d0a // section header
+	block_idb = 0x00000001 // interface description
+	block_epb = 0x00000006 // enhanced packet
+)
+
+const byte_order_magic = 0x1a2b3c4d
+
+// usbmon event types
+const (
+	event_submit   = 'S'
+	event_complete = 'C'
+)
+
+// usbmon transfer types
+const (
+	xfer_isochronous = 0
+	xfer_interrupt   = 1
+	xfer_control     = 2
+	xfer_bulk        = 3
+)
+
+// usbmon header flags
+const (
+	flag_present  = 0   // the setup packet or data is captured
+	flag_no_setup = '-' // not a control submission
+	flag_in       = '<' // no data, direction in
+	flag_out      = '>' // no data, direction out
+)
+
+// usbmon_header_size is the size of the usbmon header before the data.
+const usbmon_header_size = 64
+
+// Linux errno values for the transfer status
+const (
+	status_ok          = 0
+	status_in_progress = -115 // EINPROGRESS
+	status_pipe        = -32  // EPIPE
+	status_timeout     = -2   // ENOENT, the transfer was cancelled
+	status_no_device   = -19  // ENODEV
+	status_overflow    = -75  // EOVERFLOW
+	status_proto       = -71  // EPROTO
+	status_io          = -5   // EIO
+)
+
+// return the usbmon status of a transfer error
+func transfer_status(err error) int32 {
+	if err == nil {
+		return status_ok
+	}
+	var e *libusb.Error
+	if !errors.As(err, &e) {
+		return status_io
+	}
+	switch e.Code {
+	case libusb.ERROR_PIPE:
+		return status_pipe
+	case libusb.ERROR_TIMEOUT, libusb.ERROR_INTERRUPTED:
+		return status_timeout
+	case libusb.ERROR_NO_DEVICE:
+		return status_no_device
+	case libusb.ERROR_OVERFLOW:
+		return status_overflow
+	case libusb.ERROR_IO:
+		return status_proto
+	}
+	return status_io
+}
+
+//-----------------------------------------------------------------------------
+
+// packet is a usbmon event.
+type packet struct {
+	id            uint64
+	event         byte
+	transfer_type uint8
+	endpoint      uint8
+	device        uint8
+	bus           uint16
+	flag_setup    uint8
+	flag_data     uint8
+	time          time.Time
+	status        int32
+	length        uint32 // transfer length
+	setup         []byte
+	data          []byte // captured data
+}
+
+// return the usbmon encoding of a packet
+func (p *packet) encode() []byte {
+	b := make([]byte, usbmon_header_size, usbmon_header_size+len(p.data))
+	le := binary.LittleEndian
+	le.PutUint64(b[0:], p.id)
+	b[8] = p.event
+	b[9] = p.transfer_type
+	b[10] = p.endpoint
+	b[11] = p.device
+	le.PutUint16(b[12:], p.bus)
+	b[14] = p.flag_setup
+	b[15] = p.flag_data
+	le.PutUint64(b[16:], uint64(p.time.Unix()))
+	le.PutUint32(b[24:], uint32(p.time.Nanosecond()/1000))
+	le.PutUint32(b[28:], uint32(p.status))
+	le.PutUint32(b[32:], p.length)
+	le.PutUint32(b[36:], uint32(len(p.data)))
+	copy(b[40:48], p.setup)
+	// interval, start frame, transfer flags and iso descriptors are zero
+	return append(b, p.data...)
+}
+
+//-----------------------------------------------------------------------------
+
+// Tap is a usb.Backend that captures the transfers made to another backend.
+type Tap struct {
+	mu      sync.Mutex
+	backend usb.Backend
+	w       io.Writer
+	id      uint64 // last transfer id
+	err     error  // first write error
+	// Filter selects the devices to capture by bus and address, nil captures all.
+	Filter func(bus, address uint8) bool
+}
+
+// NewTap returns a backend that captures the transfers made to b in w.
+// The pcapng section and interface headers are written immediately.
+// Closing the tap closes b, but not w.
+func NewTap(b usb.Backend, w io.Writer) (*Tap, error) {
+	t := &Tap{backend: b, w: w}
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	// section header: byte order magic, version 1.0, unknown section length
+	body := make([]byte, 16)
+	le.PutUint32(body[0:], byte_order_magic)
+	le.PutUint16(body[4:], 1)
+	le.PutUint16(body[6:], 0)
+	le.PutUint64(body[8:], 0xffffffffffffffff)
+	write_block(&buf, block_shb, body)
+	// interface description: link type, no snapshot length limit, microsecond timestamps
+	body = make([]byte, 8)
+	le.PutUint16(body[0:], LINKTYPE_USB_LINUX_MMAPPED)
+	write_block(&buf, block_idb, body)
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// write a pcapng block
+func write_block(buf *bytes.Buffer, block_type uint32, body []byte) {
+	pad := (4 - len(body)%4) % 4
+	total := uint32(12 + len(body) + pad)
+	binary.Write(buf, binary.LittleEndian, [2]uint32{block_type, total})
+	buf.Write(body)
+	buf.Write(make([]byte, pad))
+	binary.Write(buf, binary.LittleEndian, total)
+}
+
+// Err returns the first error writing the capture.
+func (t *Tap) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// return a new transfer id
+func (t *Tap) next_id() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.id++
+	return t.id
+}
+
+// write a packet
+func (t *Tap) write(p *packet) {
+	data := p.encode()
+	us := uint64(p.time.UnixNano() / 1000)
+	body := make([]byte, 20, 20+len(data))
+	le := binary.LittleEndian
+	le.PutUint32(body[0:], 0) // interface id
+	le.PutUint32(body[4:], uint32(us>>32))
+	le.PutUint32(body[8:], uint32(us))
+	le.PutUint32(body[12:], uint32(len(data)))
+	le.PutUint32(body[16:], uint32(len(data)))
+	body = append(body, data...)
+	var buf bytes.Buffer
+	write_block(&buf, block_epb, body)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	_, t.err = t.w.Write(buf.Bytes())
+}
+
+func (t *Tap) Devices() ([]usb.BackendDevice, error) {
+	list, err := t.backend.Devices()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]usb.BackendDevice, len(list))
+	for i, dev := range list {
+		devices[i] = &tap_device{BackendDevice: dev, t: t}
+	}
+	return devices, nil
+}
+
+func (t *Tap) Watch(filter *libusb.WatchFilter) (<-chan libusb.DeviceEvent, func(), error) {
+	return t.backend.Watch(filter)
+}
+
+func (t *Tap) Close() error {
+	return t.backend.Close()
+}
+
+//-----------------------------------------------------------------------------
+
+// tap_device is a device with captured handles.
+type tap_device struct {
+	usb.BackendDevice
+	t *Tap
+}
+
+func (d *tap_device) Parent() usb.BackendDevice {
+	parent := d.BackendDevice.Parent()
+	if parent == nil {
+		return nil
+	}
+	return &tap_device{BackendDevice: parent, t: d.t}
+}
+
+func (d *tap_device) Ref() usb.BackendDevice {
+	return &tap_device{BackendDevice: d.BackendDevice.Ref(), t: d.t}
+}
+
+func (d *tap_device) Open() (usb.BackendHandle, error) {
+	hdl, err := d.BackendDevice.Open()
+	if err != nil {
+		return nil, err
+	}
+	if d.t.Filter != nil && !d.t.Filter(d.Bus(), d.Address()) {
+		return hdl, nil
+	}
+	return &tap_handle{BackendHandle: hdl, t: d.t, bus: d.Bus(), address: d.Address()}, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// tap_handle captures the transfers made through a handle.
+type tap_handle struct {
+	usb.BackendHandle
+	t       *Tap
+	bus     uint8
+	address uint8
+}
+
+// capture a transfer
+func (h *tap_handle) transfer(transfer_type uint8, endpoint uint8, setup []byte, data []byte, fn func() ([]byte, error)) ([]byte, error) {
+	in := endpoint&libusb.ENDPOINT_IN != 0
+	s := &packet{
+		id:            h.t.next_id(),
+		event:         event_submit,
+		transfer_type: transfer_type,
+		endpoint:      endpoint,
+		device:        h.address,
+		bus:           uint16(h.bus),
+		flag_setup:    flag_no_setup,
+		flag_data:     flag_in,
+		time:          time.Now(),
+		status:        status_in_progress,
+		length:        uint32(len(data)),
+		setup:         setup,
+	}
+	if setup != nil {
+		s.flag_setup = flag_present
+	}
+	if !in {
+		s.flag_data = flag_out
+		if len(data) != 0 {
+			s.flag_data = flag_present
+			s.data = data
+		}
+	}
+	h.t.write(s)
+	buf, err := fn()
+	c := *s
+	c.event = event_complete
+	c.flag_setup = flag_no_setup
+	c.flag_data = flag_out
+	c.time = time.Now()
+	c.status = transfer_status(err)
+	c.length = uint32(len(buf))
+	c.setup = nil
+	c.data = nil
+	if in {
+		c.flag_data = flag_present
+		c.data = buf
+	}
+	h.t.write(&c)
+	return buf, err
+}
+
+// capture a control transfer
+func (h *tap_handle) control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, fn func() ([]byte, error)) ([]byte, error) {
+	setup := make([]byte, 8)
+	setup[0] = bmRequestType
+	setup[1] = bRequest
+	binary.LittleEndian.PutUint16(setup[2:], wValue)
+	binary.LittleEndian.PutUint16(setup[4:], wIndex)
+	binary.LittleEndian.PutUint16(setup[6:], uint16(len(data)))
+	return h.transfer(xfer_control, bmRequestType&libusb.ENDPOINT_IN, setup, data, fn)
+}
+
+// capture a standard request made by a call returning only an error
+func (h *tap_handle) request(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, fn func() error) error {
+	_, err := h.control(bmRequestType, bRequest, wValue, wIndex, nil, func() ([]byte, error) {
+		return nil, fn()
+	})
+	return err
+}
+
+func (h *tap_handle) SetConfiguration(cfg int) error {
+	return h.request(libusb.RECIPIENT_DEVICE, libusb.REQUEST_SET_CONFIGURATION, uint16(cfg), 0, func() error {
+		return h.BackendHandle.SetConfiguration(cfg)
+	})
+}
+
+func (h *tap_handle) SetAltSetting(n int, alt int) error {
+	return h.request(libusb.RECIPIENT_INTERFACE, libusb.REQUEST_SET_INTERFACE, uint16(alt), uint16(n), func() error {
+		return h.BackendHandle.SetAltSetting(n, alt)
+	})
+}
+
+func (h *tap_handle) ClearHalt(endpoint uint8) error {
+	// feature selector 0 is ENDPOINT_HALT
+	return h.request(libusb.RECIPIENT_ENDPOINT, libusb.REQUEST_CLEAR_FEATURE, 0, uint16(endpoint), func() error {
+		return h.BackendHandle.ClearHalt(endpoint)
+	})
+}
+
+func (h *tap_handle) Control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.control(bmRequestType, bRequest, wValue, wIndex, data, func() ([]byte, error) {
+		return h.BackendHandle.Control(bmRequestType, bRequest, wValue, wIndex, data, timeout)
+	})
+}
+
+func (h *tap_handle) Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer(xfer_bulk, endpoint, nil, data, func() ([]byte, error) {
+		return h.BackendHandle.Bulk(endpoint, data, timeout)
+	})
+}
+
+func (h *tap_handle) Interrupt(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer(xfer_interrupt, endpoint, nil, data, func() ([]byte, error) {
+		return h.BackendHandle.Interrupt(endpoint, data, timeout)
+	})
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,137 @@
+//-----------------------------------------------------------------------------
+/*
+
+Test functions for packet capture
+
+*/
+//-----------------------------------------------------------------------------
+
+package usbpcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/usb"
+	"github.com/deadsy/libusb/usb/usbtest"
+)
+
+//-----------------------------------------------------------------------------
+
+// return a device with a bulk IN/OUT endpoint pair
+func new_device(serial string, port uint8) *usbtest.Device {
+	d := usbtest.NewBulkDevice(0x0483, 0x5740, libusb.SPEED_FULL, "", "", serial)
+	d.PortPath = []byte{port}
+	return d
+}
+
+// read the blocks of a pcapng file
+func read_blocks(t *testing.T, b []byte) (types []uint32, bodies [][]byte) {
+	le := binary.LittleEndian
+	for len(b) != 0 {
+		if len(b) < 12 {
+			t.Fatal("FAIL")
+		}
+		n := le.Uint32(b[4:])
+		if n < 12 || n%4 != 0 || int(n) > len(b) || le.Uint32(b[n-4:]) != n {
+			t.Fatal("FAIL")
+		}
+		types = append(types, le.Uint32(b))
+		bodies = append(bodies, b[8:n-4])
+		b = b[n:]
+	}
+	return types, bodies
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Capture(t *testing.T) {
+	b := usbtest.NewBackend()
+	d := new_device("A1", 1)
+	d.Queue(0x81, usbtest.Response{Data: []byte("pong")}, usbtest.Response{Err: libusb.ErrPipe})
+	b.Add(d, new_device("A2", 2))
+	var out bytes.Buffer
+	tap, err := NewTap(b, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tap.Filter = func(bus, address uint8) bool { return address == 1 }
+	ctx := usb.NewContextWithBackend(tap)
+	defer ctx.Close()
+
+	h, err := ctx.OpenBySerial(0x0483, 0x5740, "A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Bulk(0x01, []byte("ping"), time.Second)
+	h.Bulk(0x81, make([]byte, 64), time.Second)
+	h.Bulk(0x81, make([]byte, 64), time.Second)
+	h.ClearHalt(0x81)
+	h.Close()
+	// not captured
+	h, err = ctx.OpenBySerial(0x0483, 0x5740, "A2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Bulk(0x01, []byte("ping"), time.Second)
+	h.Close()
+	if tap.Err() != nil {
+		t.Fatal(tap.Err())
+	}
+
+	types, bodies := read_blocks(t, out.Bytes())
+	if len(types) != 2+2*4 || types[0] != block_shb || types[1] != block_idb {
+		t.Fatal("FAIL", len(types))
+	}
+	le := binary.LittleEndian
+	if le.Uint32(bodies[0]) != byte_order_magic || le.Uint16(bodies[1]) != LINKTYPE_USB_LINUX_MMAPPED {
+		t.Error("FAIL")
+	}
+
+	type event struct {
+		id       uint64
+		event    byte
+		xfer     uint8
+		endpoint uint8
+		device   uint8
+		status   int32
+		length   uint32
+		setup    []byte
+		data     string
+	}
+	expect := []event{
+		{1, 'S', xfer_bulk, 0x01, 1, status_in_progress, 4, nil, "ping"},
+		{1, 'C', xfer_bulk, 0x01, 1, status_ok, 4, nil, ""},
+		{2, 'S', xfer_bulk, 0x81, 1, status_in_progress, 64, nil, ""},
+		{2, 'C', xfer_bulk, 0x81, 1, status_ok, 4, nil, "pong"},
+		{3, 'S', xfer_bulk, 0x81, 1, status_in_progress, 64, nil, ""},
+		{3, 'C', xfer_bulk, 0x81, 1, status_pipe, 0, nil, ""},
+		{4, 'S', xfer_control, 0x00, 1, status_in_progress, 0, []byte{0x02, 0x01, 0, 0, 0x81, 0, 0, 0}, ""},
+		{4, 'C', xfer_control, 0x00, 1, status_ok, 0, nil, ""},
+	}
+	for i, x := range expect {
+		body := bodies[2+i]
+		n := le.Uint32(body[12:])
+		if le.Uint32(body[0:]) != 0 || n != le.Uint32(body[16:]) || int(n) > len(body)-20 {
+			t.Fatal("FAIL")
+		}
+		p := body[20 : 20+n]
+		if len(p) < usbmon_header_size || int(le.Uint32(p[36:])) != len(p)-usbmon_header_size {
+			t.Fatal("FAIL")
+		}
+		if le.Uint64(p) != x.id || p[8] != x.event || p[9] != x.xfer || p[10] != x.endpoint || p[11] != x.device || le.Uint16(p[12:]) != 1 {
+			t.Error("FAIL", i)
+		}
+		if int32(le.Uint32(p[28:])) != x.status || le.Uint32(p[32:]) != x.length || string(p[usbmon_header_size:]) != x.data {
+			t.Error("FAIL", i)
+		}
+		if (x.setup != nil) != (p[14] == flag_present) || (x.setup != nil && !bytes.Equal(p[40:48], x.setup)) {
+			t.Error("FAIL", i)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,411 @@
+//-----------------------------------------------------------------------------
+/*
+
+Record and replay of USB sessions
+
+A Recorder wraps a usb.Backend and writes every call made through a device
+handle to a log: open and close, claim and release, configuration changes,
+control setup packets, bulk and interrupt data, timings and errors. The
+descriptors of each device are logged when it is first seen.
+
+A Replayer is a usb.Backend that serves a log. The calls made to it must be
+the same as the recorded calls, each returns the recorded result. A call
+that differs from the recording fails, and is reported by Replayer.Check.
+
+The log is JSON, one record per line.
+
+*/
+//-----------------------------------------------------------------------------
+
+// Package usbreplay records USB sessions and replays them without hardware.
+package usbreplay
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/descriptor"
+	"github.com/deadsy/libusb/usb"
+)
+
+//-----------------------------------------------------------------------------
+
+// Operations in the log.
+const (
+	OP_DEVICE            = "device" // a device was seen, Info holds its descriptors
+	OP_SERIAL            = "serial"
+	OP_OPEN              = "open"
+	OP_CLOSE             = "close"
+	OP_CLAIM             = "claim"
+	OP_RELEASE           = "release"
+	OP_CONFIGURATION     = "configuration"
+	OP_SET_CONFIGURATION = "set_configuration"
+	OP_SET_ALT_SETTING   = "set_alt_setting"
+	OP_CLEAR_HALT        = "clear_halt"
+	OP_RESET             = "reset"
+	OP_AUTO_DETACH       = "auto_detach"
+	OP_CONTROL           = "control"
+	OP_BULK              = "bulk"
+	OP_INTERRUPT         = "interrupt"
+)
+
+// hex_bytes is a byte slice with a hex JSON encoding.
+type hex_bytes []byte
+
+func (x hex_bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(x))
+}
+
+func (x *hex_bytes) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	*x, err = hex.DecodeString(s)
+	return err
+}
+
+// Setup is the setup packet of a control transfer.
+type Setup struct {
+	RequestType uint8  `json:"bmRequestType"`
+	Request     uint8  `json:"bRequest"`
+	Value       uint16 `json:"wValue"`
+	Index       uint16 `json:"wIndex"`
+	Length      uint16 `json:"wLength"`
+}
+
+// Device_Info holds the recorded state of a device.
+type Device_Info struct {
+	Bus       uint8       `json:"bus"`
+	Address   uint8       `json:"address"`
+	Port_Path []int       `json:"port_path"`
+	Speed     int         `json:"speed"`
+	Config    int         `json:"config"` // active configuration value, 0 if unconfigured
+	Device    hex_bytes   `json:"device"` // raw device descriptor
+	Configs   []hex_bytes `json:"configs"`
+}
+
+// Record is a single logged call.
+type Record struct {
+	Time     int64        `json:"t"`   // microseconds since the start of the recording
+	Device   int          `json:"dev"` // device number in the recording
+	Op       string       `json:"op"`
+	Endpoint uint8        `json:"ep,omitempty"`
+	Setup    *Setup       `json:"setup,omitempty"`
+	Value    int          `json:"value,omitempty"`  // interface, configuration or flag
+	Alt      int          `json:"alt,omitempty"`    // alternate setting
+	Length   int          `json:"len,omitempty"`    // buffer length of an IN transfer
+	Data     hex_bytes    `json:"data,omitempty"`   // data written, or data read by an IN transfer
+	Sent     int          `json:"sent,omitempty"`   // bytes sent by an OUT transfer
+	Serial   string       `json:"serial,omitempty"` // serial number string
+	Error    int          `json:"err,omitempty"`    // libusb error code
+	Duration int64        `json:"us,omitempty"`     // call duration in microseconds
+	Info     *Device_Info `json:"info,omitempty"`
+}
+
+// return the libusb error code of an error
+func error_code(err error) int {
+	if err == nil {
+		return 0
+	}
+	var e *libusb.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return libusb.ERROR_OTHER
+}
+
+//-----------------------------------------------------------------------------
+
+// Recorder is a usb.Backend that logs the calls made to another backend.
+type Recorder struct {
+	mu      sync.Mutex
+	backend usb.Backend
+	enc     *json.Encoder
+	start   time.Time
+	ids     map[string]int // device number by bus and address
+	err     error          // first write error
+}
+
+// NewRecorder returns a backend that writes the calls made to b to w.
+// Closing the recorder closes b.
+func NewRecorder(b usb.Backend, w io.Writer) *Recorder {
+	return &Recorder{
+		backend: b,
+		enc:     json.NewEncoder(w),
+		start:   time.Now(),
+		ids:     make(map[string]int),
+	}
+}
+
+// Err returns the first error writing the log.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// write a record
+func (r *Recorder) write(x *Record, start time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	x.Time = start.Sub(r.start).Microseconds()
+	err := r.enc.Encode(x)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// return the device number, logging the device when it is first seen
+func (r *Recorder) device_id(dev usb.BackendDevice) int {
+	key := fmt.Sprintf("%d:%d", dev.Bus(), dev.Address())
+	r.mu.Lock()
+	id, ok := r.ids[key]
+	if !ok {
+		id = len(r.ids)
+		r.ids[key] = id
+	}
+	r.mu.Unlock()
+	if !ok {
+		r.write(&Record{Device: id, Op: OP_DEVICE, Info: device_info(dev)}, time.Now())
+	}
+	return id
+}
+
+// return the recorded state of a device
+func device_info(dev usb.BackendDevice) *Device_Info {
+	info := &Device_Info{
+		Bus:     dev.Bus(),
+		Address: dev.Address(),
+		Speed:   int(dev.Speed()),
+	}
+	path, _ := dev.PortPath()
+	for _, p := range path {
+		info.Port_Path = append(info.Port_Path, int(p))
+	}
+	if cd, err := dev.ActiveConfig(); err == nil {
+		info.Config = int(cd.BConfigurationValue)
+	}
+	dd, err := dev.Descriptor()
+	if err != nil {
+		return info
+	}
+	info.Device = descriptor.Encode_Device_Descriptor(dd)
+	for i := uint8(0); i < dd.BNumConfigurations; i++ {
+		cd, err := dev.Config(i)
+		if err != nil {
+			break
+		}
+		info.Configs = append(info.Configs, descriptor.Encode_Config_Descriptor(cd))
+	}
+	return info
+}
+
+func (r *Recorder) Devices() ([]usb.BackendDevice, error) {
+	list, err := r.backend.Devices()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]usb.BackendDevice, len(list))
+	for i, dev := range list {
+		devices[i] = &rec_device{r: r, id: r.device_id(dev), dev: dev}
+	}
+	return devices, nil
+}
+
+func (r *Recorder) Watch(filter *libusb.WatchFilter) (<-chan libusb.DeviceEvent, func(), error) {
+	return r.backend.Watch(filter)
+}
+
+func (r *Recorder) Close() error {
+	return r.backend.Close()
+}
+
+//-----------------------------------------------------------------------------
+
+// rec_device records the calls made to a device.
+type rec_device struct {
+	r   *Recorder
+	id  int
+	dev usb.BackendDevice
+}
+
+func (d *rec_device) Descriptor() (*libusb.Device_Descriptor, error) {
+	return d.dev.Descriptor()
+}
+
+func (d *rec_device) Config(index uint8) (*libusb.Config_Descriptor, error) {
+	return d.dev.Config(index)
+}
+
+func (d *rec_device) ActiveConfig() (*libusb.Config_Descriptor, error) {
+	return d.dev.ActiveConfig()
+}
+
+func (d *rec_device) Bus() uint8 {
+	return d.dev.Bus()
+}
+
+func (d *rec_device) Address() uint8 {
+	return d.dev.Address()
+}
+
+func (d *rec_device) PortPath() ([]byte, error) {
+	return d.dev.PortPath()
+}
+
+func (d *rec_device) Speed() libusb.Speed {
+	return d.dev.Speed()
+}
+
+func (d *rec_device) Serial() (string, error) {
+	start := time.Now()
+	s, err := d.dev.Serial()
+	d.r.write(&Record{Device: d.id, Op: OP_SERIAL, Serial: s, Error: error_code(err)}, start)
+	return s, err
+}
+
+func (d *rec_device) Parent() usb.BackendDevice {
+	parent := d.dev.Parent()
+	if parent == nil {
+		return nil
+	}
+	return &rec_device{r: d.r, id: d.r.device_id(parent), dev: parent}
+}
+
+func (d *rec_device) Ref() usb.BackendDevice {
+	return &rec_device{r: d.r, id: d.id, dev: d.dev.Ref()}
+}
+
+func (d *rec_device) Open() (usb.BackendHandle, error) {
+	start := time.Now()
+	hdl, err := d.dev.Open()
+	d.r.write(&Record{Device: d.id, Op: OP_OPEN, Error: error_code(err), Duration: time.Since(start).Microseconds()}, start)
+	if err != nil {
+		return nil, err
+	}
+	return &rec_handle{r: d.r, id: d.id, hdl: hdl}, nil
+}
+
+func (d *rec_device) Close() error {
+	return d.dev.Close()
+}
+
+//-----------------------------------------------------------------------------
+
+// rec_handle records the calls made to a device handle.
+type rec_handle struct {
+	r   *Recorder
+	id  int
+	hdl usb.BackendHandle
+}
+
+// record a call returning only an error
+func (h *rec_handle) call(op string, x *Record, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	x.Device = h.id
+	x.Op = op
+	x.Error = error_code(err)
+	x.Duration = time.Since(start).Microseconds()
+	h.r.write(x, start)
+	return err
+}
+
+func (h *rec_handle) Claim(n int) error {
+	return h.call(OP_CLAIM, &Record{Value: n}, func() error { return h.hdl.Claim(n) })
+}
+
+func (h *rec_handle) Release(n int) error {
+	return h.call(OP_RELEASE, &Record{Value: n}, func() error { return h.hdl.Release(n) })
+}
+
+func (h *rec_handle) Configuration() (int, error) {
+	var cfg int
+	x := &Record{}
+	err := h.call(OP_CONFIGURATION, x, func() error {
+		var err error
+		cfg, err = h.hdl.Configuration()
+		x.Value = cfg
+		return err
+	})
+	return cfg, err
+}
+
+func (h *rec_handle) SetConfiguration(cfg int) error {
+	return h.call(OP_SET_CONFIGURATION, &Record{Value: cfg}, func() error { return h.hdl.SetConfiguration(cfg) })
+}
+
+func (h *rec_handle) SetAltSetting(n int, alt int) error {
+	return h.call(OP_SET_ALT_SETTING, &Record{Value: n, Alt: alt}, func() error { return h.hdl.SetAltSetting(n, alt) })
+}
+
+func (h *rec_handle) ClearHalt(endpoint uint8) error {
+	return h.call(OP_CLEAR_HALT, &Record{Endpoint: endpoint}, func() error { return h.hdl.ClearHalt(endpoint) })
+}
+
+func (h *rec_handle) Reset() error {
+	return h.call(OP_RESET, &Record{}, h.hdl.Reset)
+}
+
+func (h *rec_handle) SetAutoDetachKernelDriver(enable bool) error {
+	x := &Record{}
+	if enable {
+		x.Value = 1
+	}
+	return h.call(OP_AUTO_DETACH, x, func() error { return h.hdl.SetAutoDetachKernelDriver(enable) })
+}
+
+// record a transfer, IN data and the length of OUT data sent are logged after
+// the transfer and OUT data before
+func (h *rec_handle) transfer(op string, x *Record, in bool, data []byte, fn func() ([]byte, error)) ([]byte, error) {
+	if in {
+		x.Length = len(data)
+	} else {
+		x.Data = append(hex_bytes(nil), data...)
+	}
+	var buf []byte
+	err := h.call(op, x, func() error {
+		var err error
+		buf, err = fn()
+		if in {
+			x.Data = append(hex_bytes(nil), buf...)
+		} else {
+			x.Sent = len(buf)
+		}
+		return err
+	})
+	return buf, err
+}
+
+func (h *rec_handle) Control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout time.Duration) ([]byte, error) {
+	x := &Record{Setup: &Setup{bmRequestType, bRequest, wValue, wIndex, uint16(len(data))}}
+	return h.transfer(OP_CONTROL, x, bmRequestType&libusb.ENDPOINT_IN != 0, data, func() ([]byte, error) {
+		return h.hdl.Control(bmRequestType, bRequest, wValue, wIndex, data, timeout)
+	})
+}
+
+func (h *rec_handle) Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer(OP_BULK, &Record{Endpoint: endpoint}, endpoint&libusb.ENDPOINT_IN != 0, data, func() ([]byte, error) {
+		return h.hdl.Bulk(endpoint, data, timeout)
+	})
+}
+
+func (h *rec_handle) Interrupt(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer(OP_INTERRUPT, &Record{Endpoint: endpoint}, endpoint&libusb.ENDPOINT_IN != 0, data, func() ([]byte, error) {
+		return h.hdl.Interrupt(endpoint, data, timeout)
+	})
+}
+
+func (h *rec_handle) Close() error {
+	return h.call(OP_CLOSE, &Record{}, h.hdl.Close)
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,425 @@
+//-----------------------------------------------------------------------------
+/*
+
+Replay a recorded session
+
+Each device has its own sequence of recorded calls, so the order of calls
+to different devices does not matter. Calls to a device must be made in the
+recorded order with the same arguments and OUT data.
+
+*/
+//-----------------------------------------------------------------------------
+
+package usbreplay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/descriptor"
+	"github.com/deadsy/libusb/usb"
+)
+
+//-----------------------------------------------------------------------------
+
+// Mismatch_Error reports a call that differs from the recording.
+type Mismatch_Error struct {
+	Device int     // device number in the recording
+	Call   int     // index of the call to the device
+	Got    string  // the call that was made
+	Expect *Record // the recorded call, nil if the recording has ended
+}
+
+func (e *Mismatch_Error) Error() string {
+	expect := "end of recording"
+	if e.Expect != nil {
+		b, _ := json.Marshal(e.Expect)
+		expect = string(b)
+	}
+	return fmt.Sprintf("usbreplay: device %d call %d: got %s, recorded %s", e.Device, e.Call, e.Got, expect)
+}
+
+//-----------------------------------------------------------------------------
+
+// replay state of a device
+type rep_state struct {
+	id      int
+	info    *Device_Info
+	dd      *libusb.Device_Descriptor
+	configs []*libusb.Config_Descriptor
+	serial  *Record // the first recorded serial number lookup
+	calls   []*Record
+	next    int
+}
+
+// Replayer is a usb.Backend that serves a recorded session.
+type Replayer struct {
+	mu       sync.Mutex
+	devices  []*rep_state
+	err      error // first mismatch
+	Realtime bool  // take the recorded time for each call
+}
+
+// NewReplayer returns a backend for a session recorded by a Recorder.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	rp := &Replayer{}
+	states := make(map[int]*rep_state)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for line := 1; scanner.Scan(); line++ {
+		x := &Record{}
+		err := json.Unmarshal(scanner.Bytes(), x)
+		if err != nil {
+			return nil, fmt.Errorf("usbreplay: line %d: %v", line, err)
+		}
+		if x.Op == OP_DEVICE {
+			s, err := new_rep_state(x)
+			if err != nil {
+				return nil, fmt.Errorf("usbreplay: line %d: %v", line, err)
+			}
+			states[x.Device] = s
+			rp.devices = append(rp.devices, s)
+			continue
+		}
+		s, ok := states[x.Device]
+		if !ok {
+			return nil, fmt.Errorf("usbreplay: line %d: unknown device %d", line, x.Device)
+		}
+		if x.Op == OP_SERIAL {
+			if s.serial == nil {
+				s.serial = x
+			}
+			continue
+		}
+		s.calls = append(s.calls, x)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(rp.devices, func(i, j int) bool { return rp.devices[i].id < rp.devices[j].id })
+	return rp, nil
+}
+
+// return the replay state for a device record
+func new_rep_state(x *Record) (*rep_state, error) {
+	if x.Info == nil {
+		return nil, fmt.Errorf("device %d has no info", x.Device)
+	}
+	dd, err := descriptor.Parse_Device_Descriptor(x.Info.Device)
+	if err != nil {
+		return nil, err
+	}
+	s := &rep_state{id: x.Device, info: x.Info, dd: dd}
+	for _, b := range x.Info.Configs {
+		cd, err := descriptor.Parse_Config_Descriptor(b)
+		if err != nil {
+			return nil, err
+		}
+		s.configs = append(s.configs, cd)
+	}
+	return s, nil
+}
+
+// Check returns the first call that differed from the recording, or an
+// error if recorded calls were not made.
+func (rp *Replayer) Check() error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.err != nil {
+		return rp.err
+	}
+	for _, s := range rp.devices {
+		if s.next < len(s.calls) {
+			return fmt.Errorf("usbreplay: device %d: %d of %d recorded calls were not made", s.id, len(s.calls)-s.next, len(s.calls))
+		}
+	}
+	return nil
+}
+
+// return the next recorded call for a device, checking it matches
+func (rp *Replayer) call(s *rep_state, x *Record) (*Record, error) {
+	rp.mu.Lock()
+	var expect *Record
+	if s.next < len(s.calls) {
+		expect = s.calls[s.next]
+	}
+	if expect == nil || !match(expect, x) {
+		got, _ := json.Marshal(x)
+		err := &Mismatch_Error{Device: s.id, Call: s.next, Got: string(got), Expect: expect}
+		if rp.err == nil {
+			rp.err = err
+		}
+		rp.mu.Unlock()
+		return nil, &libusb.Error{Code: libusb.ERROR_OTHER, Op: x.Op, Endpoint: -1}
+	}
+	s.next++
+	rp.mu.Unlock()
+	if rp.Realtime {
+		time.Sleep(time.Duration(expect.Duration) * time.Microsecond)
+	}
+	return expect, nil
+}
+
+// return true if a call matches the recorded call
+func match(expect, x *Record) bool {
+	if expect.Op != x.Op || expect.Endpoint != x.Endpoint || expect.Alt != x.Alt || expect.Length != x.Length {
+		return false
+	}
+	switch x.Op {
+	case OP_CONFIGURATION:
+		// the value is a result
+	default:
+		if expect.Value != x.Value {
+			return false
+		}
+	}
+	if (expect.Setup == nil) != (x.Setup == nil) || (x.Setup != nil && *expect.Setup != *x.Setup) {
+		return false
+	}
+	// compare OUT data, IN data is a result
+	if x.Length == 0 && string(expect.Data) != string(x.Data) {
+		return false
+	}
+	return true
+}
+
+// return the recorded error
+func (x *Record) error(endpoint int) error {
+	if x.Error == 0 {
+		return nil
+	}
+	return &libusb.Error{Code: x.Error, Op: x.Op, Endpoint: endpoint}
+}
+
+func (rp *Replayer) Devices() ([]usb.BackendDevice, error) {
+	devices := make([]usb.BackendDevice, len(rp.devices))
+	for i, s := range rp.devices {
+		devices[i] = &rep_device{rp: rp, s: s}
+	}
+	return devices, nil
+}
+
+// Watch reports the recorded devices as arriving if filter.Enumerate is set.
+// Hotplug events are not recorded.
+func (rp *Replayer) Watch(filter *libusb.WatchFilter) (<-chan libusb.DeviceEvent, func(), error) {
+	events := make(chan libusb.DeviceEvent, len(rp.devices))
+	if filter != nil && filter.Enumerate {
+		for _, s := range rp.devices {
+			if !filter.Match(s.dd) {
+				continue
+			}
+			path := make([]byte, len(s.info.Port_Path))
+			for i, p := range s.info.Port_Path {
+				path[i] = byte(p)
+			}
+			events <- libusb.DeviceEvent{
+				Event:      libusb.HOTPLUG_EVENT_DEVICE_ARRIVED,
+				Bus:        s.info.Bus,
+				Address:    s.info.Address,
+				Path:       path,
+				Descriptor: s.dd,
+			}
+		}
+	}
+	var once sync.Once
+	return events, func() { once.Do(func() { close(events) }) }, nil
+}
+
+func (rp *Replayer) Close() error {
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// rep_device is a recorded device.
+type rep_device struct {
+	rp *Replayer
+	s  *rep_state
+}
+
+func (d *rep_device) Descriptor() (*libusb.Device_Descriptor, error) {
+	return d.s.dd, nil
+}
+
+func (d *rep_device) Config(index uint8) (*libusb.Config_Descriptor, error) {
+	if int(index) >= len(d.s.configs) {
+		return nil, &libusb.Error{Code: libusb.ERROR_NOT_FOUND, Op: "Config", Endpoint: -1}
+	}
+	return d.s.configs[index], nil
+}
+
+func (d *rep_device) ActiveConfig() (*libusb.Config_Descriptor, error) {
+	for _, cd := range d.s.configs {
+		if int(cd.BConfigurationValue) == d.s.info.Config {
+			return cd, nil
+		}
+	}
+	return nil, &libusb.Error{Code: libusb.ERROR_NOT_FOUND, Op: "ActiveConfig", Endpoint: -1}
+}
+
+func (d *rep_device) Bus() uint8 {
+	return d.s.info.Bus
+}
+
+func (d *rep_device) Address() uint8 {
+	return d.s.info.Address
+}
+
+func (d *rep_device) PortPath() ([]byte, error) {
+	path := make([]byte, len(d.s.info.Port_Path))
+	for i, p := range d.s.info.Port_Path {
+		path[i] = byte(p)
+	}
+	return path, nil
+}
+
+func (d *rep_device) Speed() libusb.Speed {
+	return libusb.Speed(d.s.info.Speed)
+}
+
+func (d *rep_device) Serial() (string, error) {
+	x := d.s.serial
+	if x == nil {
+		return "", &libusb.Error{Code: libusb.ERROR_NOT_FOUND, Op: OP_SERIAL, Endpoint: -1}
+	}
+	return x.Serial, x.error(-1)
+}
+
+// the parent of a device is not recorded
+func (d *rep_device) Parent() usb.BackendDevice {
+	return nil
+}
+
+func (d *rep_device) Ref() usb.BackendDevice {
+	return &rep_device{rp: d.rp, s: d.s}
+}
+
+func (d *rep_device) Open() (usb.BackendHandle, error) {
+	x, err := d.rp.call(d.s, &Record{Device: d.s.id, Op: OP_OPEN})
+	if err != nil {
+		return nil, err
+	}
+	if err := x.error(-1); err != nil {
+		return nil, err
+	}
+	return &rep_handle{rp: d.rp, s: d.s}, nil
+}
+
+func (d *rep_device) Close() error {
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// rep_handle is a handle to a recorded device.
+type rep_handle struct {
+	rp *Replayer
+	s  *rep_state
+}
+
+// replay a call returning only an error
+func (h *rep_handle) call(op string, x *Record) error {
+	x.Device = h.s.id
+	x.Op = op
+	y, err := h.rp.call(h.s, x)
+	if err != nil {
+		return err
+	}
+	endpoint := -1
+	if op == OP_CLEAR_HALT {
+		endpoint = int(x.Endpoint)
+	}
+	return y.error(endpoint)
+}
+
+func (h *rep_handle) Claim(n int) error {
+	return h.call(OP_CLAIM, &Record{Value: n})
+}
+
+func (h *rep_handle) Release(n int) error {
+	return h.call(OP_RELEASE, &Record{Value: n})
+}
+
+func (h *rep_handle) Configuration() (int, error) {
+	y, err := h.rp.call(h.s, &Record{Device: h.s.id, Op: OP_CONFIGURATION})
+	if err != nil {
+		return 0, err
+	}
+	return y.Value, y.error(-1)
+}
+
+func (h *rep_handle) SetConfiguration(cfg int) error {
+	return h.call(OP_SET_CONFIGURATION, &Record{Value: cfg})
+}
+
+func (h *rep_handle) SetAltSetting(n int, alt int) error {
+	return h.call(OP_SET_ALT_SETTING, &Record{Value: n, Alt: alt})
+}
+
+func (h *rep_handle) ClearHalt(endpoint uint8) error {
+	return h.call(OP_CLEAR_HALT, &Record{Endpoint: endpoint})
+}
+
+func (h *rep_handle) Reset() error {
+	return h.call(OP_RESET, &Record{})
+}
+
+func (h *rep_handle) SetAutoDetachKernelDriver(enable bool) error {
+	x := &Record{}
+	if enable {
+		x.Value = 1
+	}
+	return h.call(OP_AUTO_DETACH, x)
+}
+
+// replay a transfer
+func (h *rep_handle) transfer(op string, x *Record, endpoint int, in bool, data []byte) ([]byte, error) {
+	x.Device = h.s.id
+	x.Op = op
+	if in {
+		x.Length = len(data)
+	} else {
+		x.Data = append(hex_bytes(nil), data...)
+	}
+	y, err := h.rp.call(h.s, x)
+	if err != nil {
+		return nil, err
+	}
+	if err := y.error(endpoint); err != nil {
+		return nil, err
+	}
+	if in {
+		n := copy(data, y.Data)
+		return data[:n], nil
+	}
+	// the recorded length sent, a short write replays as a short write
+	if y.Sent < len(data) {
+		return data[:y.Sent], nil
+	}
+	return data, nil
+}
+
+func (h *rep_handle) Control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout time.Duration) ([]byte, error) {
+	x := &Record{Setup: &Setup{bmRequestType, bRequest, wValue, wIndex, uint16(len(data))}}
+	return h.transfer(OP_CONTROL, x, -1, bmRequestType&libusb.ENDPOINT_IN != 0, data)
+}
+
+func (h *rep_handle) Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer(OP_BULK, &Record{Endpoint: endpoint}, int(endpoint), endpoint&libusb.ENDPOINT_IN != 0, data)
+}
+
+func (h *rep_handle) Interrupt(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer(OP_INTERRUPT, &Record{Endpoint: endpoint}, int(endpoint), endpoint&libusb.ENDPOINT_IN != 0, data)
+}
+
+func (h *rep_handle) Close() error {
+	return h.call(OP_CLOSE, &Record{})
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,196 @@
+//-----------------------------------------------------------------------------
+/*
+
+Test functions for record and replay
+
+*/
+//-----------------------------------------------------------------------------
+
+package usbreplay
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/usb"
+	"github.com/deadsy/libusb/usb/usbtest"
+)
+
+//-----------------------------------------------------------------------------
+
+// return an instrument with a bulk IN/OUT endpoint pair
+func new_instrument() *usbtest.Device {
+	d := usbtest.NewBulkDevice(0x0483, 0x5740, libusb.SPEED_FULL, "", "Meter", "M0042")
+	d.PortPath = []byte{2}
+	return d
+}
+
+// a driver session: identify the instrument and take a reading
+func session(ctx *usb.Context) (string, error) {
+	h, err := ctx.OpenBySerial(0x0483, 0x5740, "M0042")
+	if err != nil {
+		return "", err
+	}
+	defer h.Close()
+	name, err := h.StringDescriptorASCII(2)
+	if err != nil {
+		return "", err
+	}
+	err = h.Claim(0)
+	if err != nil {
+		return "", err
+	}
+	defer h.Release(0)
+	_, err = h.Bulk(0x01, []byte("READ?"), time.Second)
+	if err != nil {
+		return "", err
+	}
+	buf, err := h.Bulk(0x81, make([]byte, 64), time.Second)
+	if err != nil {
+		return "", err
+	}
+	// the second reading stalls
+	_, err = h.Bulk(0x81, make([]byte, 64), time.Second)
+	if !errors.Is(err, libusb.ErrPipe) {
+		return "", err
+	}
+	err = h.ClearHalt(0x81)
+	if err != nil {
+		return "", err
+	}
+	return name + " " + string(buf), nil
+}
+
+// record a session against the in-memory backend
+func record(t *testing.T) []byte {
+	b := usbtest.NewBackend()
+	d := new_instrument()
+	d.Queue(0x81, usbtest.Response{Data: []byte("1.234")}, usbtest.Response{Err: libusb.ErrPipe})
+	b.Add(d)
+	var log bytes.Buffer
+	r := NewRecorder(b, &log)
+	ctx := usb.NewContextWithBackend(r)
+	s, err := session(ctx)
+	ctx.Close()
+	if err != nil || s != "Meter 1.234" || r.Err() != nil {
+		t.Fatal("FAIL", s, err)
+	}
+	return log.Bytes()
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Replay(t *testing.T) {
+	log := record(t)
+	rp, err := NewReplayer(bytes.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := usb.NewContextWithBackend(rp)
+	defer ctx.Close()
+	s, err := session(ctx)
+	if err != nil || s != "Meter 1.234" {
+		t.Error("FAIL", s, err)
+	}
+	if err := rp.Check(); err != nil {
+		t.Error(err)
+	}
+
+	devices, err := ctx.Devices()
+	if err != nil || len(devices) != 1 {
+		t.Fatal("FAIL")
+	}
+	defer usb.CloseDevices(devices)
+	d := devices[0]
+	if d.Port() != 2 || d.Speed() != libusb.SPEED_FULL {
+		t.Error("FAIL")
+	}
+	if n, err := d.MaxPacketSize(0x81); err != nil || n != 64 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Short_Write(t *testing.T) {
+	// the OUT transfer sent all of its data, edit the log to send part of it
+	log := record(t)
+	if bytes.Count(log, []byte(`"sent":5`)) != 1 {
+		t.Fatal("FAIL")
+	}
+	log = bytes.Replace(log, []byte(`"sent":5`), []byte(`"sent":2`), 1)
+	rp, err := NewReplayer(bytes.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := usb.NewContextWithBackend(rp)
+	defer ctx.Close()
+	h, err := ctx.OpenBySerial(0x0483, 0x5740, "M0042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.StringDescriptorASCII(2)
+	h.Claim(0)
+	buf, err := h.Bulk(0x01, []byte("READ?"), time.Second)
+	if err != nil || string(buf) != "RE" {
+		t.Error("FAIL", buf, err)
+	}
+}
+
+func Test_Mismatch(t *testing.T) {
+	log := record(t)
+
+	// different OUT data
+	rp, err := NewReplayer(bytes.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := usb.NewContextWithBackend(rp)
+	defer ctx.Close()
+	h, err := ctx.OpenBySerial(0x0483, 0x5740, "M0042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.StringDescriptorASCII(2)
+	h.Claim(0)
+	_, err = h.Bulk(0x01, []byte("RESET"), time.Second)
+	if !errors.Is(err, libusb.ErrOther) {
+		t.Error("FAIL")
+	}
+	h.Close()
+	var e *Mismatch_Error
+	if !errors.As(rp.Check(), &e) || e.Expect == nil || e.Expect.Op != OP_BULK {
+		t.Error("FAIL", rp.Check())
+	}
+
+	// calls not made
+	rp, err = NewReplayer(bytes.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = usb.NewContextWithBackend(rp)
+	defer ctx.Close()
+	h, err = ctx.OpenBySerial(0x0483, 0x5740, "M0042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Check(); err == nil || !strings.Contains(err.Error(), "not made") {
+		t.Error("FAIL", err)
+	}
+	h.Close()
+
+	// bad logs
+	_, err = NewReplayer(strings.NewReader(`{"dev":1,"op":"open"}`))
+	if err == nil {
+		t.Error("FAIL")
+	}
+	_, err = NewReplayer(strings.NewReader(`not json`))
+	if err == nil {
+		t.Error("FAIL")
+	}
+}
+
+//-----------------------------------------------------------------------------
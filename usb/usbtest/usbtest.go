@@ -0,0 +1,866 @@
+//-----------------------------------------------------------------------------
+/*
+
+In-memory backend for testing
+
+Tests declare devices from descriptors, or start from NewBulkDevice, add
+them to a Backend, and use it with usb.NewContextWithBackend, or with
+libusb.Init_Backend for code written against the libusb functions. Transfers
+are answered from responses scripted per endpoint, errors such as
+libusb.ErrPipe or libusb.ErrTimeout are injected with a response. Adding and
+removing devices is reported to watchers as hotplug events, and handles to a
+removed device fail with libusb.ErrNoDevice.
+
+Standard control requests to the device (GET_DESCRIPTOR, GET_CONFIGURATION,
+...) are answered from the descriptors, with IN data truncated to wLength.
+Class and vendor requests, and standard requests to an interface or
+endpoint, e.g. reading a HID report descriptor, are passed to the Control
+handler of the device, or answered from the responses queued on endpoint 0.
+An IN transfer with no queued response times out, an OUT transfer with no
+queued response succeeds.
+
+*/
+//-----------------------------------------------------------------------------
+
+// Package usbtest provides an in-memory usb.Backend.
+package usbtest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/descriptor"
+	"github.com/deadsy/libusb/usb"
+)
+
+//-----------------------------------------------------------------------------
+
+// Setup is the setup packet of a control transfer.
+type Setup struct {
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+	Length      uint16
+}
+
+// Response is a scripted transfer result.
+type Response struct {
+	Data  []byte        // data returned by an IN transfer
+	Err   error         // error returned by the transfer, e.g. libusb.ErrPipe
+	Delay time.Duration // time taken by the device, longer than the transfer timeout times out
+}
+
+// Transfer is a record of a transfer made to a device.
+type Transfer struct {
+	Endpoint uint8  // endpoint address, 0 for control transfers
+	Setup    *Setup // control transfers only
+	Data     []byte // data written by an OUT transfer or read by an IN transfer
+	Err      error
+}
+
+// Device is a fake device. Set the fields before adding it to a backend,
+// they should not be changed afterwards. The descriptors are completed
+// with their lengths and counts when the device is added.
+type Device struct {
+	Bus        uint8  // bus number, 1 if not set
+	Address    uint8  // device address, assigned if not set
+	PortPath   []byte // port numbers from the root hub
+	Speed      libusb.Speed
+	Descriptor *libusb.Device_Descriptor
+	Configs    []*libusb.Config_Descriptor
+	BOS        *libusb.BOS_Descriptor
+	Languages  []uint16         // supported language ids, LANGID_ENGLISH_US if not set
+	Strings    map[uint8]string // string descriptors by index, the same in all languages
+	Parent     *Device          // parent hub, nil for a root hub
+	OpenErr    error            // error returned by Open, e.g. libusb.ErrAccess
+	// Control handles class and vendor control requests. If nil the
+	// responses queued on endpoint 0 are used.
+	Control func(setup *Setup, data []byte) ([]byte, error)
+
+	mu        sync.Mutex
+	attached  bool
+	config    int                 // active configuration value, 0 if unconfigured
+	alt       map[int]int         // alternate setting by interface
+	claimed   map[int]*dev_handle // claiming handle by interface
+	responses map[uint8][]Response
+	transfers []Transfer
+}
+
+// Queue adds responses for the transfers on an endpoint.
+// Responses for endpoint 0 answer class and vendor control requests.
+func (d *Device) Queue(endpoint uint8, responses ...Response) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.responses == nil {
+		d.responses = make(map[uint8][]Response)
+	}
+	d.responses[endpoint] = append(d.responses[endpoint], responses...)
+}
+
+// Pending returns the number of queued responses that have not been used.
+func (d *Device) Pending(endpoint uint8) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.responses[endpoint])
+}
+
+// Transfers returns the transfers made to the device, oldest first.
+// Standard control requests are not recorded.
+func (d *Device) Transfers() []Transfer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Transfer(nil), d.transfers...)
+}
+
+// Attached reports whether the device is in a backend.
+func (d *Device) Attached() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.attached
+}
+
+// fill in the defaults and descriptor lengths
+func (d *Device) complete() {
+	if d.Bus == 0 {
+		d.Bus = 1
+	}
+	dd := d.Descriptor
+	dd.BLength = libusb.DT_DEVICE_SIZE
+	dd.BDescriptorType = libusb.DT_DEVICE
+	dd.BNumConfigurations = uint8(len(d.Configs))
+	if dd.BMaxPacketSize0 == 0 {
+		dd.BMaxPacketSize0 = 64
+	}
+	for i, cd := range d.Configs {
+		if cd.BConfigurationValue == 0 {
+			cd.BConfigurationValue = uint8(i + 1)
+		}
+		descriptor.Complete_Config_Descriptor(cd)
+	}
+	if d.BOS != nil {
+		descriptor.Complete_BOS_Descriptor(d.BOS)
+	}
+	if len(d.Languages) == 0 {
+		d.Languages = []uint16{descriptor.LANGID_ENGLISH_US}
+	}
+	if len(d.Configs) != 0 {
+		d.config = int(d.Configs[0].BConfigurationValue)
+	}
+	d.alt = make(map[int]int)
+	d.claimed = make(map[int]*dev_handle)
+	if d.responses == nil {
+		d.responses = make(map[uint8][]Response)
+	}
+}
+
+// return the active configuration, nil if unconfigured
+func (d *Device) active_config() *libusb.Config_Descriptor {
+	for _, cd := range d.Configs {
+		if int(cd.BConfigurationValue) == d.config {
+			return cd
+		}
+	}
+	return nil
+}
+
+// return the alternate setting descriptor of an interface in the active configuration
+func (d *Device) altsetting(n int, alt int) *libusb.Interface_Descriptor {
+	cd := d.active_config()
+	if cd == nil {
+		return nil
+	}
+	for _, itf := range cd.Interface {
+		for _, id := range itf.Altsetting {
+			if int(id.BInterfaceNumber) == n && int(id.BAlternateSetting) == alt {
+				return id
+			}
+		}
+	}
+	return nil
+}
+
+// return the event for the device
+func (d *Device) event(event int) libusb.DeviceEvent {
+	return libusb.DeviceEvent{
+		Event:      event,
+		Bus:        d.Bus,
+		Address:    d.Address,
+		Path:       append([]byte(nil), d.PortPath...),
+		Descriptor: d.Descriptor,
+	}
+}
+
+// NewBulkDevice returns a vendor specific device with one interface and a
+// pair of bulk endpoints, 0x81 IN and 0x01 OUT, sized for the speed.
+// Strings 1, 2 and 3 are the manufacturer, product and serial number,
+// empty strings are left out. Set other fields before adding the device.
+func NewBulkDevice(vid, pid uint16, speed libusb.Speed, manufacturer, product, serial string) *Device {
+	mps := uint16(64)
+	switch speed {
+	case libusb.SPEED_HIGH:
+		mps = 512
+	case libusb.SPEED_SUPER:
+		mps = 1024
+	}
+	d := &Device{
+		Speed:      speed,
+		Descriptor: &libusb.Device_Descriptor{BcdUSB: 0x0200, IdVendor: vid, IdProduct: pid},
+		Configs: []*libusb.Config_Descriptor{{
+			Interface: []*libusb.Interface{{
+				Altsetting: []*libusb.Interface_Descriptor{{
+					BInterfaceClass: uint8(libusb.CLASS_VENDOR_SPEC),
+					Endpoint: []*libusb.Endpoint_Descriptor{
+						{BEndpointAddress: 0x81, BmAttributes: 0x02, WMaxPacketSize: mps},
+						{BEndpointAddress: 0x01, BmAttributes: 0x02, WMaxPacketSize: mps},
+					},
+				}},
+			}},
+		}},
+		Strings: make(map[uint8]string),
+	}
+	for i, p := range []*uint8{&d.Descriptor.IManufacturer, &d.Descriptor.IProduct, &d.Descriptor.ISerialNumber} {
+		str := []string{manufacturer, product, serial}[i]
+		if str != "" {
+			*p = uint8(i + 1)
+			d.Strings[uint8(i+1)] = str
+		}
+	}
+	return d
+}
+
+//-----------------------------------------------------------------------------
+
+// Backend is an in-memory usb.Backend.
+type Backend struct {
+	mu       sync.Mutex
+	devices  []*Device
+	watchers map[*watcher]bool
+}
+
+// NewBackend returns a backend with no devices.
+func NewBackend() *Backend {
+	return &Backend{watchers: make(map[*watcher]bool)}
+}
+
+// Add attaches devices to the backend and reports them to watchers.
+func (b *Backend) Add(devices ...*Device) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range devices {
+		d.mu.Lock()
+		d.complete()
+		if d.Address == 0 {
+			d.Address = b.next_address(d.Bus)
+		}
+		d.attached = true
+		d.mu.Unlock()
+		b.devices = append(b.devices, d)
+		b.notify(d, libusb.HOTPLUG_EVENT_DEVICE_ARRIVED)
+	}
+}
+
+// Remove detaches devices from the backend and reports them to watchers.
+// Open handles to the devices fail with libusb.ErrNoDevice.
+func (b *Backend) Remove(devices ...*Device) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range devices {
+		for i, x := range b.devices {
+			if x == d {
+				b.devices = append(b.devices[:i], b.devices[i+1:]...)
+				break
+			}
+		}
+		d.mu.Lock()
+		d.attached = false
+		d.claimed = make(map[int]*dev_handle)
+		d.mu.Unlock()
+		b.notify(d, libusb.HOTPLUG_EVENT_DEVICE_LEFT)
+	}
+}
+
+// return an unused address on a bus
+func (b *Backend) next_address(bus uint8) uint8 {
+	used := make(map[uint8]bool)
+	for _, d := range b.devices {
+		if d.Bus == bus {
+			used[d.Address] = true
+		}
+	}
+	addr := uint8(1)
+	for used[addr] {
+		addr++
+	}
+	return addr
+}
+
+// Devices returns the attached devices.
+func (b *Backend) Devices() ([]usb.BackendDevice, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	devices := make([]usb.BackendDevice, len(b.devices))
+	for i, d := range b.devices {
+		devices[i] = &dev_ref{d}
+	}
+	return devices, nil
+}
+
+// Close stops any watchers.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for w := range b.watchers {
+		w.close()
+	}
+	b.watchers = make(map[*watcher]bool)
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// hotplug
+
+// Size of the event channel buffer. Events are queued without limit behind it,
+// so Add and Remove don't wait for the watchers.
+const watch_queue_size = 64
+
+type watcher struct {
+	filter libusb.WatchFilter
+	events chan libusb.DeviceEvent
+	mu     sync.Mutex
+	queue  []libusb.DeviceEvent // events not yet on the channel
+	wake   chan struct{}        // an event has been queued
+	done   chan struct{}        // the watch has been stopped
+	closed bool
+}
+
+func new_watcher(filter *libusb.WatchFilter) *watcher {
+	w := &watcher{
+		events: make(chan libusb.DeviceEvent, watch_queue_size),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if filter != nil {
+		w.filter = *filter
+	}
+	go w.run()
+	return w
+}
+
+// queue an event for the device, it doesn't block
+func (w *watcher) send(d *Device, event int) {
+	if !w.filter.Match(d.Descriptor) {
+		return
+	}
+	w.mu.Lock()
+	w.queue = append(w.queue, d.event(event))
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take the queued events
+func (w *watcher) take() []libusb.DeviceEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	q := w.queue
+	w.queue = nil
+	return q
+}
+
+// move queued events to the channel until stopped
+func (w *watcher) run() {
+	defer close(w.events)
+	for {
+		for q := w.take(); len(q) != 0; q = q[1:] {
+			select {
+			case w.events <- q[0]:
+			case <-w.done:
+				w.flush(q)
+				return
+			}
+		}
+		select {
+		case <-w.wake:
+		case <-w.done:
+			w.flush(nil)
+			return
+		}
+	}
+}
+
+// put what fits of the remaining events in the channel buffer
+func (w *watcher) flush(q []libusb.DeviceEvent) {
+	for _, e := range append(q, w.take()...) {
+		select {
+		case w.events <- e:
+		default:
+			return
+		}
+	}
+}
+
+// stop the watcher, the events channel is closed once the queue is flushed
+func (w *watcher) close() {
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
+}
+
+// report a device event to the watchers
+func (b *Backend) notify(d *Device, event int) {
+	for w := range b.watchers {
+		w.send(d, event)
+	}
+}
+
+// Watch reports devices added to and removed from the backend, see libusb.Watch.
+// Stopping the watch closes the channel after the pending events that fit in its buffer.
+func (b *Backend) Watch(filter *libusb.WatchFilter) (<-chan libusb.DeviceEvent, func(), error) {
+	w := new_watcher(filter)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if w.filter.Enumerate {
+		for _, d := range b.devices {
+			w.send(d, libusb.HOTPLUG_EVENT_DEVICE_ARRIVED)
+		}
+	}
+	b.watchers[w] = true
+	stop := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.watchers, w)
+		w.close()
+	}
+	return w.events, stop, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// return an error for a failed operation
+func new_error(code int, op string, endpoint int) error {
+	return &libusb.Error{Code: code, Op: op, Endpoint: endpoint}
+}
+
+// return a scripted error with the operation and endpoint filled in
+func transfer_error(err error, op string, endpoint int) error {
+	var e *libusb.Error
+	if errors.As(err, &e) && e.Op == "" {
+		return new_error(e.Code, op, endpoint)
+	}
+	return err
+}
+
+//-----------------------------------------------------------------------------
+
+// dev_ref is a usb.BackendDevice for a fake device.
+type dev_ref struct {
+	d *Device
+}
+
+func (r *dev_ref) Descriptor() (*libusb.Device_Descriptor, error) {
+	return r.d.Descriptor, nil
+}
+
+func (r *dev_ref) Config(index uint8) (*libusb.Config_Descriptor, error) {
+	if int(index) >= len(r.d.Configs) {
+		return nil, new_error(libusb.ERROR_NOT_FOUND, "Config", -1)
+	}
+	return r.d.Configs[index], nil
+}
+
+func (r *dev_ref) ActiveConfig() (*libusb.Config_Descriptor, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	cd := r.d.active_config()
+	if cd == nil {
+		return nil, new_error(libusb.ERROR_NOT_FOUND, "ActiveConfig", -1)
+	}
+	return cd, nil
+}
+
+func (r *dev_ref) Bus() uint8 {
+	return r.d.Bus
+}
+
+func (r *dev_ref) Address() uint8 {
+	return r.d.Address
+}
+
+func (r *dev_ref) PortPath() ([]byte, error) {
+	return append([]byte(nil), r.d.PortPath...), nil
+}
+
+func (r *dev_ref) Speed() libusb.Speed {
+	return r.d.Speed
+}
+
+func (r *dev_ref) Serial() (string, error) {
+	return r.d.Strings[r.d.Descriptor.ISerialNumber], nil
+}
+
+func (r *dev_ref) Parent() usb.BackendDevice {
+	if r.d.Parent == nil {
+		return nil
+	}
+	return &dev_ref{r.d.Parent}
+}
+
+func (r *dev_ref) Ref() usb.BackendDevice {
+	return &dev_ref{r.d}
+}
+
+func (r *dev_ref) Open() (usb.BackendHandle, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	if !r.d.attached {
+		return nil, new_error(libusb.ERROR_NO_DEVICE, "Open", -1)
+	}
+	if r.d.OpenErr != nil {
+		return nil, transfer_error(r.d.OpenErr, "Open", -1)
+	}
+	return &dev_handle{d: r.d}, nil
+}
+
+func (r *dev_ref) Close() error {
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+
+// dev_handle is a usb.BackendHandle for a fake device.
+type dev_handle struct {
+	d      *Device
+	closed bool
+}
+
+// lock the device and check it is still attached, the caller must unlock
+func (h *dev_handle) lock(op string, endpoint int) error {
+	h.d.mu.Lock()
+	if h.closed {
+		h.d.mu.Unlock()
+		return new_error(libusb.ERROR_INVALID_PARAM, op, endpoint)
+	}
+	if !h.d.attached {
+		h.d.mu.Unlock()
+		return new_error(libusb.ERROR_NO_DEVICE, op, endpoint)
+	}
+	return nil
+}
+
+func (h *dev_handle) Claim(n int) error {
+	if err := h.lock("Claim", -1); err != nil {
+		return err
+	}
+	defer h.d.mu.Unlock()
+	if h.d.altsetting(n, 0) == nil {
+		return new_error(libusb.ERROR_NOT_FOUND, "Claim", -1)
+	}
+	if x, ok := h.d.claimed[n]; ok && x != h {
+		return new_error(libusb.ERROR_BUSY, "Claim", -1)
+	}
+	h.d.claimed[n] = h
+	return nil
+}
+
+func (h *dev_handle) Release(n int) error {
+	if err := h.lock("Release", -1); err != nil {
+		return err
+	}
+	defer h.d.mu.Unlock()
+	if h.d.claimed[n] != h {
+		return new_error(libusb.ERROR_NOT_FOUND, "Release", -1)
+	}
+	delete(h.d.claimed, n)
+	delete(h.d.alt, n)
+	return nil
+}
+
+func (h *dev_handle) Configuration() (int, error) {
+	if err := h.lock("Configuration", -1); err != nil {
+		return 0, err
+	}
+	defer h.d.mu.Unlock()
+	return h.d.config, nil
+}
+
+// set the configuration with the device locked
+func (h *dev_handle) set_configuration(cfg int) error {
+	if len(h.d.claimed) != 0 {
+		return new_error(libusb.ERROR_BUSY, "SetConfiguration", -1)
+	}
+	for _, cd := range h.d.Configs {
+		if int(cd.BConfigurationValue) == cfg {
+			h.d.config = cfg
+			h.d.alt = make(map[int]int)
+			return nil
+		}
+	}
+	if cfg <= 0 {
+		h.d.config = 0
+		return nil
+	}
+	return new_error(libusb.ERROR_NOT_FOUND, "SetConfiguration", -1)
+}
+
+func (h *dev_handle) SetConfiguration(cfg int) error {
+	if err := h.lock("SetConfiguration", -1); err != nil {
+		return err
+	}
+	defer h.d.mu.Unlock()
+	return h.set_configuration(cfg)
+}
+
+func (h *dev_handle) SetAltSetting(n int, alt int) error {
+	if err := h.lock("SetAltSetting", -1); err != nil {
+		return err
+	}
+	defer h.d.mu.Unlock()
+	if h.d.claimed[n] != h || h.d.altsetting(n, alt) == nil {
+		return new_error(libusb.ERROR_NOT_FOUND, "SetAltSetting", -1)
+	}
+	h.d.alt[n] = alt
+	return nil
+}
+
+func (h *dev_handle) ClearHalt(endpoint uint8) error {
+	if err := h.lock("ClearHalt", int(endpoint)); err != nil {
+		return err
+	}
+	h.d.mu.Unlock()
+	return nil
+}
+
+func (h *dev_handle) Reset() error {
+	if err := h.lock("Reset", -1); err != nil {
+		return err
+	}
+	defer h.d.mu.Unlock()
+	h.d.alt = make(map[int]int)
+	return nil
+}
+
+func (h *dev_handle) SetAutoDetachKernelDriver(enable bool) error {
+	if err := h.lock("SetAutoDetachKernelDriver", -1); err != nil {
+		return err
+	}
+	h.d.mu.Unlock()
+	return nil
+}
+
+func (h *dev_handle) Close() error {
+	h.d.mu.Lock()
+	defer h.d.mu.Unlock()
+	for n, x := range h.d.claimed {
+		if x == h {
+			delete(h.d.claimed, n)
+		}
+	}
+	h.closed = true
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// transfers
+
+// return the next response for an endpoint, false if none is queued
+func (d *Device) next_response(endpoint uint8) (Response, bool) {
+	q := d.responses[endpoint]
+	if len(q) == 0 {
+		return Response{}, false
+	}
+	d.responses[endpoint] = q[1:]
+	return q[0], true
+}
+
+// wait for the response delay, returning true if the transfer times out
+func wait(delay time.Duration, timeout time.Duration) bool {
+	if timeout > 0 && delay > timeout {
+		time.Sleep(timeout)
+		return true
+	}
+	time.Sleep(delay)
+	return false
+}
+
+// copy control response data to an IN buffer, the device sends at most wLength bytes
+func control_response(r Response, data []byte) []byte {
+	return data[:copy(data, r.Data)]
+}
+
+// copy response data to an IN buffer
+func read_response(r Response, data []byte) ([]byte, error) {
+	n := copy(data, r.Data)
+	if len(r.Data) > len(data) {
+		return data[:n], new_error(libusb.ERROR_OVERFLOW, "", -1)
+	}
+	return data[:n], nil
+}
+
+// perform a bulk or interrupt transfer
+func (h *dev_handle) transfer(op string, endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	if err := h.lock(op, int(endpoint)); err != nil {
+		return nil, err
+	}
+	r, ok := h.d.next_response(endpoint)
+	h.d.mu.Unlock()
+
+	in := endpoint&libusb.ENDPOINT_IN != 0
+	var err error
+	switch {
+	case !ok && in:
+		wait(timeout, timeout)
+		err = libusb.ErrTimeout
+	case ok && wait(r.Delay, timeout):
+		err = libusb.ErrTimeout
+	case ok && r.Err != nil:
+		err = r.Err
+	case in:
+		data, err = read_response(r, data)
+	}
+	if err != nil {
+		err = transfer_error(err, op, int(endpoint))
+		if !in || errors.Is(err, libusb.ErrOverflow) {
+			data = nil
+		}
+	}
+
+	h.d.mu.Lock()
+	t := Transfer{Endpoint: endpoint, Err: err}
+	if err == nil || in {
+		t.Data = append([]byte(nil), data...)
+	}
+	h.d.transfers = append(h.d.transfers, t)
+	h.d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (h *dev_handle) Bulk(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer("Bulk", endpoint, data, timeout)
+}
+
+func (h *dev_handle) Interrupt(endpoint uint8, data []byte, timeout time.Duration) ([]byte, error) {
+	return h.transfer("Interrupt", endpoint, data, timeout)
+}
+
+//-----------------------------------------------------------------------------
+// control transfers
+
+// request type and recipient bits of bmRequestType
+const (
+	request_type_mask = 0x60
+	recipient_mask    = 0x1f
+)
+
+// return true if a language id is supported
+func has_language(langids []uint16, langid uint16) bool {
+	for _, x := range langids {
+		if x == langid {
+			return true
+		}
+	}
+	return false
+}
+
+// answer a standard request with the device locked, false if it is not a standard request
+func (h *dev_handle) standard_request(s *Setup) ([]byte, bool, error) {
+	d := h.d
+	if s.RequestType&request_type_mask != libusb.REQUEST_TYPE_STANDARD || s.RequestType&recipient_mask != libusb.RECIPIENT_DEVICE {
+		return nil, false, nil
+	}
+	switch s.Request {
+	case libusb.REQUEST_GET_DESCRIPTOR:
+		index := uint8(s.Value)
+		switch uint8(s.Value >> 8) {
+		case libusb.DT_DEVICE:
+			return descriptor.Encode_Device_Descriptor(d.Descriptor), true, nil
+		case libusb.DT_CONFIG:
+			if int(index) < len(d.Configs) {
+				return descriptor.Encode_Config_Descriptor(d.Configs[index]), true, nil
+			}
+		case libusb.DT_BOS:
+			if d.BOS != nil {
+				return descriptor.Encode_BOS_Descriptor(d.BOS), true, nil
+			}
+		case libusb.DT_STRING:
+			if index == 0 {
+				return descriptor.Encode_Language_Descriptor(d.Languages), true, nil
+			}
+			str, ok := d.Strings[index]
+			if ok && has_language(d.Languages, s.Index) {
+				return descriptor.Encode_String_Descriptor(str), true, nil
+			}
+		}
+	case libusb.REQUEST_GET_CONFIGURATION:
+		return []byte{uint8(d.config)}, true, nil
+	case libusb.REQUEST_SET_CONFIGURATION:
+		return nil, true, h.set_configuration(int(s.Value))
+	case libusb.REQUEST_GET_STATUS:
+		return []byte{0, 0}, true, nil
+	}
+	return nil, true, libusb.ErrPipe
+}
+
+func (h *dev_handle) Control(bmRequestType uint8, bRequest uint8, wValue uint16, wIndex uint16, data []byte, timeout time.Duration) ([]byte, error) {
+	s := &Setup{bmRequestType, bRequest, wValue, wIndex, uint16(len(data))}
+	in := bmRequestType&libusb.ENDPOINT_IN != 0
+	if err := h.lock("Control", 0); err != nil {
+		return nil, err
+	}
+	buf, ok, err := h.standard_request(s)
+	var r Response
+	var queued bool
+	if !ok && h.d.Control == nil {
+		r, queued = h.d.next_response(0)
+	}
+	handler := h.d.Control
+	h.d.mu.Unlock()
+	if ok {
+		if err != nil {
+			return nil, transfer_error(err, "Control", 0)
+		}
+		if in {
+			return control_response(Response{Data: buf}, data), nil
+		}
+		return data, nil
+	}
+
+	switch {
+	case handler != nil:
+		r.Data, r.Err = handler(s, data)
+	case !queued:
+		// an unsupported request stalls
+		r.Err = libusb.ErrPipe
+	case wait(r.Delay, timeout):
+		r.Err = libusb.ErrTimeout
+	}
+	if r.Err == nil {
+		if in {
+			data = control_response(r, data)
+		}
+	} else {
+		err = r.Err
+	}
+	if err != nil {
+		err = transfer_error(err, "Control", 0)
+		data = nil
+	}
+
+	h.d.mu.Lock()
+	h.d.transfers = append(h.d.transfers, Transfer{Setup: s, Data: append([]byte(nil), data...), Err: err})
+	h.d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,374 @@
+//-----------------------------------------------------------------------------
+/*
+
+Test functions for the in-memory backend
+
+*/
+//-----------------------------------------------------------------------------
+
+package usbtest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deadsy/libusb"
+	"github.com/deadsy/libusb/descriptor"
+	"github.com/deadsy/libusb/usb"
+)
+
+//-----------------------------------------------------------------------------
+
+// return a device with one interface and a bulk IN/OUT endpoint pair
+func new_programmer(serial string, port uint8) *Device {
+	d := NewBulkDevice(0x1d50, 0x6018, libusb.SPEED_HIGH, "Acme", "書き込み装置", serial)
+	d.PortPath = []byte{1, port}
+	d.Languages = []uint16{descriptor.LANGID_ENGLISH_US, descriptor.LANGID_JAPANESE}
+	return d
+}
+
+// return a context for a backend with the leak detector enabled
+func new_context(t *testing.T, b *Backend) *usb.Context {
+	usb.EnableLeakCheck(true)
+	t.Cleanup(func() {
+		usb.EnableLeakCheck(false)
+		for _, s := range usb.Leaks() {
+			t.Error(s)
+		}
+	})
+	return usb.NewContextWithBackend(b)
+}
+
+//-----------------------------------------------------------------------------
+
+func Test_Enumerate(t *testing.T) {
+	b := NewBackend()
+	b.Add(new_programmer("A1", 1), new_programmer("A2", 2))
+	ctx := new_context(t, b)
+	defer ctx.Close()
+
+	devices, err := ctx.Devices()
+	if err != nil || len(devices) != 2 {
+		t.Fatal("FAIL")
+	}
+	defer usb.CloseDevices(devices)
+	d := devices[1]
+	if d.Bus() != 1 || d.Address() != 2 || d.Port() != 2 || d.Speed() != libusb.SPEED_HIGH {
+		t.Error("FAIL")
+	}
+	if n, err := d.MaxPacketSize(0x81); err != nil || n != 512 {
+		t.Error("FAIL")
+	}
+	if s, err := d.Serial(); err != nil || s != "A2" {
+		t.Error("FAIL")
+	}
+	cd, err := d.ActiveConfig()
+	if err != nil || cd.BNumInterfaces != 1 || cd.WTotalLength != 32 {
+		t.Error("FAIL")
+	}
+	if d.Raw() != nil || ctx.Raw() != nil {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Strings(t *testing.T) {
+	b := NewBackend()
+	b.Add(new_programmer("A1", 1))
+	ctx := new_context(t, b)
+	defer ctx.Close()
+
+	h, err := ctx.OpenBySerial(0x1d50, 0x6018, "A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	s, langid, err := h.StringDescriptorPreferred(2, []uint16{descriptor.LANGID_JAPANESE})
+	if err != nil || s != "書き込み装置" || langid != descriptor.LANGID_JAPANESE {
+		t.Error("FAIL", s, err)
+	}
+	s, err = h.StringDescriptorASCII(2)
+	if err != nil || s != "??????" {
+		t.Error("FAIL", s)
+	}
+	_, err = h.StringDescriptor(2, descriptor.LANGID_GERMAN)
+	if !errors.Is(err, libusb.ErrPipe) {
+		t.Error("FAIL")
+	}
+	buf, err := h.Descriptor(libusb.DT_DEVICE, 0, make([]byte, 64))
+	if err != nil || len(buf) != libusb.DT_DEVICE_SIZE {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Select(t *testing.T) {
+	b := NewBackend()
+	b.Add(new_programmer("A1", 1), new_programmer("A2", 2), new_programmer("A3", 3))
+	ctx := new_context(t, b)
+	defer ctx.Close()
+
+	_, err := ctx.OpenOne(&libusb.Selector{Vendor: 0x1d50})
+	var e *libusb.Selector_Error
+	if !errors.As(err, &e) || len(e.Matches) != 3 || e.Matches[2].String() != "1-1.3" {
+		t.Error("FAIL", err)
+	}
+	_, err = ctx.OpenBySerial(0x1d50, 0x6018, "A4")
+	if !errors.Is(err, libusb.ErrNotFound) {
+		t.Error("FAIL")
+	}
+	sel, _ := libusb.Parse_Selector("1-1.2")
+	h, err := ctx.OpenOne(sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if s, _ := h.Device().Serial(); s != "A2" {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Transfers(t *testing.T) {
+	b := NewBackend()
+	d := new_programmer("A1", 1)
+	b.Add(d)
+	ctx := new_context(t, b)
+	defer ctx.Close()
+	h, err := ctx.OpenDeviceWithVIDPID(0x1d50, 0x6018)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	err = h.Claim(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Claim(1) == nil {
+		t.Error("FAIL")
+	}
+
+	d.Queue(0x81, Response{Data: []byte("hello")}, Response{Err: libusb.ErrPipe}, Response{Data: []byte("late"), Delay: 50 * time.Millisecond})
+	d.Queue(0x01, Response{}, Response{Err: libusb.ErrPipe})
+
+	data, err := h.Bulk(0x81, make([]byte, 512), time.Second)
+	if err != nil || string(data) != "hello" {
+		t.Error("FAIL")
+	}
+	_, err = h.Bulk(0x81, make([]byte, 512), time.Second)
+	var ue *libusb.Error
+	if !errors.As(err, &ue) || ue.Code != libusb.ERROR_PIPE || ue.Endpoint != 0x81 {
+		t.Error("FAIL", err)
+	}
+	_, err = h.Bulk(0x81, make([]byte, 512), 10*time.Millisecond)
+	if !errors.Is(err, libusb.ErrTimeout) {
+		t.Error("FAIL")
+	}
+	// nothing queued
+	_, err = h.Bulk(0x81, make([]byte, 512), time.Millisecond)
+	if !errors.Is(err, libusb.ErrTimeout) {
+		t.Error("FAIL")
+	}
+
+	_, err = h.Bulk(0x01, []byte{1, 2, 3}, time.Second)
+	if err != nil {
+		t.Error("FAIL")
+	}
+	_, err = h.Bulk(0x01, []byte{4}, time.Second)
+	if !errors.Is(err, libusb.ErrPipe) {
+		t.Error("FAIL")
+	}
+	x := d.Transfers()
+	if len(x) != 6 || x[4].Endpoint != 0x01 || !bytes.Equal(x[4].Data, []byte{1, 2, 3}) {
+		t.Error("FAIL")
+	}
+
+	// vendor control requests
+	d.Queue(0, Response{Data: []byte{0x42}})
+	data, err = h.Control(libusb.ENDPOINT_IN|libusb.REQUEST_TYPE_VENDOR, 1, 0, 0, make([]byte, 1), time.Second)
+	if err != nil || data[0] != 0x42 {
+		t.Error("FAIL")
+	}
+	_, err = h.Control(libusb.ENDPOINT_OUT|libusb.REQUEST_TYPE_VENDOR, 2, 0, 0, nil, time.Second)
+	if !errors.Is(err, libusb.ErrPipe) {
+		t.Error("FAIL")
+	}
+	// IN data is truncated to wLength
+	d.Queue(0, Response{Data: []byte{1, 2, 3}})
+	data, err = h.Control(libusb.ENDPOINT_IN|libusb.REQUEST_TYPE_VENDOR, 1, 0, 0, make([]byte, 2), time.Second)
+	if err != nil || !bytes.Equal(data, []byte{1, 2}) {
+		t.Error("FAIL")
+	}
+	data, err = h.Descriptor(libusb.DT_DEVICE, 0, make([]byte, 8))
+	if err != nil || len(data) != 8 || data[0] != libusb.DT_DEVICE_SIZE {
+		t.Error("FAIL")
+	}
+	data, err = h.Descriptor(libusb.DT_CONFIG, 0, make([]byte, libusb.DT_CONFIG_SIZE))
+	if err != nil || len(data) != libusb.DT_CONFIG_SIZE || data[1] != libusb.DT_CONFIG {
+		t.Error("FAIL")
+	}
+	// standard request errors have the operation
+	_, err = h.Descriptor(libusb.DT_CONFIG, 4, make([]byte, libusb.DT_CONFIG_SIZE))
+	if !errors.As(err, &ue) || ue.Code != libusb.ERROR_PIPE || ue.Op != "Control" || ue.Endpoint != 0 {
+		t.Error("FAIL", err)
+	}
+	if d.Pending(0) != 0 || d.Pending(0x81) != 0 {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Remove(t *testing.T) {
+	b := NewBackend()
+	d := new_programmer("A1", 1)
+	b.Add(d)
+	ctx := new_context(t, b)
+	defer ctx.Close()
+	h, err := ctx.OpenDeviceWithVIDPID(0x1d50, 0x6018)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	b.Remove(d)
+	_, err = h.Bulk(0x81, make([]byte, 64), time.Second)
+	if !errors.Is(err, libusb.ErrNoDevice) {
+		t.Error("FAIL")
+	}
+	if d.Attached() {
+		t.Error("FAIL")
+	}
+
+	// open errors
+	d = new_programmer("A2", 2)
+	d.OpenErr = libusb.ErrAccess
+	b.Add(d)
+	_, err = ctx.OpenBySerial(0, 0, "A2")
+	if !errors.Is(err, libusb.ErrAccess) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Libusb(t *testing.T) {
+	// the backend serves the 1-1 libusb functions
+	b := NewBackend()
+	d := new_programmer("A1", 1)
+	b.Add(d, new_programmer("A2", 2))
+	var ctx libusb.Context
+	err := libusb.Init_Backend(&ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer libusb.Exit(ctx)
+
+	hdl, err := libusb.OpenBySerial(ctx, 0x1d50, 0x6018, "A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer libusb.Close(hdl)
+	if libusb.Get_Port_Number(libusb.Get_Device(hdl)) != 1 {
+		t.Error("FAIL")
+	}
+	s, err := libusb.Get_String_Descriptor_ASCII(hdl, 1, make([]byte, 64))
+	if err != nil || string(s) != "Acme" {
+		t.Error("FAIL", err)
+	}
+	err = libusb.Claim_Interface(hdl, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer libusb.Release_Interface(hdl, 0)
+
+	d.Queue(0x81, Response{Data: []byte("hello")}, Response{Err: libusb.ErrPipe})
+	data, err := libusb.Bulk_Transfer(hdl, 0x81, make([]byte, 512), 1000)
+	if err != nil || string(data) != "hello" {
+		t.Error("FAIL")
+	}
+	_, err = libusb.Bulk_Transfer(hdl, 0x81, make([]byte, 512), 1000)
+	var ue *libusb.Error
+	if !errors.As(err, &ue) || ue.Code != libusb.ERROR_PIPE || ue.Op != "Bulk_Transfer" || ue.Handle != hdl {
+		t.Error("FAIL", err)
+	}
+	_, err = libusb.Bulk_Transfer(hdl, 0x81, make([]byte, 512), 10)
+	if !errors.Is(err, libusb.ErrTimeout) {
+		t.Error("FAIL")
+	}
+
+	// the endpoint writer is built on the same functions
+	w, err := libusb.OpenOutEndpoint(hdl, 0x01)
+	if err != nil || w.MaxPacketSize != 512 {
+		t.Fatal("FAIL", err)
+	}
+	n, err := w.Write([]byte{1, 2, 3})
+	x := d.Transfers()
+	if n != 3 || err != nil || !bytes.Equal(x[len(x)-1].Data, []byte{1, 2, 3}) {
+		t.Error("FAIL")
+	}
+
+	b.Remove(d)
+	_, err = libusb.Bulk_Transfer(hdl, 0x81, make([]byte, 512), 1000)
+	if !errors.Is(err, libusb.ErrNoDevice) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Hotplug(t *testing.T) {
+	b := NewBackend()
+	d0 := new_programmer("A1", 1)
+	b.Add(d0)
+	ctx := new_context(t, b)
+	defer ctx.Close()
+
+	events, stop, err := ctx.Watch(&libusb.WatchFilter{Vendor: 0x1d50, Product: libusb.HOTPLUG_MATCH_ANY, Class: libusb.HOTPLUG_MATCH_ANY, Enumerate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d1 := new_programmer("A2", 2)
+	b.Add(d1)
+	b.Add(&Device{Descriptor: &libusb.Device_Descriptor{IdVendor: 0x046d}})
+	b.Remove(d0)
+	stop()
+	expect := []struct {
+		event   int
+		address uint8
+	}{
+		{libusb.HOTPLUG_EVENT_DEVICE_ARRIVED, 1},
+		{libusb.HOTPLUG_EVENT_DEVICE_ARRIVED, 2},
+		{libusb.HOTPLUG_EVENT_DEVICE_LEFT, 1},
+	}
+	i := 0
+	for e := range events {
+		if i >= len(expect) || e.Event != expect[i].event || e.Address != expect[i].address {
+			t.Error("FAIL", e)
+		}
+		i++
+	}
+	if i != len(expect) {
+		t.Error("FAIL")
+	}
+}
+
+func Test_Hotplug_Queue(t *testing.T) {
+	// more devices than the channel holds don't block Add or Watch
+	b := NewBackend()
+	n := 2 * watch_queue_size
+	for i := 0; i < n; i++ {
+		b.Add(&Device{Bus: 1 + uint8(i/100), Descriptor: &libusb.Device_Descriptor{IdVendor: 0x1d50}})
+	}
+	events, stop, err := b.Watch(&libusb.WatchFilter{Enumerate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Add(&Device{Bus: 3, Descriptor: &libusb.Device_Descriptor{IdVendor: 0x1d50}})
+	for i := 0; i <= n; i++ {
+		e := <-events
+		if e.Event != libusb.HOTPLUG_EVENT_DEVICE_ARRIVED || (i == n) != (e.Bus == 3) {
+			t.Fatal("FAIL", i)
+		}
+	}
+	stop()
+	stop()
+	if _, ok := <-events; ok {
+		t.Error("FAIL")
+	}
+}
+
+//-----------------------------------------------------------------------------
@@ -0,0 +1,283 @@
+//-----------------------------------------------------------------------------
+/*
+
+Device watcher
+
+Report devices arriving and leaving on a channel. Hotplug notification is
+used where the platform supports it, otherwise the device list is polled
+and successive snapshots are compared.
+
+*/
+//-----------------------------------------------------------------------------
+
+package libusb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+
+// How often the device list is polled when hotplug is not supported.
+const WATCH_POLL_INTERVAL = time.Second
+
+// How long a single round of event handling may block.
+const watch_event_timeout = 100 * time.Millisecond
+
+// Size of the event channel buffer.
+const watch_queue_size = 32
+
+//-----------------------------------------------------------------------------
+
+// DeviceEvent reports a device arriving or leaving.
+type DeviceEvent struct {
+	Event      int    // HOTPLUG_EVENT_DEVICE_ARRIVED or HOTPLUG_EVENT_DEVICE_LEFT
+	Bus        uint8  // bus number
+	Address    uint8  // device address on the bus
+	Path       []byte // port numbers from the root hub
+	Descriptor *Device_Descriptor
+}
+
+// WatchFilter selects the devices reported by Watch.
+// Zero or HOTPLUG_MATCH_ANY fields match any value, so the zero value
+// reports all devices. A class of 0 (per interface) can't be selected.
+type WatchFilter struct {
+	Vendor    int  // idVendor
+	Product   int  // idProduct
+	Class     int  // bDeviceClass
+	Enumerate bool // report devices that are already attached
+}
+
+// Match reports whether a device descriptor passes the filter.
+func (f *WatchFilter) Match(dd *Device_Descriptor) bool {
+	if match_any(f.Vendor) != HOTPLUG_MATCH_ANY && f.Vendor != int(dd.IdVendor) {
+		return false
+	}
+	if match_any(f.Product) != HOTPLUG_MATCH_ANY && f.Product != int(dd.IdProduct) {
+		return false
+	}
+	if match_any(f.Class) != HOTPLUG_MATCH_ANY && f.Class != int(dd.BDeviceClass) {
+		return false
+	}
+	return true
+}
+
+// map a zero filter field to HOTPLUG_MATCH_ANY
+func match_any(x int) int {
+	if x == 0 {
+		return HOTPLUG_MATCH_ANY
+	}
+	return x
+}
+
+// build an event for a device
+func new_device_event(dev Device, event int) (*DeviceEvent, error) {
+	dd, err := Get_Device_Descriptor(dev)
+	if err != nil {
+		return nil, err
+	}
+	path, err := Get_Port_Numbers(dev, make([]byte, 8))
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceEvent{
+		Event:      event,
+		Bus:        Get_Bus_Number(dev),
+		Address:    Get_Device_Address(dev),
+		Path:       path,
+		Descriptor: dd,
+	}, nil
+}
+
+// return a key identifying the device in a snapshot
+func (e *DeviceEvent) key() string {
+	return fmt.Sprintf("%d:%d:%v:%04x:%04x", e.Bus, e.Address, e.Path, e.Descriptor.IdVendor, e.Descriptor.IdProduct)
+}
+
+//-----------------------------------------------------------------------------
+
+type watcher struct {
+	ctx    Context
+	filter WatchFilter
+	events chan DeviceEvent
+	done   chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex // serialises sends with closing the events channel
+	closed bool
+}
+
+// send an event to the watcher channel
+func (w *watcher) send(e *DeviceEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.events <- *e:
+	case <-w.done:
+	}
+}
+
+// stop the watcher and close the events channel
+func (w *watcher) stop(cleanup func()) {
+	close(w.done)
+	w.wg.Wait()
+	if cleanup != nil {
+		cleanup()
+	}
+	w.mu.Lock()
+	w.closed = true
+	close(w.events)
+	w.mu.Unlock()
+}
+
+// handle hotplug events until stopped
+func (w *watcher) handle_events() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		err := Handle_Events_Timeout(w.ctx, watch_event_timeout)
+		if err != nil {
+			// avoid spinning on a persistent error
+			time.Sleep(watch_event_timeout)
+		}
+	}
+}
+
+// snapshot the filtered device list
+func (w *watcher) snapshot() (map[string]*DeviceEvent, error) {
+	list, err := Get_Device_List(w.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer Free_Device_List(list, 1)
+	devices := make(map[string]*DeviceEvent)
+	for _, dev := range list {
+		e, err := new_device_event(dev, HOTPLUG_EVENT_DEVICE_ARRIVED)
+		if err != nil || !w.filter.Match(e.Descriptor) {
+			continue
+		}
+		devices[e.key()] = e
+	}
+	return devices, nil
+}
+
+// poll the device list until stopped, reporting the differences
+func (w *watcher) poll(devices map[string]*DeviceEvent) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(WATCH_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		current, err := w.snapshot()
+		if err != nil {
+			continue
+		}
+		for k, e := range devices {
+			if current[k] == nil {
+				e.Event = HOTPLUG_EVENT_DEVICE_LEFT
+				w.send(e)
+			}
+		}
+		for k, e := range current {
+			if devices[k] == nil {
+				w.send(e)
+			}
+		}
+		devices = current
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// Watch reports devices arriving and leaving on the returned channel.
+// A nil filter reports all devices. Call the returned stop function to end
+// the watch, it deregisters any callback and closes the channel.
+// The devices of a backend context are watched by the backend.
+func Watch(ctx Context, filter *WatchFilter) (<-chan DeviceEvent, func(), error) {
+	if b, ok := lookup_backend(ctx); ok {
+		return b.Watch(filter)
+	}
+	w := &watcher{
+		ctx:    ctx,
+		events: make(chan DeviceEvent, watch_queue_size),
+		done:   make(chan struct{}),
+	}
+	if filter != nil {
+		w.filter = *filter
+	}
+
+	if Has_Capability(CAP_HAS_HOTPLUG) {
+		// HOTPLUG_ENUMERATE calls back during registration, and the channel may
+		// not hold every device, so snapshot the list and enumerate from the
+		// event goroutine. A device arriving in between may be reported twice.
+		f := &w.filter
+		events := HOTPLUG_EVENT_DEVICE_ARRIVED | HOTPLUG_EVENT_DEVICE_LEFT
+		handle, err := Hotplug_Register_Callback(ctx, events, 0, match_any(f.Vendor), match_any(f.Product), match_any(f.Class),
+			func(ctx Context, dev Device, event int) bool {
+				e, err := new_device_event(dev, event)
+				if err == nil {
+					w.send(e)
+				}
+				return false
+			})
+		if err != nil {
+			return nil, nil, err
+		}
+		var devices map[string]*DeviceEvent
+		if f.Enumerate {
+			devices, err = w.snapshot()
+			if err != nil {
+				Hotplug_Deregister_Callback(ctx, handle)
+				return nil, nil, err
+			}
+		}
+		w.wg.Add(1)
+		go func() {
+			for _, e := range devices {
+				w.send(e)
+			}
+			w.handle_events()
+		}()
+		stop := func() {
+			w.stop(func() { Hotplug_Deregister_Callback(ctx, handle) })
+		}
+		return w.events, once(stop), nil
+	}
+
+	// no hotplug support: fall back to polling
+	devices, err := w.snapshot()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.wg.Add(1)
+	go func() {
+		// the channel may not hold every device, so enumerate from the polling goroutine
+		if w.filter.Enumerate {
+			for _, e := range devices {
+				w.send(e)
+			}
+		}
+		w.poll(devices)
+	}()
+	return w.events, once(func() { w.stop(nil) }), nil
+}
+
+// return a function that calls fn the first time only
+func once(fn func()) func() {
+	var o sync.Once
+	return func() { o.Do(fn) }
+}
+
+//-----------------------------------------------------------------------------